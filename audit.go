@@ -0,0 +1,817 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants
+const (
+	AUDIT_FILE         = "audit.log"
+	AUDIT_GENESIS_HASH = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	// Hash formats. Entries written before the format was recorded carry
+	// none and hash either their before/after values (plain) or the digests
+	// of those values. Keyed entries hash an HMAC of the values under a key
+	// of their own, which is destroyed when they are redacted.
+	AUDIT_FORMAT_LEGACY  = 0
+	AUDIT_FORMAT_DIGEST  = 1
+	AUDIT_FORMAT_KEYED   = 2
+	AUDIT_FORMAT_CURRENT = AUDIT_FORMAT_KEYED
+
+	// Before/after values are sealed with the data key. The prefix is
+	// followed by the check of the key that sealed them.
+	AUDIT_SEALED_PREFIX = "sealed:"
+
+	// Audit actions
+	AUDIT_CREATE       = "create"
+	AUDIT_UPDATE       = "update"
+	AUDIT_DELETE       = "delete"
+	AUDIT_VIEW         = "view"
+	AUDIT_EXPORT       = "export"
+	AUDIT_IMPORT       = "import"
+	AUDIT_SAVE         = "save"
+	AUDIT_LOGIN        = "login"
+	AUDIT_LOGIN_FAILED = "login_failed"
+	AUDIT_ERASE        = "erase"
+	AUDIT_RESTORE      = "restore"
+	AUDIT_PURGE        = "purge"
+	AUDIT_UNDO         = "undo"
+	AUDIT_REDO         = "redo"
+	AUDIT_NOTIFY       = "notify"
+	AUDIT_CONFLICT     = "conflict"
+	AUDIT_REANCHOR     = "reanchor"
+	AUDIT_REDACTED     = "[redacted]"
+)
+
+// Data structures
+type AuditEntry struct {
+	Seq       int    `json:"seq"`
+	Timestamp string `json:"timestamp"`
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Entity    string `json:"entity"`
+	EntityID  int    `json:"entity_id"`
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+	Format    int    `json:"format,omitempty"`
+
+	// Set when personal data is erased; the digests keep the chain verifiable.
+	// Key is removed at the same time, so the values cannot be guessed back
+	// from their digests.
+	BeforeDigest string `json:"before_digest,omitempty"`
+	AfterDigest  string `json:"after_digest,omitempty"`
+	Key          string `json:"key,omitempty"`
+}
+
+// AuditHead is the last audit entry at the time of saving. It is kept in the
+// data file so that entries cut off the end of the log can be detected.
+type AuditHead struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Audit chain state, loaded from the log file on first use and again when
+// another terminal has written to it
+var (
+	auditLoaded   bool
+	auditLastSeq  int
+	auditLastHash = AUDIT_GENESIS_HASH
+	auditHead     AuditHead
+	auditFileSize int64
+
+	// Entries not yet written because the log could not be locked or read;
+	// the data file is not saved until they are
+	auditMu      sync.Mutex
+	auditPending []AuditEntry
+)
+
+// Hash chain functions
+func auditDigest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditKeyedDigest is the HMAC of a before/after value under the entry's key
+func auditKeyedDigest(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	secret, _ := hex.DecodeString(key)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAuditKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// auditContentDigest gives the digest of a before/after value, using the
+// stored digest once the value has been redacted. A sealed value is digested
+// as it was before sealing.
+func auditContentDigest(e AuditEntry, value, digest string) string {
+	switch {
+	case digest != "":
+		return digest
+	case e.Format == AUDIT_FORMAT_KEYED:
+		return auditKeyedDigest(e.Key, plainAuditValue(value))
+	}
+	return auditDigest(plainAuditValue(value))
+}
+
+// computeAuditHash covers the digests of the before/after values rather than
+// the values themselves, so they can be redacted without breaking the chain
+func computeAuditHash(e AuditEntry) string {
+	return hashAuditContent(e, auditContentDigest(e, e.Before, e.BeforeDigest), auditContentDigest(e, e.After, e.AfterDigest))
+}
+
+// computePlainAuditHash is the hash of entries from before redaction existed,
+// which covers the before/after values themselves
+func computePlainAuditHash(e AuditEntry) string {
+	return hashAuditContent(e, plainAuditValue(e.Before), plainAuditValue(e.After))
+}
+
+func hashAuditContent(e AuditEntry, before, after string) string {
+	content := fmt.Sprintf("%d|%s|%s|%s|%s|%d|%s|%s|%s",
+		e.Seq, e.Timestamp, e.User, e.Action, e.Entity, e.EntityID, before, after, e.PrevHash)
+	return auditDigest(content)
+}
+
+// Sealing functions
+// sealAuditValue encrypts a before/after value with the data key, so the
+// audit log holds no patient data in the clear. Hashes cover the value
+// before sealing, so it can be sealed again under a new key.
+func sealAuditValue(value string) string {
+	if value == "" || value == AUDIT_REDACTED || strings.HasPrefix(value, AUDIT_SEALED_PREFIX) || dataKey == nil {
+		return value
+	}
+	gcm, err := newGCM(dataKey.Key)
+	if err != nil {
+		return value
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return value
+	}
+	check := keyCheck(dataKey.Key)
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(check))
+	return AUDIT_SEALED_PREFIX + check + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// openAuditValue decrypts a sealed value with the data key, or with one of
+// keys when it was sealed before a key rotation. Values written before
+// sealing are returned as they are.
+func openAuditValue(value string, keys ...[]byte) (string, error) {
+	if !strings.HasPrefix(value, AUDIT_SEALED_PREFIX) {
+		return value, nil
+	}
+	check, encoded, _ := strings.Cut(strings.TrimPrefix(value, AUDIT_SEALED_PREFIX), ":")
+	if dataKey != nil {
+		keys = append(keys, dataKey.Key)
+	}
+	for _, key := range keys {
+		if key == nil || keyCheck(key) != check {
+			continue
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sealed) < gcm.NonceSize() {
+			return "", fmt.Errorf("sealed value is damaged")
+		}
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(check))
+		if err != nil {
+			return "", fmt.Errorf("sealed value is damaged")
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("sealed with a key that is not loaded")
+}
+
+// plainAuditValue is the value a hash covers. A value that cannot be opened
+// is left sealed, so its entry no longer verifies.
+func plainAuditValue(value string) string {
+	if plain, err := openAuditValue(value); err == nil {
+		return plain
+	}
+	return value
+}
+
+// auditValueText shows a before/after value in the audit viewer
+func auditValueText(value string) string {
+	plain, err := openAuditValue(value)
+	if err != nil {
+		return "[" + err.Error() + "]"
+	}
+	return plain
+}
+
+// isAuditHashValid checks an entry's hash under the format it was written in
+func isAuditHashValid(e AuditEntry) bool {
+	switch e.Format {
+	case AUDIT_FORMAT_DIGEST, AUDIT_FORMAT_KEYED:
+		return computeAuditHash(e) == e.Hash
+	case AUDIT_FORMAT_LEGACY:
+		if e.BeforeDigest == "" && e.AfterDigest == "" && computePlainAuditHash(e) == e.Hash {
+			return true
+		}
+		return computeAuditHash(e) == e.Hash
+	}
+	return false
+}
+
+// isAuditRedactable reports whether an entry still holds content to redact
+func isAuditRedactable(e AuditEntry) bool {
+	return (e.Before != "" && e.BeforeDigest == "") || (e.After != "" && e.AfterDigest == "")
+}
+
+func isRedactionValid(value, digest string) bool {
+	return digest == "" || value == "" || value == AUDIT_REDACTED
+}
+
+// redactAuditEntry replaces the before/after values with their digests,
+// destroys the key they were made with and reports whether anything was left
+// to redact
+func redactAuditEntry(e *AuditEntry) bool {
+	changed := false
+	if e.Before != "" && e.BeforeDigest == "" {
+		e.BeforeDigest = auditContentDigest(*e, e.Before, "")
+		e.Before = AUDIT_REDACTED
+		changed = true
+	}
+	if e.After != "" && e.AfterDigest == "" {
+		e.AfterDigest = auditContentDigest(*e, e.After, "")
+		e.After = AUDIT_REDACTED
+		changed = true
+	}
+	e.Key = ""
+	return changed
+}
+
+// hasAuditDigest reports whether an entry has been redacted
+func hasAuditDigest(e AuditEntry) bool {
+	return e.BeforeDigest != "" || e.AfterDigest != ""
+}
+
+// verifyAuditChain returns the sequence number of the first entry that breaks
+// the chain, or 0 when every entry is intact
+func verifyAuditChain(entries []AuditEntry) (int, string) {
+	prevHash := AUDIT_GENESIS_HASH
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			return e.Seq, fmt.Sprintf("expected sequence %d, found %d (entry missing or reordered)", i+1, e.Seq)
+		}
+		if e.PrevHash != prevHash {
+			return e.Seq, "previous hash does not match (entry removed or inserted before it)"
+		}
+		if !isRedactionValid(e.Before, e.BeforeDigest) || !isRedactionValid(e.After, e.AfterDigest) {
+			return e.Seq, "redacted entry still has content (entry modified)"
+		}
+		for _, value := range []string{e.Before, e.After} {
+			if _, err := openAuditValue(value); err != nil {
+				return e.Seq, err.Error()
+			}
+		}
+		if !isAuditHashValid(e) {
+			return e.Seq, "content does not match its hash (entry modified)"
+		}
+		prevHash = e.Hash
+	}
+	return 0, ""
+}
+
+// verifyAuditHead checks that the log still reaches the entry recorded in the
+// encrypted data file. Nothing read from the log itself can stand in for it:
+// a re-anchored log is only accepted once its new head has been saved.
+func verifyAuditHead(entries []AuditEntry, head AuditHead) bool {
+	if head.Seq == 0 {
+		return true
+	}
+	return head.Seq <= len(entries) && entries[head.Seq-1].Hash == head.Hash
+}
+
+// reanchorAuditLog rewrites entries from older hash formats in the current
+// one, so they can be redacted. Digests of entries redacted before entries
+// had keys are keyed with a key that is not kept. Only an intact chain is
+// re-anchored, and a reanchor entry records the old and new hash of the last
+// entry.
+func reanchorAuditLog(entries []AuditEntry) ([]AuditEntry, error) {
+	if badSeq, reason := verifyAuditChain(entries); badSeq != 0 {
+		return nil, fmt.Errorf("entry %d does not verify (%s), so the log cannot be re-anchored", badSeq, reason)
+	}
+
+	oldHead := AuditHead{Seq: len(entries), Hash: entries[len(entries)-1].Hash}
+	renamed := make(map[string]string)
+	prevHash := AUDIT_GENESIS_HASH
+	for i := range entries {
+		e := &entries[i]
+		e.PrevHash = prevHash
+		if e.Format != AUDIT_FORMAT_KEYED {
+			key := newAuditKey()
+			e.BeforeDigest = auditKeyedDigest(key, e.BeforeDigest)
+			e.AfterDigest = auditKeyedDigest(key, e.AfterDigest)
+			if isAuditRedactable(*e) {
+				e.Key = key
+			}
+			e.Format = AUDIT_FORMAT_KEYED
+		}
+		old := e.Hash
+		e.Hash = computeAuditHash(*e)
+		renamed[old] = e.Hash
+		prevHash = e.Hash
+	}
+
+	newHead := AuditHead{Seq: oldHead.Seq, Hash: prevHash}
+	entry := newAuditEntry(newHead.Seq+1, newHead.Hash, AUDIT_REANCHOR, "audit", 0, oldHead, newHead)
+
+	// The head this terminal saves next refers to the new hashes
+	if h, ok := renamed[auditHead.Hash]; ok {
+		auditHead.Hash = h
+	}
+	return append(entries, entry), nil
+}
+
+// Audit file functions
+func readAuditLog() ([]AuditEntry, error) {
+	file, err := os.Open(AUDIT_FILE)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	reader := bufio.NewScanner(file)
+	reader.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	line := 0
+	for reader.Scan() {
+		line++
+		text := strings.TrimSpace(reader.Text())
+		if text == "" {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal([]byte(text), &e); err != nil {
+			return entries, fmt.Errorf("audit log line %d is corrupted: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := reader.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return entries, nil
+}
+
+// redactAuditLog redacts every entry that matches and rewrites the log in one
+// step. It returns the number of entries redacted. The caller saves the data
+// next, so that the data file records the head of a re-anchored log.
+func redactAuditLog(match func(AuditEntry) bool) (int, error) {
+	if err := acquireLock(AUDIT_FILE); err != nil {
+		return 0, err
+	}
+	defer releaseLock(AUDIT_FILE)
+
+	entries, err := readAuditLog()
+	if err != nil {
+		return 0, err
+	}
+
+	// Older entries are re-anchored before one of them is redacted, and so
+	// are digests from earlier erasures that were made without a key
+	reanchor := false
+	for _, e := range entries {
+		if e.Format != AUDIT_FORMAT_CURRENT && ((match(e) && isAuditRedactable(e)) || hasAuditDigest(e)) {
+			reanchor = true
+		}
+	}
+	if reanchor {
+		if entries, err = reanchorAuditLog(entries); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for i := range entries {
+		if match(entries[i]) && redactAuditEntry(&entries[i]) {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if err := writeAuditFile(entries); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// resealAuditLog seals the values of the log with the current data key:
+// those sealed with oldKey before a key rotation, and those written before
+// values were sealed. It returns the number of entries sealed again.
+func resealAuditLog(oldKey []byte) (int, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if dataKey == nil {
+		return 0, ErrNoDataKey
+	}
+
+	// Entries still waiting were sealed with the key of the time
+	reseal := func(e *AuditEntry) bool {
+		changed := false
+		for _, value := range []*string{&e.Before, &e.After} {
+			if *value == "" || *value == AUDIT_REDACTED || strings.HasPrefix(*value, AUDIT_SEALED_PREFIX+keyCheck(dataKey.Key)+":") {
+				continue
+			}
+			if plain, err := openAuditValue(*value, oldKey); err == nil {
+				*value = sealAuditValue(plain)
+				changed = true
+			}
+		}
+		return changed
+	}
+	for i := range auditPending {
+		reseal(&auditPending[i])
+	}
+
+	if err := acquireLock(AUDIT_FILE); err != nil {
+		return 0, err
+	}
+	defer releaseLock(AUDIT_FILE)
+
+	entries, err := readAuditLog()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range entries {
+		if reseal(&entries[i]) {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if err := writeAuditFile(entries); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// writeAuditFile replaces the log with entries in one step; the caller holds
+// its lock
+func writeAuditFile(entries []AuditEntry) error {
+	var content []byte
+	for _, e := range entries {
+		line, _ := json.Marshal(e)
+		content = append(append(content, line...), '\n')
+	}
+
+	tmp := AUDIT_FILE + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	if err := os.Rename(tmp, AUDIT_FILE); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace audit log: %v", err)
+	}
+	// The chain continues from the rewritten log, and the next save records
+	// its head
+	auditLoaded = true
+	auditLastSeq, auditLastHash = 0, AUDIT_GENESIS_HASH
+	if len(entries) > 0 {
+		auditLastSeq, auditLastHash = entries[len(entries)-1].Seq, entries[len(entries)-1].Hash
+	}
+	auditFileSize = auditLogSize()
+	return nil
+}
+
+// loadAuditState finds the entry the chain continues from. A log that cannot
+// be read is not appended to, since the new entry would not link to it.
+func loadAuditState() error {
+	size := auditLogSize()
+	entries, err := readAuditLog()
+	if err != nil {
+		return err
+	}
+	auditLoaded = true
+	auditFileSize = size
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		auditLastSeq = last.Seq
+		auditLastHash = last.Hash
+	}
+	return nil
+}
+
+func auditLogSize() int64 {
+	info, err := os.Stat(AUDIT_FILE)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func auditSnapshot(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// auditLog appends an entry to the audit log. before and after are the
+// entity values around the change; either may be nil. An entry that cannot
+// be written waits and goes out with the next one.
+func auditLog(action, entity string, entityID int, before, after interface{}) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	auditPending = append(auditPending, newAuditEntry(0, "", action, entity, entityID, before, after))
+	if err := writePendingAudit(); err != nil {
+		printWarning(fmt.Sprintf("Failed to write audit log: %v. %d entries wait to be written; data is not saved until then.",
+			err, len(auditPending)))
+	}
+}
+
+// flushAuditLog writes the entries still waiting, before the data is saved
+func flushAuditLog() error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	// With nothing to write, the head saved with the data still follows what
+	// other terminals appended or re-anchored
+	if len(auditPending) == 0 && (!auditLoaded || auditLogSize() != auditFileSize) && acquireLock(AUDIT_FILE) == nil {
+		loadAuditState()
+		releaseLock(AUDIT_FILE)
+	}
+	if err := writePendingAudit(); err != nil {
+		return fmt.Errorf("%d audit entries could not be written: %v", len(auditPending), err)
+	}
+	return nil
+}
+
+func writePendingAudit() error {
+	if len(auditPending) == 0 {
+		return nil
+	}
+
+	// Other terminals append to the same log, so the chain continues from
+	// whatever entry is last in the file
+	if err := acquireLock(AUDIT_FILE); err != nil {
+		return err
+	}
+	defer releaseLock(AUDIT_FILE)
+
+	if !auditLoaded || auditLogSize() != auditFileSize {
+		if err := loadAuditState(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(AUDIT_FILE, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for len(auditPending) > 0 {
+		entry := auditPending[0]
+		entry.Seq, entry.PrevHash = auditLastSeq+1, auditLastHash
+		entry.Hash = computeAuditHash(entry)
+		line, _ := json.Marshal(entry)
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			auditLoaded = false
+			return err
+		}
+		auditLastSeq = entry.Seq
+		auditLastHash = entry.Hash
+		auditPending = auditPending[1:]
+	}
+	auditFileSize = auditLogSize()
+	return nil
+}
+
+// newAuditEntry builds the entry that continues the chain after prevHash
+func newAuditEntry(seq int, prevHash, action, entity string, entityID int, before, after interface{}) AuditEntry {
+	user := "-"
+	if currentUser != nil {
+		user = currentUser.Username
+	}
+
+	entry := AuditEntry{
+		Seq:       seq,
+		Timestamp: time.Now().Format(time.RFC3339),
+		User:      user,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Before:    sealAuditValue(auditSnapshot(before)),
+		After:     sealAuditValue(auditSnapshot(after)),
+		PrevHash:  prevHash,
+		Format:    AUDIT_FORMAT_CURRENT,
+	}
+	if isAuditRedactable(entry) {
+		entry.Key = newAuditKey()
+	}
+	entry.Hash = computeAuditHash(entry)
+	return entry
+}
+
+// auditUser strips password material before a user is written to the audit log
+func auditUser(u User) User {
+	u.PasswordHash = ""
+	u.Salt = ""
+	return u
+}
+
+// Audit viewer functions
+func matchesAuditFilter(e AuditEntry, filter int, value string, entityID int, from, to string) bool {
+	switch filter {
+	case 2:
+		return strings.EqualFold(e.User, value)
+	case 3:
+		return strings.EqualFold(e.Action, value)
+	case 4:
+		return strings.EqualFold(e.Entity, value) && (entityID == 0 || e.EntityID == entityID)
+	case 5:
+		t, err := time.Parse(time.RFC3339, e.Timestamp)
+		if err != nil {
+			return false
+		}
+		date := t.Format("02/01/2006")
+		return compareDates(date, from) >= 0 && compareDates(date, to) <= 0
+	}
+	return true
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
+
+func viewAuditLog() {
+	printHeader("Audit Log")
+
+	if !requirePermission(PERM_AUDIT_VIEW) {
+		return
+	}
+
+	entries, err := readAuditLog()
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	if len(entries) == 0 {
+		printWarning("The audit log is empty.")
+		pause()
+		return
+	}
+
+	fmt.Println("Filter: 1. All  2. User  3. Action  4. Entity  5. Date range")
+	filter := getValidInt("Choose filter: ", 1, 5)
+
+	var value, from, to string
+	entityID := 0
+	switch filter {
+	case 2:
+		value = getValidInput("Enter username: ")
+	case 3:
+		value = getValidInput("Enter action (create, update, delete, view, export, import, save, login, login_failed, erase, restore, purge, undo, redo): ")
+	case 4:
+		value = getValidInput("Enter entity (patient, package, record, ...): ")
+		entityID = getValidInt("Enter entity ID (0 for all): ", 0, 999999)
+	case 5:
+		from = getValidDate("Enter start date")
+		to = getValidDate("Enter end date")
+	}
+
+	// Record the viewing before showing anything
+	auditLog(AUDIT_VIEW, "audit", 0, nil, nil)
+
+	fmt.Printf("\n%s%-6s %-20s %-12s %-13s %-12s %-8s %-40s%s\n", BOLD,
+		"Seq", "Timestamp", "User", "Action", "Entity", "ID", "Change", RESET)
+	fmt.Println(strings.Repeat("-", 118))
+
+	count := 0
+	for _, e := range entries {
+		if !matchesAuditFilter(e, filter, value, entityID, from, to) {
+			continue
+		}
+		change := auditValueText(e.After)
+		if change == "" {
+			change = auditValueText(e.Before)
+		}
+		timestamp := e.Timestamp
+		if t, err := time.Parse(time.RFC3339, e.Timestamp); err == nil {
+			timestamp = t.Format("02/01/2006 15:04:05")
+		}
+		fmt.Printf("%-6d %-20s %-12s %-13s %-12s %-8d %-40s\n",
+			e.Seq, timestamp, e.User, e.Action, e.Entity, e.EntityID, truncate(change, 40))
+		count++
+	}
+	fmt.Printf("\n%d entries shown.\n", count)
+
+	seq := getValidInt("\nEnter sequence number for details (0 to return): ", 0, entries[len(entries)-1].Seq)
+	for _, e := range entries {
+		if seq > 0 && e.Seq == seq {
+			fmt.Printf("\n%sEntry %d%s\n", BOLD, e.Seq, RESET)
+			fmt.Printf("Timestamp: %s\nUser: %s\nAction: %s\nEntity: %s %d\n", e.Timestamp, e.User, e.Action, e.Entity, e.EntityID)
+			fmt.Printf("Before: %s\nAfter: %s\nHash: %s\n", auditValueText(e.Before), auditValueText(e.After), e.Hash)
+			pause()
+			break
+		}
+	}
+}
+
+func verifyAuditLog() {
+	printHeader("Verify Audit Log")
+
+	if !requirePermission(PERM_AUDIT_VIEW) {
+		return
+	}
+
+	entries, err := readAuditLog()
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+
+	// The head is taken from the data file as saved, not from this session
+	saved, err := readDataStore(DATA_FILE, diskKey)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	head := saved.AuditHead
+
+	if badSeq, reason := verifyAuditChain(entries); badSeq != 0 {
+		printError(fmt.Sprintf("Audit chain broken at entry %d: %s.", badSeq, reason))
+	} else if !verifyAuditHead(entries, head) {
+		printError(fmt.Sprintf("Audit log does not reach entry %d as recorded at the last save (entries removed or rewritten).", head.Seq))
+	} else {
+		printSuccess(fmt.Sprintf("Audit chain intact: %d entries verified.", len(entries)))
+	}
+
+	auditLog(AUDIT_VIEW, "audit_verify", 0, nil, nil)
+	pause()
+}
+
+func securityManagement() {
+	for {
+		printHeader("Security & Privacy")
+		fmt.Printf("%s1.%s View Audit Log\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Verify Audit Chain\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Rotate Encryption Key\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Retention Policy\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Run Retention Job\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Forget Patient (Erasure Request)\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 6)
+
+		switch choice {
+		case 1:
+			viewAuditLog()
+		case 2:
+			verifyAuditLog()
+		case 3:
+			rotateDataKey()
+		case 4:
+			editRetentionPolicy()
+		case 5:
+			runRetentionJob()
+		case 6:
+			forgetPatient()
+		case 0:
+			return
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Constants
+const (
+	USER_ID_START       = 80001
+	PASSWORD_ITERATIONS = 100000
+	PASSWORD_MIN_LENGTH = 8
+	MAX_LOGIN_ATTEMPTS  = 3
+
+	// Roles
+	ROLE_RECEPTIONIST = "receptionist"
+	ROLE_NURSE        = "nurse"
+	ROLE_DOCTOR       = "doctor"
+	ROLE_CASHIER      = "cashier"
+	ROLE_MANAGER      = "manager"
+	ROLE_ADMIN        = "admin"
+
+	// Permissions
+	PERM_PATIENT_VIEW        = "patient.view"
+	PERM_PATIENT_CREATE      = "patient.create"
+	PERM_PATIENT_UPDATE      = "patient.update"
+	PERM_PATIENT_DELETE      = "patient.delete"
+	PERM_PACKAGE_VIEW        = "package.view"
+	PERM_PACKAGE_MANAGE      = "package.manage"
+	PERM_PRICE_EDIT          = "price.edit"
+	PERM_RECORD_VIEW         = "record.view"
+	PERM_RECORD_CREATE       = "record.create"
+	PERM_RECORD_DELETE       = "record.delete"
+	PERM_RESULT_ENTER        = "result.enter"
+	PERM_RESULT_SIGN         = "result.sign"
+	PERM_REPORT_PATIENT      = "report.patient"
+	PERM_REPORT_PACKAGE      = "report.package"
+	PERM_REPORT_REVENUE      = "report.revenue"
+	PERM_COMPANY_MANAGE      = "company.manage"
+	PERM_BILLING_MANAGE      = "billing.manage"
+	PERM_PROMOTION_MANAGE    = "promotion.manage"
+	PERM_CATEGORY_MANAGE     = "category.manage"
+	PERM_USER_MANAGE         = "user.manage"
+	PERM_AUDIT_VIEW          = "audit.view"
+	PERM_KEY_MANAGE          = "security.key"
+	PERM_RESEARCH_EXPORT     = "research.export"
+	PERM_PRIVACY_MANAGE      = "privacy.manage"
+	PERM_CLINIC_MANAGE       = "clinic.manage"
+	PERM_DATA_EXCHANGE       = "data.exchange"
+	PERM_PRACTITIONER_MANAGE = "practitioner.manage"
+	PERM_RECORD_ASSIGN       = "record.assign"
+	PERM_RECALL_MANAGE       = "recall.manage"
+	PERM_NOTIFY_MANAGE       = "notify.manage"
+	PERM_BRANCH_MANAGE       = "branch.manage"
+)
+
+// Data structures
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Salt         string `json:"salt"`
+	Role         string `json:"role"`
+	Active       bool   `json:"active"`
+	Branches     []int  `json:"branches,omitempty"`
+}
+
+type UserArray struct {
+	Daftar [NMAX]User `json:"daftar"`
+	N      int        `json:"n"`
+}
+
+var ROLES = []string{ROLE_RECEPTIONIST, ROLE_NURSE, ROLE_DOCTOR, ROLE_CASHIER, ROLE_MANAGER, ROLE_ADMIN}
+
+var rolePermissions = map[string][]string{
+	ROLE_RECEPTIONIST: {
+		PERM_PATIENT_VIEW, PERM_PATIENT_CREATE, PERM_PATIENT_UPDATE,
+		PERM_PACKAGE_VIEW, PERM_RECORD_VIEW, PERM_RECORD_CREATE, PERM_RECORD_ASSIGN, PERM_COMPANY_MANAGE,
+		PERM_RECALL_MANAGE, PERM_NOTIFY_MANAGE,
+	},
+	ROLE_NURSE: {
+		PERM_PATIENT_VIEW, PERM_PACKAGE_VIEW, PERM_RECORD_VIEW, PERM_RESULT_ENTER,
+	},
+	ROLE_DOCTOR: {
+		PERM_PATIENT_VIEW, PERM_PACKAGE_VIEW, PERM_RECORD_VIEW, PERM_RESULT_ENTER, PERM_RESULT_SIGN, PERM_REPORT_PATIENT,
+	},
+	ROLE_CASHIER: {
+		PERM_PATIENT_VIEW, PERM_PACKAGE_VIEW, PERM_RECORD_VIEW, PERM_BILLING_MANAGE, PERM_REPORT_REVENUE,
+	},
+	ROLE_MANAGER: {
+		PERM_PATIENT_VIEW, PERM_PATIENT_CREATE, PERM_PATIENT_UPDATE, PERM_PATIENT_DELETE,
+		PERM_PACKAGE_VIEW, PERM_PACKAGE_MANAGE, PERM_PRICE_EDIT,
+		PERM_RECORD_VIEW, PERM_RECORD_CREATE, PERM_RECORD_DELETE, PERM_RESULT_ENTER,
+		PERM_REPORT_PATIENT, PERM_REPORT_PACKAGE, PERM_REPORT_REVENUE,
+		PERM_COMPANY_MANAGE, PERM_BILLING_MANAGE, PERM_PROMOTION_MANAGE, PERM_CATEGORY_MANAGE,
+		PERM_RESEARCH_EXPORT, PERM_CLINIC_MANAGE, PERM_DATA_EXCHANGE,
+		PERM_RECORD_ASSIGN, PERM_PRACTITIONER_MANAGE, PERM_RECALL_MANAGE, PERM_NOTIFY_MANAGE,
+	},
+}
+
+// currentUser is the logged-in user, nil before login
+var currentUser *User
+
+// Password functions
+func hashPassword(password, salt string) string {
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), PASSWORD_ITERATIONS, 32)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(key)
+}
+
+func newSalt() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func setUserPassword(u *User, password string) {
+	u.Salt = newSalt()
+	u.PasswordHash = hashPassword(password, u.Salt)
+}
+
+func checkPassword(u User, password string) bool {
+	hash := hashPassword(password, u.Salt)
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(u.PasswordHash)) == 1
+}
+
+func getValidPassword(prompt string) string {
+	for {
+		password := getValidSecret(prompt)
+		if len(password) >= PASSWORD_MIN_LENGTH {
+			return password
+		}
+		printError(fmt.Sprintf("Password must be at least %d characters.", PASSWORD_MIN_LENGTH))
+	}
+}
+
+// Permission functions
+func roleHasPermission(role, perm string) bool {
+	if role == ROLE_ADMIN {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPermission(perm string) bool {
+	return currentUser != nil && currentUser.Active && roleHasPermission(currentUser.Role, perm)
+}
+
+// checkPermission reports a denial to the user without pausing
+func checkPermission(perm string) bool {
+	if hasPermission(perm) {
+		return true
+	}
+
+	role := "not logged in"
+	if currentUser != nil {
+		role = currentUser.Role
+	}
+	printError(fmt.Sprintf("Access denied: %s permission required (your role: %s).", perm, role))
+	return false
+}
+
+// requirePermission guards an action. It reports the denial to the user, so
+// callers only need to return when it fails.
+func requirePermission(perm string) bool {
+	if checkPermission(perm) {
+		return true
+	}
+	pause()
+	return false
+}
+
+// Search and ID functions
+func sequentialSearchUserByUsername(username string) int {
+	for i := 0; i < users.N; i++ {
+		if strings.EqualFold(users.Daftar[i].Username, username) {
+			return i
+		}
+	}
+	return -1
+}
+
+func binarySearchUserByID(id int) int {
+	left, right := 0, users.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if users.Daftar[mid].ID == id {
+			return mid
+		} else if users.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func getNextUserID() int {
+	maxID := USER_ID_START - 1
+	for i := 0; i < users.N; i++ {
+		if users.Daftar[i].ID > maxID {
+			maxID = users.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func countActiveAdmins() int {
+	count := 0
+	for i := 0; i < users.N; i++ {
+		if users.Daftar[i].Active && users.Daftar[i].Role == ROLE_ADMIN {
+			count++
+		}
+	}
+	return count
+}
+
+func selectRole() string {
+	fmt.Println("Roles:")
+	for i, role := range ROLES {
+		fmt.Printf("%d. %s\n", i+1, role)
+	}
+	return ROLES[getValidInt("Select role: ", 1, len(ROLES))-1]
+}
+
+// Login functions
+func createFirstAdmin() {
+	printHeader("First-Time Setup")
+	printWarning("No user accounts exist yet. Create the administrator account.")
+
+	admin := User{
+		ID:       getNextUserID(),
+		Username: getValidInput("Enter admin username: "),
+		Role:     ROLE_ADMIN,
+		Active:   true,
+	}
+	setUserPassword(&admin, getValidPassword("Enter admin password: "))
+
+	users.Daftar[users.N] = admin
+	users.N++
+	auditLog(AUDIT_CREATE, "user", admin.ID, nil, auditUser(admin))
+
+	printSuccess(fmt.Sprintf("Administrator %s created.", admin.Username))
+}
+
+// authenticate returns the index of the user with these credentials, or -1
+func authenticate(username, password string) int {
+	idx := sequentialSearchUserByUsername(username)
+	if idx == -1 || !users.Daftar[idx].Active || !checkPassword(users.Daftar[idx], password) {
+		return -1
+	}
+	return idx
+}
+
+// login asks for credentials until they are valid or the attempts run out
+func login() bool {
+	if users.N == 0 {
+		createFirstAdmin()
+	}
+
+	for attempt := 1; attempt <= MAX_LOGIN_ATTEMPTS; attempt++ {
+		printHeader("Login")
+		username := getValidInput("Username: ")
+		password := getValidSecret("Password: ")
+
+		if idx := authenticate(username, password); idx != -1 {
+			currentUser = &users.Daftar[idx]
+			auditLog(AUDIT_LOGIN, "user", currentUser.ID, nil, nil)
+			printSuccess(fmt.Sprintf("Welcome, %s (%s).", currentUser.Username, currentUser.Role))
+			return true
+		}
+
+		auditLog(AUDIT_LOGIN_FAILED, "user", 0, nil, map[string]string{"username": username})
+		printError(fmt.Sprintf("Invalid username or password (%d of %d attempts).", attempt, MAX_LOGIN_ATTEMPTS))
+		pause()
+	}
+
+	return false
+}
+
+// User management functions
+func addUser() {
+	printHeader("Add User")
+
+	if !requirePermission(PERM_USER_MANAGE) {
+		return
+	}
+
+	if users.N >= NMAX {
+		printError("Cannot add more users. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	username := getValidInput("Enter username: ")
+	if sequentialSearchUserByUsername(username) != -1 {
+		printError("This username is already taken.")
+		pause()
+		return
+	}
+
+	user := User{
+		ID:       getNextUserID(),
+		Username: username,
+		Role:     selectRole(),
+		Active:   true,
+	}
+	setUserPassword(&user, getValidPassword("Enter initial password: "))
+
+	users.Daftar[users.N] = user
+	users.N++
+	auditLog(AUDIT_CREATE, "user", user.ID, nil, auditUser(user))
+
+	printSuccess(fmt.Sprintf("User %s added with ID: %d", user.Username, user.ID))
+	pause()
+}
+
+func displayUsers() {
+	printHeader("User Accounts")
+
+	if !requirePermission(PERM_USER_MANAGE) {
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "user", 0, nil, nil)
+
+	fmt.Printf("%s%-10s %-20s %-15s %-10s %-20s%s\n", BOLD, "ID", "Username", "Role", "Status", "Branches", RESET)
+	fmt.Println(strings.Repeat("-", 79))
+
+	for i := 0; i < users.N; i++ {
+		u := users.Daftar[i]
+		status := "Active"
+		if !u.Active {
+			status = "Disabled"
+		}
+		fmt.Printf("%-10d %-20s %-15s %-10s %-20s\n", u.ID, u.Username, u.Role, status, branchCodes(u.Branches))
+	}
+
+	pause()
+}
+
+func updateUser() {
+	printHeader("Update User")
+
+	if !requirePermission(PERM_USER_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter user ID: ", 1, 999999)
+	idx := binarySearchUserByID(id)
+
+	if idx == -1 {
+		printError("User not found.")
+		pause()
+		return
+	}
+
+	u := &users.Daftar[idx]
+	before := auditUser(*u)
+	fmt.Printf("\nUser: %s (%s)\n", u.Username, u.Role)
+	fmt.Println("1. Change role  2. Reset password  3. Enable/disable account  4. Branches")
+	choice := getValidInt("Choose option: ", 1, 4)
+
+	lastAdmin := u.Role == ROLE_ADMIN && u.Active && countActiveAdmins() == 1
+
+	switch choice {
+	case 1:
+		role := selectRole()
+		if lastAdmin && role != ROLE_ADMIN {
+			printError("Cannot change the role of the last active administrator.")
+			break
+		}
+		u.Role = role
+		printSuccess(fmt.Sprintf("%s is now %s.", u.Username, u.Role))
+	case 2:
+		setUserPassword(u, getValidPassword("Enter new password: "))
+		printSuccess("Password reset.")
+	case 3:
+		if lastAdmin {
+			printError("Cannot disable the last active administrator.")
+			break
+		}
+		u.Active = !u.Active
+		if u.Active {
+			printSuccess(fmt.Sprintf("%s enabled.", u.Username))
+		} else {
+			printSuccess(fmt.Sprintf("%s disabled.", u.Username))
+		}
+	case 4:
+		if branches.N == 0 {
+			printError("No branches available. Please add the branches first.")
+			break
+		}
+		fmt.Printf("Branches now: %s\n", branchCodes(u.Branches))
+		for {
+			ids, err := parseBranchCodes(templateText("Branch codes, e.g. JKT,BDG (- for all branches): "))
+			if err != nil {
+				printError(err.Error())
+				continue
+			}
+			u.Branches = ids
+			break
+		}
+		printSuccess(fmt.Sprintf("%s works at: %s.", u.Username, branchCodes(u.Branches)))
+	}
+
+	after := auditUser(*u)
+	if !sameValue(before, after) || choice == 2 {
+		auditLog(AUDIT_UPDATE, "user", u.ID, before, after)
+	}
+
+	pause()
+}
+
+func changeOwnPassword() {
+	printHeader("Change Password")
+
+	if currentUser == nil {
+		printError("Not logged in.")
+		pause()
+		return
+	}
+
+	if !checkPassword(*currentUser, getValidSecret("Enter current password: ")) {
+		printError("Current password is incorrect.")
+		pause()
+		return
+	}
+
+	setUserPassword(currentUser, getValidPassword("Enter new password: "))
+	auditLog(AUDIT_UPDATE, "user", currentUser.ID, nil, map[string]string{"password": "changed"})
+	printSuccess("Password changed.")
+	pause()
+}
+
+func userManagement() {
+	for {
+		printHeader("User Accounts")
+		fmt.Printf("%s1.%s Add User\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Users\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Update User\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Change My Password\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Screen Privacy (masking: %s)\n", YELLOW, RESET, maskingLabel())
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 5)
+
+		switch choice {
+		case 1:
+			addUser()
+		case 2:
+			displayUsers()
+		case 3:
+			updateUser()
+		case 4:
+			changeOwnPassword()
+		case 5:
+			togglePrivacyMode()
+		case 0:
+			return
+		}
+	}
+}
+
+func exitOnFailedLogin() {
+	printError("Too many failed login attempts.")
+	os.Exit(1)
+}
@@ -0,0 +1,980 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	BRANCH_ID_START = 1
+)
+
+// Data structures
+// Branch is one clinic location. Patients are shared by all branches, while
+// packages, practitioners and records belong to one branch. Packages and
+// practitioners of branch 0 are offered at every branch.
+type Branch struct {
+	ID      int    `json:"id"`
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	Phone   string `json:"phone,omitempty"`
+}
+
+type BranchArray struct {
+	Daftar [NMAX]Branch `json:"daftar"`
+	N      int          `json:"n"`
+}
+
+// BranchSyncSettings connect a branch installation to the central store:
+// the branch it runs at and either the folder holding the central data file
+// or the address of the central server. Base is this branch's share of the
+// central store as of the last sync, and CentralIDs the highest IDs in use
+// there, so that new items here follow the other branches' ones. Conflicts
+// wait for someone to resolve them.
+type BranchSyncSettings struct {
+	HomeBranch int             `json:"home_branch,omitempty"`
+	CentralDir string          `json:"central_dir,omitempty"`
+	CentralURL string          `json:"central_url,omitempty"`
+	LastSync   string          `json:"last_sync,omitempty"`
+	Base       *BranchSnapshot `json:"base,omitempty"`
+	CentralIDs map[string]int  `json:"central_ids,omitempty"`
+	Conflicts  []SyncConflict  `json:"conflicts,omitempty"`
+}
+
+// BranchSnapshot is the part of a store that is exchanged with the central
+// store
+type BranchSnapshot struct {
+	Branches      []Branch       `json:"branches,omitempty"`
+	Patients      []Patient      `json:"patients,omitempty"`
+	Packages      []Package      `json:"packages,omitempty"`
+	Records       []Record       `json:"records,omitempty"`
+	Practitioners []Practitioner `json:"practitioners,omitempty"`
+	Erasures      []Erasure      `json:"erasures,omitempty"`
+}
+
+var (
+	branches   BranchArray
+	branchSync BranchSyncSettings
+
+	// activeBranch is the branch this session works at; 0 works across all
+	// of the user's branches
+	activeBranch int
+)
+
+// Search and ID functions
+func binarySearchBranchByID(id int) int {
+	left, right := 0, branches.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if branches.Daftar[mid].ID == id {
+			return mid
+		} else if branches.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func getNextBranchID() int {
+	maxID := BRANCH_ID_START - 1
+	for i := 0; i < branches.N; i++ {
+		if branches.Daftar[i].ID > maxID {
+			maxID = branches.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func branchByCode(code string) int {
+	for i := 0; i < branches.N; i++ {
+		if strings.EqualFold(branches.Daftar[i].Code, code) {
+			return i
+		}
+	}
+	return -1
+}
+
+func branchName(id int) string {
+	if id == 0 {
+		return "All branches"
+	}
+	if idx := binarySearchBranchByID(id); idx != -1 {
+		return branches.Daftar[idx].Name
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+// Access functions
+// userCanUseBranch reports whether a user may work at a branch. A user
+// without branches may work at all of them.
+func userCanUseBranch(u User, id int) bool {
+	if len(u.Branches) == 0 {
+		return true
+	}
+	for _, b := range u.Branches {
+		if b == id {
+			return true
+		}
+	}
+	return false
+}
+
+func canUseBranch(id int) bool {
+	return currentUser == nil || userCanUseBranch(*currentUser, id)
+}
+
+// allowedBranches lists the IDs of the branches the logged-in user may use
+func allowedBranches() []int {
+	var ids []int
+	for i := 0; i < branches.N; i++ {
+		if canUseBranch(branches.Daftar[i].ID) {
+			ids = append(ids, branches.Daftar[i].ID)
+		}
+	}
+	return ids
+}
+
+// inBranch reports whether a record made at branch id belongs to branch,
+// or with branch 0 to any branch the user may use. Records from before
+// branches were set up have branch 0.
+func inBranch(id, branch int) bool {
+	if branch != 0 {
+		return id == branch
+	}
+	return id == 0 || canUseBranch(id)
+}
+
+// offeredAt reports whether a package or practitioner of branch id is
+// available at branch; those of branch 0 are available everywhere
+func offeredAt(id, branch int) bool {
+	return id == 0 || inBranch(id, branch)
+}
+
+// branchVisible reports whether an item of branch id is shown in this
+// session
+func branchVisible(id int) bool {
+	return offeredAt(id, activeBranch)
+}
+
+// searchBranchRecordByID finds a record of a branch this session can see
+func searchBranchRecordByID(id int) int {
+	idx := searchRecordByID(id)
+	if idx != -1 && !branchVisible(records.Daftar[idx].BranchID) {
+		return -1
+	}
+	return idx
+}
+
+// branchPackages lists the indexes of the packages offered at a branch
+func branchPackages(branch int) []int {
+	var list []int
+	for i := 0; i < packages.N; i++ {
+		if offeredAt(packages.Daftar[i].BranchID, branch) {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+// branchPatients lists the patients a report for a branch covers: all of
+// them across every branch, otherwise those examined at the branch
+func branchPatients(branch int) []Patient {
+	all := patients.Daftar[:patients.N]
+	if branches.N == 0 || (branch == 0 && (currentUser == nil || len(currentUser.Branches) == 0)) {
+		return all
+	}
+	examined := make(map[int]bool)
+	for i := 0; i < records.N; i++ {
+		if inBranch(records.Daftar[i].BranchID, branch) {
+			examined[records.Daftar[i].Patient.ID] = true
+		}
+	}
+	var list []Patient
+	for _, p := range all {
+		if examined[p.ID] {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// Selection functions
+// selectBranch asks for one of the user's branches by ID. With an allLabel,
+// 0 stands for all of them.
+func selectBranch(prompt, allLabel string) int {
+	allowed := allowedBranches()
+	for _, id := range allowed {
+		b := branches.Daftar[binarySearchBranchByID(id)]
+		fmt.Printf("%d. %s - %s\n", b.ID, b.Code, b.Name)
+	}
+	min := 1
+	if allLabel != "" {
+		fmt.Printf("0. %s\n", allLabel)
+		min = 0
+	}
+	for {
+		id := getValidInt(prompt, min, 999999)
+		if id == 0 {
+			return 0
+		}
+		for _, b := range allowed {
+			if b == id {
+				return id
+			}
+		}
+		printError("Please choose one of the branches listed.")
+	}
+}
+
+// chooseBranch sets the branch of the session after login. A branch
+// installation always works at its own branch; elsewhere a user with several
+// branches picks one, or all of them.
+func chooseBranch() bool {
+	activeBranch = 0
+	if branches.N == 0 {
+		return true
+	}
+
+	if home := branchSync.HomeBranch; home != 0 {
+		if !canUseBranch(home) {
+			printError(fmt.Sprintf("Your account is not assigned to %s.", branchName(home)))
+			return false
+		}
+		activeBranch = home
+		return true
+	}
+
+	allowed := allowedBranches()
+	switch len(allowed) {
+	case 0:
+		printError("Your account is not assigned to any existing branch. Ask an administrator.")
+		return false
+	case 1:
+		activeBranch = allowed[0]
+	default:
+		printHeader("Select Branch")
+		activeBranch = selectBranch("Work at branch: ", "All of my branches")
+	}
+	return true
+}
+
+// recordBranch returns the branch a new record is made at, asking when the
+// session works across branches
+func recordBranch() int {
+	if activeBranch != 0 || branches.N == 0 {
+		return activeBranch
+	}
+	fmt.Println("\nBranches:")
+	return selectBranch("Branch of this check-up: ", "")
+}
+
+// getReportBranch asks which branch a report covers; 0 consolidates all of
+// the user's branches
+func getReportBranch() int {
+	if branches.N == 0 {
+		return 0
+	}
+	if allowed := allowedBranches(); len(allowed) == 1 {
+		return allowed[0]
+	}
+	fmt.Println("\nReport for branch:")
+	return selectBranch("Choose branch (0 for consolidated): ", "All of them (consolidated)")
+}
+
+// setReportBranch names the branch a report covers, once branches are set up
+func setReportBranch(report *Report, branch int) {
+	if branches.N == 0 {
+		return
+	}
+	switch {
+	case branch != 0:
+		report.Branch = branchName(branch)
+	case currentUser != nil && len(currentUser.Branches) > 0:
+		var names []string
+		for _, id := range allowedBranches() {
+			names = append(names, branchName(id))
+		}
+		report.Branch = "Consolidated: " + strings.Join(names, ", ")
+	default:
+		report.Branch = "All branches (consolidated)"
+	}
+}
+
+// branchInUse reports what still belongs to a branch, or "" when nothing does
+func branchInUse(id int) string {
+	if branchSync.HomeBranch == id {
+		return "this installation runs at it"
+	}
+	for i := 0; i < records.N; i++ {
+		if records.Daftar[i].BranchID == id {
+			return "it has medical records"
+		}
+	}
+	for i := 0; i < packages.N; i++ {
+		if packages.Daftar[i].BranchID == id {
+			return "it has packages"
+		}
+	}
+	for i := 0; i < practitioners.N; i++ {
+		if practitioners.Daftar[i].BranchID == id {
+			return "it has practitioners"
+		}
+	}
+	for i := 0; i < users.N; i++ {
+		for _, b := range users.Daftar[i].Branches {
+			if b == id {
+				return "user accounts are assigned to it"
+			}
+		}
+	}
+	return ""
+}
+
+// parseBranchCodes reads branch codes such as "JKT,BDG" into branch IDs
+func parseBranchCodes(input string) ([]int, error) {
+	var ids []int
+	for _, code := range strings.Split(input, ",") {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		idx := branchByCode(code)
+		if idx == -1 {
+			return nil, fmt.Errorf("there is no branch with code %q", code)
+		}
+		ids = append(ids, branches.Daftar[idx].ID)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func branchCodes(ids []int) string {
+	if len(ids) == 0 {
+		return "All"
+	}
+	var codes []string
+	for _, id := range ids {
+		if idx := binarySearchBranchByID(id); idx != -1 {
+			codes = append(codes, branches.Daftar[idx].Code)
+		} else {
+			codes = append(codes, fmt.Sprintf("#%d", id))
+		}
+	}
+	return strings.Join(codes, ",")
+}
+
+// Branch management functions
+func addBranch() {
+	printHeader("Add Branch")
+
+	if !requirePermission(PERM_BRANCH_MANAGE) {
+		return
+	}
+
+	if branches.N >= NMAX {
+		printError("Cannot add more branches. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	b := Branch{ID: getNextBranchID()}
+	b.Code = strings.ToUpper(getValidInput("Enter branch code (e.g. JKT): "))
+	if branchByCode(b.Code) != -1 {
+		printError("A branch with this code already exists.")
+		pause()
+		return
+	}
+	b.Name = getValidInput("Enter branch name: ")
+	b.Address = templateText("Enter address (- for none): ")
+	b.Phone = templateText("Enter phone (- for none): ")
+
+	branches.Daftar[branches.N] = b
+	branches.N++
+	auditLog(AUDIT_CREATE, "branch", b.ID, nil, b)
+
+	printSuccess(fmt.Sprintf("Branch added successfully with ID: %d", b.ID))
+	if branches.N == 1 {
+		fmt.Println("Existing packages and practitioners stay available at every branch until you assign them to one.")
+	}
+	pause()
+}
+
+func displayBranches() {
+	printHeader("Branches")
+
+	if branches.N == 0 {
+		printWarning("No branches found. The clinic works as a single location.")
+		pause()
+		return
+	}
+
+	fmt.Printf("%s%-5s %-6s %-25s %-30s %-15s %-8s%s\n", BOLD, "ID", "Code", "Name", "Address", "Phone", "Records", RESET)
+	fmt.Println(strings.Repeat("-", 94))
+
+	for i := 0; i < branches.N; i++ {
+		b := branches.Daftar[i]
+		count := 0
+		for j := 0; j < records.N; j++ {
+			if records.Daftar[j].BranchID == b.ID {
+				count++
+			}
+		}
+		marker := ""
+		if b.ID == activeBranch {
+			marker = " *"
+		}
+		fmt.Printf("%-5d %-6s %-25s %-30s %-15s %-8d%s\n", b.ID, b.Code, b.Name, b.Address, b.Phone, count, marker)
+	}
+	if activeBranch != 0 {
+		fmt.Println("\n* the branch you are working at")
+	}
+
+	pause()
+}
+
+func editBranch() {
+	printHeader("Edit Branch")
+
+	if !requirePermission(PERM_BRANCH_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter branch ID: ", 1, 999999)
+	idx := binarySearchBranchByID(id)
+
+	if idx == -1 {
+		printError("Branch not found.")
+		pause()
+		return
+	}
+
+	b := &branches.Daftar[idx]
+	before := *b
+	fmt.Printf("1. Code: %s\n", b.Code)
+	fmt.Printf("2. Name: %s\n", b.Name)
+	fmt.Printf("3. Address: %s\n", b.Address)
+	fmt.Printf("4. Phone: %s\n", b.Phone)
+
+	choice := getValidInt("\nChoose field to change (0 to return): ", 0, 4)
+	switch choice {
+	case 0:
+		return
+	case 1:
+		code := strings.ToUpper(getValidInput("Enter branch code: "))
+		if other := branchByCode(code); other != -1 && other != idx {
+			printError("A branch with this code already exists.")
+			pause()
+			return
+		}
+		b.Code = code
+	case 2:
+		b.Name = getValidInput("Enter branch name: ")
+	case 3:
+		b.Address = templateText("Enter address (- for none): ")
+	case 4:
+		b.Phone = templateText("Enter phone (- for none): ")
+	}
+
+	auditLog(AUDIT_UPDATE, "branch", b.ID, before, *b)
+	printSuccess("Branch updated.")
+	pause()
+}
+
+func deleteBranch() {
+	printHeader("Delete Branch")
+
+	if !requirePermission(PERM_BRANCH_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter branch ID to delete: ", 1, 999999)
+	idx := binarySearchBranchByID(id)
+
+	if idx == -1 {
+		printError("Branch not found.")
+		pause()
+		return
+	}
+
+	b := branches.Daftar[idx]
+	if reason := branchInUse(b.ID); reason != "" {
+		printError(fmt.Sprintf("%s cannot be deleted: %s.", b.Name, reason))
+		pause()
+		return
+	}
+
+	confirm := getValidInput(fmt.Sprintf("\nAre you sure you want to delete %s? (y/N): ", b.Name))
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Deletion cancelled.")
+		pause()
+		return
+	}
+
+	// Shift elements to remove the branch
+	for i := idx; i < branches.N-1; i++ {
+		branches.Daftar[i] = branches.Daftar[i+1]
+	}
+	branches.N--
+	if activeBranch == b.ID {
+		activeBranch = 0
+	}
+	auditLog(AUDIT_DELETE, "branch", b.ID, b, nil)
+
+	printSuccess("Branch deleted successfully.")
+	pause()
+}
+
+func switchBranch() {
+	printHeader("Switch Branch")
+
+	if branches.N == 0 {
+		printWarning("No branches found.")
+		pause()
+		return
+	}
+	if branchSync.HomeBranch != 0 {
+		printWarning(fmt.Sprintf("This installation runs at %s. Other branches' data is in the central store.", branchName(branchSync.HomeBranch)))
+		pause()
+		return
+	}
+	if len(allowedBranches()) < 2 {
+		printWarning(fmt.Sprintf("Your account works at %s only.", branchName(activeBranch)))
+		pause()
+		return
+	}
+
+	fmt.Printf("Working at: %s\n\n", branchName(activeBranch))
+	activeBranch = selectBranch("Work at branch: ", "All of my branches")
+	printSuccess(fmt.Sprintf("Now working at: %s.", branchName(activeBranch)))
+	pause()
+}
+
+// Central store functions
+func editCentralSettings() {
+	printHeader("Central Store")
+
+	if !requirePermission(PERM_BRANCH_MANAGE) {
+		return
+	}
+
+	if branches.N == 0 {
+		printError("No branches available. Please add the branches first.")
+		pause()
+		return
+	}
+
+	before := branchSync
+	fmt.Printf("This installation runs at: %s\n", homeBranchText())
+	fmt.Printf("Central store: %s\n", centralText())
+	fmt.Printf("Last sync: %s\n\n", textOrDash(branchSync.LastSync))
+
+	fmt.Println("Branch of this installation:")
+	for i := 0; i < branches.N; i++ {
+		b := branches.Daftar[i]
+		fmt.Printf("%d. %s - %s\n", b.ID, b.Code, b.Name)
+	}
+	fmt.Println("0. None (the central store itself, or a single location)")
+	home := -1
+	for home == -1 {
+		home = getValidInt("Choose branch: ", 0, 999999)
+		if home != 0 && binarySearchBranchByID(home) == -1 {
+			printError("Please choose one of the branches listed.")
+			home = -1
+		}
+	}
+
+	dir, url := "", ""
+	if home != 0 {
+		fmt.Println("\nThe central store is reached through:")
+		fmt.Println("1. A shared folder (e.g. a network drive)")
+		fmt.Println("2. A central server (\"branch serve\" at the central installation)")
+		if getValidInt("Choose option: ", 1, 2) == 1 {
+			dir = getValidInput("Folder of the central store: ")
+		} else {
+			url = getCentralURL()
+		}
+	}
+
+	// A sync base only applies to the store and branch it was made with
+	if home != branchSync.HomeBranch || dir != branchSync.CentralDir || url != branchSync.CentralURL {
+		branchSync = BranchSyncSettings{HomeBranch: home, CentralDir: dir, CentralURL: url}
+		lastSyncAttempt, centralOffline = time.Time{}, false
+	}
+	if home != 0 {
+		activeBranch = home
+	}
+
+	auditLog(AUDIT_UPDATE, "branch_sync", home,
+		map[string]interface{}{"home_branch": before.HomeBranch, "central_dir": before.CentralDir, "central_url": before.CentralURL},
+		map[string]interface{}{"home_branch": branchSync.HomeBranch, "central_dir": branchSync.CentralDir, "central_url": branchSync.CentralURL})
+	printSuccess(fmt.Sprintf("This installation runs at: %s.", homeBranchText()))
+	pause()
+}
+
+func homeBranchText() string {
+	if branchSync.HomeBranch == 0 {
+		return "- (central store or single location)"
+	}
+	return branchName(branchSync.HomeBranch)
+}
+
+func centralText() string {
+	if branchSync.CentralURL != "" {
+		return branchSync.CentralURL
+	}
+	return textOrDash(branchSync.CentralDir)
+}
+
+func textOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// branchSnapshot takes a branch's share of a store: all branches and
+// patients and erasures, and the packages, practitioners and records of the
+// branch. Branch 0 takes everything.
+func branchSnapshot(d DataStore, branch int) BranchSnapshot {
+	s := BranchSnapshot{
+		Branches: append([]Branch(nil), d.Branches.Daftar[:d.Branches.N]...),
+		Patients: append([]Patient(nil), d.Patients.Daftar[:d.Patients.N]...),
+		Erasures: append([]Erasure(nil), d.Erasures...),
+	}
+	for _, p := range d.Packages.Daftar[:d.Packages.N] {
+		if branch == 0 || p.BranchID == 0 || p.BranchID == branch {
+			s.Packages = append(s.Packages, p)
+		}
+	}
+	for _, p := range d.Practitioners.Daftar[:d.Practitioners.N] {
+		if branch == 0 || p.BranchID == 0 || p.BranchID == branch {
+			s.Practitioners = append(s.Practitioners, p)
+		}
+	}
+	for _, r := range d.Records.Daftar[:d.Records.N] {
+		if branch == 0 || r.BranchID == branch {
+			s.Records = append(s.Records, r)
+		}
+	}
+	return s
+}
+
+// snapshotStore turns a snapshot back into a store for merging. The trash
+// is passed along so that renumbered items never reuse a deleted ID.
+func snapshotStore(s *BranchSnapshot, bin TrashArray) DataStore {
+	d := DataStore{Trash: bin}
+	if s == nil {
+		return d
+	}
+	d.Branches.N = copy(d.Branches.Daftar[:], s.Branches)
+	d.Patients.N = copy(d.Patients.Daftar[:], s.Patients)
+	d.Packages.N = copy(d.Packages.Daftar[:], s.Packages)
+	d.Practitioners.N = copy(d.Practitioners.Daftar[:], s.Practitioners)
+	d.Records.N = copy(d.Records.Daftar[:], s.Records)
+	d.Erasures = append([]Erasure(nil), s.Erasures...)
+	return d
+}
+
+// countChanges counts the items that are new or different in to
+func countChanges(from, to BranchSnapshot) int {
+	return changedItems(from.Branches, to.Branches, branchKey) +
+		changedItems(from.Patients, to.Patients, patientKey) +
+		changedItems(from.Packages, to.Packages, packageKey) +
+		changedItems(from.Practitioners, to.Practitioners, practitionerKey) +
+		changedItems(from.Records, to.Records, recordKey)
+}
+
+func changedItems[T any](from, to []T, key func(T) string) int {
+	index := indexByKey(from, key)
+	count := 0
+	for _, item := range to {
+		if old := index[key(item)]; old == nil || !sameContent(*old, item) {
+			count++
+		}
+	}
+	return count
+}
+
+// syncWithCentral merges this branch's share of the data with the central
+// store, the same way a save merges the changes of two terminals: whatever
+// changed on one side since the last sync is taken. Patients and records
+// that both sides changed are merged field by field, the later change
+// winning where both changed the same field; other items changed on both
+// sides keep the central version and the conflict is reported. It runs
+// while the local data file is locked, and starts again when another branch
+// synced in between.
+func syncWithCentral() (sent, received int, conflicts []DataConflict, err error) {
+	for attempt := 1; ; attempt++ {
+		sent, received, conflicts, err = syncWithCentralOnce()
+		if !errors.Is(err, ErrCentralChanged) || attempt == BRANCH_SYNC_RETRIES {
+			return sent, received, conflicts, err
+		}
+	}
+}
+
+func syncWithCentralOnce() (sent, received int, conflicts []DataConflict, err error) {
+	home := branchSync.HomeBranch
+	store, err := openCentralStore()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer store.Close()
+
+	central, err := store.Load()
+	if err != nil {
+		if errors.Is(err, ErrWrongKey) {
+			err = fmt.Errorf("the central store is encrypted with a different key; all branches must use the same data key")
+		}
+		return 0, 0, nil, err
+	}
+
+	// Records from before branches were set up were made here
+	for i := 0; i < records.N; i++ {
+		if records.Daftar[i].BranchID == 0 {
+			records.Daftar[i].BranchID = home
+		}
+	}
+	bumpVersions(syncBase)
+
+	local := currentDataStore()
+	mine, ours := branchSnapshot(local, home), branchSnapshot(local, home)
+	before, theirs := branchSnapshot(central, 0), branchSnapshot(central, 0)
+	claimLegacyRecords(&theirs, ours, home)
+	fields, err := mergeChangedFields(branchSync.Base, &ours, &theirs)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	merged, conflicts := mergeDataStores(snapshotStore(branchSync.Base, local.Trash),
+		snapshotStore(&ours, local.Trash), snapshotStore(&theirs, local.Trash))
+
+	// Our new items that were saved under other IDs are renumbered here too
+	for _, c := range conflicts {
+		id, _ := strconv.Atoi(c.Key)
+		switch {
+		case c.NewID == 0:
+		case c.Entity == ENTITY_PATIENT:
+			renumberPatient(&local, id, c.NewID, 0)
+		case c.Entity == ENTITY_PACKAGE:
+			renumberPackage(&local, id, c.NewID, 0)
+		case c.Entity == ENTITY_RECORD:
+			renumberRecord(&local, id, c.NewID, 0)
+		}
+	}
+
+	central.Branches, central.Patients = merged.Branches, merged.Patients
+	central.Packages, central.Practitioners, central.Records = merged.Packages, merged.Practitioners, merged.Records
+	central.Erasures = merged.Erasures
+	central.Revision++
+	plaintext, err := json.MarshalIndent(central, "", "  ")
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to encode the central store: %v", err)
+	}
+	content, err := encryptStore(plaintext, dataKey)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to encrypt the central store: %v", err)
+	}
+	if err := store.Save(content); err != nil {
+		return 0, 0, nil, err
+	}
+
+	// Keep the branch's share of the merged data here
+	share := branchSnapshot(merged, home)
+	shareStore := snapshotStore(&share, local.Trash)
+	local.Branches, local.Patients = shareStore.Branches, shareStore.Patients
+	local.Packages, local.Practitioners, local.Records = shareStore.Packages, shareStore.Practitioners, shareStore.Records
+	local.Erasures = shareStore.Erasures
+	applyDataStore(local)
+
+	sent, received = countChanges(before, branchSnapshot(merged, 0)), countChanges(mine, share)
+	branchSync.Base = &share
+	branchSync.CentralIDs = map[string]int{
+		ENTITY_PATIENT: storeMaxID(central, ENTITY_PATIENT),
+		ENTITY_PACKAGE: storeMaxID(central, ENTITY_PACKAGE),
+		ENTITY_RECORD:  storeMaxID(central, ENTITY_RECORD),
+		"practitioner": maxKey(central.Practitioners.Daftar[:central.Practitioners.N], practitionerKey),
+	}
+	branchSync.LastSync = time.Now().Format("02/01/2006 15:04")
+
+	for i := range conflicts {
+		conflicts[i].Reason = strings.Replace(conflicts[i].Reason, "another terminal", "another branch", 1)
+	}
+	auditConflicts(conflicts)
+	// Conflicts about erased patients are not kept; the erasure decided them
+	erasedRecords := erasedRecordIDs(merged.Erasures, mine.Records, theirs.Records)
+	var open []SyncConflict
+	for _, c := range fields {
+		if !isErasedSyncConflict(merged.Erasures, erasedRecords, c) {
+			open = append(open, c)
+		}
+	}
+	queueSyncConflicts(open)
+	auditLog(AUDIT_UPDATE, "branch_sync", home, nil, map[string]interface{}{
+		"central": store.String(), "revision": central.Revision, "sent": sent, "received": received,
+		"conflicts": len(conflicts), "field_conflicts": len(fields),
+	})
+	return sent, received, conflicts, nil
+}
+
+// claimLegacyRecords gives the home branch the central records from before
+// branches were set up that it holds unchanged, so that a central store
+// started from this branch's data does not see them as new
+func claimLegacyRecords(central *BranchSnapshot, ours BranchSnapshot, home int) {
+	own := indexByKey(ours.Records, recordKey)
+	for i := range central.Records {
+		r := &central.Records[i]
+		if r.BranchID != 0 || own[recordKey(*r)] == nil {
+			continue
+		}
+		claimed := *r
+		claimed.BranchID = home
+		if sameContent(claimed, *own[recordKey(*r)]) {
+			*r = claimed
+		}
+	}
+}
+
+// runCentralSync saves this installation's changes and syncs them with the
+// central store under the local data lock
+func runCentralSync() (sent, received int, conflicts []DataConflict, err error) {
+	var syncErr error
+	saved, err := commitData(func() {
+		sent, received, conflicts, syncErr = syncWithCentral()
+	})
+	conflicts = append(saved, conflicts...)
+	if err == nil {
+		err = syncErr
+	}
+	lastSyncAttempt = time.Now()
+	centralOffline = errors.Is(err, ErrCentralUnreachable)
+	return sent, received, conflicts, err
+}
+
+func syncCentralStore() {
+	printHeader("Sync with Central Store")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	fmt.Printf("Branch: %s\nCentral store: %s\n\n", homeBranchText(), centralText())
+	sent, received, conflicts, err := runCentralSync()
+	printSyncResult(sent, received, conflicts, err)
+	pause()
+}
+
+func printSyncResult(sent, received int, conflicts []DataConflict, err error) {
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "The central store")
+	}
+	switch {
+	case errors.Is(err, ErrCentralUnreachable):
+		printWarning(fmt.Sprintf("Sync failed: %v", err))
+		printWarning(fmt.Sprintf("Your changes are kept here and sent on the next sync (%d waiting).", pendingChanges()))
+	case err != nil:
+		printError(fmt.Sprintf("Sync failed: %v", err))
+	default:
+		printSuccess(fmt.Sprintf("Synced with the central store: %d changes sent, %d received.", sent, received))
+	}
+	if n := len(branchSync.Conflicts); n > 0 {
+		printWarning(fmt.Sprintf("Sync conflicts to resolve: %d (Branches -> Sync Conflicts).", n))
+	}
+}
+
+func branchCommand(args []string) int {
+	switch {
+	case len(args) == 1 && args[0] == "sync":
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "serve":
+		return branchServeCommand(args[1:])
+	default:
+		fmt.Println("Usage: branch sync | branch serve [address]")
+		return 2
+	}
+	if !checkPermission(PERM_DATA_EXCHANGE) {
+		return 1
+	}
+
+	sent, received, conflicts, err := runCentralSync()
+	printSyncResult(sent, received, conflicts, err)
+	if err != nil || len(conflicts) > 0 || len(branchSync.Conflicts) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// branchServeCommand runs "branch serve [address]" at the central
+// installation until Enter or Ctrl+C
+func branchServeCommand(args []string) int {
+	if !checkPermission(PERM_BRANCH_MANAGE) || !centralServerAllowed() {
+		return 1
+	}
+
+	address := BRANCH_SYNC_ADDRESS
+	if len(args) > 0 {
+		address = args[0]
+	}
+	err := serveCentralStore(address, func(line string) {
+		fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), line)
+	}, func() {
+		fmt.Println("Press Enter or Ctrl+C to stop.")
+		// Without a terminal (e.g. run as a service) only Ctrl+C stops it
+		if _, ok := nextLine(); !ok {
+			select {}
+		}
+	})
+	if err != nil {
+		printError(err.Error())
+		return 1
+	}
+	return 0
+}
+
+func branchManagement() {
+	for {
+		printHeader("Branches")
+		fmt.Printf("Working at: %s\n\n", branchName(activeBranch))
+		fmt.Printf("%s1.%s Add Branch\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Branches\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Edit Branch\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Delete Branch\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Switch Branch\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Central Store Settings\n", YELLOW, RESET)
+		fmt.Printf("%s7.%s Sync with Central Store\n", YELLOW, RESET)
+		fmt.Printf("%s8.%s Sync Conflicts (%d)\n", YELLOW, RESET, len(branchSync.Conflicts))
+		fmt.Printf("%s9.%s Run Central Server\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 9)
+
+		switch choice {
+		case 1:
+			addBranch()
+		case 2:
+			displayBranches()
+		case 3:
+			editBranch()
+		case 4:
+			deleteBranch()
+		case 5:
+			switchBranch()
+		case 6:
+			editCentralSettings()
+		case 7:
+			syncCentralStore()
+		case 8:
+			resolveSyncConflicts()
+		case 9:
+			runCentralServer()
+		case 0:
+			return
+		}
+	}
+}
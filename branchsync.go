@@ -0,0 +1,841 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	BRANCH_SYNC_ADDRESS  = "127.0.0.1:8470"
+	BRANCH_SYNC_PATH     = "/branch-sync/store"
+	BRANCH_SYNC_TIMEOUT  = 10 * time.Second
+	BRANCH_SYNC_INTERVAL = 5 * time.Minute
+	BRANCH_SYNC_RETRIES  = 3
+	BRANCH_SYNC_MAX_SIZE = 64 << 20
+
+	// Field of a conflict about a whole item, e.g. a signed-off record
+	SYNC_WHOLE_ITEM = "*"
+)
+
+var (
+	// ErrCentralUnreachable means the central store could not be reached;
+	// the branch keeps working on its own data and syncs later
+	ErrCentralUnreachable = errors.New("central store not reachable")
+
+	// ErrCentralChanged means another branch synced between fetching and
+	// sending the central store; the sync starts again
+	ErrCentralChanged = errors.New("central store changed during the sync")
+)
+
+// Data structures
+
+// SyncConflict is a field of a patient or record that this branch and
+// another one both changed since the last sync. The value of the later change
+// was kept everywhere; the other one waits here until someone resolves it.
+// A signed-off record is never merged field by field: its conflict is about
+// the whole record (field SYNC_WHOLE_ITEM) and the signed version is kept.
+type SyncConflict struct {
+	ID       int    `json:"id"`
+	Entity   string `json:"entity"`
+	ItemID   int    `json:"item_id"`
+	Field    string `json:"field"`
+	Kept     string `json:"kept,omitempty"`
+	Other    string `json:"other,omitempty"`
+	KeptFrom string `json:"kept_from"`
+	KeptAt   string `json:"kept_at,omitempty"`
+	OtherAt  string `json:"other_at,omitempty"`
+	FoundAt  string `json:"found_at"`
+}
+
+// centralStore is the central data as one sync sees it: Load is followed by
+// at most one Save, and Close ends the sync. A store changed by another
+// branch in between refuses the Save with ErrCentralChanged.
+type centralStore interface {
+	Load() (DataStore, error)
+	Save(content []byte) error
+	Close()
+	String() string
+}
+
+// folderStore is a central data file in a shared folder, locked for the
+// whole sync
+type folderStore struct {
+	path   string
+	locked bool
+}
+
+// serverStore is a central installation running "branch serve"
+type serverStore struct {
+	url    string
+	client *http.Client
+	tag    string
+}
+
+// Sync state of this session
+var (
+	lastSyncAttempt time.Time
+	centralOffline  bool
+)
+
+// Central store functions
+func openCentralStore() (centralStore, error) {
+	switch {
+	case branchSync.HomeBranch == 0:
+		return nil, fmt.Errorf("set the branch of this installation and the central store first")
+	case branchSync.CentralURL != "":
+		return &serverStore{url: strings.TrimSuffix(branchSync.CentralURL, "/") + BRANCH_SYNC_PATH,
+			client: &http.Client{Timeout: BRANCH_SYNC_TIMEOUT}}, nil
+	case branchSync.CentralDir != "":
+		return &folderStore{path: filepath.Join(branchSync.CentralDir, DATA_FILE)}, nil
+	}
+	return nil, fmt.Errorf("set the branch of this installation and the central store first")
+}
+
+func (f *folderStore) Load() (DataStore, error) {
+	// A network drive that is not connected looks like a missing folder
+	if _, err := os.Stat(filepath.Dir(f.path)); err != nil {
+		return DataStore{}, fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	if err := acquireLock(f.path); err != nil {
+		return DataStore{}, err
+	}
+	f.locked = true
+	return readDataStore(f.path, dataKey.Key)
+}
+
+func (f *folderStore) Save(content []byte) error {
+	return writeDataFile(f.path, content)
+}
+
+func (f *folderStore) Close() {
+	if f.locked {
+		releaseLock(f.path)
+		f.locked = false
+	}
+}
+
+func (f *folderStore) String() string { return filepath.Dir(f.path) }
+
+func (s *serverStore) Load() (DataStore, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return DataStore{}, fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, BRANCH_SYNC_MAX_SIZE))
+	if err != nil {
+		return DataStore{}, fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return DataStore{}, fmt.Errorf("central server answered %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	s.tag = resp.Header.Get("ETag")
+	return decodeDataStore(content, dataKey.Key)
+}
+
+func (s *serverStore) Save(content []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", s.tag)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		s.tag = resp.Header.Get("ETag")
+		return nil
+	case http.StatusPreconditionFailed:
+		return ErrCentralChanged
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("central server answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (s *serverStore) Close() {}
+
+func (s *serverStore) String() string { return strings.TrimSuffix(s.url, BRANCH_SYNC_PATH) }
+
+// storeTag identifies one version of a data file
+func storeTag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Server functions
+
+// centralHandler serves the central data file to branch installations. A
+// branch fetches it, merges its changes in and sends it back; a store that
+// changed since it was fetched is refused, and the branch merges again. The
+// store travels encrypted with the data key all branches share, and a store
+// that key does not open is refused.
+func centralHandler(path string, key []byte, report func(string)) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(BRANCH_SYNC_PATH, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := acquireLock(path); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer releaseLock(path)
+
+		content, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, "cannot read the central store", http.StatusInternalServerError)
+			return
+		}
+		tag := storeTag(content)
+
+		if r.Method == http.MethodGet {
+			w.Header().Set("ETag", tag)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(content)
+			report(fmt.Sprintf("%s fetched the store", r.RemoteAddr))
+			return
+		}
+
+		if r.Header.Get("If-Match") != tag {
+			http.Error(w, ErrCentralChanged.Error(), http.StatusPreconditionFailed)
+			report(fmt.Sprintf("%s sent an outdated store; it syncs again", r.RemoteAddr))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, BRANCH_SYNC_MAX_SIZE))
+		if err != nil {
+			http.Error(w, "store too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		store, encrypted := parseEncryptedStore(body)
+		if !encrypted {
+			http.Error(w, "the store must be encrypted with the data key", http.StatusBadRequest)
+			return
+		}
+		plaintext, err := decryptStore(store, key)
+		if err != nil {
+			http.Error(w, "the store is not encrypted with this store's data key", http.StatusForbidden)
+			report(fmt.Sprintf("%s sent a store encrypted with another key; refused", r.RemoteAddr))
+			return
+		}
+		var data DataStore
+		if err := json.Unmarshal(plaintext, &data); err != nil {
+			http.Error(w, "the store cannot be read", http.StatusBadRequest)
+			return
+		}
+		if err := writeDataFile(path, body); err != nil {
+			http.Error(w, "cannot write the central store", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", storeTag(body))
+		w.WriteHeader(http.StatusNoContent)
+		report(fmt.Sprintf("%s saved revision %d", r.RemoteAddr, data.Revision))
+	})
+	return mux
+}
+
+// serveCentralStore runs the central server until stop returns
+func serveCentralStore(address string, report func(string), stop func()) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", address, err)
+	}
+	server := &http.Server{Handler: centralHandler(DATA_FILE, dataKey.Key, report), ReadHeaderTimeout: BRANCH_SYNC_TIMEOUT}
+	auditLog(AUDIT_VIEW, "branch_server", 0, nil, map[string]string{"address": ln.Addr().String()})
+	printSuccess(fmt.Sprintf("Serving the central store on http://%s. Branches set this address as their central server.", ln.Addr()))
+
+	done := make(chan bool)
+	go func() {
+		server.Serve(ln)
+		done <- true
+	}()
+	stop()
+	server.Close()
+	<-done
+	return nil
+}
+
+// isValidCentralURL accepts http too: the central server serves plain http
+// and everything it sends is already encrypted with the shared key
+func isValidCentralURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func getCentralURL() string {
+	for {
+		raw := getValidInput(fmt.Sprintf("Address of the central server (e.g. http://%s): ", BRANCH_SYNC_ADDRESS))
+		if isValidCentralURL(raw) {
+			return raw
+		}
+		printError("Please enter a full http:// or https:// address.")
+	}
+}
+
+// centralServerAllowed checks that this installation holds the central store
+func centralServerAllowed() bool {
+	if branchSync.HomeBranch != 0 {
+		printError(fmt.Sprintf("This installation runs at %s. Run the central server where the central store is kept.", branchName(branchSync.HomeBranch)))
+		return false
+	}
+	return true
+}
+
+func runCentralServer() {
+	printHeader("Central Server")
+
+	if !requirePermission(PERM_BRANCH_MANAGE) {
+		return
+	}
+	if !centralServerAllowed() {
+		pause()
+		return
+	}
+
+	address := getValidInput(fmt.Sprintf("Listen address (e.g. %s): ", BRANCH_SYNC_ADDRESS))
+	err := serveCentralStore(address, func(line string) {
+		fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), line)
+		touchActivity()
+	}, func() {
+		readLine("Press Enter to stop.\n")
+	})
+	if err != nil {
+		printError(err.Error())
+	} else {
+		printSuccess("Central server stopped.")
+	}
+	pause()
+}
+
+// Field merge functions
+
+// mergeFields merges two changed copies of an item field by field. A field
+// changed on one side takes that change. A field both sides changed to
+// different values takes the value of the later change (ours if oursLater)
+// and is returned in conflicts.
+func mergeFields[T any](base, ours, theirs T, oursLater bool) (merged T, conflicts []string, err error) {
+	b, err1 := fieldsOf(base)
+	o, err2 := fieldsOf(ours)
+	t, err3 := fieldsOf(theirs)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return merged, nil, err
+	}
+
+	result := make(map[string]json.RawMessage)
+	take := func(k string, v json.RawMessage) {
+		if v != nil {
+			result[k] = v
+		}
+	}
+	for _, k := range fieldNames(b, o, t) {
+		switch {
+		case k == "version" || k == "updated_at":
+		case bytes.Equal(o[k], b[k]):
+			take(k, t[k])
+		case bytes.Equal(t[k], b[k]) || bytes.Equal(o[k], t[k]):
+			take(k, o[k])
+		case oursLater:
+			take(k, o[k])
+			conflicts = append(conflicts, k)
+		default:
+			take(k, t[k])
+			conflicts = append(conflicts, k)
+		}
+	}
+
+	// The merged item is a new version of both, changed at the later time
+	result["version"] = json.RawMessage(strconv.Itoa(max(versionOf(ours), versionOf(theirs)) + 1))
+	if oursLater {
+		take("updated_at", o["updated_at"])
+	} else {
+		take("updated_at", t["updated_at"])
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return merged, nil, err
+	}
+	err = json.Unmarshal(content, &merged)
+	return merged, conflicts, err
+}
+
+// keepWhole keeps one side's item as it is, as a new version of both
+func keepWhole[T any](ours, theirs T, keepOurs bool) (T, []string, error) {
+	kept := theirs
+	if keepOurs {
+		kept = ours
+	}
+	version := strconv.Itoa(max(versionOf(ours), versionOf(theirs)) + 1)
+	err := setField(&kept, "version", version)
+	return kept, []string{SYNC_WHOLE_ITEM}, err
+}
+
+func fieldsOf(v interface{}) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(content, &fields)
+}
+
+func fieldNames(maps ...map[string]json.RawMessage) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// changedLater reports whether a change made at a came after one made at b.
+// A change without a time is older than any other.
+func changedLater(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	switch {
+	case errA != nil:
+		return false
+	case errB != nil:
+		return true
+	}
+	return ta.After(tb)
+}
+
+// mergeChangedFields merges the patients and records that both this branch
+// and the central store changed since the last sync, so that the item merge
+// that follows sees the same version on both sides. Fields that both changed
+// are returned as conflicts.
+func mergeChangedFields(base *BranchSnapshot, ours, theirs *BranchSnapshot) ([]SyncConflict, error) {
+	if base == nil {
+		return nil, nil
+	}
+	var conflicts []SyncConflict
+	patientConflicts, err := mergeChangedItems(ENTITY_PATIENT, base.Patients, ours.Patients, theirs.Patients, patientKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	conflicts = append(conflicts, patientConflicts...)
+	recordConflicts, err := mergeChangedItems(ENTITY_RECORD, base.Records, ours.Records, theirs.Records, recordKey, isSignedOff)
+	if err != nil {
+		return nil, err
+	}
+	return append(conflicts, recordConflicts...), nil
+}
+
+// mergeChangedItems merges items changed on both sides field by field.
+// Items that signed is true for on either side, or before, are kept whole:
+// the signed one, or the later change when both or neither are signed.
+func mergeChangedItems[T any](entity string, base, ours, theirs []T, key func(T) string, signed func(T) bool) ([]SyncConflict, error) {
+	baseIndex, theirIndex := indexByKey(base, key), indexByKey(theirs, key)
+	now := time.Now().Format("02/01/2006 15:04")
+	var conflicts []SyncConflict
+
+	for i := range ours {
+		k := key(ours[i])
+		b, t := baseIndex[k], theirIndex[k]
+		if b == nil || t == nil || sameContent(ours[i], *b) || sameContent(*t, *b) || sameContent(ours[i], *t) {
+			continue
+		}
+
+		oursLater := changedLater(updatedAt(ours[i]), updatedAt(*t))
+		var merged T
+		var fields []string
+		var err error
+		if signed != nil && (signed(*b) || signed(ours[i]) || signed(*t)) {
+			if signed(ours[i]) != signed(*t) {
+				oursLater = signed(ours[i])
+			}
+			merged, fields, err = keepWhole(ours[i], *t, oursLater)
+		} else {
+			merged, fields, err = mergeFields(*b, ours[i], *t, oursLater)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s %s: %v", entity, k, err)
+		}
+
+		kept, other, from := ours[i], *t, "this branch"
+		if !oursLater {
+			kept, other, from = *t, ours[i], "another branch"
+		}
+		keptFields, _ := fieldsOf(kept)
+		otherFields, _ := fieldsOf(other)
+		id, _ := strconv.Atoi(k)
+		for _, field := range fields {
+			c := SyncConflict{
+				Entity: entity, ItemID: id, Field: field,
+				Kept: string(keptFields[field]), Other: string(otherFields[field]),
+				KeptFrom: from, KeptAt: updatedAt(kept), OtherAt: updatedAt(other), FoundAt: now,
+			}
+			if field == SYNC_WHOLE_ITEM {
+				keptItem, _ := json.Marshal(kept)
+				otherItem, _ := json.Marshal(other)
+				c.Kept, c.Other = string(keptItem), string(otherItem)
+			}
+			conflicts = append(conflicts, c)
+		}
+		ours[i], *t = merged, merged
+	}
+	return conflicts, nil
+}
+
+// queueSyncConflicts adds conflicts to the ones waiting for resolution; a
+// newer conflict on the same field replaces the older one
+func queueSyncConflicts(conflicts []SyncConflict) {
+	for _, c := range conflicts {
+		next := 1
+		queue := branchSync.Conflicts[:0]
+		for _, q := range branchSync.Conflicts {
+			next = max(next, q.ID+1)
+			if q.Entity != c.Entity || q.ItemID != c.ItemID || q.Field != c.Field {
+				queue = append(queue, q)
+			}
+		}
+		c.ID = next
+		branchSync.Conflicts = append(queue, c)
+		auditLog(AUDIT_CONFLICT, c.Entity, c.ItemID, map[string]string{c.Field: c.Other}, map[string]string{c.Field: c.Kept})
+	}
+}
+
+// Resolution functions
+
+// fieldText shows a JSON field value the way a user typed it
+func fieldText(raw string) string {
+	if raw == "" || raw == "null" {
+		return "-"
+	}
+	var s string
+	if json.Unmarshal([]byte(raw), &s) == nil {
+		return textOrDash(s)
+	}
+	return truncate(raw, 60)
+}
+
+// conflictValueText shows the kept or other value of a conflict; for a whole
+// record it tells whether that version was signed
+func conflictValueText(c SyncConflict, raw string) string {
+	if c.Field != SYNC_WHOLE_ITEM {
+		return fieldText(raw)
+	}
+	var r Record
+	if json.Unmarshal([]byte(raw), &r) != nil {
+		return "-"
+	}
+	if isSignedOff(r) {
+		return "signed by " + r.ConcludedBy
+	}
+	return "not signed, " + recordStatus(r)
+}
+
+// conflictFieldText names the field of a conflict
+func conflictFieldText(c SyncConflict) string {
+	if c.Field == SYNC_WHOLE_ITEM {
+		return "whole record"
+	}
+	return c.Field
+}
+
+// changeTime shows the time of a change in local time
+func changeTime(at string) string {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return "time unknown"
+	}
+	return t.Local().Format("02/01/2006 15:04:05")
+}
+
+// setField replaces one field of an item with a JSON value; an empty value
+// clears it
+func setField[T any](item *T, field, raw string) error {
+	fields, err := fieldsOf(*item)
+	if err != nil {
+		return err
+	}
+	if raw == "" {
+		delete(fields, field)
+	} else {
+		fields[field] = json.RawMessage(raw)
+	}
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var updated T
+	if err := json.Unmarshal(content, &updated); err != nil {
+		return err
+	}
+	*item = updated
+	return nil
+}
+
+// conflictItemName names the patient or record a conflict is about, or is
+// empty when the item is no longer here
+func conflictItemName(c SyncConflict) string {
+	switch c.Entity {
+	case ENTITY_PATIENT:
+		if idx := binarySearchPatientByID(c.ItemID); idx != -1 {
+			return fmt.Sprintf("Patient %s (%s)", displayPatientID(c.ItemID), displayName(patients.Daftar[idx].Name))
+		}
+	case ENTITY_RECORD:
+		if idx := searchRecordByID(c.ItemID); idx != -1 {
+			return fmt.Sprintf("Record %d (%s)", c.ItemID, displayName(records.Daftar[idx].Patient.Name))
+		}
+	}
+	return ""
+}
+
+// currentFieldValue is the value a conflicting field has here now
+func currentFieldValue(c SyncConflict) string {
+	if c.Field == SYNC_WHOLE_ITEM {
+		if idx := searchRecordByID(c.ItemID); idx != -1 {
+			var kept Record
+			if json.Unmarshal([]byte(c.Kept), &kept) == nil && sameContent(kept, records.Daftar[idx]) {
+				return c.Kept
+			}
+			current, _ := json.Marshal(records.Daftar[idx])
+			return string(current)
+		}
+		return ""
+	}
+	var fields map[string]json.RawMessage
+	switch c.Entity {
+	case ENTITY_PATIENT:
+		if idx := binarySearchPatientByID(c.ItemID); idx != -1 {
+			fields, _ = fieldsOf(patients.Daftar[idx])
+		}
+	case ENTITY_RECORD:
+		if idx := searchRecordByID(c.ItemID); idx != -1 {
+			fields, _ = fieldsOf(records.Daftar[idx])
+		}
+	}
+	return string(fields[c.Field])
+}
+
+// useOtherValue applies the value that lost a conflict; it is sent to the
+// other branches on the next sync
+func useOtherValue(c SyncConflict) error {
+	switch c.Entity {
+	case ENTITY_PATIENT:
+		idx := binarySearchPatientByID(c.ItemID)
+		if idx == -1 {
+			return fmt.Errorf("patient %d is no longer here", c.ItemID)
+		}
+		p := &patients.Daftar[idx]
+		before := *p
+		if err := setField(p, c.Field, c.Other); err != nil {
+			return err
+		}
+		pushUndo(AUDIT_UPDATE, ENTITY_PATIENT, p.ID, before, *p)
+		auditLog(AUDIT_UPDATE, "patient", p.ID, before, *p)
+		publishChange(ENTITY_PATIENT, p.ID, before, *p)
+	case ENTITY_RECORD:
+		idx := searchRecordByID(c.ItemID)
+		if idx == -1 {
+			return fmt.Errorf("record %d is no longer here", c.ItemID)
+		}
+		r := &records.Daftar[idx]
+		before := *r
+		if c.Field == SYNC_WHOLE_ITEM {
+			// The signed version stays locked; replacing it goes through reopening
+			if isSignedOff(*r) {
+				return fmt.Errorf("record %d is signed off; reopen it before using the other version", r.ID)
+			}
+			var other Record
+			if err := json.Unmarshal([]byte(c.Other), &other); err != nil {
+				return err
+			}
+			other.Version, other.UpdatedAt = r.Version, r.UpdatedAt
+			*r = other
+		} else if err := setField(r, c.Field, c.Other); err != nil {
+			return err
+		}
+		pushUndo(AUDIT_UPDATE, ENTITY_RECORD, r.ID, before, *r)
+		auditLog(AUDIT_UPDATE, "record", r.ID, before, *r)
+		publishChange(ENTITY_RECORD, r.ID, before, *r)
+	}
+	return nil
+}
+
+func removeSyncConflict(id int) {
+	for i, c := range branchSync.Conflicts {
+		if c.ID == id {
+			branchSync.Conflicts = append(branchSync.Conflicts[:i], branchSync.Conflicts[i+1:]...)
+			return
+		}
+	}
+}
+
+func resolveSyncConflicts() {
+	for {
+		printHeader("Sync Conflicts")
+
+		if !requirePermission(PERM_DATA_EXCHANGE) {
+			return
+		}
+
+		if len(branchSync.Conflicts) == 0 {
+			fmt.Println("No sync conflicts. Fields changed on two branches at once are listed here.")
+			pause()
+			return
+		}
+
+		fmt.Println("These fields were changed here and on another branch between two syncs.")
+		fmt.Print("The later change was kept; choose a conflict to keep it or use the other value.\n\n")
+		fmt.Printf("%s%-4s %-32s %-16s %-20s %-20s%s\n", BOLD, "No", "Item", "Field", "Kept", "Other", RESET)
+		fmt.Println(strings.Repeat("-", 96))
+		for _, c := range branchSync.Conflicts {
+			name := conflictItemName(c)
+			if name == "" {
+				name = fmt.Sprintf("%s %d (gone)", c.Entity, c.ItemID)
+			}
+			fmt.Printf("%-4d %-32s %-16s %-20s %-20s\n", c.ID, truncate(name, 32), conflictFieldText(c),
+				truncate(conflictValueText(c, c.Kept), 20), truncate(conflictValueText(c, c.Other), 20))
+		}
+
+		id := getValidInt("\nConflict to resolve (0 to go back): ", 0, 999999)
+		if id == 0 {
+			return
+		}
+		idx := -1
+		for i, c := range branchSync.Conflicts {
+			if c.ID == id {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			printError("Conflict not found!")
+			pause()
+			continue
+		}
+		resolveSyncConflict(branchSync.Conflicts[idx])
+	}
+}
+
+func resolveSyncConflict(c SyncConflict) {
+	name := conflictItemName(c)
+	if name == "" {
+		printWarning(fmt.Sprintf("%s %d is no longer here; the conflict is closed.", c.Entity, c.ItemID))
+		removeSyncConflict(c.ID)
+		auditLog(AUDIT_UPDATE, "sync_conflict", c.ID, c, nil)
+		pause()
+		return
+	}
+
+	otherFrom := "this branch"
+	if c.KeptFrom == otherFrom {
+		otherFrom = "another branch"
+	}
+	fmt.Printf("\n%s - %s\n", name, conflictFieldText(c))
+	fmt.Printf("Kept:  %s (changed on %s, %s)\n", conflictValueText(c, c.Kept), c.KeptFrom, changeTime(c.KeptAt))
+	fmt.Printf("Other: %s (changed on %s, %s)\n", conflictValueText(c, c.Other), otherFrom, changeTime(c.OtherAt))
+	if now := currentFieldValue(c); now != c.Kept {
+		printWarning(fmt.Sprintf("The field has been changed since: it is now %s.", conflictValueText(c, now)))
+	}
+	fmt.Println("\n1. Keep this value  2. Use the other value  0. Back")
+
+	switch getValidInt("Choose option: ", 0, 2) {
+	case 0:
+		return
+	case 2:
+		if err := useOtherValue(c); err != nil {
+			printError(err.Error())
+			pause()
+			return
+		}
+	}
+
+	removeSyncConflict(c.ID)
+	auditLog(AUDIT_UPDATE, "sync_conflict", c.ID, c, map[string]string{"resolved": c.Field})
+	printSuccess("Conflict resolved. The choice is sent to the other branches on the next sync.")
+	pause()
+}
+
+// Offline functions
+
+// centralConfigured reports whether this installation syncs with a central
+// store
+func centralConfigured() bool {
+	return branchSync.HomeBranch != 0 && (branchSync.CentralURL != "" || branchSync.CentralDir != "")
+}
+
+// pendingChanges counts this branch's items that changed since the last
+// sync
+func pendingChanges() int {
+	share := branchSnapshot(currentDataStore(), branchSync.HomeBranch)
+	if branchSync.Base == nil {
+		return countChanges(BranchSnapshot{}, share)
+	}
+	return countChanges(*branchSync.Base, share)
+}
+
+// branchSyncStatus is the line shown under the main menu header of a
+// branch installation
+func branchSyncStatus() string {
+	if !centralConfigured() {
+		return ""
+	}
+	status := "Central store: last sync " + textOrDash(branchSync.LastSync)
+	if centralOffline {
+		status += ", " + RED + "offline" + RESET
+	}
+	if n := pendingChanges(); n > 0 {
+		status += fmt.Sprintf(", %d changes waiting", n)
+	}
+	if n := len(branchSync.Conflicts); n > 0 {
+		status += fmt.Sprintf(", %s%d sync conflicts to resolve%s", YELLOW, n, RESET)
+	}
+	return status
+}
+
+// autoSyncBranch syncs with the central store from the main menu every
+// BRANCH_SYNC_INTERVAL. It runs for every user, as the data of the branch
+// has to reach the others. While the central store cannot be reached the
+// branch keeps working on its own data.
+func autoSyncBranch() {
+	if !centralConfigured() || time.Since(lastSyncAttempt) < BRANCH_SYNC_INTERVAL {
+		return
+	}
+
+	_, _, conflicts, err := runCentralSync()
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "The central store")
+	}
+	switch {
+	case errors.Is(err, ErrCentralUnreachable):
+	case err != nil:
+		printWarning(fmt.Sprintf("Could not sync with the central store: %v", err))
+		printWarning("Your changes are kept here and sent on the next try.")
+	}
+	if len(conflicts) > 0 || (err != nil && !errors.Is(err, ErrCentralUnreachable)) {
+		pause()
+	}
+}
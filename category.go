@@ -0,0 +1,482 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constants
+const (
+	CATEGORY_ID_START = 70001
+)
+
+// Data structures
+type Category struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	SortOrder int    `json:"sort_order"`
+	Retired   bool   `json:"retired"`
+}
+
+type CategoryArray struct {
+	Daftar [NMAX]Category `json:"daftar"`
+	N      int            `json:"n"`
+}
+
+// Search and ID functions
+func binarySearchCategoryByID(id int) int {
+	left, right := 0, categories.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if categories.Daftar[mid].ID == id {
+			return mid
+		} else if categories.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func sequentialSearchCategoryByName(name string) int {
+	for i := 0; i < categories.N; i++ {
+		if strings.EqualFold(categories.Daftar[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func getNextCategoryID() int {
+	maxID := CATEGORY_ID_START - 1
+	for i := 0; i < categories.N; i++ {
+		if categories.Daftar[i].ID > maxID {
+			maxID = categories.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func getNextSortOrder() int {
+	maxOrder := 0
+	for i := 0; i < categories.N; i++ {
+		if categories.Daftar[i].SortOrder > maxOrder {
+			maxOrder = categories.Daftar[i].SortOrder
+		}
+	}
+	return maxOrder + 1
+}
+
+func appendCategory(name string, retired bool) Category {
+	cat := Category{
+		ID:        getNextCategoryID(),
+		Name:      name,
+		SortOrder: getNextSortOrder(),
+		Retired:   retired,
+	}
+	categories.Daftar[categories.N] = cat
+	categories.N++
+	return cat
+}
+
+// categoryLabel gives the current name for a category ID, so records booked
+// before a rename are reported under the same category as new ones
+func categoryLabel(id int, fallback string) string {
+	if idx := binarySearchCategoryByID(id); idx != -1 {
+		c := categories.Daftar[idx]
+		if c.Retired {
+			return c.Name + " (retired)"
+		}
+		return c.Name
+	}
+	return fallback
+}
+
+func recordCategory(r Record) string {
+	return categoryLabel(r.Package.CategoryID, r.Package.Category)
+}
+
+func categorySortOrder(id int) int {
+	if idx := binarySearchCategoryByID(id); idx != -1 {
+		return categories.Daftar[idx].SortOrder
+	}
+	return 0
+}
+
+// sortedCategories returns the categories ordered by sort order using insertion sort
+func sortedCategories(includeRetired bool) []Category {
+	var list []Category
+	for i := 0; i < categories.N; i++ {
+		if includeRetired || !categories.Daftar[i].Retired {
+			list = append(list, categories.Daftar[i])
+		}
+	}
+
+	for i := 1; i < len(list); i++ {
+		key := list[i]
+		j := i - 1
+		for j >= 0 && list[j].SortOrder > key.SortOrder {
+			list[j+1] = list[j]
+			j--
+		}
+		list[j+1] = key
+	}
+	return list
+}
+
+// selectCategory asks the user to pick one of the active categories
+func selectCategory() Category {
+	active := sortedCategories(false)
+	if len(active) == 0 {
+		// Never leave the user without a choice
+		seedDefaultCategories()
+		active = sortedCategories(false)
+	}
+
+	fmt.Println("Available categories:")
+	for i, cat := range active {
+		fmt.Printf("%d. %s\n", i+1, cat.Name)
+	}
+
+	choice := getValidInt(fmt.Sprintf("Select category (1-%d): ", len(active)), 1, len(active))
+	return active[choice-1]
+}
+
+// parseValidCategory accepts the same active categories selectCategory offers
+func parseValidCategory(name string) (Category, error) {
+	idx := sequentialSearchCategoryByName(strings.TrimSpace(name))
+	if idx == -1 {
+		return Category{}, fmt.Errorf("unknown category %q", strings.TrimSpace(name))
+	}
+	if categories.Daftar[idx].Retired {
+		return Category{}, fmt.Errorf("category %q is retired", categories.Daftar[idx].Name)
+	}
+	return categories.Daftar[idx], nil
+}
+
+// Migration functions
+func seedDefaultCategories() {
+	defaults := []string{"Basic", "Standard", "Premium", "Executive"}
+	for _, name := range defaults {
+		if sequentialSearchCategoryByName(name) == -1 && categories.N < NMAX {
+			appendCategory(name, false)
+		}
+	}
+}
+
+// resolveCategoryID finds the category for a name, creating it when an older
+// data file uses a name that isn't in the list yet
+func resolveCategoryID(name string, retired bool) int {
+	if idx := sequentialSearchCategoryByName(name); idx != -1 {
+		return categories.Daftar[idx].ID
+	}
+	if name == "" || categories.N >= NMAX {
+		return 0
+	}
+	return appendCategory(name, retired).ID
+}
+
+// migrateCategories links packages and records from older data files to category IDs
+func migrateCategories() {
+	if categories.N == 0 {
+		seedDefaultCategories()
+	}
+
+	for i := 0; i < packages.N; i++ {
+		p := &packages.Daftar[i]
+		if p.CategoryID == 0 {
+			p.CategoryID = resolveCategoryID(p.Category, false)
+		}
+	}
+
+	// Categories only seen on records are no longer in use
+	for i := 0; i < records.N; i++ {
+		r := &records.Daftar[i]
+		if r.Package.CategoryID == 0 {
+			r.Package.CategoryID = resolveCategoryID(r.Package.Category, true)
+		}
+	}
+}
+
+// Category management functions
+func addCategory() {
+	printHeader("Add Category")
+
+	if !requirePermission(PERM_CATEGORY_MANAGE) {
+		return
+	}
+
+	if categories.N >= NMAX {
+		printError("Cannot add more categories. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	name := getValidInput("Enter category name: ")
+	if sequentialSearchCategoryByName(name) != -1 {
+		printError("A category with this name already exists.")
+		pause()
+		return
+	}
+
+	cat := appendCategory(name, false)
+	auditLog(AUDIT_CREATE, "category", cat.ID, nil, cat)
+	printSuccess(fmt.Sprintf("Category %s added with sort order %d.", cat.Name, cat.SortOrder))
+	pause()
+}
+
+func displayCategories() {
+	printHeader("Package Categories")
+
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
+	if categories.N == 0 {
+		printWarning("No categories found.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "category", 0, nil, nil)
+
+	fmt.Printf("%s%-8s %-10s %-25s %-10s %-10s%s\n", BOLD, "Order", "ID", "Name", "Packages", "Status", RESET)
+	fmt.Println(strings.Repeat("-", 66))
+
+	for _, c := range sortedCategories(true) {
+		count := 0
+		for i := 0; i < packages.N; i++ {
+			if packages.Daftar[i].CategoryID == c.ID {
+				count++
+			}
+		}
+		status := "Active"
+		if c.Retired {
+			status = "Retired"
+		}
+		fmt.Printf("%-8d %-10d %-25s %-10d %-10s\n", c.SortOrder, c.ID, c.Name, count, status)
+	}
+
+	pause()
+}
+
+func renameCategory() {
+	printHeader("Rename Category")
+
+	if !requirePermission(PERM_CATEGORY_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter category ID: ", 1, 999999)
+	idx := binarySearchCategoryByID(id)
+
+	if idx == -1 {
+		printError("Category not found.")
+		pause()
+		return
+	}
+
+	c := &categories.Daftar[idx]
+	oldName := c.Name
+	newName := getValidInput(fmt.Sprintf("Enter new name for %s: ", oldName))
+	if other := sequentialSearchCategoryByName(newName); other != -1 && other != idx {
+		printError("A category with this name already exists.")
+		pause()
+		return
+	}
+
+	before := *c
+	c.Name = newName
+	auditLog(AUDIT_UPDATE, "category", c.ID, before, *c)
+
+	// Keep the names on live packages, coverage rules and promotions in step
+	for i := 0; i < packages.N; i++ {
+		if packages.Daftar[i].CategoryID == c.ID {
+			packages.Daftar[i].Category = newName
+		}
+	}
+	for i := 0; i < payers.N; i++ {
+		for j := range payers.Daftar[i].Rules {
+			if payers.Daftar[i].Rules[j].Category == oldName {
+				payers.Daftar[i].Rules[j].Category = newName
+			}
+		}
+	}
+	for i := 0; i < promotions.N; i++ {
+		if promotions.Daftar[i].Category == oldName {
+			promotions.Daftar[i].Category = newName
+		}
+	}
+
+	printSuccess(fmt.Sprintf("Category %s renamed to %s.", oldName, newName))
+	pause()
+}
+
+func moveCategoryPackages(from Category, to Category) int {
+	moved := 0
+	for i := 0; i < packages.N; i++ {
+		p := &packages.Daftar[i]
+		if p.CategoryID == from.ID {
+			before := map[string]interface{}{"category_id": p.CategoryID, "category": p.Category}
+			p.CategoryID = to.ID
+			p.Category = to.Name
+			auditLog(AUDIT_UPDATE, "package_category", p.ID, before,
+				map[string]interface{}{"category_id": p.CategoryID, "category": p.Category})
+			moved++
+		}
+	}
+	return moved
+}
+
+func retireCategory() {
+	printHeader("Retire/Restore Category")
+
+	if !requirePermission(PERM_CATEGORY_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter category ID: ", 1, 999999)
+	idx := binarySearchCategoryByID(id)
+
+	if idx == -1 {
+		printError("Category not found.")
+		pause()
+		return
+	}
+
+	c := &categories.Daftar[idx]
+	before := *c
+	if c.Retired {
+		c.Retired = false
+		auditLog(AUDIT_UPDATE, "category", c.ID, before, *c)
+		printSuccess(fmt.Sprintf("Category %s restored.", c.Name))
+		pause()
+		return
+	}
+
+	if len(sortedCategories(false)) <= 1 {
+		printError("Cannot retire the last active category.")
+		pause()
+		return
+	}
+
+	inUse := 0
+	for i := 0; i < packages.N; i++ {
+		if packages.Daftar[i].CategoryID == c.ID {
+			inUse++
+		}
+	}
+
+	c.Retired = true
+	auditLog(AUDIT_UPDATE, "category", c.ID, before, *c)
+	if inUse > 0 {
+		printWarning(fmt.Sprintf("%d packages are still in %s. Choose a category to move them to.", inUse, c.Name))
+		target := selectCategory()
+		moved := moveCategoryPackages(*c, target)
+		printSuccess(fmt.Sprintf("%d packages moved to %s.", moved, target.Name))
+	}
+
+	printSuccess(fmt.Sprintf("Category %s retired. Historical records keep it in reports.", c.Name))
+	pause()
+}
+
+func changeCategoryOrder() {
+	printHeader("Category Sort Order")
+
+	if !requirePermission(PERM_CATEGORY_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter category ID: ", 1, 999999)
+	idx := binarySearchCategoryByID(id)
+
+	if idx == -1 {
+		printError("Category not found.")
+		pause()
+		return
+	}
+
+	c := &categories.Daftar[idx]
+	before := *c
+	c.SortOrder = getValidInt(fmt.Sprintf("Enter new sort order for %s: ", c.Name), 1, 999)
+	auditLog(AUDIT_UPDATE, "category", c.ID, before, *c)
+
+	printSuccess("Sort order updated.")
+	pause()
+}
+
+func reassignPackages() {
+	printHeader("Reassign Packages")
+
+	if !requirePermission(PERM_CATEGORY_MANAGE) {
+		return
+	}
+
+	fmt.Println("1. Move one package  2. Move all packages in a category")
+	choice := getValidInt("Choose option: ", 1, 2)
+
+	switch choice {
+	case 1:
+		id := getValidInt("Enter package ID: ", 1, 999999)
+		idx := binarySearchPackageByID(id)
+		if idx == -1 {
+			printError("Package not found.")
+			break
+		}
+		p := &packages.Daftar[idx]
+		fmt.Printf("Current category: %s\n", p.Category)
+		target := selectCategory()
+		before := map[string]interface{}{"category_id": p.CategoryID, "category": p.Category}
+		p.CategoryID = target.ID
+		p.Category = target.Name
+		auditLog(AUDIT_UPDATE, "package_category", p.ID, before,
+			map[string]interface{}{"category_id": p.CategoryID, "category": p.Category})
+		printSuccess(fmt.Sprintf("%s moved to %s.", p.Name, target.Name))
+	case 2:
+		fmt.Println("Move packages from:")
+		all := sortedCategories(true)
+		for i, cat := range all {
+			fmt.Printf("%d. %s\n", i+1, categoryLabel(cat.ID, cat.Name))
+		}
+		from := all[getValidInt("Select category: ", 1, len(all))-1]
+		fmt.Println("\nMove packages to:")
+		target := selectCategory()
+		moved := moveCategoryPackages(from, target)
+		printSuccess(fmt.Sprintf("%d packages moved from %s to %s.", moved, from.Name, target.Name))
+	}
+
+	pause()
+}
+
+func categoryManagement() {
+	for {
+		printHeader("Package Categories")
+		fmt.Printf("%s1.%s Add Category\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Categories\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Rename Category\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Retire/Restore Category\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Change Sort Order\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Reassign Packages\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 6)
+
+		switch choice {
+		case 1:
+			addCategory()
+		case 2:
+			displayCategories()
+		case 3:
+			renameCategory()
+		case 4:
+			retireCategory()
+		case 5:
+			changeCategoryOrder()
+		case 6:
+			reassignPackages()
+		case 0:
+			return
+		}
+	}
+}
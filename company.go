@@ -0,0 +1,713 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constants
+const (
+	COMPANY_ID_START = 40001
+
+	// Summary cells with fewer patients than this are suppressed
+	MIN_SUMMARY_GROUP = 3
+)
+
+// Data structures
+type ContractPrice struct {
+	PackageID int     `json:"package_id"`
+	Price     float64 `json:"price"`
+}
+
+type Company struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	ContactPerson  string          `json:"contact_person"`
+	ContractPrices []ContractPrice `json:"contract_prices,omitempty"`
+	Employees      []int           `json:"employees,omitempty"`
+}
+
+type CompanyArray struct {
+	Daftar [NMAX]Company `json:"daftar"`
+	N      int           `json:"n"`
+}
+
+// Search and ID functions
+func binarySearchCompanyByID(id int) int {
+	left, right := 0, companies.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if companies.Daftar[mid].ID == id {
+			return mid
+		} else if companies.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func getNextCompanyID() int {
+	maxID := COMPANY_ID_START - 1
+	for i := 0; i < companies.N; i++ {
+		if companies.Daftar[i].ID > maxID {
+			maxID = companies.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func getContractPrice(c Company, packageID int) (float64, bool) {
+	for _, cp := range c.ContractPrices {
+		if cp.PackageID == packageID {
+			return cp.Price, true
+		}
+	}
+	return 0, false
+}
+
+func setContractPrice(c *Company, packageID int, price float64) {
+	for i := range c.ContractPrices {
+		if c.ContractPrices[i].PackageID == packageID {
+			c.ContractPrices[i].Price = price
+			return
+		}
+	}
+	c.ContractPrices = append(c.ContractPrices, ContractPrice{PackageID: packageID, Price: price})
+}
+
+func isEmployee(c Company, patientID int) bool {
+	for _, id := range c.Employees {
+		if id == patientID {
+			return true
+		}
+	}
+	return false
+}
+
+func removeEmployee(c *Company, patientID int) bool {
+	for i, id := range c.Employees {
+		if id == patientID {
+			c.Employees = append(c.Employees[:i], c.Employees[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func selectCompany() int {
+	if companies.N == 0 {
+		printError("No companies available. Please add a company first.")
+		return -1
+	}
+
+	fmt.Println("Companies:")
+	for i := 0; i < companies.N; i++ {
+		c := companies.Daftar[i]
+		fmt.Printf("%d. %s (ID: %d, %d employees)\n", i+1, c.Name, c.ID, len(c.Employees))
+	}
+	return getValidInt("Select company: ", 1, companies.N) - 1
+}
+
+// selectPackage asks for one of the packages offered at a branch and returns
+// its index, or -1 when the branch offers none
+func selectPackage(branch int) int {
+	offered := branchPackages(branch)
+	if len(offered) == 0 {
+		printError(fmt.Sprintf("No packages are offered at %s.", branchName(branch)))
+		return -1
+	}
+	fmt.Println("\nAvailable packages:")
+	for n, i := range offered {
+		p := packages.Daftar[i]
+		fmt.Printf("%d. %s - %s ($%.2f)\n", n+1, p.Name, p.Category, p.Price)
+	}
+	return offered[getValidInt("Select package: ", 1, len(offered))-1]
+}
+
+// Company management functions
+func addCompany() {
+	printHeader("Add Corporate Client")
+
+	if !requirePermission(PERM_COMPANY_MANAGE) {
+		return
+	}
+
+	if companies.N >= NMAX {
+		printError("Cannot add more companies. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	company := Company{
+		ID:            getNextCompanyID(),
+		Name:          getValidInput("Enter company name: "),
+		ContactPerson: getValidInput("Enter contact person: "),
+	}
+
+	companies.Daftar[companies.N] = company
+	companies.N++
+	auditLog(AUDIT_CREATE, "company", company.ID, nil, company)
+
+	printSuccess(fmt.Sprintf("Company added successfully with ID: %d", company.ID))
+	pause()
+}
+
+func displayCompanies() {
+	printHeader("Corporate Clients")
+
+	if !requirePermission(PERM_COMPANY_MANAGE) {
+		return
+	}
+
+	if companies.N == 0 {
+		printWarning("No companies found.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "company", 0, nil, nil)
+
+	fmt.Printf("%s%-10s %-30s %-20s %-10s %-10s%s\n", BOLD, "ID", "Name", "Contact", "Employees", "Contracts", RESET)
+	fmt.Println(strings.Repeat("-", 84))
+
+	for i := 0; i < companies.N; i++ {
+		c := companies.Daftar[i]
+		fmt.Printf("%-10d %-30s %-20s %-10d %-10d\n", c.ID, c.Name, c.ContactPerson, len(c.Employees), len(c.ContractPrices))
+	}
+
+	pause()
+}
+
+func manageContractPrices() {
+	printHeader("Contract Prices")
+
+	if !requirePermission(PERM_PRICE_EDIT) {
+		return
+	}
+
+	idx := selectCompany()
+	if idx == -1 {
+		pause()
+		return
+	}
+	if packages.N == 0 {
+		printError("No packages available. Please add packages first.")
+		pause()
+		return
+	}
+
+	c := &companies.Daftar[idx]
+	fmt.Printf("\n%sContract prices for %s:%s\n", BOLD, c.Name, RESET)
+	fmt.Printf("%-10s %-30s %-12s %-12s\n", "ID", "Package", "List Price", "Contract")
+	fmt.Println(strings.Repeat("-", 66))
+	for i := 0; i < packages.N; i++ {
+		p := packages.Daftar[i]
+		contract := "-"
+		if price, ok := getContractPrice(*c, p.ID); ok {
+			contract = fmt.Sprintf("$%.2f", price)
+		}
+		fmt.Printf("%-10d %-30s $%-11.2f %-12s\n", p.ID, p.Name, p.Price, contract)
+	}
+
+	pkgIdx := selectPackage(activeBranch)
+	if pkgIdx == -1 {
+		pause()
+		return
+	}
+	price := getValidFloat("Enter contracted price: ", 0.0)
+	before := append([]ContractPrice(nil), c.ContractPrices...)
+	setContractPrice(c, packages.Daftar[pkgIdx].ID, price)
+	auditLog(AUDIT_UPDATE, "company_contract", c.ID, before, c.ContractPrices)
+
+	printSuccess("Contract price saved.")
+	pause()
+}
+
+func manageRoster() {
+	printHeader("Employee Roster")
+
+	if !requirePermission(PERM_COMPANY_MANAGE) {
+		return
+	}
+
+	idx := selectCompany()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	c := &companies.Daftar[idx]
+	auditLog(AUDIT_VIEW, "company_roster", c.ID, nil, nil)
+	before := append([]int(nil), c.Employees...)
+	fmt.Printf("\n%sRoster for %s:%s\n", BOLD, c.Name, RESET)
+	if len(c.Employees) == 0 {
+		printWarning("No employees on the roster.")
+	}
+	for _, id := range c.Employees {
+		if pIdx := binarySearchPatientByID(id); pIdx != -1 {
+			p := patients.Daftar[pIdx]
+			fmt.Printf("%-10d %-30s %-8s %-5d\n", p.ID, displayName(p.Name), p.Gender, p.Age)
+		} else {
+			fmt.Printf("%-10d %s(patient no longer exists)%s\n", id, YELLOW, RESET)
+		}
+	}
+
+	fmt.Println("\n1. Add existing patient  2. Register new patient  3. Remove employee  0. Back")
+	choice := getValidInt("Choose option: ", 0, 3)
+
+	switch choice {
+	case 1:
+		id := getValidInt("Enter patient ID: ", 1, 999999)
+		if binarySearchPatientByID(id) == -1 {
+			printError("Patient not found.")
+		} else if isEmployee(*c, id) {
+			printWarning("Patient is already on the roster.")
+		} else {
+			c.Employees = append(c.Employees, id)
+			printSuccess("Employee added to roster.")
+		}
+	case 2:
+		if !checkPermission(PERM_PATIENT_CREATE) {
+			break
+		}
+		if patients.N >= NMAX {
+			printError("Cannot add more patients. Maximum capacity reached.")
+			break
+		}
+		patient := Patient{
+			ID:     getNextPatientID(),
+			Name:   getValidInput("Enter patient name: "),
+			Gender: getValidGender(),
+			Age:    getValidInt("Enter age: ", 0, 150),
+		}
+		patients.Daftar[patients.N] = patient
+		patients.N++
+		auditLog(AUDIT_CREATE, "patient", patient.ID, nil, patient)
+		c.Employees = append(c.Employees, patient.ID)
+		printSuccess(fmt.Sprintf("Patient registered with ID %d and added to roster.", patient.ID))
+	case 3:
+		id := getValidInt("Enter patient ID to remove: ", 1, 999999)
+		if removeEmployee(c, id) {
+			printSuccess("Employee removed from roster.")
+		} else {
+			printError("Patient is not on the roster.")
+		}
+	case 0:
+		return
+	}
+
+	if len(before) != len(c.Employees) {
+		auditLog(AUDIT_UPDATE, "company_roster", c.ID, before, c.Employees)
+	}
+
+	pause()
+}
+
+func deleteCompany() {
+	printHeader("Delete Corporate Client")
+
+	if !requirePermission(PERM_COMPANY_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter company ID to delete: ", 1, 999999)
+	idx := binarySearchCompanyByID(id)
+
+	if idx == -1 {
+		printError("Company not found.")
+		pause()
+		return
+	}
+
+	c := companies.Daftar[idx]
+	confirm := getValidInput(fmt.Sprintf("\nAre you sure you want to delete %s? (y/N): ", c.Name))
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Deletion cancelled.")
+		pause()
+		return
+	}
+
+	// Shift elements to remove the company
+	for i := idx; i < companies.N-1; i++ {
+		companies.Daftar[i] = companies.Daftar[i+1]
+	}
+	companies.N--
+	auditLog(AUDIT_DELETE, "company", c.ID, c, nil)
+
+	printSuccess("Company deleted successfully. Existing records are kept.")
+	pause()
+}
+
+// Bulk booking functions
+func hasBooking(patientID, packageID int, from, to string) bool {
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.Patient.ID == patientID && r.Package.ID == packageID &&
+			compareDates(r.Date, from) >= 0 && compareDates(r.Date, to) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkBookCompany books every employee on the roster at a branch, spreading
+// them evenly over the days from start to end. It returns the number of
+// records created and the number of employees skipped.
+func bulkBookCompany(c Company, pkg Package, start, end string, branch int) (int, int) {
+	days := daysBetween(start, end) + 1
+	created, skipped := 0, 0
+
+	for i, patientID := range c.Employees {
+		pIdx := binarySearchPatientByID(patientID)
+		if pIdx == -1 || hasBooking(patientID, pkg.ID, start, end) || records.N >= NMAX {
+			skipped++
+			continue
+		}
+
+		record := Record{
+			ID:        getNextRecordID(),
+			Patient:   patients.Daftar[pIdx],
+			Package:   pkg,
+			Date:      addDays(start, i%days),
+			CompanyID: c.ID,
+			BranchID:  branch,
+		}
+		stampRecordPrice(&record)
+		if price, ok := getContractPrice(c, pkg.ID); ok {
+			record.Price = price
+		}
+		record.Package.Price = record.Price
+		record.Package.PriceHistory = nil
+		applyCoverage(&record)
+
+		records.Daftar[records.N] = record
+		records.N++
+		pushUndo(AUDIT_CREATE, ENTITY_RECORD, record.ID, nil, record)
+		auditLog(AUDIT_CREATE, "record", record.ID, nil, record)
+		publishChange(ENTITY_RECORD, record.ID, nil, record)
+		queueNotification(EVENT_RECORD_CREATED, record)
+		created++
+	}
+
+	return created, skipped
+}
+
+func bulkBooking() {
+	printHeader("Bulk Employee Booking")
+
+	if !requirePermission(PERM_RECORD_CREATE) {
+		return
+	}
+
+	idx := selectCompany()
+	if idx == -1 {
+		pause()
+		return
+	}
+	if packages.N == 0 {
+		printError("No packages available. Please add packages first.")
+		pause()
+		return
+	}
+
+	c := companies.Daftar[idx]
+	if len(c.Employees) == 0 {
+		printError("This company has no employees on the roster.")
+		pause()
+		return
+	}
+
+	branch := recordBranch()
+	pkgIdx := selectPackage(branch)
+	if pkgIdx == -1 {
+		pause()
+		return
+	}
+	pkg := packages.Daftar[pkgIdx]
+	start := getValidDate("Enter first check-up date")
+	end := getValidDate("Enter last check-up date")
+	if compareDates(end, start) < 0 {
+		printError("The last date must not be before the first date.")
+		pause()
+		return
+	}
+
+	if records.N+len(c.Employees) > NMAX {
+		printWarning(fmt.Sprintf("Only %d record slots left; some employees will be skipped.", NMAX-records.N))
+	}
+
+	created, skipped := bulkBookCompany(c, pkg, start, end, branch)
+	printSuccess(fmt.Sprintf("%d records created for %s between %s and %s.", created, c.Name, start, end))
+	if skipped > 0 {
+		printWarning(fmt.Sprintf("%d employees skipped (already booked, missing or no capacity).", skipped))
+	}
+	deliverNotificationsSoon()
+	pause()
+}
+
+// Company report functions
+func generateCompanyInvoice() {
+	printHeader("Consolidated Company Invoice")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	idx := selectCompany()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	c := companies.Daftar[idx]
+	start := getValidDate("Enter period start date")
+	end := getValidDate("Enter period end date")
+	branch := getReportBranch()
+
+	auditLog(AUDIT_VIEW, "company_invoice", c.ID, nil, map[string]string{"start": start, "end": end})
+
+	fmt.Printf("\n%sINVOICE INV-%d-%s%s\n", BOLD, c.ID, dateKey(end), RESET)
+	fmt.Printf("Bill to: %s (Attn: %s)\n", c.Name, c.ContactPerson)
+	fmt.Printf("Period: %s - %s\n", start, end)
+	if branches.N > 0 {
+		fmt.Printf("Branch: %s\n", branchName(branch))
+	}
+	fmt.Println()
+	fmt.Printf("%s%-8s %-25s %-25s %-12s %-12s%s\n", BOLD, "Record", "Employee", "Package", "Date", "Amount", RESET)
+	fmt.Println(strings.Repeat("-", 86))
+
+	var total float64
+	count := 0
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.CompanyID != c.ID || compareDates(r.Date, start) < 0 || compareDates(r.Date, end) > 0 || !inBranch(r.BranchID, branch) {
+			continue
+		}
+		price := recordPrice(r)
+		fmt.Printf("%-8d %-25s %-25s %-12s $%-11.2f\n", r.ID, r.Patient.Name, r.Package.Name, r.Date, price)
+		total += price
+		count++
+	}
+
+	fmt.Println(strings.Repeat("-", 86))
+	fmt.Printf("%sTotal (%d check-ups): $%.2f%s\n", BOLD, count, total, RESET)
+
+	pause()
+}
+
+// suppressedCount hides counts small enough to identify an individual
+func suppressedCount(count int) string {
+	if count > 0 && count < MIN_SUMMARY_GROUP {
+		return fmt.Sprintf("<%d", MIN_SUMMARY_GROUP)
+	}
+	return fmt.Sprintf("%d", count)
+}
+
+// isSmallCell reports whether a count, or the rest of its group, is small
+// enough to identify an individual. Either one can be worked out from the
+// other and the group total.
+func isSmallCell(count, total int) bool {
+	rest := total - count
+	return (count > 0 && count < MIN_SUMMARY_GROUP) || (rest > 0 && rest < MIN_SUMMARY_GROUP)
+}
+
+// smallCells marks the counts of a breakdown that identify individuals:
+// those below MIN_SUMMARY_GROUP, and the smallest other one when only one
+// would be hidden, since the total would give it away
+func smallCells(counts []int) []bool {
+	hidden := make([]bool, len(counts))
+	n := 0
+	for i, count := range counts {
+		if count > 0 && count < MIN_SUMMARY_GROUP {
+			hidden[i] = true
+			n++
+		}
+	}
+	if n == 1 {
+		next := -1
+		for i, count := range counts {
+			if !hidden[i] && count > 0 && (next == -1 || count < counts[next]) {
+				next = i
+			}
+		}
+		if next != -1 {
+			hidden[next] = true
+		}
+	}
+	return hidden
+}
+
+func generateCompanyHealthSummary() {
+	printHeader("Company Health Summary")
+
+	if !requirePermission(PERM_REPORT_PATIENT) {
+		return
+	}
+
+	idx := selectCompany()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	c := companies.Daftar[idx]
+	start := getValidDate("Enter period start date")
+	end := getValidDate("Enter period end date")
+	branch := getReportBranch()
+
+	auditLog(AUDIT_VIEW, "company_health_summary", c.ID, nil, map[string]string{"start": start, "end": end})
+
+	examined := make(map[int]bool)
+	var maleCount, femaleCount, otherCount int
+	ageBands := make(map[string]int)
+	tested := make(map[string]int)
+	abnormal := make(map[string]int)
+	valueSum := make(map[string]float64)
+
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.CompanyID != c.ID || compareDates(r.Date, start) < 0 || compareDates(r.Date, end) > 0 || !inBranch(r.BranchID, branch) {
+			continue
+		}
+
+		if !examined[r.Patient.ID] {
+			examined[r.Patient.ID] = true
+			switch r.Patient.Gender {
+			case "M":
+				maleCount++
+			case "F":
+				femaleCount++
+			default:
+				otherCount++
+			}
+			ageBands[ageBand(r.Patient.Age)]++
+		}
+
+		for _, res := range r.Results {
+			tested[res.Code]++
+			valueSum[res.Code] += res.Value
+			if isAbnormal(res) {
+				abnormal[res.Code]++
+			}
+		}
+	}
+
+	fmt.Printf("\n%sHealth summary for %s (%s - %s)%s\n", BOLD, c.Name, start, end, RESET)
+	if branches.N > 0 {
+		fmt.Printf("Branch: %s\n", branchName(branch))
+	}
+	fmt.Println()
+	fmt.Printf("Employees on roster: %d\n", len(c.Employees))
+	fmt.Printf("Employees examined: %d\n", len(examined))
+
+	if len(examined) < MIN_SUMMARY_GROUP {
+		printWarning(fmt.Sprintf("Fewer than %d employees examined; details suppressed to protect privacy.", MIN_SUMMARY_GROUP))
+		pause()
+		return
+	}
+
+	// Genders recorded as anything else, or not at all, are counted apart
+	genders := []int{maleCount, femaleCount, otherCount}
+	shown := make([]string, len(genders))
+	for i, hidden := range smallCells(genders) {
+		shown[i] = fmt.Sprintf("%d", genders[i])
+		if hidden {
+			shown[i] = "-"
+		}
+	}
+	if otherCount > 0 {
+		fmt.Printf("Male: %s  Female: %s  Other/unknown: %s\n", shown[0], shown[1], shown[2])
+	} else {
+		fmt.Printf("Male: %s  Female: %s\n", shown[0], shown[1])
+	}
+
+	fmt.Printf("\n%sAge Bands:%s\n", BOLD, RESET)
+	for _, band := range AGE_BANDS {
+		if ageBands[band] > 0 {
+			fmt.Printf("%-8s %s\n", band, suppressedCount(ageBands[band]))
+		}
+	}
+
+	fmt.Printf("\n%sExamination Results:%s\n", BOLD, RESET)
+	fmt.Printf("%-8s %-30s %-8s %-10s %-10s %-10s\n", "Code", "Examination", "Tested", "Abnormal", "Abnormal%", "Mean")
+	fmt.Println(strings.Repeat("-", 80))
+	for i := 0; i < examinations.N; i++ {
+		e := examinations.Daftar[i]
+		n := tested[e.Code]
+		if n == 0 {
+			continue
+		}
+		// Every statistic of a row goes once any of its cells is too small
+		if n < MIN_SUMMARY_GROUP || isSmallCell(abnormal[e.Code], n) {
+			fmt.Printf("%-8s %-30s %-8s %-10s %-10s %-10s\n", e.Code, e.Name, suppressedCount(n), "-", "-", "-")
+			continue
+		}
+		fmt.Printf("%-8s %-30s %-8d %-10d %-10.1f %-10.2f\n", e.Code, e.Name, n,
+			abnormal[e.Code], float64(abnormal[e.Code])*100/float64(n), valueSum[e.Code]/float64(n))
+	}
+
+	pause()
+}
+
+// Age band helpers
+var AGE_BANDS = []string{"<20", "20-29", "30-39", "40-49", "50-59", "60+"}
+
+func ageBand(age int) string {
+	switch {
+	case age < 20:
+		return "<20"
+	case age < 30:
+		return "20-29"
+	case age < 40:
+		return "30-39"
+	case age < 50:
+		return "40-49"
+	case age < 60:
+		return "50-59"
+	default:
+		return "60+"
+	}
+}
+
+func companyManagement() {
+	for {
+		printHeader("Corporate Clients")
+		fmt.Printf("%s1.%s Add Company\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Companies\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Contract Prices\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Employee Roster\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Bulk Booking\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Consolidated Invoice\n", YELLOW, RESET)
+		fmt.Printf("%s7.%s Health Summary Report\n", YELLOW, RESET)
+		fmt.Printf("%s8.%s Fitness Summary\n", YELLOW, RESET)
+		fmt.Printf("%s9.%s Delete Company\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 9)
+
+		switch choice {
+		case 1:
+			addCompany()
+		case 2:
+			displayCompanies()
+		case 3:
+			manageContractPrices()
+		case 4:
+			manageRoster()
+		case 5:
+			bulkBooking()
+		case 6:
+			generateCompanyInvoice()
+		case 7:
+			generateCompanyHealthSummary()
+		case 8:
+			generateFitnessSummary()
+		case 9:
+			deleteCompany()
+		case 0:
+			return
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constants
+const (
+	PROMOTION_ID_START = 60001
+
+	DISCOUNT_PERCENTAGE  = "Percentage"
+	DISCOUNT_FIXED       = "Fixed"
+	DISCOUNT_BUY_X_GET_Y = "BuyXGetY"
+)
+
+// Data structures
+type Promotion struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Value     float64 `json:"value"`
+	BuyX      int     `json:"buy_x,omitempty"`
+	GetY      int     `json:"get_y,omitempty"`
+	Category  string  `json:"category,omitempty"`
+	PackageID int     `json:"package_id,omitempty"`
+	MinAge    int     `json:"min_age,omitempty"`
+	MaxAge    int     `json:"max_age,omitempty"`
+	Gender    string  `json:"gender,omitempty"`
+	MinVisits int     `json:"min_visits,omitempty"`
+	StartDate string  `json:"start_date,omitempty"`
+	EndDate   string  `json:"end_date,omitempty"`
+	Active    bool    `json:"active"`
+}
+
+type PromotionArray struct {
+	Daftar [NMAX]Promotion `json:"daftar"`
+	N      int             `json:"n"`
+}
+
+// Search and ID functions
+func binarySearchPromotionByID(id int) int {
+	left, right := 0, promotions.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if promotions.Daftar[mid].ID == id {
+			return mid
+		} else if promotions.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func getNextPromotionID() int {
+	maxID := PROMOTION_ID_START - 1
+	for i := 0; i < promotions.N; i++ {
+		if promotions.Daftar[i].ID > maxID {
+			maxID = promotions.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+// Eligibility functions
+
+// countPriorVisits counts the patient's records dated before the given date
+func countPriorVisits(patientID int, date string) int {
+	count := 0
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.Patient.ID == patientID && compareDates(r.Date, date) < 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func sameBundleGroup(a, b Patient) bool {
+	if a.ID == b.ID {
+		return true
+	}
+	return a.FamilyGroup != "" && strings.EqualFold(a.FamilyGroup, b.FamilyGroup)
+}
+
+// countBundleBookings counts the bookings by the patient or their family
+// group that qualified for the same promotion, paid or free, from the start
+// of the promotion up to the date of this booking
+func countBundleBookings(promo Promotion, booking Record) int {
+	count := 0
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.ID == booking.ID || !inPromotionPeriod(promo, r.Date) || compareDates(r.Date, booking.Date) > 0 {
+			continue
+		}
+		if sameBundleGroup(r.Patient, booking.Patient) && isPromotionEligible(promo, r.Patient, r.Package, r.Date) {
+			count++
+		}
+	}
+	return count
+}
+
+// inPromotionPeriod reports whether a date falls between a promotion's start
+// and end dates; either may be open
+func inPromotionPeriod(promo Promotion, date string) bool {
+	if promo.StartDate != "" && compareDates(date, promo.StartDate) < 0 {
+		return false
+	}
+	return promo.EndDate == "" || compareDates(date, promo.EndDate) <= 0
+}
+
+func isPromotionEligible(promo Promotion, patient Patient, pkg Package, date string) bool {
+	if !promo.Active {
+		return false
+	}
+	if promo.PackageID != 0 && promo.PackageID != pkg.ID {
+		return false
+	}
+	if promo.Category != "" && promo.Category != pkg.Category {
+		return false
+	}
+	if promo.MinAge > 0 && patient.Age < promo.MinAge {
+		return false
+	}
+	if promo.MaxAge > 0 && patient.Age > promo.MaxAge {
+		return false
+	}
+	if promo.Gender != "" && promo.Gender != patient.Gender {
+		return false
+	}
+	if !inPromotionPeriod(promo, date) {
+		return false
+	}
+	if promo.MinVisits > 0 && countPriorVisits(patient.ID, date) < promo.MinVisits {
+		return false
+	}
+	return true
+}
+
+// calculateDiscount returns the discount a promotion gives on a price.
+// bundleCount is the number of earlier bookings counted for buy-X-get-Y.
+func calculateDiscount(promo Promotion, price float64, bundleCount int) float64 {
+	var discount float64
+
+	switch promo.Type {
+	case DISCOUNT_PERCENTAGE:
+		discount = price * promo.Value / 100
+	case DISCOUNT_FIXED:
+		discount = promo.Value
+	case DISCOUNT_BUY_X_GET_Y:
+		cycle := promo.BuyX + promo.GetY
+		if promo.BuyX > 0 && promo.GetY > 0 && bundleCount%cycle >= promo.BuyX {
+			discount = price
+		}
+	}
+
+	if discount > price {
+		discount = price
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// applyBestDiscount picks the eligible promotion with the largest discount.
+// Promotions do not stack.
+func applyBestDiscount(r *Record) {
+	r.DiscountPromoID = 0
+	r.DiscountName = ""
+	r.DiscountAmount = 0
+
+	price := recordPrice(*r)
+	for i := 0; i < promotions.N; i++ {
+		promo := promotions.Daftar[i]
+		if !isPromotionEligible(promo, r.Patient, r.Package, r.Date) {
+			continue
+		}
+
+		discount := calculateDiscount(promo, price, countBundleBookings(promo, *r))
+		if discount > r.DiscountAmount {
+			r.DiscountPromoID = promo.ID
+			r.DiscountName = promo.Name
+			r.DiscountAmount = discount
+		}
+	}
+}
+
+func describePromotion(p Promotion) string {
+	var parts []string
+
+	switch p.Type {
+	case DISCOUNT_PERCENTAGE:
+		parts = append(parts, fmt.Sprintf("%.1f%% off", p.Value))
+	case DISCOUNT_FIXED:
+		parts = append(parts, fmt.Sprintf("$%.2f off", p.Value))
+	case DISCOUNT_BUY_X_GET_Y:
+		parts = append(parts, fmt.Sprintf("buy %d get %d free", p.BuyX, p.GetY))
+	}
+
+	if p.PackageID != 0 {
+		parts = append(parts, fmt.Sprintf("package %d", p.PackageID))
+	}
+	if p.Category != "" {
+		parts = append(parts, p.Category)
+	}
+	if p.MinAge > 0 && p.MaxAge > 0 {
+		parts = append(parts, fmt.Sprintf("age %d-%d", p.MinAge, p.MaxAge))
+	} else if p.MinAge > 0 {
+		parts = append(parts, fmt.Sprintf("age %d+", p.MinAge))
+	} else if p.MaxAge > 0 {
+		parts = append(parts, fmt.Sprintf("age up to %d", p.MaxAge))
+	}
+	if p.Gender != "" {
+		parts = append(parts, "gender "+p.Gender)
+	}
+	if p.MinVisits > 0 {
+		parts = append(parts, fmt.Sprintf("%d+ prior visits", p.MinVisits))
+	}
+	if p.StartDate != "" || p.EndDate != "" {
+		parts = append(parts, fmt.Sprintf("%s to %s", p.StartDate, p.EndDate))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Promotion management functions
+func addPromotion() {
+	printHeader("Add Promotion")
+
+	if !requirePermission(PERM_PROMOTION_MANAGE) {
+		return
+	}
+
+	if promotions.N >= NMAX {
+		printError("Cannot add more promotions. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	promo := Promotion{
+		ID:     getNextPromotionID(),
+		Name:   getValidInput("Enter promotion name: "),
+		Active: true,
+	}
+
+	fmt.Println("Discount type: 1. Percentage  2. Fixed amount  3. Buy X get Y free")
+	switch getValidInt("Select type: ", 1, 3) {
+	case 1:
+		promo.Type = DISCOUNT_PERCENTAGE
+		promo.Value = getValidFloat("Enter percentage off: ", 0.0)
+		if promo.Value > 100 {
+			promo.Value = 100
+		}
+	case 2:
+		promo.Type = DISCOUNT_FIXED
+		promo.Value = getValidFloat("Enter amount off: ", 0.0)
+	case 3:
+		promo.Type = DISCOUNT_BUY_X_GET_Y
+		promo.BuyX = getValidInt("Paid bookings (X): ", 1, 100)
+		promo.GetY = getValidInt("Free bookings (Y): ", 1, 100)
+	}
+
+	fmt.Println("\nApplies to: 1. All packages  2. One category  3. One package")
+	switch getValidInt("Choose option: ", 1, 3) {
+	case 2:
+		promo.Category = getValidCategory()
+	case 3:
+		id := getValidInt("Enter package ID: ", 1, 999999)
+		if binarySearchPackageByID(id) == -1 {
+			printWarning("Package not found; promotion will apply to all packages.")
+		} else {
+			promo.PackageID = id
+		}
+	}
+
+	fmt.Println("\nEligibility conditions (0 for no limit):")
+	promo.MinAge = getValidInt("Minimum age: ", 0, 150)
+	promo.MaxAge = getValidInt("Maximum age: ", 0, 150)
+	fmt.Println("Gender: 1. Any  2. Male  3. Female")
+	switch getValidInt("Choose option: ", 1, 3) {
+	case 2:
+		promo.Gender = "M"
+	case 3:
+		promo.Gender = "F"
+	}
+	promo.MinVisits = getValidInt("Minimum prior visits (returning patients): ", 0, 999)
+
+	fmt.Println("Limit to a date window? 1. Yes  2. No")
+	if getValidInt("Choose option: ", 1, 2) == 1 {
+		promo.StartDate = getValidDate("Enter start date")
+		promo.EndDate = getValidDate("Enter end date")
+	}
+
+	promotions.Daftar[promotions.N] = promo
+	promotions.N++
+	auditLog(AUDIT_CREATE, "promotion", promo.ID, nil, promo)
+
+	printSuccess(fmt.Sprintf("Promotion added successfully with ID: %d", promo.ID))
+	pause()
+}
+
+func displayPromotions() {
+	printHeader("Promotions")
+
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
+	if promotions.N == 0 {
+		printWarning("No promotions found.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "promotion", 0, nil, nil)
+
+	fmt.Printf("%s%-8s %-25s %-8s %-50s%s\n", BOLD, "ID", "Name", "Active", "Rule", RESET)
+	fmt.Println(strings.Repeat("-", 94))
+
+	for i := 0; i < promotions.N; i++ {
+		p := promotions.Daftar[i]
+		active := "No"
+		if p.Active {
+			active = "Yes"
+		}
+		fmt.Printf("%-8d %-25s %-8s %-50s\n", p.ID, p.Name, active, describePromotion(p))
+	}
+
+	pause()
+}
+
+func togglePromotion() {
+	printHeader("Activate/Deactivate Promotion")
+
+	if !requirePermission(PERM_PROMOTION_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter promotion ID: ", 1, 999999)
+	idx := binarySearchPromotionByID(id)
+
+	if idx == -1 {
+		printError("Promotion not found.")
+		pause()
+		return
+	}
+
+	p := &promotions.Daftar[idx]
+	before := *p
+	p.Active = !p.Active
+	auditLog(AUDIT_UPDATE, "promotion", p.ID, before, *p)
+	if p.Active {
+		printSuccess(fmt.Sprintf("Promotion %s activated.", p.Name))
+	} else {
+		printSuccess(fmt.Sprintf("Promotion %s deactivated.", p.Name))
+	}
+	pause()
+}
+
+func setFamilyGroup() {
+	printHeader("Family Group")
+
+	if !requirePermission(PERM_PATIENT_UPDATE) {
+		return
+	}
+
+	id := getValidInt("Enter patient ID: ", 1, 999999)
+	idx := binarySearchPatientByID(id)
+
+	if idx == -1 {
+		printError("Patient not found.")
+		pause()
+		return
+	}
+
+	p := &patients.Daftar[idx]
+	before := *p
+	fmt.Printf("\nPatient: %s (current group: %s)\n", p.Name, p.FamilyGroup)
+	p.FamilyGroup = getValidInput("Enter family group code (- to clear): ")
+	if p.FamilyGroup == "-" {
+		p.FamilyGroup = ""
+	}
+	auditLog(AUDIT_UPDATE, "patient", p.ID, before, *p)
+
+	printSuccess("Family group updated. Family members share buy-X-get-Y bundles.")
+	pause()
+}
+
+func promotionManagement() {
+	for {
+		printHeader("Discounts & Promotions")
+		fmt.Printf("%s1.%s Add Promotion\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Promotions\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Activate/Deactivate Promotion\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Set Patient Family Group\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 4)
+
+		switch choice {
+		case 1:
+			addPromotion()
+		case 2:
+			displayPromotions()
+		case 3:
+			togglePromotion()
+		case 4:
+			setFamilyGroup()
+		case 0:
+			return
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Constants
+const (
+	DATA_KEY_FILE         = "data.key"
+	DATA_KEY_FILE_ENV     = "MCU_KEY_FILE"
+	DATA_KEY_SIZE         = 32
+	DATA_KEY_ITERATIONS   = 200000
+	ENCRYPTION_FORMAT     = "mcu-encrypted-v1"
+	ENCRYPTION_KDF        = "pbkdf2-sha256"
+	KEY_SOURCE_PASSPHRASE = "passphrase"
+	KEY_SOURCE_FILE       = "keyfile"
+)
+
+var (
+	ErrWrongKey   = errors.New("wrong key: the data file was encrypted with a different passphrase or key file")
+	ErrCorrupted  = errors.New("data file is corrupted: the encrypted data failed its integrity check")
+	ErrNoDataKey  = errors.New("no encryption key is loaded")
+	ErrBadKeyFile = errors.New("key file must contain 64 hexadecimal characters")
+)
+
+// Data structures
+type EncryptedStore struct {
+	Format     string `json:"format"`
+	KeySource  string `json:"key_source"`
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       string `json:"salt,omitempty"`
+	KeyCheck   string `json:"key_check"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type DataKey struct {
+	Key        []byte
+	Source     string
+	Salt       string
+	Iterations int
+}
+
+// dataKey is the key for the data file, set up before loadData
+var dataKey *DataKey
+
+// Key functions
+func deriveDataKey(passphrase, salt string, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, []byte(salt), iterations, DATA_KEY_SIZE)
+}
+
+func newPassphraseKey(passphrase string) (*DataKey, error) {
+	salt := newSalt()
+	key, err := deriveDataKey(passphrase, salt, DATA_KEY_ITERATIONS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	return &DataKey{Key: key, Source: KEY_SOURCE_PASSPHRASE, Salt: salt, Iterations: DATA_KEY_ITERATIONS}, nil
+}
+
+func parseKeyFile(content string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(content))
+	if err != nil || len(key) != DATA_KEY_SIZE {
+		return nil, ErrBadKeyFile
+	}
+	return key, nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %v", path, err)
+	}
+	key, err := parseKeyFile(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+func writeKeyFile(path string, key []byte) error {
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write key file %s: %v", path, err)
+	}
+	return nil
+}
+
+func generateDataKey() ([]byte, error) {
+	key := make([]byte, DATA_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	return key, nil
+}
+
+func keyFilePath() string {
+	if path := os.Getenv(DATA_KEY_FILE_ENV); path != "" {
+		return path
+	}
+	return DATA_KEY_FILE
+}
+
+// keyCheck identifies a key without revealing it, so a wrong key can be told
+// apart from a damaged file
+func keyCheck(key []byte) string {
+	sum := sha256.Sum256(append([]byte("mcu-key-check:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Cipher functions
+func storeAdditionalData(s EncryptedStore) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%s|%s|%d|%s", s.Format, s.KeySource, s.KDF, s.Salt, s.Iterations, s.KeyCheck))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptStore seals the plaintext with AES-256-GCM. The header fields are
+// authenticated along with the data.
+func encryptStore(plaintext []byte, k *DataKey) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNoDataKey
+	}
+
+	gcm, err := newGCM(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up cipher: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	store := EncryptedStore{
+		Format:    ENCRYPTION_FORMAT,
+		KeySource: k.Source,
+		KeyCheck:  keyCheck(k.Key),
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+	}
+	if k.Source == KEY_SOURCE_PASSPHRASE {
+		store.KDF = ENCRYPTION_KDF
+		store.Salt = k.Salt
+		store.Iterations = k.Iterations
+	}
+
+	sealed := gcm.Seal(nil, nonce, plaintext, storeAdditionalData(store))
+	store.Ciphertext = base64.StdEncoding.EncodeToString(sealed)
+
+	return json.MarshalIndent(store, "", "  ")
+}
+
+func decryptStore(store EncryptedStore, key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoDataKey
+	}
+	if subtle.ConstantTimeCompare([]byte(keyCheck(key)), []byte(store.KeyCheck)) != 1 {
+		return nil, ErrWrongKey
+	}
+
+	nonce, err1 := base64.StdEncoding.DecodeString(store.Nonce)
+	sealed, err2 := base64.StdEncoding.DecodeString(store.Ciphertext)
+	if err1 != nil || err2 != nil {
+		return nil, ErrCorrupted
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up cipher: %v", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrCorrupted
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, storeAdditionalData(store))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return plaintext, nil
+}
+
+// parseEncryptedStore reports whether the file content is an encrypted store.
+// Plain JSON from older versions is not.
+func parseEncryptedStore(content []byte) (EncryptedStore, bool) {
+	var store EncryptedStore
+	if err := json.Unmarshal(content, &store); err != nil || store.Format != ENCRYPTION_FORMAT {
+		return store, false
+	}
+	return store, true
+}
+
+// writeDataFile replaces a data file in one step, so a failed write never
+// leaves a half-written store behind
+func writeDataFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write data file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace data file: %v", err)
+	}
+	return nil
+}
+
+// Startup functions
+func getNewPassphrase() string {
+	for {
+		passphrase := getValidPassword("Enter new data passphrase: ")
+		if getValidSecret("Confirm data passphrase: ") == passphrase {
+			return passphrase
+		}
+		printError("Passphrases do not match.")
+	}
+}
+
+// setupDataKey asks how a new store should be protected
+func setupDataKey() (*DataKey, error) {
+	fmt.Println("1. Protect with a passphrase  2. Generate a key file")
+	if getValidInt("Choose option: ", 1, 2) == 1 {
+		return newPassphraseKey(getNewPassphrase())
+	}
+
+	key, err := generateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	path := keyFilePath()
+	if err := writeKeyFile(path, key); err != nil {
+		return nil, err
+	}
+	printWarning(fmt.Sprintf("Key written to %s. Keep a copy somewhere safe: without it the data cannot be read.", path))
+	return &DataKey{Key: key, Source: KEY_SOURCE_FILE}, nil
+}
+
+// unlockKeyFile loads the key file for an encrypted store. A key left at
+// <path>.new by an interrupted rotation is picked up if it matches.
+func unlockKeyFile(store EncryptedStore) (*DataKey, error) {
+	path := keyFilePath()
+	key, err := readKeyFile(path)
+	if err == nil && keyCheck(key) == store.KeyCheck {
+		return &DataKey{Key: key, Source: KEY_SOURCE_FILE}, nil
+	}
+
+	if pending, pendingErr := readKeyFile(path + ".new"); pendingErr == nil && keyCheck(pending) == store.KeyCheck {
+		if err := os.Rename(path+".new", path); err != nil {
+			return nil, fmt.Errorf("failed to finish key rotation: %v", err)
+		}
+		printWarning("Finished an interrupted key rotation.")
+		return &DataKey{Key: pending, Source: KEY_SOURCE_FILE}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s: %w", path, ErrWrongKey)
+}
+
+func unlockPassphrase(store EncryptedStore) (*DataKey, error) {
+	for attempt := 1; attempt <= MAX_LOGIN_ATTEMPTS; attempt++ {
+		passphrase := getValidSecret("Enter data passphrase: ")
+		key, err := deriveDataKey(passphrase, store.Salt, store.Iterations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %v", err)
+		}
+		if keyCheck(key) == store.KeyCheck {
+			return &DataKey{Key: key, Source: KEY_SOURCE_PASSPHRASE, Salt: store.Salt, Iterations: store.Iterations}, nil
+		}
+		printError(fmt.Sprintf("Wrong passphrase (%d of %d attempts).", attempt, MAX_LOGIN_ATTEMPTS))
+	}
+	return nil, ErrWrongKey
+}
+
+// initDataKey unlocks the data file, or sets up a key when there is no
+// encrypted store yet. It runs before loadData.
+func initDataKey() error {
+	content, err := os.ReadFile(DATA_FILE)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to open data file: %v", err)
+	}
+
+	store, encrypted := parseEncryptedStore(content)
+	if encrypted {
+		if store.KeySource == KEY_SOURCE_FILE {
+			dataKey, err = unlockKeyFile(store)
+		} else {
+			printHeader("Unlock Data")
+			dataKey, err = unlockPassphrase(store)
+		}
+		return err
+	}
+
+	printHeader("Data Encryption Setup")
+	if len(content) > 0 {
+		printWarning("The data file is not encrypted. It will be encrypted the next time it is saved.")
+	} else {
+		printWarning("Patient data is stored encrypted. Choose how to protect it.")
+	}
+	dataKey, err = setupDataKey()
+	return err
+}
+
+// Key rotation functions
+func rotateDataKey() {
+	printHeader("Rotate Encryption Key")
+
+	if !requirePermission(PERM_KEY_MANAGE) {
+		return
+	}
+
+	if dataKey == nil {
+		printError(ErrNoDataKey.Error())
+		pause()
+		return
+	}
+
+	if dataKey.Source == KEY_SOURCE_PASSPHRASE {
+		current, err := deriveDataKey(getValidSecret("Enter current data passphrase: "), dataKey.Salt, dataKey.Iterations)
+		if err != nil || subtle.ConstantTimeCompare(current, dataKey.Key) != 1 {
+			printError("Current passphrase is incorrect.")
+			pause()
+			return
+		}
+	}
+
+	fmt.Println("New key: 1. Passphrase  2. Generate a new key file")
+	choice := getValidInt("Choose option: ", 1, 2)
+
+	var newKey *DataKey
+	var err error
+	path := keyFilePath()
+	if choice == 1 {
+		newKey, err = newPassphraseKey(getNewPassphrase())
+	} else {
+		var key []byte
+		if key, err = generateDataKey(); err == nil {
+			// The new key is kept next to the old one until the data is re-encrypted
+			err = writeKeyFile(path+".new", key)
+			newKey = &DataKey{Key: key, Source: KEY_SOURCE_FILE}
+		}
+	}
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+
+	oldKey := dataKey
+	oldSource := dataKey.Source
+	dataKey = newKey
+	if err := saveData(); err != nil {
+		dataKey = oldKey
+		os.Remove(path + ".new")
+		printError(fmt.Sprintf("Key rotation failed, data is still encrypted with the old key: %v", err))
+		pause()
+		return
+	}
+
+	// Check the new file reads back before the old key is dropped
+	if err := loadEncryptedFile(newKey.Key); err != nil {
+		printError(fmt.Sprintf("Re-encrypted file could not be verified: %v", err))
+		pause()
+		return
+	}
+
+	if newKey.Source == KEY_SOURCE_FILE {
+		if err := os.Rename(path+".new", path); err != nil {
+			printError(fmt.Sprintf("Data re-encrypted, but the key file could not be moved: %v. The key is in %s.new", err, path))
+			pause()
+			return
+		}
+		printWarning(fmt.Sprintf("New key written to %s. Replace any copies of the old key.", path))
+	} else if oldSource == KEY_SOURCE_FILE {
+		os.Remove(path)
+	}
+
+	// Audit values sealed with the old key are sealed with the new one
+	if _, err := resealAuditLog(oldKey.Key); err != nil {
+		printWarning(fmt.Sprintf("Audit log values are still sealed with the old key: %v", err))
+	}
+
+	auditLog(AUDIT_UPDATE, "encryption_key", 0,
+		map[string]string{"source": oldSource, "key_check": keyCheck(oldKey.Key)},
+		map[string]string{"source": newKey.Source, "key_check": keyCheck(newKey.Key)})
+
+	printSuccess("Data re-encrypted with the new key.")
+	pause()
+}
+
+// loadEncryptedFile checks that the data file decrypts with the given key
+func loadEncryptedFile(key []byte) error {
+	content, err := os.ReadFile(DATA_FILE)
+	if err != nil {
+		return fmt.Errorf("failed to open data file: %v", err)
+	}
+	store, encrypted := parseEncryptedStore(content)
+	if !encrypted {
+		return fmt.Errorf("data file is not encrypted")
+	}
+	_, err = decryptStore(store, key)
+	return err
+}
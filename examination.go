@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Data structures
+type Examination struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Unit    string  `json:"unit"`
+	RefLow  float64 `json:"ref_low"`
+	RefHigh float64 `json:"ref_high"`
+	Price   float64 `json:"price"`
+	Station string  `json:"station,omitempty"`
+}
+
+type ExaminationArray struct {
+	Daftar [NMAX]Examination `json:"daftar"`
+	N      int               `json:"n"`
+}
+
+type Result struct {
+	Code  string  `json:"code"`
+	Value float64 `json:"value"`
+	Flag  string  `json:"flag"`
+}
+
+// Search functions
+func sequentialSearchExaminationByCode(code string) int {
+	searchCode := strings.ToUpper(code)
+	for i := 0; i < examinations.N; i++ {
+		if examinations.Daftar[i].Code == searchCode {
+			return i
+		}
+	}
+	return -1
+}
+
+func searchRecordByID(id int) int {
+	for i := 0; i < records.N; i++ {
+		if records.Daftar[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Result functions
+func hasReferenceRange(e Examination) bool {
+	return e.RefLow != 0 || e.RefHigh != 0
+}
+
+// getResultFlag returns "L" below, "H" above and "N" within the reference range
+func getResultFlag(e Examination, value float64) string {
+	if !hasReferenceRange(e) {
+		return ""
+	}
+	if value < e.RefLow {
+		return "L"
+	}
+	if value > e.RefHigh {
+		return "H"
+	}
+	return "N"
+}
+
+func isAbnormal(r Result) bool {
+	return r.Flag == "L" || r.Flag == "H"
+}
+
+func setRecordResult(r *Record, e Examination, value float64) {
+	result := Result{Code: e.Code, Value: value, Flag: getResultFlag(e, value)}
+	for i := range r.Results {
+		if r.Results[i].Code == e.Code {
+			r.Results[i] = result
+			return
+		}
+	}
+	r.Results = append(r.Results, result)
+}
+
+func examinationName(code string) string {
+	if idx := sequentialSearchExaminationByCode(code); idx != -1 {
+		return examinations.Daftar[idx].Name
+	}
+	return code
+}
+
+// Examination catalog functions
+func addExamination() {
+	printHeader("Add Examination")
+
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
+	if examinations.N >= NMAX {
+		printError("Cannot add more examinations. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	code := strings.ToUpper(getValidInput("Enter examination code (e.g. GLU): "))
+	if sequentialSearchExaminationByCode(code) != -1 {
+		printError("An examination with this code already exists.")
+		pause()
+		return
+	}
+
+	exam := Examination{
+		Code: code,
+		Name: getValidInput("Enter examination name: "),
+		Unit: getValidInput("Enter unit (e.g. mg/dL, - for none): "),
+	}
+
+	fmt.Println("Does this examination have a numeric reference range? 1. Yes  2. No")
+	if getValidInt("Choose option: ", 1, 2) == 1 {
+		exam.RefLow = getValidFloat("Enter lower reference limit: ", 0.0)
+		exam.RefHigh = getValidFloat(fmt.Sprintf("Enter upper reference limit (>= %.2f): ", exam.RefLow), exam.RefLow)
+	}
+	exam.Price = getValidFloat("Enter examination price: ", 0.0)
+	fmt.Println("Station:")
+	exam.Station = selectStation("Choose station: ", false)
+
+	examinations.Daftar[examinations.N] = exam
+	examinations.N++
+	auditLog(AUDIT_CREATE, "examination", 0, nil, exam)
+
+	printSuccess(fmt.Sprintf("Examination %s added successfully.", exam.Code))
+	pause()
+}
+
+func displayExaminations() {
+	printHeader("Examination Catalog")
+
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
+	if examinations.N == 0 {
+		printWarning("No examinations found.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "examination", 0, nil, nil)
+
+	fmt.Printf("%s%-8s %-30s %-10s %-20s %-10s %-17s%s\n", BOLD, "Code", "Name", "Unit", "Reference Range", "Price", "Station", RESET)
+	fmt.Println(strings.Repeat("-", 100))
+
+	for i := 0; i < examinations.N; i++ {
+		e := examinations.Daftar[i]
+		refRange := "-"
+		if hasReferenceRange(e) {
+			refRange = fmt.Sprintf("%.2f - %.2f", e.RefLow, e.RefHigh)
+		}
+		fmt.Printf("%-8s %-30s %-10s %-20s $%-9.2f %-17s\n", e.Code, e.Name, e.Unit, refRange, e.Price, examinationStation(e.Code))
+	}
+
+	pause()
+}
+
+func deleteExamination() {
+	printHeader("Delete Examination")
+
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
+	code := getValidInput("Enter examination code to delete: ")
+	idx := sequentialSearchExaminationByCode(code)
+
+	if idx == -1 {
+		printError("Examination not found.")
+		pause()
+		return
+	}
+
+	e := examinations.Daftar[idx]
+	confirm := getValidInput(fmt.Sprintf("\nAre you sure you want to delete %s (%s)? (y/N): ", e.Name, e.Code))
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Deletion cancelled.")
+		pause()
+		return
+	}
+
+	// Shift elements to remove the examination
+	for i := idx; i < examinations.N-1; i++ {
+		examinations.Daftar[i] = examinations.Daftar[i+1]
+	}
+	examinations.N--
+	auditLog(AUDIT_DELETE, "examination", 0, e, nil)
+
+	printSuccess("Examination deleted successfully. Existing results keep their code.")
+	pause()
+}
+
+func packageHasExamination(p Package, code string) bool {
+	for _, c := range p.Examinations {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func assignPackageExaminations() {
+	printHeader("Package Examinations")
+
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
+	if examinations.N == 0 {
+		printError("No examinations in the catalog. Please add examinations first.")
+		pause()
+		return
+	}
+
+	id := getValidInt("Enter package ID: ", 1, 999999)
+	idx := binarySearchPackageByID(id)
+
+	if idx == -1 {
+		printError("Package not found.")
+		pause()
+		return
+	}
+
+	p := &packages.Daftar[idx]
+	before := append([]string(nil), p.Examinations...)
+	for {
+		fmt.Printf("\n%sExaminations in %s:%s\n", BOLD, p.Name, RESET)
+		for i := 0; i < examinations.N; i++ {
+			e := examinations.Daftar[i]
+			mark := " "
+			if packageHasExamination(*p, e.Code) {
+				mark = "x"
+			}
+			fmt.Printf("%d. [%s] %s - %s\n", i+1, mark, e.Code, e.Name)
+		}
+
+		choice := getValidInt("Toggle examination (0 to finish): ", 0, examinations.N)
+		if choice == 0 {
+			break
+		}
+
+		code := examinations.Daftar[choice-1].Code
+		if packageHasExamination(*p, code) {
+			for i, c := range p.Examinations {
+				if c == code {
+					p.Examinations = append(p.Examinations[:i], p.Examinations[i+1:]...)
+					break
+				}
+			}
+		} else {
+			p.Examinations = append(p.Examinations, code)
+		}
+	}
+
+	auditLog(AUDIT_UPDATE, "package_examinations", p.ID, before, p.Examinations)
+
+	printSuccess("Package examinations updated.")
+	pause()
+}
+
+func examinationManagement() {
+	for {
+		printHeader("Examination Catalog")
+		fmt.Printf("%s1.%s Add Examination\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Examinations\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Delete Examination\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Assign Examinations to Package\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 4)
+
+		switch choice {
+		case 1:
+			addExamination()
+		case 2:
+			displayExaminations()
+		case 3:
+			deleteExamination()
+		case 4:
+			assignPackageExaminations()
+		case 0:
+			return
+		}
+	}
+}
+
+// Result entry functions
+func printRecordResults(r Record) {
+	if len(r.Results) == 0 {
+		printWarning("No examination results recorded yet.")
+		return
+	}
+
+	fmt.Printf("%s%-8s %-30s %-12s %-10s %-5s%s\n", BOLD, "Code", "Examination", "Value", "Unit", "Flag", RESET)
+	fmt.Println(strings.Repeat("-", 70))
+	for _, res := range r.Results {
+		unit := ""
+		if idx := sequentialSearchExaminationByCode(res.Code); idx != -1 {
+			unit = examinations.Daftar[idx].Unit
+		}
+		flagColor := RESET
+		if isAbnormal(res) {
+			flagColor = RED
+		}
+		fmt.Printf("%-8s %-30s %-12.2f %-10s %s%-5s%s\n",
+			res.Code, examinationName(res.Code), res.Value, unit, flagColor, res.Flag, RESET)
+	}
+}
+
+func enterRecordResults() {
+	printHeader("Enter Examination Results")
+
+	if !requirePermission(PERM_RESULT_ENTER) {
+		return
+	}
+
+	if examinations.N == 0 {
+		printError("No examinations in the catalog. Please add examinations first.")
+		pause()
+		return
+	}
+
+	id := getValidInt("Enter record ID: ", 1, 999999)
+	idx := searchBranchRecordByID(id)
+
+	if idx == -1 {
+		printError("Record not found.")
+		pause()
+		return
+	}
+
+	r := &records.Daftar[idx]
+	if isSignedOff(*r) {
+		printError(fmt.Sprintf("Record %d is signed off by %s; its results are locked.", r.ID, signerName(*r)))
+		pause()
+		return
+	}
+	auditLog(AUDIT_VIEW, "record_results", r.ID, nil, nil)
+	before := append([]Result(nil), r.Results...)
+	beforeRecord := *r
+	beforeRecord.Results = before
+	fmt.Printf("\nRecord %d - %s, %s (%s)\n\n", r.ID, r.Patient.Name, r.Package.Name, r.Date)
+	printRecordResults(*r)
+
+	for {
+		fmt.Println("\nExaminations:")
+		for i := 0; i < examinations.N; i++ {
+			e := examinations.Daftar[i]
+			fmt.Printf("%d. %s - %s\n", i+1, e.Code, e.Name)
+		}
+		choice := getValidInt("Select examination (0 to finish): ", 0, examinations.N)
+		if choice == 0 {
+			break
+		}
+
+		e := examinations.Daftar[choice-1]
+		value := getValidFloat(fmt.Sprintf("Enter %s value (%s): ", e.Name, e.Unit), -999999)
+		setRecordResult(r, e, value)
+	}
+
+	auditLog(AUDIT_UPDATE, "record_results", r.ID, before, r.Results)
+	publishChange(ENTITY_RECORD, r.ID, beforeRecord, *r)
+	queueRecordChange(beforeRecord, *r)
+
+	fmt.Println()
+	printRecordResults(*r)
+	printSuccess("Results saved.")
+	deliverNotificationsSoon()
+	pause()
+}
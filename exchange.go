@@ -0,0 +1,80 @@
+package main
+
+import "fmt"
+
+// Menu functions
+func dataExchangeManagement() {
+	for {
+		printHeader("Data Exchange")
+		fmt.Printf("%s1.%s Export FHIR Bundle\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Import FHIR Bundle\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Import HL7 Results File\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Receive HL7 Results (MLLP Listener)\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Lab Code Mapping\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Webhooks\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 6)
+
+		switch choice {
+		case 1:
+			exportFHIRBundle()
+		case 2:
+			importFHIRBundle()
+		case 3:
+			importHL7Messages()
+		case 4:
+			runMLLPListener()
+		case 5:
+			labCodeMapping()
+		case 6:
+			webhookManagement()
+		case 0:
+			return
+		}
+	}
+}
+
+// runCommand runs a command given on the command line after login and
+// returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "fhir":
+		return fhirCommand(args[1:])
+	case "hl7":
+		return hl7Command(args[1:])
+	case "recall":
+		return recallCommand(args[1:])
+	case "notify":
+		return notifyCommand(args[1:])
+	case "webhook":
+		return webhookCommand(args[1:])
+	case "branch":
+		return branchCommand(args[1:])
+	}
+
+	fmt.Printf("Unknown command %q.\n", args[0])
+	fmt.Println("Commands:")
+	fmt.Println("  fhir export <file> [patient-id]   Write a FHIR R4 Bundle")
+	fmt.Println("  fhir import <file> [--dry-run]    Read a FHIR R4 Bundle")
+	fmt.Println("  hl7 import <file>                 Store results from ORU^R01 messages")
+	fmt.Println("  hl7 listen [address]              Receive ORU^R01 messages over MLLP")
+	fmt.Println("  hl7 send <address> <file>         Send messages as an analyzer would (test harness)")
+	fmt.Println("  recall list [days]                List patients due for a check-up")
+	fmt.Println("  recall send <channel> [days]      Send reminders by email, sms or outbox")
+	fmt.Println("  notify run                        Send patient notifications that are due")
+	fmt.Println("  webhook run                       Send webhook events that are due")
+	fmt.Println("  webhook replay <id> <offset>      Send a webhook everything again from an offset")
+	fmt.Println("  branch sync                       Sync this branch with the central store")
+	fmt.Println("  branch serve [address]            Serve the central store to branch installations")
+	return 2
+}
+
+// runStandaloneCommand runs commands that work without the data file or a
+// login. ok is false for every other command.
+func runStandaloneCommand(args []string) (int, bool) {
+	if len(args) > 1 && args[0] == "hl7" && args[1] == "send" {
+		return hl7SendCommand(args[2:]), true
+	}
+	return 0, false
+}
@@ -0,0 +1,1187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+// Identifiers and codes we issue use these systems, so a bundle we exported
+// can be matched back to our own IDs on import
+const (
+	FHIR_SYSTEM_PATIENT     = "urn:medicalcheckup:patient"
+	FHIR_SYSTEM_PACKAGE     = "urn:medicalcheckup:package"
+	FHIR_SYSTEM_RECORD      = "urn:medicalcheckup:record"
+	FHIR_SYSTEM_EXAMINATION = "urn:medicalcheckup:examination"
+	FHIR_SYSTEM_CATEGORY    = "urn:medicalcheckup:package-category"
+	FHIR_EXT_AGE            = "urn:medicalcheckup:fhir:age"
+	FHIR_EXT_PRICE          = "urn:medicalcheckup:fhir:price"
+
+	FHIR_ACT_CODE       = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+	FHIR_INTERPRETATION = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+)
+
+var fhirGenders = map[string]string{"M": "male", "F": "female"}
+
+// Data structures
+// Only the elements we read or write are modelled; anything else in an
+// imported bundle is ignored
+type FHIRIdentifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value"`
+}
+
+type FHIRCoding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type FHIRReference struct {
+	Reference string `json:"reference"`
+}
+
+type FHIRHumanName struct {
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+type FHIRExtension struct {
+	URL          string   `json:"url"`
+	ValueInteger *int     `json:"valueInteger,omitempty"`
+	ValueDecimal *float64 `json:"valueDecimal,omitempty"`
+}
+
+type FHIRQuantity struct {
+	Value *float64 `json:"value,omitempty"`
+	Unit  string   `json:"unit,omitempty"`
+}
+
+type FHIRRange struct {
+	Low  *FHIRQuantity `json:"low,omitempty"`
+	High *FHIRQuantity `json:"high,omitempty"`
+}
+
+type FHIRPeriod struct {
+	Start string `json:"start,omitempty"`
+}
+
+type FHIRPatient struct {
+	ResourceType string           `json:"resourceType"`
+	ID           string           `json:"id,omitempty"`
+	Extension    []FHIRExtension  `json:"extension,omitempty"`
+	Identifier   []FHIRIdentifier `json:"identifier,omitempty"`
+	Name         []FHIRHumanName  `json:"name,omitempty"`
+	Gender       string           `json:"gender,omitempty"`
+	BirthDate    string           `json:"birthDate,omitempty"`
+}
+
+type FHIRPlanAction struct {
+	Title string                `json:"title,omitempty"`
+	Code  []FHIRCodeableConcept `json:"code,omitempty"`
+}
+
+type FHIRPlanDefinition struct {
+	ResourceType string                `json:"resourceType"`
+	ID           string                `json:"id,omitempty"`
+	Extension    []FHIRExtension       `json:"extension,omitempty"`
+	Identifier   []FHIRIdentifier      `json:"identifier,omitempty"`
+	Title        string                `json:"title,omitempty"`
+	Status       string                `json:"status,omitempty"`
+	Topic        []FHIRCodeableConcept `json:"topic,omitempty"`
+	Action       []FHIRPlanAction      `json:"action,omitempty"`
+}
+
+type FHIREncounter struct {
+	ResourceType string                `json:"resourceType"`
+	ID           string                `json:"id,omitempty"`
+	Identifier   []FHIRIdentifier      `json:"identifier,omitempty"`
+	Status       string                `json:"status,omitempty"`
+	Class        *FHIRCoding           `json:"class,omitempty"`
+	Type         []FHIRCodeableConcept `json:"type,omitempty"`
+	Subject      *FHIRReference        `json:"subject,omitempty"`
+	Period       *FHIRPeriod           `json:"period,omitempty"`
+}
+
+type FHIRObservation struct {
+	ResourceType      string                `json:"resourceType"`
+	ID                string                `json:"id,omitempty"`
+	Status            string                `json:"status,omitempty"`
+	Code              *FHIRCodeableConcept  `json:"code,omitempty"`
+	Subject           *FHIRReference        `json:"subject,omitempty"`
+	Encounter         *FHIRReference        `json:"encounter,omitempty"`
+	EffectiveDateTime string                `json:"effectiveDateTime,omitempty"`
+	ValueQuantity     *FHIRQuantity         `json:"valueQuantity,omitempty"`
+	Interpretation    []FHIRCodeableConcept `json:"interpretation,omitempty"`
+	ReferenceRange    []FHIRRange           `json:"referenceRange,omitempty"`
+}
+
+type FHIRBundleEntry struct {
+	FullURL  string          `json:"fullUrl,omitempty"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	Type         string            `json:"type,omitempty"`
+	Timestamp    string            `json:"timestamp,omitempty"`
+	Entry        []FHIRBundleEntry `json:"entry,omitempty"`
+}
+
+// Conversion helpers
+// fhirDate turns DD/MM/YYYY into the FHIR YYYY-MM-DD form
+func fhirDate(date string) string {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return date
+	}
+	return parts[2] + "-" + parts[1] + "-" + parts[0]
+}
+
+// dateFromFHIR accepts a FHIR date or dateTime and returns DD/MM/YYYY
+func dateFromFHIR(value string) (string, error) {
+	if len(value) < 10 {
+		return "", fmt.Errorf("invalid date %q", value)
+	}
+	t, err := time.Parse("2006-01-02", value[:10])
+	if err != nil || !isValidDate(t.Day(), int(t.Month()), t.Year()) {
+		return "", fmt.Errorf("invalid date %q", value)
+	}
+	return t.Format("02/01/2006"), nil
+}
+
+func fhirReference(resourceType, id string) *FHIRReference {
+	return &FHIRReference{Reference: resourceType + "/" + id}
+}
+
+// ownIdentifier returns our ID from the identifier issued under system, or 0
+func ownIdentifier(identifiers []FHIRIdentifier, system string) int {
+	for _, identifier := range identifiers {
+		if identifier.System == system {
+			if id, err := strconv.Atoi(identifier.Value); err == nil {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+func findCoding(concept FHIRCodeableConcept, system string) (FHIRCoding, bool) {
+	for _, coding := range concept.Coding {
+		if coding.System == system {
+			return coding, true
+		}
+	}
+	return FHIRCoding{}, false
+}
+
+func missingElements(missing []string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing required element %s", strings.Join(missing, ", "))
+}
+
+// Patient conversion
+func fhirPatient(p Patient) FHIRPatient {
+	age := p.Age
+	name := FHIRHumanName{Text: p.Name}
+	if words := strings.Fields(p.Name); len(words) > 1 {
+		name.Family = words[len(words)-1]
+		name.Given = words[:len(words)-1]
+	} else {
+		name.Family = p.Name
+	}
+
+	return FHIRPatient{
+		ResourceType: "Patient",
+		ID:           fmt.Sprintf("patient-%d", p.ID),
+		Extension:    []FHIRExtension{{URL: FHIR_EXT_AGE, ValueInteger: &age}},
+		Identifier:   []FHIRIdentifier{{System: FHIR_SYSTEM_PATIENT, Value: strconv.Itoa(p.ID)}},
+		Name:         []FHIRHumanName{name},
+		Gender:       fhirGenders[p.Gender],
+	}
+}
+
+// patientFromFHIR reads a Patient resource. The ID is ours when the patient
+// carries our identifier, 0 otherwise. Without our age extension the age is
+// worked out from birthDate as of thisYear.
+func patientFromFHIR(fp FHIRPatient, thisYear int) (Patient, error) {
+	var missing []string
+	p := Patient{ID: ownIdentifier(fp.Identifier, FHIR_SYSTEM_PATIENT)}
+
+	if len(fp.Name) > 0 {
+		p.Name = fp.Name[0].Text
+		if p.Name == "" {
+			p.Name = strings.TrimSpace(strings.Join(append(append([]string(nil), fp.Name[0].Given...), fp.Name[0].Family), " "))
+		}
+	}
+	if p.Name == "" {
+		missing = append(missing, "Patient.name")
+	}
+
+	switch fp.Gender {
+	case "male":
+		p.Gender = "M"
+	case "female":
+		p.Gender = "F"
+	case "":
+		missing = append(missing, "Patient.gender")
+	}
+
+	p.Age = -1
+	for _, ext := range fp.Extension {
+		if ext.URL == FHIR_EXT_AGE && ext.ValueInteger != nil {
+			p.Age = *ext.ValueInteger
+		}
+	}
+	if p.Age == -1 && len(fp.BirthDate) >= 4 {
+		if year, err := strconv.Atoi(fp.BirthDate[:4]); err == nil {
+			p.Age = thisYear - year
+		}
+	}
+	if p.Age == -1 {
+		missing = append(missing, "Patient.birthDate")
+	}
+
+	if err := missingElements(missing); err != nil {
+		return p, err
+	}
+	if p.Gender == "" {
+		return p, fmt.Errorf("Patient.gender %q is not supported, only male or female", fp.Gender)
+	}
+	if p.Age < 0 || p.Age > 150 {
+		return p, fmt.Errorf("Patient age %d is out of range", p.Age)
+	}
+	return p, nil
+}
+
+// Package conversion
+func fhirPlanDefinition(p Package) FHIRPlanDefinition {
+	price := p.Price
+	pd := FHIRPlanDefinition{
+		ResourceType: "PlanDefinition",
+		ID:           fmt.Sprintf("package-%d", p.ID),
+		Extension:    []FHIRExtension{{URL: FHIR_EXT_PRICE, ValueDecimal: &price}},
+		Identifier:   []FHIRIdentifier{{System: FHIR_SYSTEM_PACKAGE, Value: strconv.Itoa(p.ID)}},
+		Title:        p.Name,
+		Status:       "active",
+		Topic: []FHIRCodeableConcept{{
+			Coding: []FHIRCoding{{System: FHIR_SYSTEM_CATEGORY, Code: p.Category}},
+			Text:   p.Category,
+		}},
+	}
+	for _, code := range p.Examinations {
+		pd.Action = append(pd.Action, FHIRPlanAction{
+			Title: code,
+			Code:  []FHIRCodeableConcept{{Coding: []FHIRCoding{{System: FHIR_SYSTEM_EXAMINATION, Code: code}}}},
+		})
+	}
+	return pd
+}
+
+// packageFromFHIR reads a PlanDefinition. The category is returned by name
+// and still has to be checked against the configured categories.
+func packageFromFHIR(pd FHIRPlanDefinition) (Package, error) {
+	var missing []string
+	p := Package{ID: ownIdentifier(pd.Identifier, FHIR_SYSTEM_PACKAGE), Name: pd.Title}
+	if pd.Status == "" {
+		missing = append(missing, "PlanDefinition.status")
+	}
+	if p.Name == "" {
+		missing = append(missing, "PlanDefinition.title")
+	}
+	if len(pd.Topic) > 0 {
+		p.Category = pd.Topic[0].Text
+		if coding, ok := findCoding(pd.Topic[0], FHIR_SYSTEM_CATEGORY); ok {
+			p.Category = coding.Code
+		}
+	}
+	if p.Category == "" {
+		missing = append(missing, "PlanDefinition.topic")
+	}
+
+	for _, ext := range pd.Extension {
+		if ext.URL == FHIR_EXT_PRICE && ext.ValueDecimal != nil {
+			p.Price = *ext.ValueDecimal
+		}
+	}
+	for _, action := range pd.Action {
+		for _, concept := range action.Code {
+			if coding, ok := findCoding(concept, FHIR_SYSTEM_EXAMINATION); ok {
+				p.Examinations = append(p.Examinations, strings.ToUpper(coding.Code))
+			}
+		}
+	}
+
+	if err := missingElements(missing); err != nil {
+		return p, err
+	}
+	if p.Price < 0 {
+		return p, fmt.Errorf("PlanDefinition price %.2f is negative", p.Price)
+	}
+	return p, nil
+}
+
+// Record conversion
+// encounterStatus follows the record: booked for a later day, under way until
+// a doctor signs it off, then finished
+func encounterStatus(r Record, today string) string {
+	switch {
+	case isSignedOff(r):
+		return "finished"
+	case compareDates(r.Date, today) > 0:
+		return "planned"
+	}
+	return "in-progress"
+}
+
+func fhirEncounter(r Record) FHIREncounter {
+	return FHIREncounter{
+		ResourceType: "Encounter",
+		ID:           fmt.Sprintf("record-%d", r.ID),
+		Identifier:   []FHIRIdentifier{{System: FHIR_SYSTEM_RECORD, Value: strconv.Itoa(r.ID)}},
+		Status:       encounterStatus(r, todayDate()),
+		Class:        &FHIRCoding{System: FHIR_ACT_CODE, Code: "AMB", Display: "ambulatory"},
+		Type: []FHIRCodeableConcept{{
+			Coding: []FHIRCoding{{System: FHIR_SYSTEM_PACKAGE, Code: strconv.Itoa(r.Package.ID), Display: r.Package.Name}},
+			Text:   r.Package.Name,
+		}},
+		Subject: fhirReference("Patient", fmt.Sprintf("patient-%d", r.Patient.ID)),
+		Period:  &FHIRPeriod{Start: fhirDate(r.Date)},
+	}
+}
+
+// encounterFromFHIR reads an Encounter into a record with our ID (or 0), its
+// date and the package named by its type. The patient is returned as the
+// subject reference, to be resolved against the bundle.
+func encounterFromFHIR(enc FHIREncounter) (Record, string, error) {
+	var missing []string
+	r := Record{ID: ownIdentifier(enc.Identifier, FHIR_SYSTEM_RECORD)}
+	if enc.Status == "" {
+		missing = append(missing, "Encounter.status")
+	}
+	if enc.Class == nil || enc.Class.Code == "" {
+		missing = append(missing, "Encounter.class")
+	}
+
+	subject := ""
+	if enc.Subject != nil {
+		subject = enc.Subject.Reference
+	}
+	if subject == "" {
+		missing = append(missing, "Encounter.subject")
+	}
+
+	if len(enc.Type) > 0 {
+		r.Package.Name = enc.Type[0].Text
+		if coding, ok := findCoding(enc.Type[0], FHIR_SYSTEM_PACKAGE); ok {
+			r.Package.ID, _ = strconv.Atoi(coding.Code)
+			if r.Package.Name == "" {
+				r.Package.Name = coding.Display
+			}
+		}
+	}
+	if r.Package.ID == 0 && r.Package.Name == "" {
+		missing = append(missing, "Encounter.type")
+	}
+
+	if enc.Period == nil || enc.Period.Start == "" {
+		missing = append(missing, "Encounter.period.start")
+	}
+	if err := missingElements(missing); err != nil {
+		return r, subject, err
+	}
+
+	date, err := dateFromFHIR(enc.Period.Start)
+	if err != nil {
+		return r, subject, fmt.Errorf("Encounter.period.start: %v", err)
+	}
+	r.Date = date
+	return r, subject, nil
+}
+
+// Result conversion
+func fhirObservation(r Record, res Result, e Examination) FHIRObservation {
+	name := e.Name
+	if name == "" {
+		name = res.Code
+	}
+	value := res.Value
+	// Results may still change until the record is signed off
+	status := "preliminary"
+	if isSignedOff(r) {
+		status = "final"
+	}
+	obs := FHIRObservation{
+		ResourceType: "Observation",
+		ID:           fmt.Sprintf("result-%d-%s", r.ID, strings.ToLower(res.Code)),
+		Status:       status,
+		Code: &FHIRCodeableConcept{
+			Coding: []FHIRCoding{{System: FHIR_SYSTEM_EXAMINATION, Code: res.Code, Display: name}},
+			Text:   name,
+		},
+		Subject:           fhirReference("Patient", fmt.Sprintf("patient-%d", r.Patient.ID)),
+		Encounter:         fhirReference("Encounter", fmt.Sprintf("record-%d", r.ID)),
+		EffectiveDateTime: fhirDate(r.Date),
+		ValueQuantity:     &FHIRQuantity{Value: &value, Unit: e.Unit},
+	}
+	if res.Flag != "" {
+		obs.Interpretation = []FHIRCodeableConcept{{Coding: []FHIRCoding{{System: FHIR_INTERPRETATION, Code: res.Flag}}}}
+	}
+	if hasReferenceRange(e) {
+		low, high := e.RefLow, e.RefHigh
+		obs.ReferenceRange = []FHIRRange{{
+			Low:  &FHIRQuantity{Value: &low, Unit: e.Unit},
+			High: &FHIRQuantity{Value: &high, Unit: e.Unit},
+		}}
+	}
+	return obs
+}
+
+// observationFromFHIR reads an Observation into a result, the examination it
+// measures (for the catalog) and the encounter reference it belongs to
+func observationFromFHIR(obs FHIRObservation) (Result, Examination, string, error) {
+	var missing []string
+	var res Result
+	var e Examination
+
+	if obs.Status == "" {
+		missing = append(missing, "Observation.status")
+	}
+	if obs.Code != nil {
+		coding, ok := findCoding(*obs.Code, FHIR_SYSTEM_EXAMINATION)
+		if !ok && len(obs.Code.Coding) > 0 {
+			coding = obs.Code.Coding[0]
+		}
+		e.Code = strings.ToUpper(coding.Code)
+		e.Name = obs.Code.Text
+		if e.Name == "" {
+			e.Name = coding.Display
+		}
+	}
+	if e.Code == "" {
+		missing = append(missing, "Observation.code")
+	}
+
+	encounter := ""
+	if obs.Encounter != nil {
+		encounter = obs.Encounter.Reference
+	}
+	if encounter == "" {
+		missing = append(missing, "Observation.encounter")
+	}
+	if obs.ValueQuantity == nil || obs.ValueQuantity.Value == nil {
+		missing = append(missing, "Observation.valueQuantity")
+	}
+	if err := missingElements(missing); err != nil {
+		return res, e, encounter, err
+	}
+
+	if e.Name == "" {
+		e.Name = e.Code
+	}
+	e.Unit = obs.ValueQuantity.Unit
+	if len(obs.ReferenceRange) > 0 {
+		if low := obs.ReferenceRange[0].Low; low != nil && low.Value != nil {
+			e.RefLow = *low.Value
+		}
+		if high := obs.ReferenceRange[0].High; high != nil && high.Value != nil {
+			e.RefHigh = *high.Value
+		}
+	}
+
+	res = Result{Code: e.Code, Value: *obs.ValueQuantity.Value}
+	for _, concept := range obs.Interpretation {
+		if coding, ok := findCoding(concept, FHIR_INTERPRETATION); ok {
+			res.Flag = coding.Code
+		}
+	}
+	return res, e, encounter, nil
+}
+
+// Bundle functions
+func addBundleEntry(bundle *FHIRBundle, resourceType, id string, resource interface{}) error {
+	content, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s/%s: %v", resourceType, id, err)
+	}
+	bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullURL: resourceType + "/" + id, Resource: content})
+	return nil
+}
+
+// buildFHIRBundle collects one patient's data, or everything when patientID
+// is 0, from the branches this session can see. Records whose patient was
+// anonymised or erased are left out.
+func buildFHIRBundle(patientID int) (FHIRBundle, error) {
+	bundle := FHIRBundle{ResourceType: "Bundle", Type: "collection", Timestamp: time.Now().Format(time.RFC3339)}
+
+	var selected []Record
+	patientSeen := make(map[int]bool)
+	var patientList []Patient
+	addPatient := func(p Patient) {
+		if !patientSeen[p.ID] {
+			patientSeen[p.ID] = true
+			patientList = append(patientList, p)
+		}
+	}
+
+	if patientID == 0 {
+		for _, p := range branchPatients(activeBranch) {
+			addPatient(p)
+		}
+	} else if idx := binarySearchPatientByID(patientID); idx != -1 {
+		addPatient(patients.Daftar[idx])
+	}
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.Patient.ID == 0 || (patientID != 0 && r.Patient.ID != patientID) || !branchVisible(r.BranchID) {
+			continue
+		}
+		// The record keeps a copy of the patient in case the patient was deleted since
+		if idx := binarySearchPatientByID(r.Patient.ID); idx != -1 {
+			r.Patient = patients.Daftar[idx]
+		}
+		addPatient(r.Patient)
+		selected = append(selected, r)
+	}
+
+	packageSeen := make(map[int]bool)
+	var packageList []Package
+	addPackage := func(p Package) {
+		if !packageSeen[p.ID] {
+			packageSeen[p.ID] = true
+			packageList = append(packageList, p)
+		}
+	}
+	if patientID == 0 {
+		for i := 0; i < packages.N; i++ {
+			if branchVisible(packages.Daftar[i].BranchID) {
+				addPackage(packages.Daftar[i])
+			}
+		}
+	}
+	for _, r := range selected {
+		if idx := binarySearchPackageByID(r.Package.ID); idx != -1 {
+			addPackage(packages.Daftar[idx])
+		} else {
+			addPackage(r.Package)
+		}
+	}
+
+	for _, p := range patientList {
+		fp := fhirPatient(p)
+		if err := addBundleEntry(&bundle, fp.ResourceType, fp.ID, fp); err != nil {
+			return bundle, err
+		}
+	}
+	for _, p := range packageList {
+		pd := fhirPlanDefinition(p)
+		if err := addBundleEntry(&bundle, pd.ResourceType, pd.ID, pd); err != nil {
+			return bundle, err
+		}
+	}
+	for _, r := range selected {
+		enc := fhirEncounter(r)
+		if err := addBundleEntry(&bundle, enc.ResourceType, enc.ID, enc); err != nil {
+			return bundle, err
+		}
+		for _, res := range r.Results {
+			e := Examination{Code: res.Code}
+			if idx := sequentialSearchExaminationByCode(res.Code); idx != -1 {
+				e = examinations.Daftar[idx]
+			}
+			obs := fhirObservation(r, res, e)
+			if err := addBundleEntry(&bundle, obs.ResourceType, obs.ID, obs); err != nil {
+				return bundle, err
+			}
+		}
+	}
+	return bundle, nil
+}
+
+func writeFHIRBundle(bundle FHIRBundle, filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(bundle); err != nil {
+		return fmt.Errorf("failed to encode bundle: %v", err)
+	}
+	return nil
+}
+
+func readFHIRBundle(filename string) (FHIRBundle, error) {
+	var bundle FHIRBundle
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return bundle, fmt.Errorf("cannot read %s: %v", filename, err)
+	}
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return bundle, fmt.Errorf("%s is not valid JSON: %v", filename, err)
+	}
+	if bundle.ResourceType != "Bundle" {
+		return bundle, fmt.Errorf("%s holds a %q resource, not a Bundle", filename, bundle.ResourceType)
+	}
+	if bundle.Type == "" {
+		return bundle, errors.New("missing required element Bundle.type")
+	}
+	return bundle, nil
+}
+
+// Import planning
+// A FHIR import is checked in full before anything is changed: every resource
+// is converted and every reference resolved into a plan, which is then applied
+type fhirPatientItem struct {
+	patient Patient
+	idx     int // existing patient, or -1 to add
+}
+
+type fhirPackageItem struct {
+	pkg Package
+	idx int // existing package, or -1 to add
+}
+
+type fhirRecordItem struct {
+	record  Record
+	idx     int // existing record, or -1 to add
+	patient *fhirPatientItem
+	pkg     *fhirPackageItem
+	results []Result
+}
+
+type fhirImportPlan struct {
+	patients     []*fhirPatientItem
+	packages     []*fhirPackageItem
+	records      []*fhirRecordItem
+	examinations []Examination // new catalog entries
+	ignored      int           // entries of resource types we don't hold
+}
+
+// entryReferences lists the ways other resources may refer to an entry
+func entryReferences(entry FHIRBundleEntry, resourceType, id string) []string {
+	refs := []string{resourceType + "/" + id}
+	if entry.FullURL != "" {
+		refs = append(refs, entry.FullURL)
+	}
+	return refs
+}
+
+func planFHIRImport(bundle FHIRBundle) (*fhirImportPlan, []ImportError) {
+	plan := &fhirImportPlan{}
+	var errs []ImportError
+	patientRefs := make(map[string]*fhirPatientItem)
+	packageRefs := make(map[string]*fhirPackageItem)
+	recordRefs := make(map[string]*fhirRecordItem)
+	thisYear := time.Now().Year()
+
+	type pendingRecord struct {
+		entry   int
+		item    *fhirRecordItem
+		subject string
+	}
+	type pendingResult struct {
+		entry     int
+		result    Result
+		encounter string
+	}
+	var pendingRecords []pendingRecord
+	var pendingResults []pendingResult
+	newExams := make(map[string]bool)
+
+	for i, entry := range bundle.Entry {
+		fail := func(resourceType, id, message string) {
+			errs = append(errs, ImportError{Line: i + 1, Row: i + 1, Column: resourceType, Value: id, Message: message})
+		}
+
+		var head struct {
+			ResourceType string `json:"resourceType"`
+			ID           string `json:"id"`
+		}
+		if err := json.Unmarshal(entry.Resource, &head); err != nil || head.ResourceType == "" {
+			fail("", "", "missing required element resourceType")
+			continue
+		}
+		decode := func(v interface{}) bool {
+			if err := json.Unmarshal(entry.Resource, v); err != nil {
+				fail(head.ResourceType, head.ID, fmt.Sprintf("invalid resource: %v", err))
+				return false
+			}
+			return true
+		}
+
+		switch head.ResourceType {
+		case "Patient":
+			var fp FHIRPatient
+			if !decode(&fp) {
+				continue
+			}
+			p, err := patientFromFHIR(fp, thisYear)
+			if err != nil {
+				fail(head.ResourceType, head.ID, err.Error())
+				continue
+			}
+			item := &fhirPatientItem{patient: p, idx: -1}
+			if p.ID != 0 {
+				item.idx = binarySearchPatientByID(p.ID)
+			}
+			if item.idx == -1 {
+				item.idx = findPatient(p.Name, p.Gender, p.Age)
+			}
+			plan.patients = append(plan.patients, item)
+			for _, ref := range entryReferences(entry, head.ResourceType, head.ID) {
+				patientRefs[ref] = item
+			}
+
+		case "PlanDefinition":
+			var pd FHIRPlanDefinition
+			if !decode(&pd) {
+				continue
+			}
+			p, err := packageFromFHIR(pd)
+			if err != nil {
+				fail(head.ResourceType, head.ID, err.Error())
+				continue
+			}
+			item := &fhirPackageItem{pkg: p, idx: -1}
+			if p.ID != 0 {
+				item.idx = binarySearchPackageByID(p.ID)
+			}
+			if item.idx == -1 {
+				item.idx = findPackage(p.Name)
+			}
+			if item.idx == -1 {
+				category, err := parseValidCategory(p.Category)
+				if err != nil {
+					fail(head.ResourceType, head.ID, err.Error())
+					continue
+				}
+				item.pkg.Category, item.pkg.CategoryID = category.Name, category.ID
+			}
+			plan.packages = append(plan.packages, item)
+			for _, ref := range entryReferences(entry, head.ResourceType, head.ID) {
+				packageRefs[ref] = item
+			}
+			if p.ID != 0 {
+				packageRefs[strconv.Itoa(p.ID)] = item
+			}
+			packageRefs["title:"+strings.ToLower(p.Name)] = item
+
+		case "Encounter":
+			var enc FHIREncounter
+			if !decode(&enc) {
+				continue
+			}
+			r, subject, err := encounterFromFHIR(enc)
+			if err != nil {
+				fail(head.ResourceType, head.ID, err.Error())
+				continue
+			}
+			item := &fhirRecordItem{record: r, idx: -1}
+			if r.ID != 0 {
+				item.idx = searchRecordByID(r.ID)
+			}
+			plan.records = append(plan.records, item)
+			pendingRecords = append(pendingRecords, pendingRecord{entry: i, item: item, subject: subject})
+			for _, ref := range entryReferences(entry, head.ResourceType, head.ID) {
+				recordRefs[ref] = item
+			}
+
+		case "Observation":
+			var obs FHIRObservation
+			if !decode(&obs) {
+				continue
+			}
+			res, e, encounter, err := observationFromFHIR(obs)
+			if err != nil {
+				fail(head.ResourceType, head.ID, err.Error())
+				continue
+			}
+			if sequentialSearchExaminationByCode(e.Code) == -1 && !newExams[e.Code] {
+				newExams[e.Code] = true
+				plan.examinations = append(plan.examinations, e)
+			}
+			pendingResults = append(pendingResults, pendingResult{entry: i, result: res, encounter: encounter})
+
+		default:
+			plan.ignored++
+		}
+	}
+
+	// Resolve references now that every entry has been read
+	for _, pending := range pendingRecords {
+		item := pending.item
+		fail := func(message string) {
+			errs = append(errs, ImportError{Line: pending.entry + 1, Row: pending.entry + 1,
+				Column: "Encounter", Value: pending.subject, Message: message})
+		}
+
+		item.patient = patientRefs[pending.subject]
+		if item.patient == nil {
+			fail("subject does not refer to a Patient in the bundle")
+		} else if item.idx != -1 && item.patient.idx != -1 &&
+			records.Daftar[item.idx].Patient.ID != patients.Daftar[item.patient.idx].ID {
+			fail(fmt.Sprintf("record %d belongs to another patient", item.record.ID))
+		}
+
+		pkg := item.record.Package
+		item.pkg = packageRefs[strconv.Itoa(pkg.ID)]
+		if item.pkg == nil {
+			item.pkg = packageRefs["title:"+strings.ToLower(pkg.Name)]
+		}
+		if item.pkg == nil {
+			idx := binarySearchPackageByID(pkg.ID)
+			if idx == -1 {
+				idx = findPackage(pkg.Name)
+			}
+			if idx == -1 {
+				fail(fmt.Sprintf("package %q is neither in the bundle nor known here", pkg.Name))
+			} else {
+				item.pkg = &fhirPackageItem{pkg: packages.Daftar[idx], idx: idx}
+			}
+		}
+	}
+	for _, pending := range pendingResults {
+		item := recordRefs[pending.encounter]
+		if item == nil {
+			errs = append(errs, ImportError{Line: pending.entry + 1, Row: pending.entry + 1, Column: "Observation",
+				Value: pending.encounter, Message: "encounter does not refer to an Encounter in the bundle"})
+			continue
+		}
+		if item.idx != -1 && isSignedOff(records.Daftar[item.idx]) && !hasResult(records.Daftar[item.idx], pending.result) {
+			errs = append(errs, ImportError{Line: pending.entry + 1, Row: pending.entry + 1, Column: "Observation",
+				Value: pending.result.Code, Message: fmt.Sprintf("record %d is signed off; its results are locked", item.record.ID)})
+			continue
+		}
+		item.results = append(item.results, pending.result)
+	}
+
+	// Make sure everything new fits
+	count := func(n, added int, what string) {
+		if n+added > NMAX {
+			errs = append(errs, ImportError{Column: what, Message: fmt.Sprintf("%s list is full (maximum %d)", what, NMAX)})
+		}
+	}
+	newPatients, newPackages, newRecords := 0, 0, 0
+	for _, item := range plan.patients {
+		if item.idx == -1 {
+			newPatients++
+		}
+	}
+	for _, item := range plan.packages {
+		if item.idx == -1 {
+			newPackages++
+		}
+	}
+	for _, item := range plan.records {
+		if item.idx == -1 {
+			newRecords++
+		}
+	}
+	count(patients.N, newPatients, "patient")
+	count(packages.N, newPackages, "package")
+	count(records.N, newRecords, "record")
+	count(examinations.N, len(plan.examinations), "examination")
+
+	return plan, errs
+}
+
+// importBranch returns the branch new records of a plan are made at, asking
+// only when there are any
+func importBranch(plan *fhirImportPlan) int {
+	for _, item := range plan.records {
+		if item.idx == -1 {
+			return recordBranch()
+		}
+	}
+	return activeBranch
+}
+
+// applyFHIRImport carries out a checked plan, making new records at branch,
+// and returns a summary
+func applyFHIRImport(plan *fhirImportPlan, branch int) []string {
+	patientsAdded, patientsUpdated := 0, 0
+	for _, item := range plan.patients {
+		if item.idx != -1 {
+			p := &patients.Daftar[item.idx]
+			if p.Name != item.patient.Name || p.Gender != item.patient.Gender || p.Age != item.patient.Age {
+				before := *p
+				p.Name, p.Gender, p.Age = item.patient.Name, item.patient.Gender, item.patient.Age
+				auditLog(AUDIT_UPDATE, "patient", p.ID, before, *p)
+				publishChange(ENTITY_PATIENT, p.ID, before, *p)
+				patientsUpdated++
+			}
+			item.patient = *p
+			continue
+		}
+		item.patient.ID = getNextPatientID()
+		patients.Daftar[patients.N] = item.patient
+		item.idx = patients.N
+		patients.N++
+		auditLog(AUDIT_CREATE, "patient", item.patient.ID, nil, item.patient)
+		publishChange(ENTITY_PATIENT, item.patient.ID, nil, item.patient)
+		patientsAdded++
+	}
+
+	for _, e := range plan.examinations {
+		examinations.Daftar[examinations.N] = e
+		examinations.N++
+		auditLog(AUDIT_CREATE, "examination", 0, nil, e)
+	}
+
+	packagesAdded := 0
+	for _, item := range plan.packages {
+		if item.idx != -1 {
+			// Known packages are kept as they are; prices change through price versions
+			item.pkg = packages.Daftar[item.idx]
+			continue
+		}
+		item.pkg.ID = getNextPackageID()
+		item.pkg.BranchID = activeBranch
+		ensurePriceHistory(&item.pkg)
+		packages.Daftar[packages.N] = item.pkg
+		item.idx = packages.N
+		packages.N++
+		auditLog(AUDIT_CREATE, "package", item.pkg.ID, nil, item.pkg)
+		publishChange(ENTITY_PACKAGE, item.pkg.ID, nil, item.pkg)
+		packagesAdded++
+	}
+
+	recordsAdded, recordsUpdated, results := 0, 0, 0
+	for _, item := range plan.records {
+		var r *Record
+		if item.idx != -1 {
+			r = &records.Daftar[item.idx]
+			before := append([]Result(nil), r.Results...)
+			beforeRecord := *r
+			beforeRecord.Results = before
+			for _, res := range item.results {
+				setImportedResult(r, res)
+			}
+			if !sameValue(before, r.Results) {
+				auditLog(AUDIT_UPDATE, "record_results", r.ID, before, r.Results)
+				publishChange(ENTITY_RECORD, r.ID, beforeRecord, *r)
+				recordsUpdated++
+			}
+		} else {
+			// The sender billed the check-up, so only the list price is stamped
+			record := Record{
+				ID:       getNextRecordID(),
+				Patient:  item.patient.patient,
+				Package:  item.pkg.pkg,
+				Date:     item.record.Date,
+				BranchID: branch,
+			}
+			stampRecordPrice(&record)
+			record.Package.Price = record.Price
+			record.Package.PriceHistory = nil
+			for _, res := range item.results {
+				setImportedResult(&record, res)
+			}
+			records.Daftar[records.N] = record
+			records.N++
+			auditLog(AUDIT_CREATE, "record", record.ID, nil, record)
+			publishChange(ENTITY_RECORD, record.ID, nil, record)
+			recordsAdded++
+		}
+		results += len(item.results)
+	}
+
+	return []string{
+		fmt.Sprintf("Patients: %d added, %d updated", patientsAdded, patientsUpdated),
+		fmt.Sprintf("Packages: %d added", packagesAdded),
+		fmt.Sprintf("Examinations added to the catalog: %d", len(plan.examinations)),
+		fmt.Sprintf("Records: %d added, %d with new results", recordsAdded, recordsUpdated),
+		fmt.Sprintf("Results imported: %d", results),
+		fmt.Sprintf("Entries of other resource types ignored: %d", plan.ignored),
+	}
+}
+
+// hasResult reports whether a record already holds this result value
+func hasResult(r Record, res Result) bool {
+	for _, existing := range r.Results {
+		if existing.Code == res.Code && existing.Value == res.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// setImportedResult stores a result, flagged against our own reference range
+func setImportedResult(r *Record, res Result) {
+	if idx := sequentialSearchExaminationByCode(res.Code); idx != -1 {
+		setRecordResult(r, examinations.Daftar[idx], res.Value)
+	}
+}
+
+// Export and import functions
+func exportFHIRFile(filename string, patientID int) (int, error) {
+	bundle, err := buildFHIRBundle(patientID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFHIRBundle(bundle, filename); err != nil {
+		return 0, err
+	}
+	auditLog(AUDIT_EXPORT, "fhir_bundle", patientID, nil, map[string]interface{}{
+		"file": filepath.Base(filename), "entries": len(bundle.Entry),
+	})
+	return len(bundle.Entry), nil
+}
+
+// importFHIRFile checks a bundle and, unless dryRun is set and if no errors
+// were found, imports it
+func importFHIRFile(filename string, dryRun bool) ([]string, []ImportError, error) {
+	bundle, err := readFHIRBundle(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, errs := planFHIRImport(bundle)
+	if dryRun || len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	summary := applyFHIRImport(plan, importBranch(plan))
+	refreshAllCurrentPrices()
+	auditLog(AUDIT_IMPORT, "fhir_bundle", 0, nil, map[string]interface{}{
+		"file": filepath.Base(filename), "entries": len(bundle.Entry),
+	})
+	return summary, nil, nil
+}
+
+func exportFHIRBundle() {
+	printHeader("Export FHIR Bundle")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	fmt.Println("1. All patients, packages and records")
+	fmt.Println("2. One patient")
+	patientID := 0
+	if getValidInt("Choose option: ", 1, 2) == 2 {
+		patientID = getValidInt("Enter patient ID: ", 1, 999999)
+		if binarySearchPatientByID(patientID) == -1 {
+			printError("Patient not found.")
+			pause()
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("fhir-bundle-%s.json", time.Now().Format("20060102150405"))
+	entries, err := exportFHIRFile(filename, patientID)
+	if err != nil {
+		printError(err.Error())
+	} else {
+		printSuccess(fmt.Sprintf("FHIR bundle with %d entries written to %s.", entries, filename))
+	}
+	pause()
+}
+
+func importFHIRBundle() {
+	printHeader("Import FHIR Bundle")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	filename := getValidInput("Enter bundle file path (.json): ")
+	fmt.Println("\nImport mode:")
+	fmt.Println("1. Dry run (validate only, nothing is imported)")
+	fmt.Println("2. Import (only if the whole bundle is valid)")
+	mode := getValidInt("Choose mode: ", 1, 2)
+
+	summary, errs, err := importFHIRFile(filename, mode == 1)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	showImportErrors("fhir", errs)
+	switch {
+	case len(errs) > 0:
+		printError(fmt.Sprintf("%d errors found. Nothing was imported; fix the bundle and try again.", len(errs)))
+	case mode == 1:
+		printSuccess("Dry run: the bundle is valid. Nothing was imported.")
+	default:
+		printSuccess("FHIR bundle imported.")
+		for _, line := range summary {
+			fmt.Println("  " + line)
+		}
+	}
+	pause()
+}
+
+// fhirCommand runs "fhir export <file> [patient-id]" or
+// "fhir import <file> [--dry-run]" from the command line
+func fhirCommand(args []string) int {
+	if len(args) < 2 || (args[0] != "export" && args[0] != "import") {
+		fmt.Println("Usage: fhir export <file> [patient-id]")
+		fmt.Println("       fhir import <file> [--dry-run]")
+		return 2
+	}
+	if !checkPermission(PERM_DATA_EXCHANGE) {
+		return 1
+	}
+
+	if args[0] == "export" {
+		patientID := 0
+		if len(args) > 2 {
+			id, err := parseValidInt(args[2], 1, 999999)
+			if err != nil || binarySearchPatientByID(id) == -1 {
+				printError(fmt.Sprintf("Patient %s not found.", args[2]))
+				return 1
+			}
+			patientID = id
+		}
+		entries, err := exportFHIRFile(args[1], patientID)
+		if err != nil {
+			printError(err.Error())
+			return 1
+		}
+		printSuccess(fmt.Sprintf("FHIR bundle with %d entries written to %s.", entries, args[1]))
+		return 0
+	}
+
+	dryRun := len(args) > 2 && args[2] == "--dry-run"
+	summary, errs, err := importFHIRFile(args[1], dryRun)
+	if err != nil {
+		printError(err.Error())
+		return 1
+	}
+	if len(errs) > 0 {
+		showImportErrors("fhir", errs)
+		printError("Nothing was imported.")
+		return 1
+	}
+	if dryRun {
+		printSuccess("The bundle is valid.")
+		return 0
+	}
+	if err := saveData(); err != nil {
+		printError(fmt.Sprintf("Failed to save data: %v", err))
+		return 1
+	}
+	printSuccess("FHIR bundle imported.")
+	for _, line := range summary {
+		fmt.Println("  " + line)
+	}
+	return 0
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Constants
+const (
+	// Fitness categories for work
+	FITNESS_FIT        = "fit"
+	FITNESS_FIT_NOTES  = "fit_with_notes"
+	FITNESS_TEMP_UNFIT = "temporarily_unfit"
+	FITNESS_UNFIT      = "unfit"
+
+	MAX_FOLLOW_UP_MONTHS = 60
+)
+
+var FITNESS_CATEGORIES = []string{FITNESS_FIT, FITNESS_FIT_NOTES, FITNESS_TEMP_UNFIT, FITNESS_UNFIT}
+
+var fitnessNames = map[string]string{
+	FITNESS_FIT:        "Fit",
+	FITNESS_FIT_NOTES:  "Fit with notes",
+	FITNESS_TEMP_UNFIT: "Temporarily unfit",
+	FITNESS_UNFIT:      "Unfit",
+}
+
+// Data structures
+// Finding is one diagnosis or finding, coded in ICD-10
+type Finding struct {
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+// ConclusionDraft is the structured conclusion of a record while it is
+// being written, before it is signed
+type ConclusionDraft struct {
+	Text            string    `json:"conclusion"`
+	Fitness         string    `json:"fitness"`
+	Findings        []Finding `json:"findings"`
+	Recommendations string    `json:"recommendations"`
+	FollowUpMonths  int       `json:"follow_up_months"`
+}
+
+// COMMON_FINDINGS are the findings most often coded at check-ups
+var COMMON_FINDINGS = []Finding{
+	{"Z00.0", "General examination without abnormal findings"},
+	{"I10", "Essential (primary) hypertension"},
+	{"E78.5", "Hyperlipidaemia, unspecified"},
+	{"R73.0", "Abnormal glucose"},
+	{"E11.9", "Type 2 diabetes mellitus without complications"},
+	{"E66.9", "Obesity, unspecified"},
+	{"K76.0", "Fatty (change of) liver"},
+	{"D64.9", "Anaemia, unspecified"},
+	{"H52.1", "Myopia"},
+	{"H91.9", "Hearing loss, unspecified"},
+}
+
+// Conclusion functions
+func fitnessName(category string) string {
+	if name, ok := fitnessNames[category]; ok {
+		return name
+	}
+	return "Not concluded"
+}
+
+// isValidICD10 checks the shape of an ICD-10 code: a letter, two digits and
+// an optional subdivision after a dot, e.g. "I10" or "E78.5"
+func isValidICD10(code string) bool {
+	head, sub, hasSub := strings.Cut(code, ".")
+	if len(head) != 3 || head[0] < 'A' || head[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < 3; i++ {
+		if head[i] < '0' || head[i] > '9' {
+			return false
+		}
+	}
+	if !hasSub {
+		return true
+	}
+	if len(sub) == 0 || len(sub) > 4 {
+		return false
+	}
+	for i := 0; i < len(sub); i++ {
+		c := sub[i]
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// followUpDue returns the date a follow-up is due, or "" when none is set
+func followUpDue(date string, months int) string {
+	if months <= 0 {
+		return ""
+	}
+	t, err := parseDate(date)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, months, 0).Format("02/01/2006")
+}
+
+// checkConclusion tells whether a conclusion is complete enough to sign
+func checkConclusion(c ConclusionDraft) error {
+	if _, ok := fitnessNames[c.Fitness]; !ok {
+		return errors.New("a fitness category is required")
+	}
+	if c.Fitness != FITNESS_FIT && len(c.Findings) == 0 && strings.TrimSpace(c.Recommendations) == "" {
+		return fmt.Errorf("%q needs at least one finding or recommendation", fitnessName(c.Fitness))
+	}
+	if c.Fitness == FITNESS_TEMP_UNFIT && c.FollowUpMonths == 0 {
+		return errors.New("a temporarily unfit patient needs a follow-up interval")
+	}
+	if c.FollowUpMonths < 0 || c.FollowUpMonths > MAX_FOLLOW_UP_MONTHS {
+		return fmt.Errorf("the follow-up interval must be 0 to %d months", MAX_FOLLOW_UP_MONTHS)
+	}
+	return nil
+}
+
+func conclusionOf(r Record) ConclusionDraft {
+	return ConclusionDraft{
+		Text:            r.Conclusion,
+		Fitness:         r.Fitness,
+		Findings:        append([]Finding(nil), r.Findings...),
+		Recommendations: r.Recommendations,
+		FollowUpMonths:  r.FollowUpMonths,
+	}
+}
+
+func setConclusion(r *Record, c ConclusionDraft) {
+	r.Conclusion = c.Text
+	r.Fitness = c.Fitness
+	r.Findings = c.Findings
+	r.Recommendations = c.Recommendations
+	r.FollowUpMonths = c.FollowUpMonths
+}
+
+func hasConclusion(r Record) bool {
+	return r.Conclusion != "" || r.Fitness != "" || len(r.Findings) > 0 || r.Recommendations != ""
+}
+
+func printConclusion(r Record) {
+	if r.Conclusion != "" {
+		fmt.Println(r.Conclusion)
+	}
+	fmt.Printf("Fitness: %s\n", fitnessName(r.Fitness))
+	for _, f := range r.Findings {
+		fmt.Printf("  %-7s %s\n", f.Code, f.Text)
+	}
+	if r.Recommendations != "" {
+		fmt.Printf("Recommendations:\n%s\n", r.Recommendations)
+	}
+	if due := followUpDue(r.Date, r.FollowUpMonths); due != "" {
+		fmt.Printf("Follow-up in %d months (by %s)\n", r.FollowUpMonths, due)
+	}
+}
+
+// Input functions
+// getLines reads text one line at a time until a line holding only "."
+func getLines(prompt string) string {
+	fmt.Println(prompt + " Enter '.' on its own line to finish.")
+	var lines []string
+	for {
+		line := getValidInput("> ")
+		if line == "." {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func getFitness() string {
+	fmt.Println("\nFitness category:")
+	for i, category := range FITNESS_CATEGORIES {
+		fmt.Printf("%d. %s\n", i+1, fitnessNames[category])
+	}
+	return FITNESS_CATEGORIES[getValidInt("Choose category: ", 1, len(FITNESS_CATEGORIES))-1]
+}
+
+// getFindings edits a list of coded findings
+func getFindings(findings []Finding) []Finding {
+	findings = append([]Finding(nil), findings...)
+	for {
+		fmt.Printf("\n%sFindings:%s\n", BOLD, RESET)
+		if len(findings) == 0 {
+			fmt.Println("  (none)")
+		}
+		for i, f := range findings {
+			fmt.Printf("  %d. %-7s %s\n", i+1, f.Code, f.Text)
+		}
+		fmt.Println("1. Add common finding  2. Add other ICD-10 code  3. Remove finding  0. Done")
+		switch getValidInt("Choose option: ", 0, 3) {
+		case 0:
+			return findings
+		case 1:
+			for i, f := range COMMON_FINDINGS {
+				fmt.Printf("%2d. %-7s %s\n", i+1, f.Code, f.Text)
+			}
+			if choice := getValidInt("Select finding (0 to cancel): ", 0, len(COMMON_FINDINGS)); choice != 0 {
+				findings = addFinding(findings, COMMON_FINDINGS[choice-1])
+			}
+		case 2:
+			code := strings.ToUpper(getValidInput("Enter ICD-10 code (e.g. E78.5): "))
+			if !isValidICD10(code) {
+				printError("That is not an ICD-10 code (letter, two digits, optional .subdivision).")
+				continue
+			}
+			findings = addFinding(findings, Finding{Code: code, Text: getValidInput("Enter description: ")})
+		case 3:
+			if len(findings) == 0 {
+				continue
+			}
+			i := getValidInt("Remove finding number (0 to cancel): ", 0, len(findings))
+			if i != 0 {
+				findings = append(findings[:i-1], findings[i:]...)
+			}
+		}
+	}
+}
+
+func addFinding(findings []Finding, f Finding) []Finding {
+	for _, existing := range findings {
+		if existing.Code == f.Code {
+			printWarning(fmt.Sprintf("%s is already listed.", f.Code))
+			return findings
+		}
+	}
+	return append(findings, f)
+}
+
+// getConclusionDraft asks for every part of the conclusion, starting from
+// the current draft
+func getConclusionDraft(current ConclusionDraft) ConclusionDraft {
+	c := current
+	keep := ""
+	if c.Text != "" {
+		keep = " A '.' alone keeps the current text."
+	}
+	if text := getLines("\nEnter the conclusion one line at a time." + keep); text != "" {
+		c.Text = text
+	}
+	c.Fitness = getFitness()
+	c.Findings = getFindings(c.Findings)
+
+	keep = ""
+	if c.Recommendations != "" {
+		keep = " A '.' alone keeps the current ones."
+	}
+	if recommendations := getLines("\nEnter recommendations, or '-' for none." + keep); recommendations == "-" {
+		c.Recommendations = ""
+	} else if recommendations != "" {
+		c.Recommendations = recommendations
+	}
+	c.FollowUpMonths = getValidInt(fmt.Sprintf("Follow-up interval in months (0 for none, max %d): ", MAX_FOLLOW_UP_MONTHS),
+		0, MAX_FOLLOW_UP_MONTHS)
+	return c
+}
+
+// Company functions
+// fitnessSummaryReport counts the employees examined for a company at a
+// branch between two dates by the fitness category of their latest signed
+// record. Only the counts leave the clinic: categories small enough to
+// identify an employee are suppressed.
+func fitnessSummaryReport(c Company, start, end string, branch int) Report {
+	report := newReport("fitness-summary", "Fitness Summary - "+c.Name)
+	report.Period = start + " - " + end
+	setReportBranch(&report, branch)
+
+	latest := make(map[int]int)
+	var order []int
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.CompanyID != c.ID || isAnonymised(r) || compareDates(r.Date, start) < 0 || compareDates(r.Date, end) > 0 ||
+			!inBranch(r.BranchID, branch) {
+			continue
+		}
+		prev, seen := latest[r.Patient.ID]
+		if !seen {
+			order = append(order, r.Patient.ID)
+		}
+		// A signed record beats a draft; otherwise the newest one counts
+		p := records.Daftar[prev]
+		if !seen || (isSignedOff(r) && !isSignedOff(p)) ||
+			(isSignedOff(r) == isSignedOff(p) && compareDates(r.Date, p.Date) >= 0) {
+			latest[r.Patient.ID] = i
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, patientID := range order {
+		r := records.Daftar[latest[patientID]]
+		fitness := ""
+		if isSignedOff(r) {
+			fitness = r.Fitness
+		}
+		counts[fitness]++
+	}
+
+	categories := append(append([]string(nil), FITNESS_CATEGORIES...), "")
+	cells := make([]int, len(categories))
+	for i, category := range categories {
+		cells[i] = counts[category]
+	}
+	hidden := smallCells(cells)
+
+	summary := ReportSection{Title: "Fitness Categories", Columns: []ReportColumn{
+		{Key: "category", Header: "Category"},
+		{Key: "employees", Header: "Employees", Numeric: true},
+		{Key: "percent", Header: "Percent", Numeric: true},
+	}}
+	for i, category := range categories {
+		if len(order) < MIN_SUMMARY_GROUP || hidden[i] {
+			summary.Rows = append(summary.Rows, []string{fitnessName(category), suppressedCount(cells[i]), "-"})
+			continue
+		}
+		summary.Rows = append(summary.Rows, []string{fitnessName(category), strconv.Itoa(cells[i]),
+			percent(float64(cells[i]), float64(len(order)))})
+	}
+
+	report.Sections = []ReportSection{
+		{Title: "Overview", Columns: summaryColumns, Summary: true, Rows: [][]string{
+			{"Employees on Roster", strconv.Itoa(len(c.Employees))},
+			{"Employees Examined", strconv.Itoa(len(order))},
+		}},
+		summary,
+	}
+	return report
+}
+
+func generateFitnessSummary() {
+	printHeader("Company Fitness Summary")
+
+	if !requirePermission(PERM_COMPANY_MANAGE) {
+		return
+	}
+
+	idx := selectCompany()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	c := companies.Daftar[idx]
+	start := getValidDate("Enter period start date")
+	end := getValidDate("Enter period end date")
+	branch := getReportBranch()
+
+	auditLog(AUDIT_VIEW, "company_fitness_summary", c.ID, nil, map[string]string{"start": start, "end": end})
+
+	report := fitnessSummaryReport(c, start, end, branch)
+	printReport(report)
+	offerExport(report)
+}
@@ -0,0 +1,850 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants
+const (
+	HL7_DEFAULT_ADDRESS = "127.0.0.1:2575"
+
+	// MLLP frames a message between a start block and an end block plus carriage return
+	MLLP_START_BLOCK = 0x0B
+	MLLP_END_BLOCK   = 0x1C
+	MLLP_MAX_MESSAGE = 1 << 20
+
+	// Acknowledgment codes
+	HL7_ACCEPT = "AA"
+	HL7_ERROR  = "AE"
+	HL7_REJECT = "AR"
+)
+
+var ErrMLLPTooLarge = errors.New("MLLP message too large")
+
+// Data structures
+// HL7Delimiters are the separators a message declares in MSH-1 and MSH-2
+type HL7Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+var defaultHL7Delimiters = HL7Delimiters{'|', '^', '~', '\\', '&'}
+
+type HL7Segment struct {
+	Name   string
+	fields []string // fields[0] is the segment name
+	delims HL7Delimiters
+}
+
+type HL7Message struct {
+	Delims   HL7Delimiters
+	Segments []HL7Segment
+}
+
+// HL7Observation is one OBX result line
+type HL7Observation struct {
+	SetID  string
+	Code   string
+	Text   string
+	Value  string
+	Units  string
+	Status string
+}
+
+// HL7Order is one OBR with the OBX results that follow it
+type HL7Order struct {
+	RecordID     string
+	Observations []HL7Observation
+}
+
+// labCodes maps analyzer test codes to examination codes
+var labCodes map[string]string
+
+// hl7Mu makes messages from several connections apply one at a time
+var hl7Mu sync.Mutex
+
+var hl7ControlSeq int
+
+// Parsing functions
+func splitSegments(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+	var lines []string
+	for _, line := range strings.Split(text, "\r") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseHL7 reads a message using the delimiters declared in its MSH segment
+func parseHL7(text string) (HL7Message, error) {
+	var msg HL7Message
+	lines := splitSegments(text)
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "MSH") || len(lines[0]) < 8 {
+		return msg, errors.New("message does not start with an MSH segment")
+	}
+
+	header := lines[0]
+	encoding := strings.SplitN(header[4:], header[3:4], 2)[0]
+	if len(encoding) < 4 {
+		return msg, errors.New("MSH-2 must hold four encoding characters")
+	}
+	msg.Delims = HL7Delimiters{
+		Field:        header[3],
+		Component:    encoding[0],
+		Repetition:   encoding[1],
+		Escape:       encoding[2],
+		Subcomponent: encoding[3],
+	}
+
+	for _, line := range lines {
+		fields := strings.Split(line, string(msg.Delims.Field))
+		if len(fields[0]) != 3 {
+			return msg, fmt.Errorf("invalid segment %q", fields[0])
+		}
+		msg.Segments = append(msg.Segments, HL7Segment{Name: fields[0], fields: fields, delims: msg.Delims})
+	}
+	return msg, nil
+}
+
+// Segment returns the first segment with the given name
+func (m HL7Message) Segment(name string) (HL7Segment, bool) {
+	for _, s := range m.Segments {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return HL7Segment{}, false
+}
+
+// rawField returns field n as numbered in the HL7 spec. In MSH the field
+// separator itself is MSH-1.
+func (s HL7Segment) rawField(n int) string {
+	if s.Name == "MSH" {
+		if n == 1 {
+			return string(s.delims.Field)
+		}
+		n--
+	}
+	if n <= 0 || n >= len(s.fields) {
+		return ""
+	}
+	return s.fields[n]
+}
+
+// Repeats returns the number of repetitions of field n
+func (s HL7Segment) Repeats(n int) int {
+	raw := s.rawField(n)
+	if raw == "" {
+		return 0
+	}
+	if s.Name == "MSH" && n <= 2 {
+		return 1
+	}
+	return strings.Count(raw, string(s.delims.Repetition)) + 1
+}
+
+// Get returns component comp (1-based; 0 for the whole repetition) of
+// repetition rep (0-based) of field n, unescaped
+func (s HL7Segment) Get(n, rep, comp int) string {
+	raw := s.rawField(n)
+	if s.Name == "MSH" && n <= 2 {
+		return raw
+	}
+	reps := strings.Split(raw, string(s.delims.Repetition))
+	if rep >= len(reps) {
+		return ""
+	}
+	value := reps[rep]
+	if comp > 0 {
+		components := strings.Split(value, string(s.delims.Component))
+		if comp > len(components) {
+			return ""
+		}
+		value = components[comp-1]
+	}
+	return hl7Unescape(value, s.delims)
+}
+
+// Value returns component comp of the first repetition of field n
+func (s HL7Segment) Value(n, comp int) string {
+	return s.Get(n, 0, comp)
+}
+
+// hl7Unescape replaces escape sequences with the characters they stand for
+func hl7Unescape(value string, d HL7Delimiters) string {
+	esc := string(d.Escape)
+	if !strings.Contains(value, esc) {
+		return value
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(value, esc)
+		if start == -1 {
+			b.WriteString(value)
+			break
+		}
+		end := strings.Index(value[start+1:], esc)
+		if end == -1 {
+			b.WriteString(value)
+			break
+		}
+		b.WriteString(value[:start])
+		seq := value[start+1 : start+1+end]
+		switch {
+		case seq == "F":
+			b.WriteByte(d.Field)
+		case seq == "S":
+			b.WriteByte(d.Component)
+		case seq == "T":
+			b.WriteByte(d.Subcomponent)
+		case seq == "R":
+			b.WriteByte(d.Repetition)
+		case seq == "E":
+			b.WriteByte(d.Escape)
+		case seq == ".br":
+			b.WriteByte('\n')
+		case strings.HasPrefix(seq, "X") && len(seq)%2 == 1:
+			for i := 1; i+1 < len(seq); i += 2 {
+				if n, err := strconv.ParseUint(seq[i:i+2], 16, 8); err == nil {
+					b.WriteByte(byte(n))
+				}
+			}
+		default:
+			// Formatting sequences we don't render are dropped
+		}
+		value = value[start+end+2:]
+	}
+	return b.String()
+}
+
+// hl7Escape escapes delimiter characters in text written into a message
+func hl7Escape(value string, d HL7Delimiters) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case d.Escape:
+			b.WriteString(string(d.Escape) + "E" + string(d.Escape))
+		case d.Field:
+			b.WriteString(string(d.Escape) + "F" + string(d.Escape))
+		case d.Component:
+			b.WriteString(string(d.Escape) + "S" + string(d.Escape))
+		case d.Subcomponent:
+			b.WriteString(string(d.Escape) + "T" + string(d.Escape))
+		case d.Repetition:
+			b.WriteString(string(d.Escape) + "R" + string(d.Escape))
+		case '\r', '\n':
+			b.WriteString(string(d.Escape) + ".br" + string(d.Escape))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitHL7Batch splits a file holding several messages at each MSH segment.
+// MLLP framing characters and FHS/BHS/BTS/FTS batch segments are skipped.
+func splitHL7Batch(text string) []string {
+	text = strings.NewReplacer(string(rune(MLLP_START_BLOCK)), "", string(rune(MLLP_END_BLOCK)), "").Replace(text)
+	var messages []string
+	var current []string
+	for _, line := range splitSegments(text) {
+		switch {
+		case strings.HasPrefix(line, "MSH"):
+			if len(current) > 0 {
+				messages = append(messages, strings.Join(current, "\r"))
+			}
+			current = []string{line}
+		case strings.HasPrefix(line, "FHS"), strings.HasPrefix(line, "BHS"),
+			strings.HasPrefix(line, "BTS"), strings.HasPrefix(line, "FTS"):
+		case len(current) > 0:
+			current = append(current, line)
+		}
+	}
+	if len(current) > 0 {
+		messages = append(messages, strings.Join(current, "\r"))
+	}
+	return messages
+}
+
+// parseORU checks that the message is an ORU^R01 and collects its orders
+func parseORU(msg HL7Message) ([]HL7Order, error) {
+	msh := msg.Segments[0]
+	if msh.Value(9, 1) != "ORU" || msh.Value(9, 2) != "R01" {
+		return nil, fmt.Errorf("unsupported message type %s^%s", msh.Value(9, 1), msh.Value(9, 2))
+	}
+
+	var orders []HL7Order
+	for _, s := range msg.Segments {
+		switch s.Name {
+		case "OBR":
+			// The placer order number is our record ID; fall back to the filler's
+			recordID := s.Value(2, 1)
+			if recordID == "" {
+				recordID = s.Value(3, 1)
+			}
+			orders = append(orders, HL7Order{RecordID: recordID})
+		case "OBX":
+			if len(orders) == 0 {
+				return nil, errors.New("OBX segment before any OBR segment")
+			}
+			order := &orders[len(orders)-1]
+			order.Observations = append(order.Observations, HL7Observation{
+				SetID:  s.Value(1, 0),
+				Code:   s.Value(3, 1),
+				Text:   s.Value(3, 2),
+				Value:  s.Value(5, 0),
+				Units:  s.Value(6, 1),
+				Status: s.Value(11, 0),
+			})
+		}
+	}
+	if len(orders) == 0 {
+		return nil, errors.New("message has no OBR segment")
+	}
+	return orders, nil
+}
+
+// buildACK answers a message with an acknowledgment
+func buildACK(msg HL7Message, code, text, controlID string, now time.Time) string {
+	d := msg.Delims
+	msh, _ := msg.Segment("MSH")
+	version := msh.Value(12, 0)
+	if version == "" {
+		version = "2.5"
+	}
+	sep := string(d.Field)
+	encoding := msh.rawField(2)
+	if encoding == "" {
+		encoding = string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent})
+	}
+
+	header := strings.Join([]string{
+		"MSH", encoding, msh.rawField(5), msh.rawField(6), msh.rawField(3), msh.rawField(4),
+		now.Format("20060102150405"), "",
+		"ACK" + string(d.Component) + msh.Value(9, 2) + string(d.Component) + "ACK",
+		controlID, msh.rawField(11), version,
+	}, sep)
+	ack := strings.Join([]string{"MSA", code, msh.rawField(10), hl7Escape(text, d)}, sep)
+	return header + "\r" + ack + "\r"
+}
+
+// MLLP functions
+func writeMLLP(w io.Writer, message string) error {
+	frame := make([]byte, 0, len(message)+3)
+	frame = append(frame, MLLP_START_BLOCK)
+	frame = append(frame, message...)
+	frame = append(frame, MLLP_END_BLOCK, '\r')
+	_, err := w.Write(frame)
+	return err
+}
+
+// readMLLP reads one framed message, skipping anything before the start block
+func readMLLP(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == MLLP_START_BLOCK {
+			break
+		}
+	}
+
+	var message []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == MLLP_END_BLOCK {
+			if next, err := r.ReadByte(); err == nil && next != '\r' {
+				r.UnreadByte()
+			}
+			return string(message), nil
+		}
+		if len(message) >= MLLP_MAX_MESSAGE {
+			return "", ErrMLLPTooLarge
+		}
+		message = append(message, b)
+	}
+}
+
+// Result matching functions
+func lookupLabCode(code string) (Examination, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if mapped, ok := labCodes[code]; ok {
+		code = mapped
+	}
+	if idx := sequentialSearchExaminationByCode(code); idx != -1 {
+		return examinations.Daftar[idx], true
+	}
+	return Examination{}, false
+}
+
+// applyORU stores the results of every order in the message, or none of them
+// if any result cannot be matched
+func applyORU(msg HL7Message) (string, string) {
+	orders, err := parseORU(msg)
+	if err != nil {
+		return HL7_REJECT, err.Error()
+	}
+
+	type pendingResult struct {
+		exam  Examination
+		value float64
+	}
+	pending := make(map[int][]pendingResult)
+	var order []int
+	var problems []string
+
+	pid, _ := msg.Segment("PID")
+	for _, o := range orders {
+		id, err := strconv.Atoi(o.RecordID)
+		idx := -1
+		if err == nil {
+			idx = searchRecordByID(id)
+		}
+		if idx == -1 {
+			problems = append(problems, fmt.Sprintf("record %q not found", o.RecordID))
+			continue
+		}
+		r := records.Daftar[idx]
+		if r.Patient.ID == 0 {
+			problems = append(problems, fmt.Sprintf("record %d has been anonymised", id))
+			continue
+		}
+		if isSignedOff(r) {
+			problems = append(problems, fmt.Sprintf("record %d is signed off; its results are locked", id))
+			continue
+		}
+		// An analyzer that sends our patient ID must send the right one
+		if patientID, err := strconv.Atoi(pid.Value(3, 1)); err == nil && patientID != r.Patient.ID {
+			problems = append(problems, fmt.Sprintf("record %d belongs to another patient", id))
+			continue
+		}
+
+		if _, seen := pending[id]; !seen {
+			order = append(order, id)
+			pending[id] = nil
+		}
+		for _, obs := range o.Observations {
+			if obs.Status != "" && obs.Status != "F" && obs.Status != "C" {
+				// Preliminary, cancelled or deleted results are not stored
+				continue
+			}
+			exam, ok := lookupLabCode(obs.Code)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("OBX %s: test code %q is not mapped to an examination", obs.SetID, obs.Code))
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(obs.Value), 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("OBX %s: value %q is not numeric", obs.SetID, obs.Value))
+				continue
+			}
+			pending[id] = append(pending[id], pendingResult{exam: exam, value: value})
+		}
+	}
+	if len(problems) > 0 {
+		return HL7_ERROR, strings.Join(problems, "; ")
+	}
+
+	stored := 0
+	for _, id := range order {
+		r := &records.Daftar[searchRecordByID(id)]
+		before := append([]Result(nil), r.Results...)
+		beforeRecord := *r
+		beforeRecord.Results = before
+		for _, p := range pending[id] {
+			setRecordResult(r, p.exam, p.value)
+			stored++
+		}
+		auditLog(AUDIT_UPDATE, "record_results", r.ID, before, r.Results)
+		publishChange(ENTITY_RECORD, r.ID, beforeRecord, *r)
+		queueRecordChange(beforeRecord, *r)
+	}
+	return HL7_ACCEPT, fmt.Sprintf("%d results stored", stored)
+}
+
+func nextControlID(now time.Time) string {
+	hl7ControlSeq++
+	return fmt.Sprintf("%s%04d", now.Format("20060102150405"), hl7ControlSeq%10000)
+}
+
+// handleHL7Message processes one message and returns the ACK to send back
+func handleHL7Message(text, source string) (string, string, string) {
+	hl7Mu.Lock()
+	defer hl7Mu.Unlock()
+
+	now := time.Now()
+	msg, err := parseHL7(text)
+	if err != nil {
+		// Without a readable MSH there is nothing to address the reply to
+		msg = HL7Message{Delims: defaultHL7Delimiters, Segments: []HL7Segment{{Name: "MSH", delims: defaultHL7Delimiters}}}
+		return buildACK(msg, HL7_REJECT, err.Error(), nextControlID(now), now), HL7_REJECT, err.Error()
+	}
+
+	code, detail := applyORU(msg)
+	msh, _ := msg.Segment("MSH")
+	auditLog(AUDIT_IMPORT, "hl7_oru", 0, nil, map[string]interface{}{
+		"source": source, "control_id": msh.Value(10, 0), "ack": code, "detail": detail,
+	})
+	return buildACK(msg, code, detail, nextControlID(now), now), code, detail
+}
+
+// Listener functions
+// serveMLLP accepts connections until the listener is closed, answering every
+// message with an ACK. Accepted messages are saved straight away.
+func serveMLLP(ln net.Listener, report func(string)) {
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	defer func() {
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+
+		go func(conn net.Conn) {
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				conn.Close()
+			}()
+
+			reader := bufio.NewReader(conn)
+			for {
+				text, err := readMLLP(reader)
+				if err != nil {
+					if err == ErrMLLPTooLarge {
+						report(fmt.Sprintf("%s: %v, connection closed", conn.RemoteAddr(), err))
+					}
+					return
+				}
+				ack, code, detail := handleHL7Message(text, conn.RemoteAddr().String())
+				if code == HL7_ACCEPT {
+					hl7Mu.Lock()
+					if err := saveData(); err != nil {
+						detail += fmt.Sprintf(" (save failed: %v)", err)
+					}
+					hl7Mu.Unlock()
+				}
+				report(fmt.Sprintf("%s: %s %s", conn.RemoteAddr(), code, detail))
+				if err := writeMLLP(conn, ack); err != nil {
+					return
+				}
+				// Patients are told only after the analyzer has its ACK
+				if code == HL7_ACCEPT {
+					hl7Mu.Lock()
+					if sent, failed := deliverNotifications(time.Now()); sent+failed > 0 {
+						if err := saveData(); err != nil {
+							report(fmt.Sprintf("Failed to save notifications: %v", err))
+						}
+					}
+					hl7Mu.Unlock()
+				}
+			}
+		}(conn)
+	}
+}
+
+// sendMLLP sends each message and waits for its ACK; it is the test harness
+// standing in for an analyzer
+func sendMLLP(address string, messages []string) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %v", address, err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	var acks []string
+	for _, message := range messages {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+		if err := writeMLLP(conn, message); err != nil {
+			return acks, fmt.Errorf("failed to send: %v", err)
+		}
+		ack, err := readMLLP(reader)
+		if err != nil {
+			return acks, fmt.Errorf("no acknowledgment: %v", err)
+		}
+		acks = append(acks, ack)
+	}
+	return acks, nil
+}
+
+// describeACK sums up an acknowledgment as "AA: text"
+func describeACK(ack string) string {
+	msg, err := parseHL7(ack)
+	if err != nil {
+		return "unreadable acknowledgment"
+	}
+	msa, ok := msg.Segment("MSA")
+	if !ok {
+		return "acknowledgment without MSA segment"
+	}
+	return fmt.Sprintf("%s: %s", msa.Value(1, 0), msa.Value(3, 0))
+}
+
+// File functions
+func importHL7File(filename string) ([]string, int, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read %s: %v", filename, err)
+	}
+	messages := splitHL7Batch(string(content))
+	if len(messages) == 0 {
+		return nil, 0, fmt.Errorf("%s holds no HL7 messages", filename)
+	}
+
+	var lines []string
+	accepted := 0
+	for i, text := range messages {
+		_, code, detail := handleHL7Message(text, filepath.Base(filename))
+		if code == HL7_ACCEPT {
+			accepted++
+		}
+		lines = append(lines, fmt.Sprintf("Message %d: %s %s", i+1, code, detail))
+	}
+	return lines, accepted, nil
+}
+
+// Menu functions
+func importHL7Messages() {
+	printHeader("Import HL7 Results File")
+
+	if !requirePermission(PERM_RESULT_ENTER) {
+		return
+	}
+
+	filename := getValidInput("Enter HL7 file path: ")
+	lines, accepted, err := importHL7File(filename)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	printSuccess(fmt.Sprintf("%d of %d messages accepted.", accepted, len(lines)))
+	deliverNotificationsSoon()
+	pause()
+}
+
+func runMLLPListener() {
+	printHeader("MLLP Listener")
+
+	if !requirePermission(PERM_RESULT_ENTER) {
+		return
+	}
+
+	address := getValidInput(fmt.Sprintf("Listen address (e.g. %s): ", HL7_DEFAULT_ADDRESS))
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		printError(fmt.Sprintf("Cannot listen on %s: %v", address, err))
+		pause()
+		return
+	}
+	auditLog(AUDIT_VIEW, "hl7_listener", 0, nil, map[string]string{"address": address})
+	printSuccess(fmt.Sprintf("Listening for HL7 results on %s. Press Enter to stop.", ln.Addr()))
+
+	done := make(chan bool)
+	go func() {
+		serveMLLP(ln, func(line string) {
+			fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), line)
+			touchActivity()
+		})
+		done <- true
+	}()
+
+	readLine("")
+	ln.Close()
+	<-done
+	printSuccess("Listener stopped.")
+	pause()
+}
+
+func labCodeMapping() {
+	printHeader("Lab Code Mapping")
+
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
+	if len(labCodes) == 0 {
+		fmt.Println("No mappings. Analyzer codes equal to an examination code are matched directly.")
+	} else {
+		fmt.Printf("%s%-15s %-10s %s%s\n", BOLD, "Analyzer Code", "Exam Code", "Examination", RESET)
+		fmt.Println(strings.Repeat("-", 50))
+		for _, code := range sortedLabCodes() {
+			fmt.Printf("%-15s %-10s %s\n", code, labCodes[code], examinationName(labCodes[code]))
+		}
+	}
+
+	fmt.Println("\n1. Add or change mapping  2. Remove mapping  0. Back")
+	choice := getValidInt("Choose option: ", 0, 2)
+	if choice == 0 {
+		return
+	}
+
+	before := make(map[string]string)
+	for k, v := range labCodes {
+		before[k] = v
+	}
+	code := strings.ToUpper(getValidInput("Enter analyzer test code: "))
+	if choice == 1 {
+		exam := strings.ToUpper(getValidInput("Enter examination code: "))
+		if sequentialSearchExaminationByCode(exam) == -1 {
+			printError("Examination not found.")
+			pause()
+			return
+		}
+		if labCodes == nil {
+			labCodes = make(map[string]string)
+		}
+		labCodes[code] = exam
+	} else {
+		if _, ok := labCodes[code]; !ok {
+			printError("Mapping not found.")
+			pause()
+			return
+		}
+		delete(labCodes, code)
+	}
+
+	auditLog(AUDIT_UPDATE, "lab_codes", 0, before, labCodes)
+	printSuccess("Lab code mapping updated.")
+	pause()
+}
+
+func sortedLabCodes() []string {
+	var codes []string
+	for code := range labCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Command functions
+// hl7Command runs "hl7 import <file>" or "hl7 listen [address]"
+func hl7Command(args []string) int {
+	if len(args) < 1 || (args[0] == "import" && len(args) < 2) || (args[0] != "import" && args[0] != "listen") {
+		fmt.Println("Usage: hl7 import <file>")
+		fmt.Println("       hl7 listen [address]")
+		fmt.Println("       hl7 send <address> <file>")
+		return 2
+	}
+	if !checkPermission(PERM_RESULT_ENTER) {
+		return 1
+	}
+
+	if args[0] == "import" {
+		lines, accepted, err := importHL7File(args[1])
+		if err != nil {
+			printError(err.Error())
+			return 1
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		// Nothing runs in the background after a command, so patients are
+		// told now
+		deliverNotifications(time.Now())
+		if err := saveData(); err != nil {
+			printError(fmt.Sprintf("Failed to save data: %v", err))
+			return 1
+		}
+		printSuccess(fmt.Sprintf("%d of %d messages accepted.", accepted, len(lines)))
+		if accepted < len(lines) {
+			return 1
+		}
+		return 0
+	}
+
+	address := HL7_DEFAULT_ADDRESS
+	if len(args) > 1 {
+		address = args[1]
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		printError(fmt.Sprintf("Cannot listen on %s: %v", address, err))
+		return 1
+	}
+	printSuccess(fmt.Sprintf("Listening for HL7 results on %s. Press Enter or Ctrl+C to stop.", ln.Addr()))
+	go func() {
+		// Without a terminal (e.g. run as a service) only Ctrl+C stops it
+		if _, ok := nextLine(); ok {
+			ln.Close()
+		}
+	}()
+	serveMLLP(ln, func(line string) {
+		fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), line)
+	})
+	return 0
+}
+
+// hl7SendCommand runs "hl7 send <address> <file>". It needs no login, as it
+// only plays the part of an analyzer.
+func hl7SendCommand(args []string) int {
+	if len(args) < 2 {
+		fmt.Println("Usage: hl7 send <address> <file>")
+		return 2
+	}
+	content, err := os.ReadFile(args[1])
+	if err != nil {
+		printError(fmt.Sprintf("Cannot read %s: %v", args[1], err))
+		return 1
+	}
+	messages := splitHL7Batch(string(content))
+	if len(messages) == 0 {
+		printError(fmt.Sprintf("%s holds no HL7 messages", args[1]))
+		return 1
+	}
+
+	acks, err := sendMLLP(args[0], messages)
+	for i, ack := range acks {
+		fmt.Printf("Message %d: %s\n", i+1, describeACK(ack))
+	}
+	if err != nil {
+		printError(err.Error())
+		return 1
+	}
+	for _, ack := range acks {
+		if !strings.HasPrefix(describeACK(ack), HL7_ACCEPT) {
+			return 1
+		}
+	}
+	return 0
+}
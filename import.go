@@ -0,0 +1,644 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	IMPORT_DRY_RUN      = 1
+	IMPORT_SKIP_INVALID = 2
+	IMPORT_ALL_OR_NONE  = 3
+
+	IMPORT_REPORT_SHOWN = 20
+)
+
+// Data structures
+// ImportRow is one data row from the file. Line is the line in a CSV file or
+// the row number in a worksheet.
+type ImportRow struct {
+	Line  int
+	Cells []string
+}
+
+type ImportField struct {
+	Name    string
+	Aliases []string
+}
+
+type ImportError struct {
+	Line    int
+	Row     int
+	Column  string
+	Value   string
+	Message string
+}
+
+var patientImportFields = []ImportField{
+	{Name: "name", Aliases: []string{"patient name", "full name", "employee name", "nama"}},
+	{Name: "gender", Aliases: []string{"sex", "jenis kelamin", "jk"}},
+	{Name: "age", Aliases: []string{"umur", "usia"}},
+}
+
+var packageImportFields = []ImportField{
+	{Name: "name", Aliases: []string{"package", "package name", "nama paket"}},
+	{Name: "category", Aliases: []string{"kategori"}},
+	{Name: "price", Aliases: []string{"harga"}},
+}
+
+// File reading functions
+func readImportFile(filename string) ([]string, []ImportRow, error) {
+	var rows []ImportRow
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt":
+		rows, err = readCSVRows(filename)
+	case ".xlsx":
+		rows, err = readXLSXRows(filename)
+	default:
+		return nil, nil, fmt.Errorf("unsupported file type %q, use .csv or .xlsx", filepath.Ext(filename))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s is empty", filename)
+	}
+
+	header := rows[0].Cells
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	return header, rows[1:], nil
+}
+
+// readCSVRows reads comma or semicolon separated files, as saved by Excel
+func readCSVRows(filename string) ([]ImportRow, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+	text := strings.TrimPrefix(string(content), "\ufeff")
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	firstLine := strings.SplitN(text, "\n", 2)[0]
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+
+	var rows []ImportRow
+	for {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, ImportRow{Line: line, Cells: cells})
+	}
+	return rows, nil
+}
+
+// XLSX structures, only the parts needed to read cell values
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.Text)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readZipXML(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%s not found in workbook", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// xlsxColumn turns a cell reference such as "C12" into a column index
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+// readXLSXRows reads the first worksheet of an Excel workbook
+func readXLSXRows(filename string) ([]ImportRow, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", filename, err)
+	}
+	defer archive.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	sheetPath := "xl/worksheets/sheet1.xml"
+	var workbook xlsxWorkbook
+	var rels xlsxRelationships
+	if readZipXML(files, "xl/workbook.xml", &workbook) == nil && len(workbook.Sheets) > 0 &&
+		readZipXML(files, "xl/_rels/workbook.xml.rels", &rels) == nil {
+		for _, rel := range rels.Relationships {
+			if rel.ID == workbook.Sheets[0].RID {
+				sheetPath = path.Join("xl", strings.TrimPrefix(rel.Target, "/xl/"))
+			}
+		}
+	}
+
+	var shared xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := readZipXML(files, "xl/sharedStrings.xml", &shared); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+	}
+
+	var sheet xlsxSheet
+	if err := readZipXML(files, sheetPath, &sheet); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+
+	var rows []ImportRow
+	for i, r := range sheet.Rows {
+		row := ImportRow{Line: r.R}
+		if row.Line == 0 {
+			row.Line = i + 1
+		}
+		for j, c := range r.Cells {
+			col := j
+			if c.Ref != "" {
+				col = xlsxColumn(c.Ref)
+			}
+			for len(row.Cells) <= col {
+				row.Cells = append(row.Cells, "")
+			}
+
+			value := c.Value
+			switch c.Type {
+			case "s":
+				if idx, err := strconv.Atoi(c.Value); err == nil && idx < len(shared.Items) {
+					value = shared.Items[idx].String()
+				}
+			case "inlineStr":
+				value = c.Inline.String()
+			}
+			row.Cells[col] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Column mapping functions
+// autoMapColumns matches each field to a header by name or alias; -1 means
+// no column was found
+func autoMapColumns(header []string, fields []ImportField) []int {
+	mapping := make([]int, len(fields))
+	for i, field := range fields {
+		mapping[i] = -1
+		for col, h := range header {
+			name := strings.ToLower(strings.TrimSpace(h))
+			if name == field.Name {
+				mapping[i] = col
+				break
+			}
+			for _, alias := range field.Aliases {
+				if name == alias {
+					mapping[i] = col
+				}
+			}
+			if mapping[i] != -1 {
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+func printColumnMapping(header []string, fields []ImportField, mapping []int) {
+	for i, field := range fields {
+		if mapping[i] == -1 {
+			fmt.Printf("  %-10s -> %s(not found)%s\n", field.Name, YELLOW, RESET)
+		} else {
+			fmt.Printf("  %-10s -> column %d (%s)\n", field.Name, mapping[i]+1, header[mapping[i]])
+		}
+	}
+}
+
+// mapColumns shows the detected mapping and lets the user change it
+func mapColumns(header []string, fields []ImportField) []int {
+	mapping := autoMapColumns(header, fields)
+
+	fmt.Println("\nColumns in file:")
+	for i, h := range header {
+		fmt.Printf("  %d. %s\n", i+1, h)
+	}
+	fmt.Println("\nColumn mapping:")
+	printColumnMapping(header, fields, mapping)
+
+	complete := true
+	for _, col := range mapping {
+		if col == -1 {
+			complete = false
+		}
+	}
+	if complete {
+		confirm := getValidInput("\nUse this mapping? (y/N): ")
+		if strings.ToLower(confirm) == "y" || strings.ToLower(confirm) == "yes" {
+			return mapping
+		}
+	}
+
+	for i, field := range fields {
+		mapping[i] = getValidInt(fmt.Sprintf("Column for %s (1-%d): ", field.Name, len(header)), 1, len(header)) - 1
+	}
+	return mapping
+}
+
+func cellValue(row ImportRow, col int) string {
+	if col < 0 || col >= len(row.Cells) {
+		return ""
+	}
+	return strings.TrimSpace(row.Cells[col])
+}
+
+func isBlankRow(row ImportRow) bool {
+	for _, c := range row.Cells {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Validation functions
+// validatePatientRows checks every row the way addPatient does and returns
+// the patients that passed
+func validatePatientRows(rows []ImportRow, mapping []int) ([]Patient, []ImportError) {
+	var valid []Patient
+	var errs []ImportError
+	seen := make(map[string]int)
+
+	for i, row := range rows {
+		if isBlankRow(row) {
+			continue
+		}
+		fail := func(field int, message string) {
+			errs = append(errs, ImportError{Line: row.Line, Row: i + 1, Column: patientImportFields[field].Name,
+				Value: cellValue(row, mapping[field]), Message: message})
+		}
+
+		p := Patient{Name: cellValue(row, mapping[0])}
+		ok := true
+		if p.Name == "" {
+			fail(0, "input cannot be empty")
+			ok = false
+		}
+		gender, err := parseValidGender(cellValue(row, mapping[1]))
+		if err != nil {
+			fail(1, err.Error())
+			ok = false
+		}
+		age, err := parseValidInt(cellValue(row, mapping[2]), 0, 150)
+		if err != nil {
+			fail(2, err.Error())
+			ok = false
+		}
+		if !ok {
+			continue
+		}
+		p.Gender, p.Age = gender, age
+
+		key := fmt.Sprintf("%s|%s|%d", strings.ToLower(p.Name), p.Gender, p.Age)
+		if line, dup := seen[key]; dup {
+			fail(0, fmt.Sprintf("duplicate of line %d", line))
+			continue
+		}
+		if idx := findPatient(p.Name, p.Gender, p.Age); idx != -1 {
+			fail(0, fmt.Sprintf("already registered as patient %d", patients.Daftar[idx].ID))
+			continue
+		}
+		if patients.N+len(valid) >= NMAX {
+			fail(0, fmt.Sprintf("patient list is full (maximum %d)", NMAX))
+			continue
+		}
+		seen[key] = row.Line
+		valid = append(valid, p)
+	}
+	return valid, errs
+}
+
+func findPatient(name, gender string, age int) int {
+	for i := 0; i < patients.N; i++ {
+		p := patients.Daftar[i]
+		if strings.EqualFold(p.Name, name) && p.Gender == gender && p.Age == age {
+			return i
+		}
+	}
+	return -1
+}
+
+func findPackage(name string) int {
+	for i := 0; i < packages.N; i++ {
+		if strings.EqualFold(packages.Daftar[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// validatePackageRows checks every row the way addPackage does
+func validatePackageRows(rows []ImportRow, mapping []int) ([]Package, []ImportError) {
+	var valid []Package
+	var errs []ImportError
+	seen := make(map[string]int)
+
+	for i, row := range rows {
+		if isBlankRow(row) {
+			continue
+		}
+		fail := func(field int, message string) {
+			errs = append(errs, ImportError{Line: row.Line, Row: i + 1, Column: packageImportFields[field].Name,
+				Value: cellValue(row, mapping[field]), Message: message})
+		}
+
+		p := Package{Name: cellValue(row, mapping[0])}
+		ok := true
+		if p.Name == "" {
+			fail(0, "input cannot be empty")
+			ok = false
+		}
+		category, err := parseValidCategory(cellValue(row, mapping[1]))
+		if err != nil {
+			fail(1, err.Error())
+			ok = false
+		}
+		price, err := parseValidFloat(cellValue(row, mapping[2]), 0.0)
+		if err != nil {
+			fail(2, err.Error())
+			ok = false
+		}
+		if !ok {
+			continue
+		}
+		p.Category, p.CategoryID, p.Price = category.Name, category.ID, price
+
+		key := strings.ToLower(p.Name)
+		if line, dup := seen[key]; dup {
+			fail(0, fmt.Sprintf("duplicate of line %d", line))
+			continue
+		}
+		if idx := findPackage(p.Name); idx != -1 {
+			fail(0, fmt.Sprintf("package already exists with ID %d", packages.Daftar[idx].ID))
+			continue
+		}
+		if packages.N+len(valid) >= NMAX {
+			fail(0, fmt.Sprintf("package list is full (maximum %d)", NMAX))
+			continue
+		}
+		seen[key] = row.Line
+		valid = append(valid, p)
+	}
+	return valid, errs
+}
+
+// Report functions
+func writeImportReport(filename string, errs []ImportError) error {
+	rows := [][]string{{"line", "row", "column", "value", "error"}}
+	for _, e := range errs {
+		rows = append(rows, []string{strconv.Itoa(e.Line), strconv.Itoa(e.Row), e.Column, e.Value, e.Message})
+	}
+	return writeCSVFile(filename, rows)
+}
+
+// showImportErrors prints the first errors and writes all of them to a report
+func showImportErrors(entity string, errs []ImportError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%s%-6s %-6s %-10s %-20s %s%s\n", BOLD, "Line", "Row", "Column", "Value", "Error", RESET)
+	fmt.Println(strings.Repeat("-", 80))
+	for i, e := range errs {
+		if i == IMPORT_REPORT_SHOWN {
+			fmt.Printf("... and %d more\n", len(errs)-IMPORT_REPORT_SHOWN)
+			break
+		}
+		fmt.Printf("%-6d %-6d %-10s %-20s %s\n", e.Line, e.Row, e.Column, e.Value, e.Message)
+	}
+
+	filename := fmt.Sprintf("import-report-%s-%s.csv", entity, time.Now().Format("20060102150405"))
+	if err := writeImportReport(filename, errs); err != nil {
+		printError(err.Error())
+		return
+	}
+	printWarning(fmt.Sprintf("%d errors. Full report written to %s.", len(errs), filename))
+}
+
+func selectImportMode() int {
+	fmt.Println("\nImport mode:")
+	fmt.Println("1. Dry run (validate only, nothing is imported)")
+	fmt.Println("2. Import valid rows and skip rows with errors")
+	fmt.Println("3. All or nothing (import only if every row is valid)")
+	return getValidInt("Choose mode: ", 1, 3)
+}
+
+// importBlocked reports whether the mode stops the import after validation
+func importBlocked(mode, validCount, errCount int) bool {
+	switch {
+	case mode == IMPORT_DRY_RUN:
+		printSuccess(fmt.Sprintf("Dry run: %d rows valid, %d errors. Nothing was imported.", validCount, errCount))
+		return true
+	case mode == IMPORT_ALL_OR_NONE && errCount > 0:
+		printError(fmt.Sprintf("%d errors found. Nothing was imported; fix the file and try again.", errCount))
+		return true
+	case validCount == 0:
+		printWarning("No valid rows to import.")
+		return true
+	}
+	return false
+}
+
+// Import functions
+func importPatients() {
+	printHeader("Bulk Import Patients")
+
+	if !requirePermission(PERM_PATIENT_CREATE) {
+		return
+	}
+
+	filename := getValidInput("Enter file path (.csv or .xlsx): ")
+	header, rows, err := readImportFile(filename)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	fmt.Printf("%d data rows read.\n", len(rows))
+
+	mapping := mapColumns(header, patientImportFields)
+	mode := selectImportMode()
+
+	valid, errs := validatePatientRows(rows, mapping)
+	showImportErrors("patients", errs)
+	if importBlocked(mode, len(valid), len(errs)) {
+		pause()
+		return
+	}
+
+	// Corporate onboarding: put everyone on the company's roster in one go
+	var company *Company
+	if companies.N > 0 && hasPermission(PERM_COMPANY_MANAGE) {
+		confirm := getValidInput("\nAdd the imported patients to a company roster? (y/N): ")
+		if strings.ToLower(confirm) == "y" || strings.ToLower(confirm) == "yes" {
+			if idx := selectCompany(); idx != -1 {
+				company = &companies.Daftar[idx]
+			}
+		}
+	}
+
+	var rosterBefore []int
+	if company != nil {
+		rosterBefore = append([]int(nil), company.Employees...)
+	}
+
+	var ids []int
+	for _, p := range valid {
+		p.ID = getNextPatientID()
+		patients.Daftar[patients.N] = p
+		patients.N++
+		auditLog(AUDIT_CREATE, "patient", p.ID, nil, p)
+		publishChange(ENTITY_PATIENT, p.ID, nil, p)
+		ids = append(ids, p.ID)
+		if company != nil {
+			company.Employees = append(company.Employees, p.ID)
+		}
+	}
+	if company != nil {
+		auditLog(AUDIT_UPDATE, "company_roster", company.ID, rosterBefore, company.Employees)
+	}
+	auditLog(AUDIT_IMPORT, "patient", 0, nil, map[string]interface{}{
+		"file": filepath.Base(filename), "imported": len(ids), "errors": len(errs),
+	})
+
+	printSuccess(fmt.Sprintf("%d patients imported (IDs %d-%d).", len(ids), ids[0], ids[len(ids)-1]))
+	if company != nil {
+		fmt.Printf("Added to the roster of %s.\n", company.Name)
+	}
+	if len(errs) > 0 {
+		printWarning(fmt.Sprintf("%d rows skipped; see the report.", len(errs)))
+	}
+	pause()
+}
+
+func importPackages() {
+	printHeader("Bulk Import Packages")
+
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
+	filename := getValidInput("Enter file path (.csv or .xlsx): ")
+	header, rows, err := readImportFile(filename)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	fmt.Printf("%d data rows read.\n", len(rows))
+
+	mapping := mapColumns(header, packageImportFields)
+	mode := selectImportMode()
+
+	valid, errs := validatePackageRows(rows, mapping)
+	showImportErrors("packages", errs)
+	if importBlocked(mode, len(valid), len(errs)) {
+		pause()
+		return
+	}
+
+	var ids []int
+	for _, p := range valid {
+		p.ID = getNextPackageID()
+		p.BranchID = activeBranch
+		ensurePriceHistory(&p)
+		packages.Daftar[packages.N] = p
+		packages.N++
+		auditLog(AUDIT_CREATE, "package", p.ID, nil, p)
+		publishChange(ENTITY_PACKAGE, p.ID, nil, p)
+		ids = append(ids, p.ID)
+	}
+	auditLog(AUDIT_IMPORT, "package", 0, nil, map[string]interface{}{
+		"file": filepath.Base(filename), "imported": len(ids), "errors": len(errs),
+	})
+
+	printSuccess(fmt.Sprintf("%d packages imported (IDs %d-%d).", len(ids), ids[0], ids[len(ids)-1]))
+	if len(errs) > 0 {
+		printWarning(fmt.Sprintf("%d rows skipped; see the report.", len(errs)))
+	}
+	pause()
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	PAYER_ID_START = 50001
+
+	// Claim statuses stored on records
+	CLAIM_UNCLAIMED = "Unclaimed"
+	CLAIM_SUBMITTED = "Submitted"
+	CLAIM_PAID      = "Paid"
+	CLAIM_PARTIAL   = "Partial"
+	CLAIM_REJECTED  = "Rejected"
+)
+
+// Data structures
+type CoverageRule struct {
+	Category      string   `json:"category"`
+	Percent       float64  `json:"percent"`
+	Cap           float64  `json:"cap"`
+	ExcludedExams []string `json:"excluded_exams,omitempty"`
+}
+
+type Payer struct {
+	ID    int            `json:"id"`
+	Name  string         `json:"name"`
+	Type  string         `json:"type"`
+	Rules []CoverageRule `json:"rules,omitempty"`
+}
+
+type PayerArray struct {
+	Daftar [NMAX]Payer `json:"daftar"`
+	N      int         `json:"n"`
+}
+
+// Claim file structures
+type ClaimLine struct {
+	RecordID     int     `json:"record_id"`
+	PatientID    int     `json:"patient_id"`
+	MemberNumber string  `json:"member_number"`
+	PatientName  string  `json:"patient_name"`
+	PackageName  string  `json:"package_name"`
+	Category     string  `json:"category"`
+	Date         string  `json:"date"`
+	Billed       float64 `json:"billed"`
+	Claimed      float64 `json:"claimed"`
+}
+
+type ClaimBatch struct {
+	BatchID   string      `json:"batch_id"`
+	PayerID   int         `json:"payer_id"`
+	PayerName string      `json:"payer_name"`
+	CreatedAt string      `json:"created_at"`
+	Lines     []ClaimLine `json:"lines"`
+	Total     float64     `json:"total"`
+}
+
+type ClaimResponseLine struct {
+	RecordID   int     `json:"record_id"`
+	Status     string  `json:"status"`
+	PaidAmount float64 `json:"paid_amount"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// ClaimResponse is a payer's answer to one claim batch. A CSV response does
+// not name its batch, so the user gives it on import.
+type ClaimResponse struct {
+	BatchID string              `json:"batch_id"`
+	PayerID int                 `json:"payer_id,omitempty"`
+	Lines   []ClaimResponseLine `json:"lines"`
+}
+
+// Search and ID functions
+func binarySearchPayerByID(id int) int {
+	left, right := 0, payers.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if payers.Daftar[mid].ID == id {
+			return mid
+		} else if payers.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func getNextPayerID() int {
+	maxID := PAYER_ID_START - 1
+	for i := 0; i < payers.N; i++ {
+		if payers.Daftar[i].ID > maxID {
+			maxID = payers.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func getCoverageRule(p Payer, category string) (CoverageRule, bool) {
+	for _, rule := range p.Rules {
+		if rule.Category == category {
+			return rule, true
+		}
+	}
+	return CoverageRule{}, false
+}
+
+func setCoverageRule(p *Payer, rule CoverageRule) {
+	for i := range p.Rules {
+		if p.Rules[i].Category == rule.Category {
+			p.Rules[i] = rule
+			return
+		}
+	}
+	p.Rules = append(p.Rules, rule)
+}
+
+// Coverage calculation functions
+
+// excludedAmount is the value of the package examinations the rule does not cover
+func excludedAmount(rule CoverageRule, pkg Package) float64 {
+	var total float64
+	for _, code := range rule.ExcludedExams {
+		if !packageHasExamination(pkg, code) {
+			continue
+		}
+		if idx := sequentialSearchExaminationByCode(code); idx != -1 {
+			total += examinations.Daftar[idx].Price
+		}
+	}
+	return total
+}
+
+// splitCoverage divides a price into the payer-covered portion and the patient copay
+func splitCoverage(rule CoverageRule, price, excluded float64) (float64, float64) {
+	coverable := price - excluded
+	if coverable < 0 {
+		coverable = 0
+	}
+
+	covered := coverable * rule.Percent / 100
+	if rule.Cap > 0 && covered > rule.Cap {
+		covered = rule.Cap
+	}
+
+	return covered, price - covered
+}
+
+// applyCoverage fills in the payer split of a record from the patient's payer
+func applyCoverage(r *Record) {
+	r.PayerID = 0
+	r.PayerAmount = 0
+	r.CopayAmount = recordPrice(*r)
+	r.ClaimStatus = ""
+
+	if r.Patient.PayerID == 0 {
+		return
+	}
+
+	idx := binarySearchPayerByID(r.Patient.PayerID)
+	if idx == -1 {
+		return
+	}
+
+	payer := payers.Daftar[idx]
+	rule, ok := getCoverageRule(payer, r.Package.Category)
+	if !ok {
+		return
+	}
+
+	covered, copay := splitCoverage(rule, recordPrice(*r), excludedAmount(rule, r.Package))
+	r.PayerID = payer.ID
+	r.PayerAmount = covered
+	r.CopayAmount = copay
+	if covered > 0 {
+		r.ClaimStatus = CLAIM_UNCLAIMED
+	}
+}
+
+func selectPayer() int {
+	if payers.N == 0 {
+		printError("No payers available. Please add a payer first.")
+		return -1
+	}
+
+	fmt.Println("Payers:")
+	for i := 0; i < payers.N; i++ {
+		p := payers.Daftar[i]
+		fmt.Printf("%d. %s - %s (ID: %d)\n", i+1, p.Name, p.Type, p.ID)
+	}
+	return getValidInt("Select payer: ", 1, payers.N) - 1
+}
+
+// Payer management functions
+func addPayer() {
+	printHeader("Add Payer")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	if payers.N >= NMAX {
+		printError("Cannot add more payers. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	name := getValidInput("Enter payer name: ")
+	fmt.Println("Payer type: 1. Private Insurer  2. National Health Insurance  3. Other")
+	types := []string{"Private Insurer", "National Health Insurance", "Other"}
+	payerType := types[getValidInt("Select type: ", 1, 3)-1]
+
+	payer := Payer{
+		ID:   getNextPayerID(),
+		Name: name,
+		Type: payerType,
+	}
+
+	payers.Daftar[payers.N] = payer
+	payers.N++
+	auditLog(AUDIT_CREATE, "payer", payer.ID, nil, payer)
+
+	printSuccess(fmt.Sprintf("Payer added successfully with ID: %d", payer.ID))
+	pause()
+}
+
+func displayPayers() {
+	printHeader("Payer List")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	if payers.N == 0 {
+		printWarning("No payers found.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "payer", 0, nil, nil)
+
+	for i := 0; i < payers.N; i++ {
+		p := payers.Daftar[i]
+		fmt.Printf("\n%s%d - %s (%s)%s\n", BOLD, p.ID, p.Name, p.Type, RESET)
+		if len(p.Rules) == 0 {
+			printWarning("No coverage rules.")
+			continue
+		}
+		fmt.Printf("%-15s %-10s %-12s %-20s\n", "Category", "Percent", "Cap", "Excluded")
+		fmt.Println(strings.Repeat("-", 60))
+		for _, rule := range p.Rules {
+			capText := "-"
+			if rule.Cap > 0 {
+				capText = fmt.Sprintf("$%.2f", rule.Cap)
+			}
+			excluded := "-"
+			if len(rule.ExcludedExams) > 0 {
+				excluded = strings.Join(rule.ExcludedExams, ", ")
+			}
+			fmt.Printf("%-15s %-10.1f %-12s %-20s\n", rule.Category, rule.Percent, capText, excluded)
+		}
+	}
+
+	pause()
+}
+
+func editCoverageRules() {
+	printHeader("Coverage Rules")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	idx := selectPayer()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	p := &payers.Daftar[idx]
+	category := getValidCategory()
+
+	rule := CoverageRule{Category: category}
+	rule.Percent = getValidFloat("Enter covered percentage (0-100): ", 0.0)
+	if rule.Percent > 100 {
+		rule.Percent = 100
+	}
+	rule.Cap = getValidFloat("Enter coverage cap per check-up (0 for no cap): ", 0.0)
+
+	if examinations.N > 0 {
+		fmt.Println("\nExclude examinations from coverage (0 to finish):")
+		for i := 0; i < examinations.N; i++ {
+			e := examinations.Daftar[i]
+			fmt.Printf("%d. %s - %s\n", i+1, e.Code, e.Name)
+		}
+		for {
+			choice := getValidInt("Exclude examination: ", 0, examinations.N)
+			if choice == 0 {
+				break
+			}
+			code := examinations.Daftar[choice-1].Code
+			alreadyExcluded := false
+			for _, c := range rule.ExcludedExams {
+				if c == code {
+					alreadyExcluded = true
+				}
+			}
+			if !alreadyExcluded {
+				rule.ExcludedExams = append(rule.ExcludedExams, code)
+			}
+		}
+	}
+
+	before, _ := getCoverageRule(*p, category)
+	setCoverageRule(p, rule)
+	auditLog(AUDIT_UPDATE, "payer_rule", p.ID, before, rule)
+	printSuccess(fmt.Sprintf("Coverage rule for %s saved for %s.", category, p.Name))
+	pause()
+}
+
+func assignPatientPayer() {
+	printHeader("Patient Coverage")
+
+	if !requirePermission(PERM_PATIENT_UPDATE) {
+		return
+	}
+
+	id := getValidInt("Enter patient ID: ", 1, 999999)
+	pIdx := binarySearchPatientByID(id)
+
+	if pIdx == -1 {
+		printError("Patient not found.")
+		pause()
+		return
+	}
+
+	p := &patients.Daftar[pIdx]
+	before := *p
+	fmt.Printf("\nPatient: %s (ID: %d)\n\n", p.Name, p.ID)
+	fmt.Println("1. Assign payer  2. Remove coverage")
+	if getValidInt("Choose option: ", 1, 2) == 2 {
+		p.PayerID = 0
+		p.MemberNumber = ""
+		auditLog(AUDIT_UPDATE, "patient", p.ID, before, *p)
+		printSuccess("Coverage removed. New records will be self-pay.")
+		pause()
+		return
+	}
+
+	idx := selectPayer()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	p.PayerID = payers.Daftar[idx].ID
+	p.MemberNumber = getValidInput("Enter member/policy number: ")
+	auditLog(AUDIT_UPDATE, "patient", p.ID, before, *p)
+
+	printSuccess(fmt.Sprintf("%s is now covered by %s.", p.Name, payers.Daftar[idx].Name))
+	pause()
+}
+
+// Claim functions
+// buildClaimBatch collects a payer's unclaimed records at the branches this
+// session can see
+func buildClaimBatch(payer Payer) ClaimBatch {
+	now := time.Now()
+	batch := ClaimBatch{
+		BatchID:   fmt.Sprintf("CLM-%d-%s", payer.ID, now.Format("20060102150405")),
+		PayerID:   payer.ID,
+		PayerName: payer.Name,
+		CreatedAt: now.Format("02/01/2006 15:04"),
+	}
+
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.PayerID != payer.ID || r.ClaimStatus != CLAIM_UNCLAIMED || !branchVisible(r.BranchID) {
+			continue
+		}
+		batch.Lines = append(batch.Lines, ClaimLine{
+			RecordID:     r.ID,
+			PatientID:    r.Patient.ID,
+			MemberNumber: r.Patient.MemberNumber,
+			PatientName:  r.Patient.Name,
+			PackageName:  r.Package.Name,
+			Category:     r.Package.Category,
+			Date:         r.Date,
+			Billed:       recordPrice(r),
+			Claimed:      r.PayerAmount,
+		})
+		batch.Total += r.PayerAmount
+	}
+
+	return batch
+}
+
+func writeClaimBatchJSON(batch ClaimBatch, filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create claim file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(batch); err != nil {
+		return fmt.Errorf("failed to encode claim batch: %v", err)
+	}
+	return nil
+}
+
+func writeClaimBatchCSV(batch ClaimBatch, filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create claim file: %v", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"batch_id", "record_id", "patient_id", "member_number", "patient_name",
+		"package_name", "category", "date", "billed", "claimed"})
+	for _, line := range batch.Lines {
+		writer.Write([]string{
+			batch.BatchID,
+			strconv.Itoa(line.RecordID),
+			strconv.Itoa(line.PatientID),
+			csvCell(line.MemberNumber),
+			csvCell(line.PatientName),
+			csvCell(line.PackageName),
+			csvCell(line.Category),
+			line.Date,
+			strconv.FormatFloat(line.Billed, 'f', 2, 64),
+			strconv.FormatFloat(line.Claimed, 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write claim batch: %v", err)
+	}
+	return nil
+}
+
+func generateClaimBatch() {
+	printHeader("Generate Claim Batch")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	idx := selectPayer()
+	if idx == -1 {
+		pause()
+		return
+	}
+
+	payer := payers.Daftar[idx]
+	batch := buildClaimBatch(payer)
+	if len(batch.Lines) == 0 {
+		printWarning(fmt.Sprintf("No unclaimed records for %s.", payer.Name))
+		pause()
+		return
+	}
+
+	fmt.Println("File format: 1. JSON  2. CSV")
+	format := getValidInt("Choose format: ", 1, 2)
+
+	var filename string
+	var err error
+	if format == 1 {
+		filename = batch.BatchID + ".json"
+		err = writeClaimBatchJSON(batch, filename)
+	} else {
+		filename = batch.BatchID + ".csv"
+		err = writeClaimBatchCSV(batch, filename)
+	}
+
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_EXPORT, "claim_batch", payer.ID, nil, map[string]interface{}{
+		"batch_id": batch.BatchID, "file": filename, "claims": len(batch.Lines), "total": batch.Total,
+	})
+
+	// Mark the claimed records as submitted
+	for _, line := range batch.Lines {
+		if rIdx := searchRecordByID(line.RecordID); rIdx != -1 {
+			records.Daftar[rIdx].ClaimStatus = CLAIM_SUBMITTED
+			records.Daftar[rIdx].ClaimBatch = batch.BatchID
+		}
+	}
+
+	printSuccess(fmt.Sprintf("Claim batch %s written to %s (%d claims, $%.2f).",
+		batch.BatchID, filename, len(batch.Lines), batch.Total))
+	pause()
+}
+
+// readClaimResponse reads a response file in JSON, or CSV with the columns
+// record_id,status,paid_amount[,reason]
+func readClaimResponse(filename string) (ClaimResponse, error) {
+	var response ClaimResponse
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return response, fmt.Errorf("failed to open response file: %v", err)
+	}
+	defer file.Close()
+
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		if err := json.NewDecoder(file).Decode(&response); err != nil {
+			return response, fmt.Errorf("failed to decode response file: %v", err)
+		}
+		return response, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return response, fmt.Errorf("failed to read response file: %v", err)
+	}
+
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && strings.EqualFold(row[0], "record_id") {
+			continue
+		}
+		if len(row) < 3 {
+			return response, fmt.Errorf("line %d: expected at least 3 columns", i+1)
+		}
+		recordID, err1 := strconv.Atoi(strings.TrimSpace(row[0]))
+		paid, err2 := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err1 != nil || err2 != nil {
+			return response, fmt.Errorf("line %d: invalid record ID or amount", i+1)
+		}
+		line := ClaimResponseLine{RecordID: recordID, Status: strings.TrimSpace(row[1]), PaidAmount: paid}
+		if len(row) > 3 {
+			line.Reason = strings.TrimSpace(row[3])
+		}
+		response.Lines = append(response.Lines, line)
+	}
+
+	return response, nil
+}
+
+// claimBatchPayer returns the payer a claim batch was sent to, or 0 when no
+// record was claimed in it
+func claimBatchPayer(batchID string) int {
+	for i := 0; i < records.N; i++ {
+		if r := records.Daftar[i]; batchID != "" && r.ClaimBatch == batchID {
+			return r.PayerID
+		}
+	}
+	return 0
+}
+
+// checkClaimResponse makes sure a response answers one of our batches, and
+// comes from the payer the batch was sent to
+func checkClaimResponse(response ClaimResponse) error {
+	payerID := claimBatchPayer(response.BatchID)
+	switch {
+	case response.BatchID == "":
+		return fmt.Errorf("the response does not name its claim batch")
+	case payerID == 0:
+		return fmt.Errorf("no claims were sent in batch %s", response.BatchID)
+	case response.PayerID != 0 && response.PayerID != payerID:
+		return fmt.Errorf("batch %s was sent to payer %d, but the response is from payer %d", response.BatchID, payerID, response.PayerID)
+	}
+	return nil
+}
+
+// applyClaimResponse updates payment status and returns how many lines matched
+// a claim submitted in the response's batch
+func applyClaimResponse(response ClaimResponse) (int, int) {
+	applied, unmatched := 0, 0
+
+	for _, line := range response.Lines {
+		rIdx := searchRecordByID(line.RecordID)
+		if rIdx == -1 || records.Daftar[rIdx].ClaimStatus == "" || records.Daftar[rIdx].ClaimStatus == CLAIM_UNCLAIMED ||
+			records.Daftar[rIdx].ClaimBatch != response.BatchID {
+			unmatched++
+			continue
+		}
+
+		r := &records.Daftar[rIdx]
+		before := *r
+		switch strings.ToUpper(line.Status) {
+		case "PAID", "APPROVED":
+			r.PaidAmount = line.PaidAmount
+			if line.PaidAmount < r.PayerAmount {
+				r.ClaimStatus = CLAIM_PARTIAL
+			} else {
+				r.ClaimStatus = CLAIM_PAID
+			}
+		case "REJECTED", "DENIED":
+			r.PaidAmount = 0
+			r.ClaimStatus = CLAIM_REJECTED
+		default:
+			unmatched++
+			continue
+		}
+		r.ClaimNote = line.Reason
+		auditLog(AUDIT_UPDATE, "record_claim", r.ID, before, *r)
+		applied++
+	}
+
+	return applied, unmatched
+}
+
+func importClaimResponse() {
+	printHeader("Import Claim Response")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	filename := getValidInput("Enter response file path (.json or .csv): ")
+	response, err := readClaimResponse(filename)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	if response.BatchID == "" {
+		response.BatchID = getValidInput("Enter the claim batch this response answers (e.g. CLM-1-20250601120000): ")
+	}
+	if err := checkClaimResponse(response); err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+	// A response that does not name its payer is confirmed by the user
+	if response.PayerID == 0 {
+		payerID := claimBatchPayer(response.BatchID)
+		payerName := fmt.Sprintf("payer %d", payerID)
+		if idx := binarySearchPayerByID(payerID); idx != -1 {
+			payerName = payers.Daftar[idx].Name
+		}
+		confirm := getValidInput(fmt.Sprintf("Batch %s was sent to %s. Is the response from them? (y/N): ", response.BatchID, payerName))
+		if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+			printWarning("Response not applied.")
+			pause()
+			return
+		}
+	}
+
+	auditLog(AUDIT_IMPORT, "claim_response", 0, nil, map[string]interface{}{
+		"file": filename, "batch_id": response.BatchID, "lines": len(response.Lines),
+	})
+	applied, unmatched := applyClaimResponse(response)
+	printSuccess(fmt.Sprintf("%d claim responses applied.", applied))
+	if unmatched > 0 {
+		printWarning(fmt.Sprintf("%d lines did not match a claim submitted in batch %s or had an unknown status.", unmatched, response.BatchID))
+	}
+	pause()
+}
+
+func displayClaimStatus() {
+	printHeader("Claim Status")
+
+	if !requirePermission(PERM_BILLING_MANAGE) {
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "record_claim", 0, nil, nil)
+
+	fmt.Printf("%s%-8s %-20s %-20s %-12s %-10s %-10s %-10s %-10s%s\n", BOLD,
+		"Record", "Patient", "Payer", "Date", "Payer", "Copay", "Paid", "Status", RESET)
+	fmt.Println(strings.Repeat("-", 108))
+
+	found := false
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.PayerID == 0 {
+			continue
+		}
+		payerName := fmt.Sprintf("%d", r.PayerID)
+		if idx := binarySearchPayerByID(r.PayerID); idx != -1 {
+			payerName = payers.Daftar[idx].Name
+		}
+		fmt.Printf("%-8d %-20s %-20s %-12s $%-9.2f $%-9.2f $%-9.2f %-10s\n",
+			r.ID, displayName(r.Patient.Name), payerName, r.Date, r.PayerAmount, r.CopayAmount, r.PaidAmount, r.ClaimStatus)
+		found = true
+	}
+
+	if !found {
+		printWarning("No insured records found.")
+	}
+
+	pause()
+}
+
+func insuranceManagement() {
+	for {
+		printHeader("Insurance & Claims")
+		fmt.Printf("%s1.%s Add Payer\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Payers\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Coverage Rules\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Patient Coverage\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Generate Claim Batch\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Import Claim Response\n", YELLOW, RESET)
+		fmt.Printf("%s7.%s Claim Status\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 7)
+
+		switch choice {
+		case 1:
+			addPayer()
+		case 2:
+			displayPayers()
+		case 3:
+			editCoverageRules()
+		case 4:
+			assignPatientPayer()
+		case 5:
+			generateClaimBatch()
+		case 6:
+			importClaimResponse()
+		case 7:
+			displayClaimStatus()
+		case 0:
+			return
+		}
+	}
+}
@@ -0,0 +1,1019 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	LOCK_WAIT  = 30 * time.Second
+	LOCK_STALE = 2 * time.Minute
+	LOCK_RETRY = 100 * time.Millisecond
+
+	// Notifications and webhook events are sent in the background at most
+	// every DELIVERY_INTERVAL. A claim keeps other terminals off them for
+	// DELIVERY_LEASE, longer than a batch can take to send.
+	DELIVERY_INTERVAL = time.Minute
+	DELIVERY_LEASE    = 15 * time.Minute
+	DELIVERY_BATCH    = 20
+)
+
+// ErrLocked means another terminal held a lock for longer than LOCK_WAIT
+var ErrLocked = errors.New("locked by another terminal")
+
+// Data structures
+
+// DataConflict is a change of this terminal that met a change saved by
+// another one. Either the saved version was kept and ours only went to the
+// audit trail, or (NewID set) our new item was saved under another ID.
+type DataConflict struct {
+	Entity string
+	Key    string
+	Reason string
+	NewID  int
+	Saved  interface{}
+	Local  interface{}
+}
+
+// Sync state: the store as this terminal last read or wrote it, and the key
+// the file on disk is encrypted with (it differs from dataKey while rotating)
+var (
+	syncBase DataStore
+	diskKey  []byte
+)
+
+// deliveryRun is a background run of the notifications and webhook events
+// claimed at the main menu
+type deliveryRun struct {
+	Notifications []notificationClaim
+	Webhooks      []webhookClaim
+}
+
+// Background delivery: deliveryDone is set while a run is in flight
+var (
+	lastDeliveryRun time.Time
+	deliveryDone    chan deliveryRun
+)
+
+// Lock file functions
+
+// acquireLock creates path.lock, waiting while another terminal holds it.
+// A lock older than LOCK_STALE is left over from a crash and is taken over.
+func acquireLock(path string) error {
+	lock := path + ".lock"
+	deadline := time.Now().Add(LOCK_WAIT)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%s pid %d since %s\n", lockOwner(), os.Getpid(), time.Now().Format(time.RFC3339))
+			file.Close()
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > LOCK_STALE {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			holder, _ := os.ReadFile(lock)
+			return fmt.Errorf("%s is %w (%s)", path, ErrLocked, strings.TrimSpace(string(holder)))
+		}
+		time.Sleep(LOCK_RETRY)
+	}
+}
+
+func releaseLock(path string) {
+	os.Remove(path + ".lock")
+}
+
+func lockOwner() string {
+	host, _ := os.Hostname()
+	user := "-"
+	if currentUser != nil {
+		user = currentUser.Username
+	}
+	return user + "@" + host
+}
+
+// Store functions
+func currentDataStore() DataStore {
+	return DataStore{
+		Revision:     syncBase.Revision,
+		Patients:     patients,
+		Packages:     packages,
+		Records:      records,
+		Companies:    companies,
+		Examinations: examinations,
+		Payers:       payers,
+		Promotions:   promotions,
+		Categories:   categories,
+		Users:        users,
+		AuditHead:    AuditHead{Seq: auditLastSeq, Hash: auditLastHash},
+		ResearchKey:  researchKey,
+		Retention:    retentionPolicy,
+		Trash:        trash,
+		ResultSheet:  resultTemplate,
+		LabCodes:     labCodes,
+
+		Practitioners: practitioners,
+		Recalls:       recallNotices,
+		Notifiers:     notifierSettings,
+
+		Notifications:   notifications,
+		NotifyTemplates: notifyTemplates,
+
+		Events:      eventLog,
+		Webhooks:    webhooks,
+		DeadLetters: deadLetters,
+
+		Branches:   branches,
+		BranchSync: branchSync,
+
+		Erasures: erasures,
+	}
+}
+
+func applyDataStore(data DataStore) {
+	// currentUser points into the user list, which may be reordered
+	var user *User
+	if currentUser != nil {
+		u := *currentUser
+		user = &u
+	}
+
+	patients = data.Patients
+	packages = data.Packages
+	records = data.Records
+	companies = data.Companies
+	examinations = data.Examinations
+	payers = data.Payers
+	promotions = data.Promotions
+	categories = data.Categories
+	users = data.Users
+	auditHead = data.AuditHead
+	researchKey = data.ResearchKey
+	retentionPolicy = data.Retention
+	trash = data.Trash
+	resultTemplate = data.ResultSheet
+	labCodes = data.LabCodes
+	practitioners = data.Practitioners
+	recallNotices = data.Recalls
+	notifierSettings = data.Notifiers
+	notifications = data.Notifications
+	notifyTemplates = data.NotifyTemplates
+	eventLog = data.Events
+	webhooks = data.Webhooks
+	deadLetters = data.DeadLetters
+	branches = data.Branches
+	branchSync = data.BranchSync
+	erasures = data.Erasures
+
+	if user != nil {
+		currentUser = user
+		if idx := binarySearchUserByID(user.ID); idx != -1 {
+			currentUser = &users.Daftar[idx]
+		}
+		refreshPrivacyMode()
+	}
+}
+
+// readDataStore reads a store as it is on disk; no file is an empty store
+func readDataStore(path string, key []byte) (DataStore, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DataStore{}, nil
+		}
+		return DataStore{}, fmt.Errorf("failed to open data file: %v", err)
+	}
+	return decodeDataStore(content, key)
+}
+
+// decodeDataStore reads a store from the content of a data file
+func decodeDataStore(content []byte, key []byte) (DataStore, error) {
+	var data DataStore
+	if len(content) == 0 {
+		return data, nil
+	}
+
+	// Older data files are plain JSON; they are encrypted on the next save
+	if store, encrypted := parseEncryptedStore(content); encrypted {
+		var err error
+		if content, err = decryptStore(store, key); err != nil {
+			return data, err
+		}
+	}
+
+	if err := json.Unmarshal(content, &data); err != nil {
+		return data, fmt.Errorf("failed to decode data: %v", err)
+	}
+	return data, nil
+}
+
+// sameStore compares two stores, leaving out what changes on every save
+func sameStore(a, b DataStore) bool {
+	a.Revision, b.Revision = 0, 0
+	a.AuditHead, b.AuditHead = AuditHead{}, AuditHead{}
+	return sameValue(a, b)
+}
+
+// commitData writes this terminal's changes under the data lock. Whatever
+// other terminals saved since we last read the file is merged in first, and
+// work (if any) then runs on the merged data while the lock is still held.
+func commitData(work func()) ([]DataConflict, error) {
+	if err := acquireLock(DATA_FILE); err != nil {
+		return nil, err
+	}
+	defer releaseLock(DATA_FILE)
+
+	saved, err := readDataStore(DATA_FILE, diskKey)
+	if err != nil {
+		if errors.Is(err, ErrWrongKey) {
+			return nil, fmt.Errorf("the data file was re-encrypted on another terminal; restart the program and unlock it with the new key")
+		}
+		return nil, err
+	}
+
+	var conflicts []DataConflict
+	if saved.Revision != syncBase.Revision {
+		merged, found := mergeDataStores(syncBase, currentDataStore(), saved)
+		applyDataStore(merged)
+		conflicts = found
+		auditConflicts(conflicts)
+	}
+	syncBase = saved
+
+	if work != nil {
+		work()
+	}
+
+	// Changes are not saved before the entries about them are in the log
+	if err := flushAuditLog(); err != nil {
+		return conflicts, fmt.Errorf("%v; the changes are kept here and saved once the audit log can be written", err)
+	}
+
+	// A new key means the file is written again even without changes
+	if sameStore(currentDataStore(), saved) && bytes.Equal(diskKey, dataKey.Key) {
+		return conflicts, nil
+	}
+
+	bumpVersions(saved)
+	data := currentDataStore()
+	data.Revision = saved.Revision + 1
+
+	plaintext, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return conflicts, fmt.Errorf("failed to encode data: %v", err)
+	}
+	content, err := encryptStore(plaintext, dataKey)
+	if err != nil {
+		return conflicts, fmt.Errorf("failed to encrypt data: %v", err)
+	}
+	if err := writeDataFile(DATA_FILE, content); err != nil {
+		return conflicts, err
+	}
+
+	syncBase = data
+	diskKey = dataKey.Key
+	return conflicts, nil
+}
+
+// syncData runs at the main menu: it saves this terminal's changes and picks
+// up what other terminals saved. Notifications and webhook events that are
+// due are claimed under the lock and sent in the background once it is
+// released; their outcome is saved by a later call.
+func syncData() {
+	now := time.Now()
+	var run deliveryRun
+	conflicts, err := commitData(func() {
+		finishDeliveries(false)
+		if deliveryDone == nil && now.Sub(lastDeliveryRun) >= DELIVERY_INTERVAL {
+			run = deliveryRun{Notifications: claimNotifications(now), Webhooks: claimWebhooks(now)}
+			lastDeliveryRun = now
+		}
+	})
+	if err != nil {
+		// Claims that were not saved are not safe to send
+		releaseClaims(run)
+		printWarning(fmt.Sprintf("Could not sync with the data file: %v", err))
+		printWarning("Your changes are kept here and saved on the next try.")
+		pause()
+		return
+	}
+	startDeliveries(run)
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "Another terminal")
+		pause()
+	}
+}
+
+// startDeliveries sends a run in the background. It works on copies only;
+// finishDeliveries applies the outcome on the main goroutine.
+func startDeliveries(run deliveryRun) {
+	if len(run.Notifications) == 0 && len(run.Webhooks) == 0 {
+		return
+	}
+	done := make(chan deliveryRun, 1)
+	deliveryDone = done
+	settings := notifierSettings
+	go func() {
+		sendNotifications(run.Notifications, settings)
+		sendWebhooks(run.Webhooks, time.Now())
+		done <- run
+	}()
+}
+
+// finishDeliveries applies the outcome of a background run that has ended.
+// With wait it waits for a run still in flight, e.g. before exiting.
+func finishDeliveries(wait bool) {
+	if deliveryDone == nil {
+		return
+	}
+	var run deliveryRun
+	if wait {
+		run = <-deliveryDone
+	} else {
+		select {
+		case run = <-deliveryDone:
+		default:
+			return
+		}
+	}
+	deliveryDone = nil
+	now := time.Now()
+	applyNotifications(run.Notifications, now)
+	applyWebhooks(run.Webhooks, now)
+}
+
+// releaseClaims gives back claims that will not be sent
+func releaseClaims(run deliveryRun) {
+	for _, c := range run.Notifications {
+		for i := range notifications {
+			if notifications[i].ID == c.ID && notifications[i].LeasedUntil == c.LeasedUntil {
+				notifications[i].LeasedUntil = ""
+			}
+		}
+	}
+	for _, c := range run.Webhooks {
+		if idx := findWebhook(c.Webhook.ID); idx != -1 && webhooks[idx].LeasedUntil == c.LeasedUntil {
+			webhooks[idx].LeasedUntil = ""
+		}
+	}
+}
+
+// leaseHeld reports whether a claim made until the given time still holds
+func leaseHeld(until string, now time.Time) bool {
+	t, err := time.ParseInLocation(NOTIFY_TIME_FORMAT, until, time.Local)
+	return err == nil && now.Before(t)
+}
+
+// printConflicts lists conflicts with changes saved by source, such as
+// "Another terminal"
+func printConflicts(conflicts []DataConflict, source string) {
+	fmt.Printf("\n%s⚠ %s saved changes to the same data:%s\n", YELLOW, source, RESET)
+	for _, c := range conflicts {
+		name := c.Entity
+		if c.Key != "" {
+			name += " " + c.Key
+		}
+		if c.NewID != 0 {
+			fmt.Printf("  - %s was saved as %d (%s)\n", name, c.NewID, c.Reason)
+		} else {
+			fmt.Printf("  - %s: %s; your change was not saved\n", name, c.Reason)
+		}
+	}
+	fmt.Println("Changes that were not saved are kept in the audit trail (action \"conflict\"). Check the data and enter them again if needed.")
+}
+
+// auditConflicts keeps our side of every conflict in the audit trail so that
+// nothing is lost silently
+func auditConflicts(conflicts []DataConflict) {
+	for _, c := range conflicts {
+		id, _ := strconv.Atoi(c.Key)
+		if c.NewID != 0 {
+			auditLog(AUDIT_UPDATE, c.Entity, c.NewID, map[string]int{"id": id}, map[string]int{"id": c.NewID})
+			continue
+		}
+		auditLog(AUDIT_CONFLICT, c.Entity, id, c.Saved, c.Local)
+	}
+}
+
+// Versions
+
+// bumpVersions numbers every patient, package and record that differs from
+// the saved copy one version above it; new ones start at version 1. Patients
+// and records also get the time of the change, which decides between two
+// branches that changed the same field.
+func bumpVersions(saved DataStore) {
+	now := time.Now().Format(time.RFC3339)
+	savedPatients := indexByKey(saved.Patients.Daftar[:saved.Patients.N], patientKey)
+	for i := 0; i < patients.N; i++ {
+		p := &patients.Daftar[i]
+		p.UpdatedAt = changedAt(savedPatients[patientKey(*p)], p, p.UpdatedAt, now)
+		p.Version = nextVersion(savedPatients[patientKey(*p)], p, p.Version)
+	}
+	savedPackages := indexByKey(saved.Packages.Daftar[:saved.Packages.N], packageKey)
+	for i := 0; i < packages.N; i++ {
+		p := &packages.Daftar[i]
+		p.Version = nextVersion(savedPackages[packageKey(*p)], p, p.Version)
+	}
+	savedRecords := indexByKey(saved.Records.Daftar[:saved.Records.N], recordKey)
+	for i := 0; i < records.N; i++ {
+		r := &records.Daftar[i]
+		r.UpdatedAt = changedAt(savedRecords[recordKey(*r)], r, r.UpdatedAt, now)
+		r.Version = nextVersion(savedRecords[recordKey(*r)], r, r.Version)
+	}
+}
+
+func nextVersion[T any](saved *T, current *T, version int) int {
+	switch {
+	case saved == nil:
+		return max(version, 1)
+	case sameContent(*saved, *current):
+		return versionOf(*saved)
+	default:
+		return versionOf(*saved) + 1
+	}
+}
+
+// changedAt keeps the time of an item's last change, or gives it now when
+// it differs from the saved copy. Items merged from elsewhere keep theirs.
+func changedAt[T any](saved *T, current *T, at, now string) string {
+	switch {
+	case saved == nil:
+		if at == "" {
+			return now
+		}
+		return at
+	case sameContent(*saved, *current):
+		return updatedAt(*saved)
+	case at != updatedAt(*saved):
+		return at
+	default:
+		return now
+	}
+}
+
+func updatedAt(v interface{}) string {
+	switch e := v.(type) {
+	case Patient:
+		return e.UpdatedAt
+	case Record:
+		return e.UpdatedAt
+	}
+	return ""
+}
+
+func versionOf(v interface{}) int {
+	switch e := v.(type) {
+	case Patient:
+		return e.Version
+	case Package:
+		return e.Version
+	case Record:
+		return e.Version
+	}
+	return 0
+}
+
+// sameContent compares two values, leaving out the version number and the
+// time of the last change
+func sameContent(a, b interface{}) bool {
+	return sameValue(unversioned(a), unversioned(b))
+}
+
+func unversioned(v interface{}) interface{} {
+	switch e := v.(type) {
+	case Patient:
+		e.Version, e.UpdatedAt = 0, ""
+		return e
+	case Package:
+		e.Version = 0
+		return e
+	case Record:
+		e.Version, e.UpdatedAt = 0, ""
+		return e
+	}
+	return v
+}
+
+// Merge functions
+
+func patientKey(p Patient) string { return strconv.Itoa(p.ID) }
+func packageKey(p Package) string { return strconv.Itoa(p.ID) }
+func recordKey(r Record) string   { return strconv.Itoa(r.ID) }
+
+func practitionerKey(p Practitioner) string { return strconv.Itoa(p.ID) }
+func branchKey(b Branch) string             { return strconv.Itoa(b.ID) }
+
+func indexByKey[T any](list []T, key func(T) string) map[string]*T {
+	index := make(map[string]*T, len(list))
+	for i := range list {
+		index[key(list[i])] = &list[i]
+	}
+	return index
+}
+
+func sameItem[T any](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return sameContent(*a, *b)
+}
+
+// mergeItem settles one item from its base, local and saved versions (nil
+// where it does not exist). A side's change is taken when only that side
+// made one; when both did, the saved version stays and the reason is given.
+func mergeItem[T any](base, ours, saved *T) (*T, string) {
+	switch {
+	case sameItem(ours, base):
+		return saved, ""
+	case sameItem(saved, base) || sameItem(ours, saved):
+		return ours, ""
+	case base == nil:
+		return saved, "added with the same ID on another terminal"
+	case ours == nil:
+		return saved, "changed on another terminal after you deleted it"
+	case saved == nil:
+		return nil, "deleted on another terminal while you changed it"
+	}
+	reason := "changed on another terminal at the same time"
+	if v := versionOf(*saved); v > 0 {
+		reason = fmt.Sprintf("changed on another terminal (now version %d)", v)
+		if b := versionOf(*base); b > 0 {
+			reason += fmt.Sprintf(" while you edited version %d", b)
+		}
+	}
+	return saved, reason
+}
+
+func conflictValue[T any](v *T) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// mergeList merges a list item by item. The saved order is kept and our new
+// items come after it.
+func mergeList[T any](entity string, base, ours, saved []T, key func(T) string, conflicts *[]DataConflict) []T {
+	baseIndex, ourIndex := indexByKey(base, key), indexByKey(ours, key)
+	seen := make(map[string]bool)
+	var merged []T
+
+	settle := func(k string, s *T) {
+		seen[k] = true
+		result, reason := mergeItem(baseIndex[k], ourIndex[k], s)
+		if reason != "" {
+			*conflicts = append(*conflicts, DataConflict{Entity: entity, Key: k, Reason: reason,
+				Saved: conflictValue(s), Local: conflictValue(ourIndex[k])})
+		}
+		if result != nil {
+			merged = append(merged, *result)
+		}
+	}
+
+	for i := range saved {
+		settle(key(saved[i]), &saved[i])
+	}
+	for i := range base {
+		if k := key(base[i]); !seen[k] {
+			settle(k, nil)
+		}
+	}
+	for i := range ours {
+		if k := key(ours[i]); !seen[k] {
+			settle(k, nil)
+		}
+	}
+	return merged
+}
+
+// mergeMap merges a map key by key
+func mergeMap[V any](entity string, base, ours, saved map[string]V, conflicts *[]DataConflict) map[string]V {
+	keys := make(map[string]bool)
+	for _, m := range []map[string]V{base, ours, saved} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+
+	var merged map[string]V
+	for k := range keys {
+		result, reason := mergeItem(mapItem(base, k), mapItem(ours, k), mapItem(saved, k))
+		if reason != "" {
+			*conflicts = append(*conflicts, DataConflict{Entity: entity, Key: k, Reason: reason,
+				Saved: conflictValue(mapItem(saved, k)), Local: conflictValue(mapItem(ours, k))})
+		}
+		if result != nil {
+			if merged == nil {
+				merged = make(map[string]V)
+			}
+			merged[k] = *result
+		}
+	}
+	return merged
+}
+
+func mapItem[V any](m map[string]V, k string) *V {
+	if v, ok := m[k]; ok {
+		return &v
+	}
+	return nil
+}
+
+// mergeSetting merges a value that is changed as a whole, such as a policy
+func mergeSetting[T any](entity string, base, ours, saved T, conflicts *[]DataConflict) T {
+	result, reason := mergeItem(&base, &ours, &saved)
+	if reason != "" {
+		*conflicts = append(*conflicts, DataConflict{Entity: entity, Reason: reason, Saved: saved, Local: ours})
+	}
+	return *result
+}
+
+// fillArray puts a merged list back into a fixed-size array; items that no
+// longer fit are reported
+func fillArray[T any](entity string, array *[NMAX]T, list []T, key func(T) string, conflicts *[]DataConflict) int {
+	*array = [NMAX]T{}
+	for i, item := range list {
+		if i == NMAX {
+			*conflicts = append(*conflicts, DataConflict{Entity: entity, Key: key(item),
+				Reason: "no room left after adding the other terminal's items", Local: item})
+			continue
+		}
+		array[i] = item
+	}
+	return min(len(list), NMAX)
+}
+
+func sortByID[T any](list []T, key func(T) string) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, _ := strconv.Atoi(key(list[i]))
+		b, _ := strconv.Atoi(key(list[j]))
+		return a < b
+	})
+}
+
+// mergeDataStores merges our changes since base with the store another
+// terminal saved
+func mergeDataStores(base, ours, saved DataStore) (DataStore, []DataConflict) {
+	var conflicts []DataConflict
+	renumberNewItems(base, &ours, saved, &conflicts)
+
+	merged := saved
+	list := mergeList(ENTITY_PATIENT, base.Patients.Daftar[:base.Patients.N], ours.Patients.Daftar[:ours.Patients.N], saved.Patients.Daftar[:saved.Patients.N], patientKey, &conflicts)
+	sortByID(list, patientKey)
+	merged.Patients.N = fillArray(ENTITY_PATIENT, &merged.Patients.Daftar, list, patientKey, &conflicts)
+
+	pkgs := mergeList(ENTITY_PACKAGE, base.Packages.Daftar[:base.Packages.N], ours.Packages.Daftar[:ours.Packages.N], saved.Packages.Daftar[:saved.Packages.N], packageKey, &conflicts)
+	sortByID(pkgs, packageKey)
+	merged.Packages.N = fillArray(ENTITY_PACKAGE, &merged.Packages.Daftar, pkgs, packageKey, &conflicts)
+
+	recs := mergeList(ENTITY_RECORD, base.Records.Daftar[:base.Records.N], ours.Records.Daftar[:ours.Records.N], saved.Records.Daftar[:saved.Records.N], recordKey, &conflicts)
+	sortByID(recs, recordKey)
+	merged.Records.N = fillArray(ENTITY_RECORD, &merged.Records.Daftar, recs, recordKey, &conflicts)
+
+	companyKey := func(c Company) string { return strconv.Itoa(c.ID) }
+	comps := mergeList("company", base.Companies.Daftar[:base.Companies.N], ours.Companies.Daftar[:ours.Companies.N], saved.Companies.Daftar[:saved.Companies.N], companyKey, &conflicts)
+	sortByID(comps, companyKey)
+	merged.Companies.N = fillArray("company", &merged.Companies.Daftar, comps, companyKey, &conflicts)
+
+	examKey := func(e Examination) string { return e.Code }
+	exams := mergeList("examination", base.Examinations.Daftar[:base.Examinations.N], ours.Examinations.Daftar[:ours.Examinations.N], saved.Examinations.Daftar[:saved.Examinations.N], examKey, &conflicts)
+	merged.Examinations.N = fillArray("examination", &merged.Examinations.Daftar, exams, examKey, &conflicts)
+
+	payerKey := func(p Payer) string { return strconv.Itoa(p.ID) }
+	pays := mergeList("payer", base.Payers.Daftar[:base.Payers.N], ours.Payers.Daftar[:ours.Payers.N], saved.Payers.Daftar[:saved.Payers.N], payerKey, &conflicts)
+	sortByID(pays, payerKey)
+	merged.Payers.N = fillArray("payer", &merged.Payers.Daftar, pays, payerKey, &conflicts)
+
+	promoKey := func(p Promotion) string { return strconv.Itoa(p.ID) }
+	promos := mergeList("promotion", base.Promotions.Daftar[:base.Promotions.N], ours.Promotions.Daftar[:ours.Promotions.N], saved.Promotions.Daftar[:saved.Promotions.N], promoKey, &conflicts)
+	sortByID(promos, promoKey)
+	merged.Promotions.N = fillArray("promotion", &merged.Promotions.Daftar, promos, promoKey, &conflicts)
+
+	categoryKey := func(c Category) string { return strconv.Itoa(c.ID) }
+	cats := mergeList("category", base.Categories.Daftar[:base.Categories.N], ours.Categories.Daftar[:ours.Categories.N], saved.Categories.Daftar[:saved.Categories.N], categoryKey, &conflicts)
+	sortByID(cats, categoryKey)
+	merged.Categories.N = fillArray("category", &merged.Categories.Daftar, cats, categoryKey, &conflicts)
+
+	userKey := func(u User) string { return strconv.Itoa(u.ID) }
+	accounts := mergeList("user", base.Users.Daftar[:base.Users.N], ours.Users.Daftar[:ours.Users.N], saved.Users.Daftar[:saved.Users.N], userKey, &conflicts)
+	sortByID(accounts, userKey)
+	merged.Users.N = fillArray("user", &merged.Users.Daftar, accounts, userKey, &conflicts)
+
+	staff := mergeList("practitioner", base.Practitioners.Daftar[:base.Practitioners.N], ours.Practitioners.Daftar[:ours.Practitioners.N], saved.Practitioners.Daftar[:saved.Practitioners.N], practitionerKey, &conflicts)
+	sortByID(staff, practitionerKey)
+	merged.Practitioners.N = fillArray("practitioner", &merged.Practitioners.Daftar, staff, practitionerKey, &conflicts)
+
+	sites := mergeList("branch", base.Branches.Daftar[:base.Branches.N], ours.Branches.Daftar[:ours.Branches.N], saved.Branches.Daftar[:saved.Branches.N], branchKey, &conflicts)
+	sortByID(sites, branchKey)
+	merged.Branches.N = fillArray("branch", &merged.Branches.Daftar, sites, branchKey, &conflicts)
+
+	trashKey := func(t TrashItem) string { return fmt.Sprintf("%s-%d-%s", t.Entity, trashItemID(t), t.DeletedAt) }
+	bin := mergeList("trash", base.Trash.Daftar[:base.Trash.N], ours.Trash.Daftar[:ours.Trash.N], saved.Trash.Daftar[:saved.Trash.N], trashKey, &conflicts)
+	merged.Trash.N = fillArray("trash", &merged.Trash.Daftar, bin, trashKey, &conflicts)
+	merged.Trash.PurgeDays = mergeSetting("trash period", base.Trash.PurgeDays, ours.Trash.PurgeDays, saved.Trash.PurgeDays, &conflicts)
+
+	merged.ResearchKey = mergeSetting("research key", base.ResearchKey, ours.ResearchKey, saved.ResearchKey, &conflicts)
+	merged.Retention = mergeSetting("retention policy", base.Retention, ours.Retention, saved.Retention, &conflicts)
+	merged.ResultSheet = mergeSetting("result sheet template", base.ResultSheet, ours.ResultSheet, saved.ResultSheet, &conflicts)
+	merged.Notifiers = mergeSetting("message channels", base.Notifiers, ours.Notifiers, saved.Notifiers, &conflicts)
+	merged.BranchSync = mergeSetting("central store settings", base.BranchSync, ours.BranchSync, saved.BranchSync, &conflicts)
+	merged.LabCodes = mergeMap("lab code", base.LabCodes, ours.LabCodes, saved.LabCodes, &conflicts)
+	merged.NotifyTemplates = mergeMap("notification template", base.NotifyTemplates, ours.NotifyTemplates, saved.NotifyTemplates, &conflicts)
+
+	merged.Recalls = mergeList("recall reminder", base.Recalls, ours.Recalls, saved.Recalls, func(n RecallNotice) string {
+		return fmt.Sprintf("%d-%d-%s-%s", n.PatientID, n.RecordID, n.Channel, n.SentAt)
+	}, &conflicts)
+	merged.Notifications = mergeList("notification", base.Notifications, ours.Notifications, saved.Notifications, func(n Notification) string {
+		return strconv.Itoa(n.ID)
+	}, &conflicts)
+	merged.Webhooks = mergeList("webhook", base.Webhooks, ours.Webhooks, saved.Webhooks, func(w Webhook) string {
+		return strconv.Itoa(w.ID)
+	}, &conflicts)
+	merged.DeadLetters = mergeList("dead letter", base.DeadLetters, ours.DeadLetters, saved.DeadLetters, func(d DeadLetter) string {
+		return fmt.Sprintf("%d-%d", d.WebhookID, d.Offset)
+	}, &conflicts)
+
+	merged.Erasures = mergeList("erasure", base.Erasures, ours.Erasures, saved.Erasures, erasureKey, &conflicts)
+
+	// Events about changes that were not saved must not reach subscribers;
+	// the rest of ours follow the saved ones without a gap
+	ours.Events.Events = dropConflictEvents(ours.Events.Events, saved.Events.Last, conflicts, merged.Erasures)
+	ours.Events.Last = saved.Events.Last
+	for i := range ours.Events.Events {
+		if ours.Events.Events[i].Offset > saved.Events.Last {
+			ours.Events.Last++
+			ours.Events.Events[i].Offset = ours.Events.Last
+		}
+	}
+	merged.Events.Events = mergeList("event", base.Events.Events, ours.Events.Events, saved.Events.Events, func(e DomainEvent) string {
+		return strconv.Itoa(e.Offset)
+	}, &conflicts)
+	merged.Events.Last = ours.Events.Last
+	if len(merged.Events.Events) > EVENT_LOG_MAX {
+		merged.Events.Events = merged.Events.Events[len(merged.Events.Events)-EVENT_LOG_MAX:]
+	}
+
+	// An erasure wins over changes made to the patient elsewhere
+	applyErasures(&merged, &conflicts)
+
+	merged.AuditHead = ours.AuditHead
+	return merged, conflicts
+}
+
+// dropConflictEvents leaves out our events about items whose change was not
+// saved. Erased items are left to the erasure, which keeps its own events.
+func dropConflictEvents(events []DomainEvent, since int, conflicts []DataConflict, erased []Erasure) []DomainEvent {
+	var kept []DomainEvent
+	for _, ev := range events {
+		dropped := false
+		for _, c := range conflicts {
+			if c.NewID == 0 && ev.Offset > since && ev.Entity == c.Entity && strconv.Itoa(ev.EntityID) == c.Key && !isErasedItem(erased, c.Entity, c.Key) {
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			kept = append(kept, ev)
+		}
+	}
+	return kept
+}
+
+func trashItemID(t TrashItem) int {
+	switch {
+	case t.Patient != nil:
+		return t.Patient.ID
+	case t.Package != nil:
+		return t.Package.ID
+	case t.Record != nil:
+		return t.Record.ID
+	}
+	return 0
+}
+
+// Renumbering
+
+// renumberNewItems moves this terminal's new patients, packages, records,
+// notifications, webhooks and events to fresh IDs where another terminal
+// saved different ones under the same IDs, and updates what refers to them
+func renumberNewItems(base DataStore, ours *DataStore, saved DataStore, conflicts *[]DataConflict) {
+	renumber := func(entity string, clashes []int, next int, apply func(from, to int)) {
+		for _, id := range clashes {
+			apply(id, next)
+			*conflicts = append(*conflicts, DataConflict{Entity: entity, Key: strconv.Itoa(id), NewID: next,
+				Reason: "the ID was taken on another terminal"})
+			next++
+		}
+	}
+
+	renumber(ENTITY_PATIENT, clashingIDs(base.Patients.Daftar[:base.Patients.N], ours.Patients.Daftar[:ours.Patients.N], saved.Patients.Daftar[:saved.Patients.N], patientKey),
+		max(storeMaxID(*ours, ENTITY_PATIENT), storeMaxID(saved, ENTITY_PATIENT))+1, func(from, to int) { renumberPatient(ours, from, to, base.Events.Last) })
+	renumber(ENTITY_PACKAGE, clashingIDs(base.Packages.Daftar[:base.Packages.N], ours.Packages.Daftar[:ours.Packages.N], saved.Packages.Daftar[:saved.Packages.N], packageKey),
+		max(storeMaxID(*ours, ENTITY_PACKAGE), storeMaxID(saved, ENTITY_PACKAGE))+1, func(from, to int) { renumberPackage(ours, from, to, base.Events.Last) })
+	renumber(ENTITY_RECORD, clashingIDs(base.Records.Daftar[:base.Records.N], ours.Records.Daftar[:ours.Records.N], saved.Records.Daftar[:saved.Records.N], recordKey),
+		max(storeMaxID(*ours, ENTITY_RECORD), storeMaxID(saved, ENTITY_RECORD))+1, func(from, to int) { renumberRecord(ours, from, to, base.Events.Last) })
+
+	// Delivery log entries and webhooks are numbered in order, so all of our
+	// new ones move behind the saved ones
+	renumberSequence(base.Notifications, ours.Notifications, saved.Notifications,
+		func(n Notification) string { return strconv.Itoa(n.ID) }, func(n *Notification, id int) { n.ID = id })
+	moved := renumberSequence(base.Webhooks, ours.Webhooks, saved.Webhooks,
+		func(w Webhook) string { return strconv.Itoa(w.ID) }, func(w *Webhook, id int) { w.ID = id })
+	for from, to := range moved {
+		for i := range ours.DeadLetters {
+			if ours.DeadLetters[i].WebhookID == from {
+				ours.DeadLetters[i].WebhookID = to
+			}
+		}
+		*conflicts = append(*conflicts, DataConflict{Entity: "webhook", Key: strconv.Itoa(from), NewID: to,
+			Reason: "the ID was taken on another terminal"})
+	}
+
+	// Our new events go after the ones published elsewhere
+	if shift := saved.Events.Last - base.Events.Last; shift > 0 {
+		for i := range ours.Events.Events {
+			if ours.Events.Events[i].Offset > base.Events.Last {
+				ours.Events.Events[i].Offset += shift
+			}
+		}
+	}
+}
+
+// clashingIDs lists our new IDs that another terminal saved something else under
+func clashingIDs[T any](base, ours, saved []T, key func(T) string) []int {
+	baseIndex, savedIndex := indexByKey(base, key), indexByKey(saved, key)
+	var clashes []int
+	for i := range ours {
+		k := key(ours[i])
+		if baseIndex[k] == nil && savedIndex[k] != nil && !sameContent(ours[i], *savedIndex[k]) {
+			id, _ := strconv.Atoi(k)
+			clashes = append(clashes, id)
+		}
+	}
+	return clashes
+}
+
+// renumberSequence gives all of our new items IDs after the saved ones, in
+// order, when any of them clash. It returns the old and new IDs.
+func renumberSequence[T any](base, ours, saved []T, key func(T) string, setID func(*T, int)) map[int]int {
+	if len(clashingIDs(base, ours, saved, key)) == 0 {
+		return nil
+	}
+	baseIndex := indexByKey(base, key)
+	next := max(maxKey(base, key), maxKey(saved, key)) + 1
+	moved := make(map[int]int)
+	for i := range ours {
+		if k := key(ours[i]); baseIndex[k] == nil {
+			id, _ := strconv.Atoi(k)
+			setID(&ours[i], next)
+			moved[id] = next
+			next++
+		}
+	}
+	return moved
+}
+
+func maxKey[T any](list []T, key func(T) string) int {
+	highest := 0
+	for _, item := range list {
+		if id, _ := strconv.Atoi(key(item)); id > highest {
+			highest = id
+		}
+	}
+	return highest
+}
+
+// storeMaxID is the highest ID in use for an entity, the trash included
+func storeMaxID(d DataStore, entity string) int {
+	highest := 0
+	switch entity {
+	case ENTITY_PATIENT:
+		highest = maxKey(d.Patients.Daftar[:d.Patients.N], patientKey)
+	case ENTITY_PACKAGE:
+		highest = maxKey(d.Packages.Daftar[:d.Packages.N], packageKey)
+	case ENTITY_RECORD:
+		highest = maxKey(d.Records.Daftar[:d.Records.N], recordKey)
+	}
+	for i := 0; i < d.Trash.N; i++ {
+		if t := d.Trash.Daftar[i]; t.Entity == entity && trashItemID(t) > highest {
+			highest = trashItemID(t)
+		}
+	}
+	return max(highest, maxErasedID(d.Erasures, entity))
+}
+
+func renumberPatient(d *DataStore, from, to, since int) {
+	for i := 0; i < d.Patients.N; i++ {
+		if d.Patients.Daftar[i].ID == from {
+			d.Patients.Daftar[i].ID = to
+		}
+	}
+	for i := 0; i < d.Records.N; i++ {
+		if d.Records.Daftar[i].Patient.ID == from {
+			d.Records.Daftar[i].Patient.ID = to
+		}
+	}
+	for i := 0; i < d.Companies.N; i++ {
+		for j, id := range d.Companies.Daftar[i].Employees {
+			if id == from {
+				d.Companies.Daftar[i].Employees[j] = to
+			}
+		}
+	}
+	for i := range d.Notifications {
+		if d.Notifications[i].PatientID == from {
+			d.Notifications[i].PatientID = to
+		}
+	}
+	for i := range d.Recalls {
+		if d.Recalls[i].PatientID == from {
+			d.Recalls[i].PatientID = to
+		}
+	}
+	renumberEvents(d, ENTITY_PATIENT, "patient_id", from, to, since)
+}
+
+func renumberPackage(d *DataStore, from, to, since int) {
+	for i := 0; i < d.Packages.N; i++ {
+		if d.Packages.Daftar[i].ID == from {
+			d.Packages.Daftar[i].ID = to
+		}
+	}
+	for i := 0; i < d.Records.N; i++ {
+		if d.Records.Daftar[i].Package.ID == from {
+			d.Records.Daftar[i].Package.ID = to
+		}
+	}
+	for i := 0; i < d.Promotions.N; i++ {
+		if d.Promotions.Daftar[i].PackageID == from {
+			d.Promotions.Daftar[i].PackageID = to
+		}
+	}
+	for i := 0; i < d.Companies.N; i++ {
+		for j := range d.Companies.Daftar[i].ContractPrices {
+			if d.Companies.Daftar[i].ContractPrices[j].PackageID == from {
+				d.Companies.Daftar[i].ContractPrices[j].PackageID = to
+			}
+		}
+	}
+	renumberEvents(d, ENTITY_PACKAGE, "package_id", from, to, since)
+}
+
+func renumberRecord(d *DataStore, from, to, since int) {
+	for i := 0; i < d.Records.N; i++ {
+		if d.Records.Daftar[i].ID == from {
+			d.Records.Daftar[i].ID = to
+		}
+	}
+	for i := range d.Notifications {
+		if d.Notifications[i].RecordID == from {
+			d.Notifications[i].RecordID = to
+		}
+	}
+	for i := range d.Recalls {
+		if d.Recalls[i].RecordID == from {
+			d.Recalls[i].RecordID = to
+		}
+	}
+	renumberEvents(d, ENTITY_RECORD, "", from, to, since)
+}
+
+// renumberEvents fixes the IDs in our new events (after offset since) that
+// were published for a renumbered item or refer to it by field
+func renumberEvents(d *DataStore, entity, field string, from, to, since int) {
+	for i := range d.Events.Events {
+		ev := &d.Events.Events[i]
+		switch {
+		case ev.Offset <= since:
+		case ev.Entity == entity && ev.EntityID == from:
+			ev.EntityID = to
+			setEventField(ev, "id", from, to)
+		case field != "" && ev.Entity == ENTITY_RECORD:
+			setEventField(ev, field, from, to)
+		}
+	}
+}
+
+func setEventField(ev *DomainEvent, field string, from, to int) {
+	var data map[string]interface{}
+	if json.Unmarshal(ev.Data, &data) != nil {
+		return
+	}
+	if v, ok := data[field].(float64); ok && int(v) == from {
+		data[field] = to
+		ev.Data, _ = json.Marshal(data)
+	}
+}
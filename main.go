@@ -2,12 +2,15 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/term"
 )
 
 // Constants
@@ -32,24 +35,71 @@ const (
 
 // Data structures
 type Patient struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	Gender string `json:"gender"`
-	Age    int    `json:"age"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Gender       string `json:"gender"`
+	Age          int    `json:"age"`
+	PayerID      int    `json:"payer_id,omitempty"`
+	MemberNumber string `json:"member_number,omitempty"`
+	FamilyGroup  string `json:"family_group,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	Email        string `json:"email,omitempty"`
+	NoRecall     bool   `json:"no_recall,omitempty"`
+	NoNotify     bool   `json:"no_notify,omitempty"`
+	Version      int    `json:"version,omitempty"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
 }
 
 type Package struct {
-	ID       int     `json:"id"`
-	Name     string  `json:"name"`
-	Category string  `json:"category"`
-	Price    float64 `json:"price"`
+	ID           int            `json:"id"`
+	Name         string         `json:"name"`
+	Category     string         `json:"category"`
+	CategoryID   int            `json:"category_id,omitempty"`
+	Price        float64        `json:"price"`
+	PriceHistory []PriceVersion `json:"price_history,omitempty"`
+	Examinations []string       `json:"examinations,omitempty"`
+	RecallMonths int            `json:"recall_months,omitempty"`
+	Version      int            `json:"version,omitempty"`
+	BranchID     int            `json:"branch_id,omitempty"`
 }
 
 type Record struct {
-	ID      int     `json:"id"`
-	Patient Patient `json:"patient"`
-	Package Package `json:"package"`
-	Date    string  `json:"date"`
+	ID           int      `json:"id"`
+	Patient      Patient  `json:"patient"`
+	Package      Package  `json:"package"`
+	Date         string   `json:"date"`
+	PriceVersion int      `json:"price_version,omitempty"`
+	Price        float64  `json:"price,omitempty"`
+	CompanyID    int      `json:"company_id,omitempty"`
+	Results      []Result `json:"results,omitempty"`
+	PayerID      int      `json:"payer_id,omitempty"`
+	PayerAmount  float64  `json:"payer_amount,omitempty"`
+	CopayAmount  float64  `json:"copay_amount,omitempty"`
+	PaidAmount   float64  `json:"paid_amount,omitempty"`
+	ClaimStatus  string   `json:"claim_status,omitempty"`
+	ClaimBatch   string   `json:"claim_batch,omitempty"`
+	ClaimNote    string   `json:"claim_note,omitempty"`
+
+	DiscountPromoID int     `json:"discount_promo_id,omitempty"`
+	DiscountName    string  `json:"discount_name,omitempty"`
+	DiscountAmount  float64 `json:"discount_amount,omitempty"`
+
+	Conclusion  string `json:"conclusion,omitempty"`
+	ConcludedBy string `json:"concluded_by,omitempty"`
+	ConcludedAt string `json:"concluded_at,omitempty"`
+
+	Fitness         string    `json:"fitness,omitempty"`
+	Findings        []Finding `json:"findings,omitempty"`
+	Recommendations string    `json:"recommendations,omitempty"`
+	FollowUpMonths  int       `json:"follow_up_months,omitempty"`
+
+	DoctorID   int               `json:"doctor_id,omitempty"`
+	Examiners  []StationExaminer `json:"examiners,omitempty"`
+	SignedByID int               `json:"signed_by_id,omitempty"`
+
+	Version   int    `json:"version,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+	BranchID  int    `json:"branch_id,omitempty"`
 }
 
 type PatientArray struct {
@@ -68,27 +118,140 @@ type RecordArray struct {
 }
 
 type DataStore struct {
-	Patients PatientArray `json:"patients"`
-	Packages PackageArray `json:"packages"`
-	Records  RecordArray  `json:"records"`
+	Revision     int               `json:"revision"`
+	Patients     PatientArray      `json:"patients"`
+	Packages     PackageArray      `json:"packages"`
+	Records      RecordArray       `json:"records"`
+	Companies    CompanyArray      `json:"companies"`
+	Examinations ExaminationArray  `json:"examinations"`
+	Payers       PayerArray        `json:"payers"`
+	Promotions   PromotionArray    `json:"promotions"`
+	Categories   CategoryArray     `json:"categories"`
+	Users        UserArray         `json:"users"`
+	AuditHead    AuditHead         `json:"audit_head"`
+	ResearchKey  string            `json:"research_key,omitempty"`
+	Retention    RetentionPolicy   `json:"retention"`
+	Trash        TrashArray        `json:"trash"`
+	ResultSheet  ResultTemplate    `json:"result_sheet"`
+	LabCodes     map[string]string `json:"lab_codes,omitempty"`
+
+	Practitioners PractitionerArray `json:"practitioners"`
+	Recalls       []RecallNotice    `json:"recalls,omitempty"`
+	Notifiers     NotifierSettings  `json:"notifiers"`
+
+	Notifications   []Notification             `json:"notifications,omitempty"`
+	NotifyTemplates map[string]MessageTemplate `json:"notify_templates,omitempty"`
+
+	Events      EventLog     `json:"events"`
+	Webhooks    []Webhook    `json:"webhooks,omitempty"`
+	DeadLetters []DeadLetter `json:"dead_letters,omitempty"`
+
+	Branches   BranchArray        `json:"branches"`
+	BranchSync BranchSyncSettings `json:"branch_sync"`
+
+	Erasures []Erasure `json:"erasures,omitempty"`
 }
 
 // Global variables
 var (
-	patients PatientArray
-	packages PackageArray
-	records  RecordArray
-	scanner  = bufio.NewScanner(os.Stdin)
+	patients     PatientArray
+	packages     PackageArray
+	records      RecordArray
+	companies    CompanyArray
+	examinations ExaminationArray
+	payers       PayerArray
+	promotions   PromotionArray
+	categories   CategoryArray
+	users        UserArray
+	scanner      = bufio.NewScanner(os.Stdin)
 )
 
 // Utility functions
 func clearScreen() {
 	fmt.Print("\033[H\033[2J")
+	clearPHIOnScreen()
 }
 
 func pause() {
-	fmt.Print("\nPress Enter to continue...")
-	scanner.Scan()
+	readLine("\nPress Enter to continue...")
+}
+
+// inputLine is a line read from standard input
+type inputLine struct {
+	Text string
+	OK   bool
+}
+
+// Standard input is read by one goroutine, one line per request, so a
+// prompt can wait for input and for the idle timeout at the same time
+var (
+	inputOnce    sync.Once
+	lineRequests = make(chan bool)
+	lineResults  = make(chan inputLine)
+)
+
+func requestLine() {
+	inputOnce.Do(func() {
+		go func() {
+			for range lineRequests {
+				ok := scanner.Scan()
+				lineResults <- inputLine{Text: scanner.Text(), OK: ok}
+			}
+		}()
+	})
+	lineRequests <- true
+}
+
+// nextLine waits for the next line of input; ok is false at the end of input
+func nextLine() (string, bool) {
+	requestLine()
+	line := <-lineResults
+	return line.Text, line.OK
+}
+
+// readLine shows a prompt and waits for a line. When the screen is left
+// alone with patient details on it, they are hidden and the prompt comes
+// back once Enter is pressed.
+func readLine(prompt string) (string, bool) {
+	fmt.Print(prompt)
+	requestLine()
+
+	ticker := time.NewTicker(PRIVACY_IDLE_CHECK)
+	defer ticker.Stop()
+	hidden := false
+	for {
+		select {
+		case line := <-lineResults:
+			touchActivity()
+			if hidden && line.OK {
+				hidden = false
+				fmt.Print(prompt)
+				requestLine()
+				continue
+			}
+			return line.Text, line.OK
+		case now := <-ticker.C:
+			if !hidden && checkIdle(now) {
+				hidden = true
+				clearScreen()
+				printWarning("Screen hidden after inactivity. Press Enter to continue.")
+			}
+		}
+	}
+}
+
+// readSecret shows a prompt and reads a line without echoing it. Input that
+// is not a terminal is read like any other line.
+func readSecret(prompt string) (string, bool) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine(prompt)
+	}
+	fmt.Print(prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Println()
+	touchActivity()
+	return string(secret), err == nil
 }
 
 func printHeader(title string) {
@@ -111,9 +274,8 @@ func printWarning(message string) {
 // Input validation functions
 func getValidInput(prompt string) string {
 	for {
-		fmt.Print(prompt)
-		if scanner.Scan() {
-			input := strings.TrimSpace(scanner.Text())
+		if line, ok := readLine(prompt); ok {
+			input := strings.TrimSpace(line)
 			if input != "" {
 				return input
 			}
@@ -122,54 +284,87 @@ func getValidInput(prompt string) string {
 	}
 }
 
-func getValidInt(prompt string, min, max int) int {
+// getValidSecret asks for a password or passphrase without showing it
+func getValidSecret(prompt string) string {
 	for {
-		input := getValidInput(prompt)
-		if num, err := strconv.Atoi(input); err == nil {
-			if num >= min && num <= max {
-				return num
+		if secret, ok := readSecret(prompt); ok {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				return secret
 			}
-			printError(fmt.Sprintf("Please enter a number between %d and %d.", min, max))
-		} else {
-			printError("Please enter a valid number.")
 		}
+		printError("Input cannot be empty. Please try again.")
+	}
+}
+
+func getValidInt(prompt string, min, max int) int {
+	for {
+		num, err := parseValidInt(getValidInput(prompt), min, max)
+		if err == nil {
+			return num
+		}
+		printError(validationMessage(err))
 	}
 }
 
 func getValidFloat(prompt string, min float64) float64 {
 	for {
-		input := getValidInput(prompt)
-		if num, err := strconv.ParseFloat(input, 64); err == nil {
-			if num >= min {
-				return num
-			}
-			printError(fmt.Sprintf("Please enter a number greater than or equal to %.2f.", min))
-		} else {
-			printError("Please enter a valid number.")
+		num, err := parseValidFloat(getValidInput(prompt), min)
+		if err == nil {
+			return num
 		}
+		printError(validationMessage(err))
 	}
 }
 
 func getValidGender() string {
 	for {
-		gender := strings.ToUpper(getValidInput("Enter gender (M/F): "))
-		if gender == "M" || gender == "F" {
+		gender, err := parseValidGender(getValidInput("Enter gender (M/F): "))
+		if err == nil {
 			return gender
 		}
-		printError("Please enter 'M' for Male or 'F' for Female.")
+		printError(validationMessage(err))
 	}
 }
 
 func getValidCategory() string {
-	categories := []string{"Basic", "Standard", "Premium", "Executive"}
+	return selectCategory().Name
+}
 
-	fmt.Println("Available categories:")
-	for i, cat := range categories {
-		fmt.Printf("%d. %s\n", i+1, cat)
+// Value validation functions, shared by the prompts and the bulk import
+func parseValidInt(input string, min, max int) (int, error) {
+	num, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil {
+		return 0, errors.New("please enter a valid number")
+	}
+	if num < min || num > max {
+		return 0, fmt.Errorf("please enter a number between %d and %d", min, max)
+	}
+	return num, nil
+}
+
+func parseValidFloat(input string, min float64) (float64, error) {
+	num, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+	if err != nil {
+		return 0, errors.New("please enter a valid number")
+	}
+	if num < min {
+		return 0, fmt.Errorf("please enter a number greater than or equal to %.2f", min)
+	}
+	return num, nil
+}
+
+func parseValidGender(input string) (string, error) {
+	gender := strings.ToUpper(strings.TrimSpace(input))
+	if gender != "M" && gender != "F" {
+		return "", errors.New("please enter 'M' for Male or 'F' for Female")
 	}
+	return gender, nil
+}
 
-	choice := getValidInt("Select category (1-4): ", 1, 4)
-	return categories[choice-1]
+// validationMessage turns a validation error into a sentence for printError
+func validationMessage(err error) string {
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:] + "."
 }
 
 // Date validation functions
@@ -195,9 +390,8 @@ func isValidDate(day, month, year int) bool {
 
 func getValidDate(prompt string) string {
 	for {
-		fmt.Print(prompt + " (DD/MM/YYYY): ")
-		if scanner.Scan() {
-			dateStr := strings.TrimSpace(scanner.Text())
+		if line, ok := readLine(prompt + " (DD/MM/YYYY): "); ok {
+			dateStr := strings.TrimSpace(line)
 			parts := strings.Split(dateStr, "/")
 
 			if len(parts) == 3 {
@@ -216,49 +410,91 @@ func getValidDate(prompt string) string {
 	}
 }
 
-// Data persistence functions
-func saveData() error {
-	data := DataStore{
-		Patients: patients,
-		Packages: packages,
-		Records:  records,
+func todayDate() string {
+	return time.Now().Format("02/01/2006")
+}
+
+// dateKey turns a DD/MM/YYYY date into YYYYMMDD so dates can be compared as strings
+func dateKey(date string) string {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return date
+	}
+	return parts[2] + parts[1] + parts[0]
+}
+
+func compareDates(a, b string) int {
+	keyA, keyB := dateKey(a), dateKey(b)
+	if keyA < keyB {
+		return -1
+	} else if keyA > keyB {
+		return 1
 	}
+	return 0
+}
+
+func parseDate(date string) (time.Time, error) {
+	return time.Parse("02/01/2006", date)
+}
 
-	file, err := os.Create(DATA_FILE)
+func addDays(date string, days int) string {
+	t, err := parseDate(date)
 	if err != nil {
-		return fmt.Errorf("failed to create data file: %v", err)
+		return date
 	}
-	defer file.Close()
+	return t.AddDate(0, 0, days).Format("02/01/2006")
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("failed to encode data: %v", err)
+func daysBetween(from, to string) int {
+	start, err1 := parseDate(from)
+	end, err2 := parseDate(to)
+	if err1 != nil || err2 != nil {
+		return 0
 	}
+	return int(end.Sub(start).Hours() / 24)
+}
 
-	return nil
+// Data persistence functions
+
+// saveData writes the data file, merging in what other terminals saved
+// since it was last read. Conflicting changes are reported.
+func saveData() error {
+	auditLog(AUDIT_SAVE, "data", 0, nil, nil)
+
+	conflicts, err := commitData(nil)
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "Another terminal")
+	}
+	return err
 }
 
 func loadData() error {
-	file, err := os.Open(DATA_FILE)
+	if err := acquireLock(DATA_FILE); err != nil {
+		return err
+	}
+	defer releaseLock(DATA_FILE)
+
+	if dataKey != nil {
+		diskKey = dataKey.Key
+	}
+	_, statErr := os.Stat(DATA_FILE)
+	data, err := readDataStore(DATA_FILE, diskKey)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// File doesn't exist, start with empty data
-			return nil
-		}
-		return fmt.Errorf("failed to open data file: %v", err)
+		return err
 	}
-	defer file.Close()
+	applyDataStore(data)
+	syncBase = data
 
-	var data DataStore
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
-		return fmt.Errorf("failed to decode data: %v", err)
+	if os.IsNotExist(statErr) {
+		// File doesn't exist, start with empty data
+		seedDefaultCategories()
 	}
 
-	patients = data.Patients
-	packages = data.Packages
-	records = data.Records
+	// Older data files have categories as plain names only
+	migrateCategories()
+
+	// Older data files have no price history, and scheduled prices may have become current
+	refreshAllCurrentPrices()
 
 	return nil
 }
@@ -359,32 +595,47 @@ func insertionSortRecordsByDate() {
 
 // ID generation functions
 func getNextPatientID() int {
-	maxID := PATIENT_ID_START - 1
+	maxID := max(PATIENT_ID_START-1, branchSync.CentralIDs[ENTITY_PATIENT])
 	for i := 0; i < patients.N; i++ {
 		if patients.Daftar[i].ID > maxID {
 			maxID = patients.Daftar[i].ID
 		}
 	}
+	if id := maxTrashID(ENTITY_PATIENT); id > maxID {
+		maxID = id
+	}
+	if id := maxErasedID(erasures, ENTITY_PATIENT); id > maxID {
+		maxID = id
+	}
 	return maxID + 1
 }
 
 func getNextPackageID() int {
-	maxID := PACKAGE_ID_START - 1
+	maxID := max(PACKAGE_ID_START-1, branchSync.CentralIDs[ENTITY_PACKAGE])
 	for i := 0; i < packages.N; i++ {
 		if packages.Daftar[i].ID > maxID {
 			maxID = packages.Daftar[i].ID
 		}
 	}
+	if id := maxTrashID(ENTITY_PACKAGE); id > maxID {
+		maxID = id
+	}
 	return maxID + 1
 }
 
 func getNextRecordID() int {
-	maxID := RECORD_ID_START - 1
+	maxID := max(RECORD_ID_START-1, branchSync.CentralIDs[ENTITY_RECORD])
 	for i := 0; i < records.N; i++ {
 		if records.Daftar[i].ID > maxID {
 			maxID = records.Daftar[i].ID
 		}
 	}
+	if id := maxTrashID(ENTITY_RECORD); id > maxID {
+		maxID = id
+	}
+	if id := maxErasedID(erasures, ENTITY_RECORD); id > maxID {
+		maxID = id
+	}
 	return maxID + 1
 }
 
@@ -392,6 +643,10 @@ func getNextRecordID() int {
 func addPatient() {
 	printHeader("Add New Patient")
 
+	if !requirePermission(PERM_PATIENT_CREATE) {
+		return
+	}
+
 	if patients.N >= NMAX {
 		printError("Cannot add more patients. Maximum capacity reached.")
 		pause()
@@ -401,16 +656,23 @@ func addPatient() {
 	name := getValidInput("Enter patient name: ")
 	gender := getValidGender()
 	age := getValidInt("Enter age: ", 0, 150)
+	phone := getContact("Enter phone number (- for none): ", isValidPhone)
+	email := getContact("Enter e-mail address (- for none): ", isValidEmail)
 
 	patient := Patient{
 		ID:     getNextPatientID(),
 		Name:   name,
 		Gender: gender,
 		Age:    age,
+		Phone:  phone,
+		Email:  email,
 	}
 
 	patients.Daftar[patients.N] = patient
 	patients.N++
+	pushUndo(AUDIT_CREATE, ENTITY_PATIENT, patient.ID, nil, patient)
+	auditLog(AUDIT_CREATE, "patient", patient.ID, nil, patient)
+	publishChange(ENTITY_PATIENT, patient.ID, nil, patient)
 
 	printSuccess(fmt.Sprintf("Patient added successfully with ID: %d", patient.ID))
 	pause()
@@ -419,6 +681,10 @@ func addPatient() {
 func displayPatients() {
 	printHeader("Patient List")
 
+	if !requirePermission(PERM_PATIENT_VIEW) {
+		return
+	}
+
 	if patients.N == 0 {
 		printWarning("No patients found.")
 		pause()
@@ -450,20 +716,54 @@ func displayPatients() {
 		// Sort by ID (already in order typically)
 	}
 
-	fmt.Printf("\n%s%-10s %-30s %-8s %-5s%s\n", BOLD, "ID", "Name", "Gender", "Age", RESET)
-	fmt.Println(strings.Repeat("-", 60))
+	auditLog(AUDIT_VIEW, "patient", 0, nil, nil)
+
+	fmt.Printf("\n%s%-5s %-10s %-30s %-8s %-5s%s\n", BOLD, "No.", "ID", "Name", "Gender", "Age", RESET)
+	fmt.Println(strings.Repeat("-", 66))
 
 	for i := 0; i < patients.N; i++ {
 		p := patients.Daftar[i]
-		fmt.Printf("%-10d %-30s %-8s %-5d\n", p.ID, p.Name, p.Gender, p.Age)
+		fmt.Printf("%-5d %-10s %-30s %-8s %-5d\n", i+1, displayPatientID(p.ID), displayName(p.Name), p.Gender, p.Age)
 	}
 
-	pause()
+	revealRows(patients.N, func(row int) {
+		p := patients.Daftar[row]
+		auditLog(AUDIT_VIEW, "patient", p.ID, nil, nil)
+		printPatientDetails(p)
+	})
+}
+
+func printPatientDetails(p Patient) {
+	markPHIOnScreen()
+	fmt.Printf("ID: %d\n", p.ID)
+	fmt.Printf("Name: %s\n", p.Name)
+	fmt.Printf("Gender: %s\n", p.Gender)
+	fmt.Printf("Age: %d\n", p.Age)
+	printPatientContact(p)
+}
+
+func printPatientContact(p Patient) {
+	if p.Phone != "" {
+		fmt.Printf("Phone: %s\n", p.Phone)
+	}
+	if p.Email != "" {
+		fmt.Printf("E-mail: %s\n", p.Email)
+	}
+	if p.NoRecall {
+		fmt.Println("Recall reminders: off")
+	}
+	if p.NoNotify {
+		fmt.Println("Notifications: off")
+	}
 }
 
 func searchPatient() {
 	printHeader("Search Patient")
 
+	if !requirePermission(PERM_PATIENT_VIEW) {
+		return
+	}
+
 	fmt.Println("Search by: 1. Name  2. ID")
 	choice := getValidInt("Choose search method: ", 1, 2)
 
@@ -480,11 +780,9 @@ func searchPatient() {
 
 	if foundIdx != -1 {
 		p := patients.Daftar[foundIdx]
+		auditLog(AUDIT_VIEW, "patient", p.ID, nil, nil)
 		fmt.Printf("\n%sPatient Found:%s\n", GREEN, RESET)
-		fmt.Printf("ID: %d\n", p.ID)
-		fmt.Printf("Name: %s\n", p.Name)
-		fmt.Printf("Gender: %s\n", p.Gender)
-		fmt.Printf("Age: %d\n", p.Age)
+		printPatientDetails(p)
 	} else {
 		printError("Patient not found.")
 	}
@@ -495,6 +793,10 @@ func searchPatient() {
 func updatePatient() {
 	printHeader("Update Patient")
 
+	if !requirePermission(PERM_PATIENT_UPDATE) {
+		return
+	}
+
 	id := getValidInt("Enter patient ID to update: ", 1, 999999)
 	idx := binarySearchPatientByID(id)
 
@@ -505,19 +807,24 @@ func updatePatient() {
 	}
 
 	p := &patients.Daftar[idx]
+	before := *p
 	fmt.Printf("\nCurrent patient data:\n")
 	fmt.Printf("ID: %d\n", p.ID)
 	fmt.Printf("Name: %s\n", p.Name)
 	fmt.Printf("Gender: %s\n", p.Gender)
 	fmt.Printf("Age: %d\n", p.Age)
+	printPatientContact(*p)
 
 	fmt.Println("\nWhat would you like to update?")
 	fmt.Println("1. Name")
 	fmt.Println("2. Gender")
 	fmt.Println("3. Age")
 	fmt.Println("4. All fields")
+	fmt.Println("5. Contact details")
+	fmt.Println("6. Recall reminders on/off")
+	fmt.Println("7. Notifications on/off")
 
-	choice := getValidInt("Choose option: ", 1, 4)
+	choice := getValidInt("Choose option: ", 1, 7)
 
 	switch choice {
 	case 1:
@@ -530,7 +837,27 @@ func updatePatient() {
 		p.Name = getValidInput("Enter new name: ")
 		p.Gender = getValidGender()
 		p.Age = getValidInt("Enter new age: ", 0, 150)
+	case 5:
+		p.Phone = getContact("Enter phone number (- for none): ", isValidPhone)
+		p.Email = getContact("Enter e-mail address (- for none): ", isValidEmail)
+	case 6:
+		p.NoRecall = !p.NoRecall
+		if p.NoRecall {
+			printWarning("The patient will no longer get recall reminders.")
+		} else {
+			printWarning("The patient will get recall reminders again.")
+		}
+	case 7:
+		p.NoNotify = !p.NoNotify
+		if p.NoNotify {
+			printWarning("The patient will no longer be told about bookings and results.")
+		} else {
+			printWarning("The patient will be told about bookings and results again.")
+		}
 	}
+	pushUndo(AUDIT_UPDATE, ENTITY_PATIENT, p.ID, before, *p)
+	auditLog(AUDIT_UPDATE, "patient", p.ID, before, *p)
+	publishChange(ENTITY_PATIENT, p.ID, before, *p)
 
 	printSuccess("Patient updated successfully.")
 	pause()
@@ -539,6 +866,10 @@ func updatePatient() {
 func deletePatient() {
 	printHeader("Delete Patient")
 
+	if !requirePermission(PERM_PATIENT_DELETE) {
+		return
+	}
+
 	id := getValidInt("Enter patient ID to delete: ", 1, 999999)
 	idx := binarySearchPatientByID(id)
 
@@ -562,13 +893,13 @@ func deletePatient() {
 		return
 	}
 
-	// Shift elements to remove the patient
-	for i := idx; i < patients.N-1; i++ {
-		patients.Daftar[i] = patients.Daftar[i+1]
-	}
-	patients.N--
+	// Keep the patient in the trash so it can be restored
+	moveToTrash(ENTITY_PATIENT, idx)
+	pushUndo(AUDIT_DELETE, ENTITY_PATIENT, p.ID, p, nil)
+	auditLog(AUDIT_DELETE, "patient", p.ID, p, nil)
+	publishChange(ENTITY_PATIENT, p.ID, p, nil)
 
-	printSuccess("Patient deleted successfully.")
+	printSuccess(fmt.Sprintf("Patient moved to the trash. It can be restored for %d days.", trashPurgeDays()))
 	pause()
 }
 
@@ -576,6 +907,10 @@ func deletePatient() {
 func addPackage() {
 	printHeader("Add New Package")
 
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
 	if packages.N >= NMAX {
 		printError("Cannot add more packages. Maximum capacity reached.")
 		pause()
@@ -583,18 +918,29 @@ func addPackage() {
 	}
 
 	name := getValidInput("Enter package name: ")
-	category := getValidCategory()
+	category := selectCategory()
 	price := getValidFloat("Enter price: ", 0.0)
 
 	pkg := Package{
-		ID:       getNextPackageID(),
-		Name:     name,
-		Category: category,
-		Price:    price,
+		ID:         getNextPackageID(),
+		Name:       name,
+		Category:   category.Name,
+		CategoryID: category.ID,
+		Price:      price,
+		BranchID:   activeBranch,
+	}
+	if branches.N > 0 && activeBranch == 0 {
+		// A package with its own price at one branch, or the same everywhere
+		fmt.Println("\nOffered at:")
+		pkg.BranchID = selectBranch("Choose branch (0 for all branches): ", "All branches")
 	}
+	ensurePriceHistory(&pkg)
 
 	packages.Daftar[packages.N] = pkg
 	packages.N++
+	pushUndo(AUDIT_CREATE, ENTITY_PACKAGE, pkg.ID, nil, pkg)
+	auditLog(AUDIT_CREATE, "package", pkg.ID, nil, pkg)
+	publishChange(ENTITY_PACKAGE, pkg.ID, nil, pkg)
 
 	printSuccess(fmt.Sprintf("Package added successfully with ID: %d", pkg.ID))
 	pause()
@@ -603,6 +949,10 @@ func addPackage() {
 func displayPackages() {
 	printHeader("Package List")
 
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
 	if packages.N == 0 {
 		printWarning("No packages found.")
 		pause()
@@ -629,11 +979,11 @@ func displayPackages() {
 	case 2:
 		selectionSortPackagesByPrice()
 	case 3:
-		// Sort by category using insertion sort
+		// Sort by category sort order using insertion sort
 		for i := 1; i < packages.N; i++ {
 			key := packages.Daftar[i]
 			j := i - 1
-			for j >= 0 && strings.ToLower(packages.Daftar[j].Category) > strings.ToLower(key.Category) {
+			for j >= 0 && categorySortOrder(packages.Daftar[j].CategoryID) > categorySortOrder(key.CategoryID) {
 				packages.Daftar[j+1] = packages.Daftar[j]
 				j--
 			}
@@ -641,12 +991,17 @@ func displayPackages() {
 		}
 	}
 
-	fmt.Printf("\n%s%-10s %-30s %-15s %-12s%s\n", BOLD, "ID", "Name", "Category", "Price", RESET)
-	fmt.Println(strings.Repeat("-", 70))
+	auditLog(AUDIT_VIEW, "package", 0, nil, nil)
+
+	fmt.Printf("\n%s%-10s %-30s %-15s %-12s %-15s%s\n", BOLD, "ID", "Name", "Category", "Price", "Branch", RESET)
+	fmt.Println(strings.Repeat("-", 86))
 
 	for i := 0; i < packages.N; i++ {
 		p := packages.Daftar[i]
-		fmt.Printf("%-10d %-30s %-15s $%-11.2f\n", p.ID, p.Name, p.Category, p.Price)
+		if !branchVisible(p.BranchID) {
+			continue
+		}
+		fmt.Printf("%-10d %-30s %-15s $%-11.2f %-15s\n", p.ID, p.Name, p.Category, p.Price, branchName(p.BranchID))
 	}
 
 	pause()
@@ -655,6 +1010,10 @@ func displayPackages() {
 func searchPackage() {
 	printHeader("Search Package")
 
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
 	fmt.Println("Search by: 1. Name  2. ID  3. Category")
 	choice := getValidInt("Choose search method: ", 1, 3)
 
@@ -677,13 +1036,21 @@ func searchPackage() {
 		}
 	}
 
+	if foundIdx != -1 && !branchVisible(packages.Daftar[foundIdx].BranchID) {
+		foundIdx = -1
+	}
+
 	if foundIdx != -1 {
 		p := packages.Daftar[foundIdx]
+		auditLog(AUDIT_VIEW, "package", p.ID, nil, nil)
 		fmt.Printf("\n%sPackage Found:%s\n", GREEN, RESET)
 		fmt.Printf("ID: %d\n", p.ID)
 		fmt.Printf("Name: %s\n", p.Name)
 		fmt.Printf("Category: %s\n", p.Category)
 		fmt.Printf("Price: $%.2f\n", p.Price)
+		if branches.N > 0 {
+			fmt.Printf("Offered at: %s\n", branchName(p.BranchID))
+		}
 	} else {
 		printError("Package not found.")
 	}
@@ -694,42 +1061,57 @@ func searchPackage() {
 func updatePackage() {
 	printHeader("Update Package")
 
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
 	id := getValidInt("Enter package ID to update: ", 1, 999999)
 	idx := binarySearchPackageByID(id)
 
-	if idx == -1 {
+	if idx == -1 || !branchVisible(packages.Daftar[idx].BranchID) {
 		printError("Package not found.")
 		pause()
 		return
 	}
 
 	p := &packages.Daftar[idx]
+	before := *p
+	before.PriceHistory = append([]PriceVersion(nil), p.PriceHistory...)
 	fmt.Printf("\nCurrent package data:\n")
 	fmt.Printf("ID: %d\n", p.ID)
 	fmt.Printf("Name: %s\n", p.Name)
 	fmt.Printf("Category: %s\n", p.Category)
 	fmt.Printf("Price: $%.2f\n", p.Price)
+	fmt.Printf("Recall interval: %d months\n", recallMonths(*p))
 
 	fmt.Println("\nWhat would you like to update?")
 	fmt.Println("1. Name")
 	fmt.Println("2. Category")
 	fmt.Println("3. Price")
 	fmt.Println("4. All fields")
+	fmt.Println("5. Recall interval")
 
-	choice := getValidInt("Choose option: ", 1, 4)
+	choice := getValidInt("Choose option: ", 1, 5)
 
 	switch choice {
 	case 1:
 		p.Name = getValidInput("Enter new name: ")
 	case 2:
-		p.Category = getValidCategory()
+		category := selectCategory()
+		p.Category, p.CategoryID = category.Name, category.ID
 	case 3:
-		p.Price = getValidFloat("Enter new price: ", 0.0)
+		schedulePriceChange(p)
 	case 4:
 		p.Name = getValidInput("Enter new name: ")
-		p.Category = getValidCategory()
-		p.Price = getValidFloat("Enter new price: ", 0.0)
+		category := selectCategory()
+		p.Category, p.CategoryID = category.Name, category.ID
+		schedulePriceChange(p)
+	case 5:
+		p.RecallMonths = getValidInt("Repeat the check-up after how many months? ", 1, 120)
 	}
+	pushUndo(AUDIT_UPDATE, ENTITY_PACKAGE, p.ID, before, *p)
+	auditLog(AUDIT_UPDATE, "package", p.ID, before, *p)
+	publishChange(ENTITY_PACKAGE, p.ID, before, *p)
 
 	printSuccess("Package updated successfully.")
 	pause()
@@ -738,10 +1120,14 @@ func updatePackage() {
 func deletePackage() {
 	printHeader("Delete Package")
 
+	if !requirePermission(PERM_PACKAGE_MANAGE) {
+		return
+	}
+
 	id := getValidInt("Enter package ID to delete: ", 1, 999999)
 	idx := binarySearchPackageByID(id)
 
-	if idx == -1 {
+	if idx == -1 || !branchVisible(packages.Daftar[idx].BranchID) {
 		printError("Package not found.")
 		pause()
 		return
@@ -761,13 +1147,13 @@ func deletePackage() {
 		return
 	}
 
-	// Shift elements to remove the package
-	for i := idx; i < packages.N-1; i++ {
-		packages.Daftar[i] = packages.Daftar[i+1]
-	}
-	packages.N--
+	// Keep the package in the trash so it can be restored
+	moveToTrash(ENTITY_PACKAGE, idx)
+	pushUndo(AUDIT_DELETE, ENTITY_PACKAGE, p.ID, p, nil)
+	auditLog(AUDIT_DELETE, "package", p.ID, p, nil)
+	publishChange(ENTITY_PACKAGE, p.ID, p, nil)
 
-	printSuccess("Package deleted successfully.")
+	printSuccess(fmt.Sprintf("Package moved to the trash. It can be restored for %d days.", trashPurgeDays()))
 	pause()
 }
 
@@ -775,6 +1161,10 @@ func deletePackage() {
 func addRecord() {
 	printHeader("Add New Medical Record")
 
+	if !requirePermission(PERM_RECORD_CREATE) {
+		return
+	}
+
 	if records.N >= NMAX {
 		printError("Cannot add more records. Maximum capacity reached.")
 		pause()
@@ -797,42 +1187,77 @@ func addRecord() {
 	fmt.Println("Available patients:")
 	for i := 0; i < patients.N; i++ {
 		p := patients.Daftar[i]
-		fmt.Printf("%d. %s (ID: %d)\n", i+1, p.Name, p.ID)
+		fmt.Printf("%d. %s (ID: %s)\n", i+1, displayName(p.Name), displayPatientID(p.ID))
 	}
 
 	patientChoice := getValidInt("Select patient: ", 1, patients.N)
 	selectedPatient := patients.Daftar[patientChoice-1]
 
-	// Select package
+	// Select package from those offered at the branch
+	branch := recordBranch()
+	offered := branchPackages(branch)
+	if len(offered) == 0 {
+		printError(fmt.Sprintf("No packages are offered at %s.", branchName(branch)))
+		pause()
+		return
+	}
 	fmt.Println("\nAvailable packages:")
-	for i := 0; i < packages.N; i++ {
+	for n, i := range offered {
 		p := packages.Daftar[i]
-		fmt.Printf("%d. %s - %s ($%.2f)\n", i+1, p.Name, p.Category, p.Price)
+		fmt.Printf("%d. %s - %s ($%.2f)\n", n+1, p.Name, p.Category, p.Price)
 	}
 
-	packageChoice := getValidInt("Select package: ", 1, packages.N)
-	selectedPackage := packages.Daftar[packageChoice-1]
+	packageChoice := getValidInt("Select package: ", 1, len(offered))
+	selectedPackage := packages.Daftar[offered[packageChoice-1]]
 
 	// Get date
 	date := getValidDate("Enter checkup date")
 
 	record := Record{
-		ID:      getNextRecordID(),
-		Patient: selectedPatient,
-		Package: selectedPackage,
-		Date:    date,
+		ID:       getNextRecordID(),
+		Patient:  selectedPatient,
+		Package:  selectedPackage,
+		Date:     date,
+		BranchID: branch,
 	}
 
+	// Stamp the price in effect on the check-up date, so later price changes don't affect it
+	stampRecordPrice(&record)
+	record.Package.Price = record.Price
+	record.Package.PriceHistory = nil
+
+	// Apply the best eligible promotion before splitting with the payer
+	applyBestDiscount(&record)
+
+	// Split the price between the patient's payer and the patient
+	applyCoverage(&record)
+
 	records.Daftar[records.N] = record
 	records.N++
+	pushUndo(AUDIT_CREATE, ENTITY_RECORD, record.ID, nil, record)
+	auditLog(AUDIT_CREATE, "record", record.ID, nil, record)
+	publishChange(ENTITY_RECORD, record.ID, nil, record)
+	queueNotification(EVENT_RECORD_CREATED, record)
 
-	printSuccess(fmt.Sprintf("Medical record added successfully with ID: %d", record.ID))
+	printSuccess(fmt.Sprintf("Medical record added successfully with ID: %d (price $%.2f, version %d)",
+		record.ID, record.Price, record.PriceVersion))
+	if record.DiscountAmount > 0 {
+		fmt.Printf("Promotion %s applied: -$%.2f, total $%.2f\n", record.DiscountName, record.DiscountAmount, recordPrice(record))
+	}
+	if record.PayerID != 0 {
+		fmt.Printf("Payer covers $%.2f, patient copay $%.2f\n", record.PayerAmount, record.CopayAmount)
+	}
+	deliverNotificationsSoon()
 	pause()
 }
 
 func displayRecords() {
 	printHeader("Medical Records")
 
+	if !requirePermission(PERM_RECORD_VIEW) {
+		return
+	}
+
 	if records.N == 0 {
 		printWarning("No medical records found.")
 		pause()
@@ -871,40 +1296,92 @@ func displayRecords() {
 		}
 	}
 
-	fmt.Printf("\n%s%-8s %-20s %-20s %-15s %-12s%s\n", BOLD, "ID", "Patient", "Package", "Category", "Date", RESET)
-	fmt.Println(strings.Repeat("-", 80))
+	auditLog(AUDIT_VIEW, "record", 0, nil, nil)
 
+	fmt.Printf("\n%s%-5s %-8s %-20s %-20s %-15s %-12s%s\n", BOLD, "No.", "ID", "Patient", "Package", "Category", "Date", RESET)
+	fmt.Println(strings.Repeat("-", 86))
+
+	// Only the records of the branches this session works at
+	var shown []Record
 	for i := 0; i < records.N; i++ {
-		r := records.Daftar[i]
-		fmt.Printf("%-8d %-20s %-20s %-15s %-12s\n",
-			r.ID, r.Patient.Name, r.Package.Name, r.Package.Category, r.Date)
+		if branchVisible(records.Daftar[i].BranchID) {
+			shown = append(shown, records.Daftar[i])
+			printRecordRow(len(shown), records.Daftar[i])
+		}
 	}
 
-	pause()
+	revealRows(len(shown), func(row int) {
+		r := shown[row]
+		auditLog(AUDIT_VIEW, "record", r.ID, nil, nil)
+		printRecordDetails(r)
+	})
+}
+
+func printRecordRow(no int, r Record) {
+	fmt.Printf("%-5d %-8d %-20s %-20s %-15s %-12s\n",
+		no, r.ID, displayName(r.Patient.Name), r.Package.Name, recordCategory(r), r.Date)
+}
+
+func printRecordDetails(r Record) {
+	markPHIOnScreen()
+	fmt.Printf("Record ID: %d\n", r.ID)
+	fmt.Printf("Patient: %s (ID: %d)\n", r.Patient.Name, r.Patient.ID)
+	fmt.Printf("Package: %s (%s)\n", r.Package.Name, recordCategory(r))
+	fmt.Printf("Price: $%.2f\n", recordPrice(r))
+	if r.DiscountAmount > 0 {
+		fmt.Printf("Discount: %s (-$%.2f)\n", r.DiscountName, r.DiscountAmount)
+	}
+	fmt.Printf("Date: %s\n", r.Date)
+	if r.BranchID != 0 {
+		fmt.Printf("Branch: %s\n", branchName(r.BranchID))
+	}
+	if r.CompanyID != 0 {
+		fmt.Printf("Company ID: %d\n", r.CompanyID)
+	}
+	if r.PayerID != 0 {
+		fmt.Printf("Payer ID: %d (covers $%.2f, copay $%.2f, claim %s)\n",
+			r.PayerID, r.PayerAmount, r.CopayAmount, r.ClaimStatus)
+	}
+	fmt.Println()
+	printRecordResults(r)
+	fmt.Println()
+	printRecordExaminers(r)
+	if isSignedOff(r) {
+		fmt.Printf("\n%sDoctor's conclusion%s (%s, %s):\n", BOLD, RESET, signerName(r), r.ConcludedAt)
+		printConclusion(r)
+	} else if hasConclusion(r) {
+		fmt.Printf("\n%sDoctor's conclusion (draft, not signed)%s:\n", BOLD, RESET)
+		printConclusion(r)
+	}
 }
 
 func searchRecords() {
 	printHeader("Search Medical Records")
 
+	if !requirePermission(PERM_RECORD_VIEW) {
+		return
+	}
+
 	fmt.Println("Search by: 1. Patient Name  2. Package Name  3. Date  4. Record ID")
 	choice := getValidInt("Choose search method: ", 1, 4)
 
 	var found bool = false
+	var matched []Record
 
 	switch choice {
 	case 1:
 		name := getValidInput("Enter patient name to search: ")
 		searchName := strings.ToLower(name)
 		fmt.Printf("\n%sRecords found for patient containing '%s':%s\n", GREEN, name, RESET)
-		fmt.Printf("%-8s %-20s %-20s %-15s %-12s\n", "ID", "Patient", "Package", "Category", "Date")
-		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("%-5s %-8s %-20s %-20s %-15s %-12s\n", "No.", "ID", "Patient", "Package", "Category", "Date")
+		fmt.Println(strings.Repeat("-", 86))
 
 		for i := 0; i < records.N; i++ {
-			if strings.Contains(strings.ToLower(records.Daftar[i].Patient.Name), searchName) {
+			if strings.Contains(strings.ToLower(records.Daftar[i].Patient.Name), searchName) && branchVisible(records.Daftar[i].BranchID) {
 				r := records.Daftar[i]
-				fmt.Printf("%-8d %-20s %-20s %-15s %-12s\n",
-					r.ID, r.Patient.Name, r.Package.Name, r.Package.Category, r.Date)
-				found = true
+				auditLog(AUDIT_VIEW, "record", r.ID, nil, nil)
+				matched = append(matched, r)
+				printRecordRow(len(matched), r)
 			}
 		}
 
@@ -912,50 +1389,52 @@ func searchRecords() {
 		name := getValidInput("Enter package name to search: ")
 		searchName := strings.ToLower(name)
 		fmt.Printf("\n%sRecords found for package containing '%s':%s\n", GREEN, name, RESET)
-		fmt.Printf("%-8s %-20s %-20s %-15s %-12s\n", "ID", "Patient", "Package", "Category", "Date")
-		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("%-5s %-8s %-20s %-20s %-15s %-12s\n", "No.", "ID", "Patient", "Package", "Category", "Date")
+		fmt.Println(strings.Repeat("-", 86))
 
 		for i := 0; i < records.N; i++ {
-			if strings.Contains(strings.ToLower(records.Daftar[i].Package.Name), searchName) {
+			if strings.Contains(strings.ToLower(records.Daftar[i].Package.Name), searchName) && branchVisible(records.Daftar[i].BranchID) {
 				r := records.Daftar[i]
-				fmt.Printf("%-8d %-20s %-20s %-15s %-12s\n",
-					r.ID, r.Patient.Name, r.Package.Name, r.Package.Category, r.Date)
-				found = true
+				auditLog(AUDIT_VIEW, "record", r.ID, nil, nil)
+				matched = append(matched, r)
+				printRecordRow(len(matched), r)
 			}
 		}
 
 	case 3:
 		date := getValidDate("Enter date to search")
 		fmt.Printf("\n%sRecords found for date %s:%s\n", GREEN, date, RESET)
-		fmt.Printf("%-8s %-20s %-20s %-15s %-12s\n", "ID", "Patient", "Package", "Category", "Date")
-		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("%-5s %-8s %-20s %-20s %-15s %-12s\n", "No.", "ID", "Patient", "Package", "Category", "Date")
+		fmt.Println(strings.Repeat("-", 86))
 
 		for i := 0; i < records.N; i++ {
-			if records.Daftar[i].Date == date {
+			if records.Daftar[i].Date == date && branchVisible(records.Daftar[i].BranchID) {
 				r := records.Daftar[i]
-				fmt.Printf("%-8d %-20s %-20s %-15s %-12s\n",
-					r.ID, r.Patient.Name, r.Package.Name, r.Package.Category, r.Date)
-				found = true
+				auditLog(AUDIT_VIEW, "record", r.ID, nil, nil)
+				matched = append(matched, r)
+				printRecordRow(len(matched), r)
 			}
 		}
 
 	case 4:
 		id := getValidInt("Enter record ID to search: ", 1, 999999)
-		for i := 0; i < records.N; i++ {
-			if records.Daftar[i].ID == id {
-				r := records.Daftar[i]
-				fmt.Printf("\n%sRecord Found:%s\n", GREEN, RESET)
-				fmt.Printf("Record ID: %d\n", r.ID)
-				fmt.Printf("Patient: %s (ID: %d)\n", r.Patient.Name, r.Patient.ID)
-				fmt.Printf("Package: %s (%s)\n", r.Package.Name, r.Package.Category)
-				fmt.Printf("Price: $%.2f\n", r.Package.Price)
-				fmt.Printf("Date: %s\n", r.Date)
-				found = true
-				break
-			}
+		if idx := searchBranchRecordByID(id); idx != -1 {
+			r := records.Daftar[idx]
+			auditLog(AUDIT_VIEW, "record", r.ID, nil, nil)
+			fmt.Printf("\n%sRecord Found:%s\n", GREEN, RESET)
+			printRecordDetails(r)
+			found = true
 		}
 	}
 
+	if len(matched) > 0 {
+		// Names in the list are masked; full details only on explicit selection
+		revealRows(len(matched), func(row int) {
+			printRecordDetails(matched[row])
+		})
+		return
+	}
+
 	if !found {
 		printError("No records found.")
 	}
@@ -966,16 +1445,13 @@ func searchRecords() {
 func deleteRecord() {
 	printHeader("Delete Medical Record")
 
-	id := getValidInt("Enter record ID to delete: ", 1, 999999)
-	var idx int = -1
-
-	for i := 0; i < records.N; i++ {
-		if records.Daftar[i].ID == id {
-			idx = i
-			break
-		}
+	if !requirePermission(PERM_RECORD_DELETE) {
+		return
 	}
 
+	id := getValidInt("Enter record ID to delete: ", 1, 999999)
+	idx := searchBranchRecordByID(id)
+
 	if idx == -1 {
 		printError("Record not found.")
 		pause()
@@ -983,6 +1459,14 @@ func deleteRecord() {
 	}
 
 	r := records.Daftar[idx]
+
+	// A signed-off record is locked until a doctor reopens it with a reason
+	if isSignedOff(r) {
+		printError(fmt.Sprintf("Record %d was signed off by %s and cannot be deleted. Reopen it first.", r.ID, signerName(r)))
+		pause()
+		return
+	}
+
 	fmt.Printf("\nRecord to be deleted:\n")
 	fmt.Printf("Record ID: %d\n", r.ID)
 	fmt.Printf("Patient: %s\n", r.Patient.Name)
@@ -996,13 +1480,13 @@ func deleteRecord() {
 		return
 	}
 
-	// Shift elements to remove the record
-	for i := idx; i < records.N-1; i++ {
-		records.Daftar[i] = records.Daftar[i+1]
-	}
-	records.N--
+	// Keep the record in the trash so it can be restored
+	moveToTrash(ENTITY_RECORD, idx)
+	pushUndo(AUDIT_DELETE, ENTITY_RECORD, r.ID, r, nil)
+	auditLog(AUDIT_DELETE, "record", r.ID, r, nil)
+	publishChange(ENTITY_RECORD, r.ID, r, nil)
 
-	printSuccess("Record deleted successfully.")
+	printSuccess(fmt.Sprintf("Record moved to the trash. It can be restored for %d days.", trashPurgeDays()))
 	pause()
 }
 
@@ -1010,138 +1494,61 @@ func deleteRecord() {
 func generatePatientReport() {
 	printHeader("Patient Statistics Report")
 
+	if !requirePermission(PERM_REPORT_PATIENT) {
+		return
+	}
+
 	if patients.N == 0 {
 		printWarning("No patients available for report.")
 		pause()
 		return
 	}
 
-	var maleCount, femaleCount int
-	var totalAge, minAge, maxAge int
-	minAge = 999
-	maxAge = 0
-
-	for i := 0; i < patients.N; i++ {
-		p := patients.Daftar[i]
-		if p.Gender == "M" {
-			maleCount++
-		} else {
-			femaleCount++
-		}
-
-		totalAge += p.Age
-		if p.Age < minAge {
-			minAge = p.Age
-		}
-		if p.Age > maxAge {
-			maxAge = p.Age
-		}
-	}
-
-	averageAge := float64(totalAge) / float64(patients.N)
-
-	fmt.Printf("%sPatient Statistics:%s\n\n", BOLD, RESET)
-	fmt.Printf("Total Patients: %d\n", patients.N)
-	fmt.Printf("Male Patients: %d (%.1f%%)\n", maleCount, float64(maleCount)*100/float64(patients.N))
-	fmt.Printf("Female Patients: %d (%.1f%%)\n", femaleCount, float64(femaleCount)*100/float64(patients.N))
-	fmt.Printf("Average Age: %.1f years\n", averageAge)
-	fmt.Printf("Youngest Patient: %d years\n", minAge)
-	fmt.Printf("Oldest Patient: %d years\n", maxAge)
+	auditLog(AUDIT_VIEW, "report_patient", 0, nil, nil)
 
-	pause()
+	report := patientStatsReport(getReportBranch())
+	printReport(report)
+	offerExport(report)
 }
 
 func generatePackageReport() {
 	printHeader("Package Statistics Report")
 
+	if !requirePermission(PERM_REPORT_PACKAGE) {
+		return
+	}
+
 	if packages.N == 0 {
 		printWarning("No packages available for report.")
 		pause()
 		return
 	}
 
-	categoryCount := make(map[string]int)
-	var totalPrice, minPrice, maxPrice float64
-	minPrice = 999999
-	maxPrice = 0
+	auditLog(AUDIT_VIEW, "report_package", 0, nil, nil)
 
-	for i := 0; i < packages.N; i++ {
-		p := packages.Daftar[i]
-		categoryCount[p.Category]++
-
-		totalPrice += p.Price
-		if p.Price < minPrice {
-			minPrice = p.Price
-		}
-		if p.Price > maxPrice {
-			maxPrice = p.Price
-		}
-	}
-
-	averagePrice := totalPrice / float64(packages.N)
-
-	fmt.Printf("%sPackage Statistics:%s\n\n", BOLD, RESET)
-	fmt.Printf("Total Packages: %d\n", packages.N)
-	fmt.Printf("Average Price: $%.2f\n", averagePrice)
-	fmt.Printf("Cheapest Package: $%.2f\n", minPrice)
-	fmt.Printf("Most Expensive Package: $%.2f\n", maxPrice)
-
-	fmt.Printf("\n%sPackages by Category:%s\n", BOLD, RESET)
-	for category, count := range categoryCount {
-		percentage := float64(count) * 100 / float64(packages.N)
-		fmt.Printf("%s: %d (%.1f%%)\n", category, count, percentage)
-	}
-
-	pause()
+	report := packageStatsReport(getReportBranch())
+	printReport(report)
+	offerExport(report)
 }
 
 func generateRevenueReport() {
 	printHeader("Revenue Report")
 
+	if !requirePermission(PERM_REPORT_REVENUE) {
+		return
+	}
+
 	if records.N == 0 {
 		printWarning("No records available for revenue calculation.")
 		pause()
 		return
 	}
 
-	var totalRevenue float64
-	monthlyRevenue := make(map[string]float64)
-	categoryRevenue := make(map[string]float64)
-
-	for i := 0; i < records.N; i++ {
-		r := records.Daftar[i]
-		price := r.Package.Price
-		totalRevenue += price
-
-		// Extract month/year from date (DD/MM/YYYY)
-		dateParts := strings.Split(r.Date, "/")
-		if len(dateParts) == 3 {
-			monthYear := dateParts[1] + "/" + dateParts[2]
-			monthlyRevenue[monthYear] += price
-		}
-
-		categoryRevenue[r.Package.Category] += price
-	}
-
-	averagePerRecord := totalRevenue / float64(records.N)
+	auditLog(AUDIT_VIEW, "report_revenue", 0, nil, nil)
 
-	fmt.Printf("%sRevenue Statistics:%s\n\n", BOLD, RESET)
-	fmt.Printf("Total Revenue: $%.2f\n", totalRevenue)
-	fmt.Printf("Total Records: %d\n", records.N)
-	fmt.Printf("Average Revenue per Record: $%.2f\n", averagePerRecord)
-
-	fmt.Printf("\n%sRevenue by Month:%s\n", BOLD, RESET)
-	for month, revenue := range monthlyRevenue {
-		fmt.Printf("%s: $%.2f\n", month, revenue)
-	}
-
-	fmt.Printf("\n%sRevenue by Category:%s\n", BOLD, RESET)
-	for category, revenue := range categoryRevenue {
-		percentage := revenue * 100 / totalRevenue
-		fmt.Printf("%s: $%.2f (%.1f%%)\n", category, revenue, percentage)
-	}
-
-	pause()
+	report := revenueReport(getPeriod(), getReportBranch())
+	printReport(report)
+	offerExport(report)
 }
 
 // Menu functions
@@ -1153,9 +1560,10 @@ func patientManagement() {
 		fmt.Printf("%s3.%s Search Patient\n", YELLOW, RESET)
 		fmt.Printf("%s4.%s Update Patient\n", YELLOW, RESET)
 		fmt.Printf("%s5.%s Delete Patient\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Bulk Import Patients\n", YELLOW, RESET)
 		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
 
-		choice := getValidInt("\nSelect option: ", 0, 5)
+		choice := getValidInt("\nSelect option: ", 0, 6)
 
 		switch choice {
 		case 1:
@@ -1168,6 +1576,8 @@ func patientManagement() {
 			updatePatient()
 		case 5:
 			deletePatient()
+		case 6:
+			importPatients()
 		case 0:
 			return
 		}
@@ -1182,9 +1592,15 @@ func packageManagement() {
 		fmt.Printf("%s3.%s Search Package\n", YELLOW, RESET)
 		fmt.Printf("%s4.%s Update Package\n", YELLOW, RESET)
 		fmt.Printf("%s5.%s Delete Package\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Price History\n", YELLOW, RESET)
+		fmt.Printf("%s7.%s Price Lookup by Date\n", YELLOW, RESET)
+		fmt.Printf("%s8.%s Examination Catalog\n", YELLOW, RESET)
+		fmt.Printf("%s9.%s Discounts & Promotions\n", YELLOW, RESET)
+		fmt.Printf("%s10.%s Package Categories\n", YELLOW, RESET)
+		fmt.Printf("%s11.%s Bulk Import Packages\n", YELLOW, RESET)
 		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
 
-		choice := getValidInt("\nSelect option: ", 0, 5)
+		choice := getValidInt("\nSelect option: ", 0, 11)
 
 		switch choice {
 		case 1:
@@ -1197,6 +1613,18 @@ func packageManagement() {
 			updatePackage()
 		case 5:
 			deletePackage()
+		case 6:
+			displayPriceHistory()
+		case 7:
+			lookupPriceAtDate()
+		case 8:
+			examinationManagement()
+		case 9:
+			promotionManagement()
+		case 10:
+			categoryManagement()
+		case 11:
+			importPackages()
 		case 0:
 			return
 		}
@@ -1210,9 +1638,15 @@ func recordManagement() {
 		fmt.Printf("%s2.%s Display Medical Records\n", YELLOW, RESET)
 		fmt.Printf("%s3.%s Search Medical Records\n", YELLOW, RESET)
 		fmt.Printf("%s4.%s Delete Medical Record\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Enter Examination Results\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Enter Doctor's Conclusion\n", YELLOW, RESET)
+		fmt.Printf("%s7.%s Print Result Sheet (PDF)\n", YELLOW, RESET)
+		fmt.Printf("%s8.%s Result Sheet Template\n", YELLOW, RESET)
+		fmt.Printf("%s9.%s Assign Examiners\n", YELLOW, RESET)
+		fmt.Printf("%s10.%s Reopen Signed Record\n", YELLOW, RESET)
 		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
 
-		choice := getValidInt("\nSelect option: ", 0, 4)
+		choice := getValidInt("\nSelect option: ", 0, 10)
 
 		switch choice {
 		case 1:
@@ -1223,6 +1657,18 @@ func recordManagement() {
 			searchRecords()
 		case 4:
 			deleteRecord()
+		case 5:
+			enterRecordResults()
+		case 6:
+			enterConclusion()
+		case 7:
+			printResultSheet()
+		case 8:
+			editResultTemplate()
+		case 9:
+			assignRecordExaminers()
+		case 10:
+			reopenSignedRecord()
 		case 0:
 			return
 		}
@@ -1235,9 +1681,11 @@ func reportManagement() {
 		fmt.Printf("%s1.%s Patient Statistics Report\n", YELLOW, RESET)
 		fmt.Printf("%s2.%s Package Statistics Report\n", YELLOW, RESET)
 		fmt.Printf("%s3.%s Revenue Report\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s De-identified Research Export\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Export Lists & Reports\n", YELLOW, RESET)
 		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
 
-		choice := getValidInt("\nSelect option: ", 0, 3)
+		choice := getValidInt("\nSelect option: ", 0, 5)
 
 		switch choice {
 		case 1:
@@ -1246,6 +1694,10 @@ func reportManagement() {
 			generatePackageReport()
 		case 3:
 			generateRevenueReport()
+		case 4:
+			exportResearchDataset()
+		case 5:
+			exportLists()
 		case 0:
 			return
 		}
@@ -1254,15 +1706,40 @@ func reportManagement() {
 
 func mainMenu() {
 	for {
+		// Save our changes, pick up other terminals' and send what is due
+		syncData()
+
+		// Exchange data with the other branches when the central store is
+		// reachable
+		autoSyncBranch()
+
 		printHeader("Medical Check-Up Management System")
+		fmt.Printf("Logged in as %s%s%s (%s)", BOLD, currentUser.Username, RESET, currentUser.Role)
+		if branches.N > 0 {
+			fmt.Printf(" at %s%s%s", BOLD, branchName(activeBranch), RESET)
+		}
+		if status := branchSyncStatus(); status != "" {
+			fmt.Printf("\n%s", status)
+		}
+		fmt.Print("\n\n")
 		fmt.Printf("%s1.%s Patient Management\n", CYAN, RESET)
 		fmt.Printf("%s2.%s Package Management\n", CYAN, RESET)
 		fmt.Printf("%s3.%s Medical Record Management\n", CYAN, RESET)
 		fmt.Printf("%s4.%s Reports & Analytics\n", CYAN, RESET)
-		fmt.Printf("%s5.%s Save Data\n", GREEN, RESET)
+		fmt.Printf("%s5.%s Corporate Clients\n", CYAN, RESET)
+		fmt.Printf("%s6.%s Insurance & Claims\n", CYAN, RESET)
+		fmt.Printf("%s7.%s User Accounts\n", CYAN, RESET)
+		fmt.Printf("%s8.%s Security & Privacy\n", CYAN, RESET)
+		fmt.Printf("%s9.%s Trash & Undo\n", CYAN, RESET)
+		fmt.Printf("%s10.%s Save Data\n", GREEN, RESET)
+		fmt.Printf("%s11.%s Data Exchange (FHIR, HL7)\n", CYAN, RESET)
+		fmt.Printf("%s12.%s Practitioners\n", CYAN, RESET)
+		fmt.Printf("%s13.%s Recall & Follow-up\n", CYAN, RESET)
+		fmt.Printf("%s14.%s Patient Notifications\n", CYAN, RESET)
+		fmt.Printf("%s15.%s Branches\n", CYAN, RESET)
 		fmt.Printf("%s0.%s Exit\n", RED, RESET)
 
-		choice := getValidInt("\nSelect option: ", 0, 5)
+		choice := getValidInt("\nSelect option: ", 0, 15)
 
 		switch choice {
 		case 1:
@@ -1274,18 +1751,48 @@ func mainMenu() {
 		case 4:
 			reportManagement()
 		case 5:
+			companyManagement()
+		case 6:
+			insuranceManagement()
+		case 7:
+			userManagement()
+		case 8:
+			securityManagement()
+		case 9:
+			trashManagement()
+		case 10:
 			if err := saveData(); err != nil {
 				printError(fmt.Sprintf("Failed to save data: %v", err))
 			} else {
 				printSuccess("Data saved successfully!")
 			}
 			pause()
+		case 11:
+			dataExchangeManagement()
+		case 12:
+			practitionerManagement()
+		case 13:
+			recallManagement()
+		case 14:
+			notificationManagement()
+		case 15:
+			branchManagement()
 		case 0:
 			fmt.Printf("\n%sSaving data before exit...%s\n", YELLOW, RESET)
-			if err := saveData(); err != nil {
+			// Messages being sent are saved as sent, so they do not go out twice
+			finishDeliveries(true)
+			for {
+				err := saveData()
+				if err == nil {
+					printSuccess("Data saved successfully!")
+					break
+				}
 				printError(fmt.Sprintf("Failed to save data: %v", err))
-			} else {
-				printSuccess("Data saved successfully!")
+				// Another terminal may be holding the data file for a moment
+				confirm := getValidInput("Try again? Changes not saved are lost on exit (y/N): ")
+				if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+					break
+				}
 			}
 			fmt.Printf("\n%sThank you for using Medical Check-Up Management System!%s\n", GREEN, RESET)
 			return
@@ -1297,10 +1804,32 @@ func main() {
 	// Initialize scanner
 	scanner = bufio.NewScanner(os.Stdin)
 
+	// Some commands need neither the data nor a login
+	if len(os.Args) > 1 {
+		if code, ok := runStandaloneCommand(os.Args[1:]); ok {
+			os.Exit(code)
+		}
+	}
+
+	// Unlock the encrypted data file
+	if err := initDataKey(); err != nil {
+		printError(fmt.Sprintf("Cannot unlock data: %v", err))
+		os.Exit(1)
+	}
+
 	// Load existing data
 	fmt.Printf("%sLoading data...%s\n", YELLOW, RESET)
 	if err := loadData(); err != nil {
 		printError(fmt.Sprintf("Failed to load data: %v", err))
+		if errors.Is(err, ErrLocked) {
+			fmt.Println("Another terminal is saving right now. Try again in a moment.")
+			os.Exit(1)
+		}
+		if errors.Is(err, ErrWrongKey) || errors.Is(err, ErrCorrupted) {
+			// Never overwrite a store that could not be read
+			fmt.Println("Restore data.json from a backup or use the correct key. Exiting without changes.")
+			os.Exit(1)
+		}
 		fmt.Println("Starting with empty data...")
 	} else {
 		printSuccess(fmt.Sprintf("Data loaded successfully! Patients: %d, Packages: %d, Records: %d",
@@ -1309,6 +1838,35 @@ func main() {
 
 	time.Sleep(2 * time.Second)
 
+	// Log in before anything else
+	if !login() {
+		exitOnFailedLogin()
+	}
+
+	// Users work at their own branches only
+	if !chooseBranch() {
+		os.Exit(1)
+	}
+
+	// Screen privacy follows the logged-in user's role
+	resetMasking()
+
+	// Apply the retention policy once a day
+	runScheduledRetention()
+
+	// Deleted items are kept in the trash for a limited time
+	purgeExpiredTrash()
+
+	// Audit entries written before values were sealed are sealed now
+	if _, err := resealAuditLog(nil); err != nil {
+		printWarning(fmt.Sprintf("Audit log values could not be sealed: %v", err))
+	}
+
+	// A command given on the command line runs once instead of the menu
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Start main menu
 	mainMenu()
 }
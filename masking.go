@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants
+const (
+	PRIVACY_IDLE_TIMEOUT = 60 * time.Second
+	PRIVACY_IDLE_CHECK   = 5 * time.Second
+
+	// Privacy modes by role
+	PRIVACY_ALWAYS      = "always"      // masked, single rows can be revealed
+	PRIVACY_DEFAULT_ON  = "default_on"  // masked, can be switched off for the session
+	PRIVACY_DEFAULT_OFF = "default_off" // unmasked, can be switched on
+)
+
+var rolePrivacy = map[string]string{
+	ROLE_RECEPTIONIST: PRIVACY_ALWAYS,
+	ROLE_CASHIER:      PRIVACY_ALWAYS,
+	ROLE_NURSE:        PRIVACY_DEFAULT_ON,
+	ROLE_DOCTOR:       PRIVACY_DEFAULT_ON,
+	ROLE_MANAGER:      PRIVACY_DEFAULT_OFF,
+	ROLE_ADMIN:        PRIVACY_DEFAULT_OFF,
+}
+
+// Screen privacy state. privacyMode is the logged-in user's mode, kept here
+// so the idle check does not need the user.
+var (
+	privacyMu    sync.Mutex
+	privacyMode  = PRIVACY_ALWAYS
+	maskingOn    = true
+	phiOnScreen  bool
+	lastActivity = time.Now()
+)
+
+// Masking functions
+// maskName keeps the first letter of each word, e.g. "Budi Santoso" -> "B*** S******"
+func maskName(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		runes := []rune(w)
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+// maskIdentifier keeps only the last two characters
+func maskIdentifier(id string) string {
+	runes := []rune(id)
+	if len(runes) <= 2 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-2:])
+}
+
+func rolePrivacyMode(role string) string {
+	if mode, ok := rolePrivacy[role]; ok {
+		return mode
+	}
+	return PRIVACY_ALWAYS
+}
+
+func currentPrivacyMode() string {
+	if currentUser == nil {
+		return PRIVACY_ALWAYS
+	}
+	return rolePrivacyMode(currentUser.Role)
+}
+
+// resetMasking puts masking back to the default for the logged-in user's role
+func resetMasking() {
+	mode := currentPrivacyMode()
+	privacyMu.Lock()
+	privacyMode = mode
+	maskingOn = mode != PRIVACY_DEFAULT_OFF
+	privacyMu.Unlock()
+}
+
+// refreshPrivacyMode follows a role change saved by another terminal; a role
+// that is always masked turns masking back on
+func refreshPrivacyMode() {
+	mode := currentPrivacyMode()
+	privacyMu.Lock()
+	privacyMode = mode
+	if mode == PRIVACY_ALWAYS {
+		maskingOn = true
+	}
+	privacyMu.Unlock()
+}
+
+func isMasking() bool {
+	privacyMu.Lock()
+	defer privacyMu.Unlock()
+	return maskingOn
+}
+
+// displayName is the name as it may be shown in a list view
+func displayName(name string) string {
+	if isMasking() {
+		return maskName(name)
+	}
+	markPHIOnScreen()
+	return name
+}
+
+func displayPatientID(id int) string {
+	if isMasking() {
+		return maskIdentifier(fmt.Sprintf("%d", id))
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+func maskingLabel() string {
+	if isMasking() {
+		return "on"
+	}
+	return "off"
+}
+
+func markPHIOnScreen() {
+	privacyMu.Lock()
+	phiOnScreen = true
+	privacyMu.Unlock()
+}
+
+// Idle functions
+func touchActivity() {
+	privacyMu.Lock()
+	lastActivity = time.Now()
+	privacyMu.Unlock()
+}
+
+func clearPHIOnScreen() {
+	privacyMu.Lock()
+	phiOnScreen = false
+	privacyMu.Unlock()
+}
+
+func isIdle(last, now time.Time) bool {
+	return now.Sub(last) >= PRIVACY_IDLE_TIMEOUT
+}
+
+// checkIdle restores masking once the screen has been left alone for
+// PRIVACY_IDLE_TIMEOUT, and reports whether details shown on it should be
+// hidden. The prompt waiting for input does the hiding, so nothing is
+// written over it from elsewhere.
+func checkIdle(now time.Time) bool {
+	privacyMu.Lock()
+	defer privacyMu.Unlock()
+	if !isIdle(lastActivity, now) {
+		return false
+	}
+	if privacyMode != PRIVACY_DEFAULT_OFF {
+		maskingOn = true
+	}
+	hide := phiOnScreen
+	phiOnScreen = false
+	return hide
+}
+
+// Reveal functions
+// revealRows lets the user show one masked row at a time. show prints the
+// full details of the chosen row; the list itself stays masked.
+func revealRows(count int, show func(row int)) {
+	if !isMasking() || count == 0 {
+		pause()
+		return
+	}
+
+	for {
+		row := getValidInt(fmt.Sprintf("\nEnter row number to reveal (1-%d, 0 to return): ", count), 0, count)
+		if row == 0 {
+			return
+		}
+		markPHIOnScreen()
+		fmt.Println()
+		show(row - 1)
+	}
+}
+
+func togglePrivacyMode() {
+	printHeader("Screen Privacy")
+
+	mode := currentPrivacyMode()
+	if mode == PRIVACY_ALWAYS {
+		printWarning(fmt.Sprintf("Names are always masked for the %s role. Use row reveal in list views.", currentUser.Role))
+		pause()
+		return
+	}
+
+	privacyMu.Lock()
+	maskingOn = !maskingOn
+	on := maskingOn
+	privacyMu.Unlock()
+
+	auditLog(AUDIT_UPDATE, "privacy_mode", currentUser.ID, nil, map[string]bool{"masking": on})
+	if on {
+		printSuccess("Masking on: names and identifiers are hidden in list views.")
+	} else if mode == PRIVACY_DEFAULT_ON {
+		printSuccess(fmt.Sprintf("Masking off. It turns back on after %s without input.", PRIVACY_IDLE_TIMEOUT))
+	} else {
+		printSuccess("Masking off: names and identifiers are shown in list views.")
+	}
+	pause()
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	// Events that notify the patient
+	EVENT_RECORD_CREATED = "record_created"
+	EVENT_STATUS_CHANGED = "status_changed"
+	EVENT_RESULTS_READY  = "results_ready"
+
+	// Record status, worked out from the results and the sign-off
+	STATUS_REGISTERED      = "registered"
+	STATUS_IN_PROGRESS     = "in_progress"
+	STATUS_AWAITING_REVIEW = "awaiting_review"
+	STATUS_SIGNED          = "signed"
+
+	// Delivery state of a notification
+	DELIVERY_QUEUED  = "queued"
+	DELIVERY_SENT    = "sent"
+	DELIVERY_FAILED  = "failed"
+	DELIVERY_SKIPPED = "skipped"
+
+	NOTIFY_MAX_ATTEMPTS = 5
+	NOTIFY_RETRY_BASE   = 5 * time.Minute
+	NOTIFY_TIME_FORMAT  = "02/01/2006 15:04:05"
+)
+
+var EVENTS = []string{EVENT_RECORD_CREATED, EVENT_STATUS_CHANGED, EVENT_RESULTS_READY}
+
+var eventNames = map[string]string{
+	EVENT_RECORD_CREATED: "Appointment confirmation",
+	EVENT_STATUS_CHANGED: "Status change",
+	EVENT_RESULTS_READY:  "Results available",
+}
+
+// statusNames is how a record status reads to the patient
+var statusNames = map[string]map[string]string{
+	LANG_EN: {
+		STATUS_REGISTERED:      "booked",
+		STATUS_IN_PROGRESS:     "examinations in progress",
+		STATUS_AWAITING_REVIEW: "all results in, awaiting the doctor's review",
+		STATUS_SIGNED:          "results signed by the doctor",
+	},
+	LANG_ID: {
+		STATUS_REGISTERED:      "terdaftar",
+		STATUS_IN_PROGRESS:     "pemeriksaan sedang berlangsung",
+		STATUS_AWAITING_REVIEW: "semua hasil sudah ada, menunggu pemeriksaan dokter",
+		STATUS_SIGNED:          "hasil sudah ditandatangani dokter",
+	},
+}
+
+// Placeholders that templates can use
+var TEMPLATE_FIELDS = []string{"{name}", "{record}", "{date}", "{package}", "{status}", "{clinic}", "{phone}"}
+
+// Data structures
+// MessageTemplate is the wording of one event in one language
+type MessageTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notification is one entry of the delivery log
+type Notification struct {
+	ID           int    `json:"id"`
+	Event        string `json:"event"`
+	RecordStatus string `json:"record_status,omitempty"`
+	PatientID    int    `json:"patient_id"`
+	RecordID     int    `json:"record_id"`
+	Channel      string `json:"channel"`
+	To           string `json:"to,omitempty"`
+	Subject      string `json:"subject"`
+	Body         string `json:"body"`
+	Delivery     string `json:"delivery"`
+	Attempts     int    `json:"attempts,omitempty"`
+	NextAttempt  string `json:"next_attempt,omitempty"`
+	LeasedUntil  string `json:"leased_until,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	SentAt       string `json:"sent_at,omitempty"`
+}
+
+// notificationClaim is a notification taken for sending without the data
+// lock. LeasedUntil tells our claim apart from a later one.
+type notificationClaim struct {
+	ID          int
+	LeasedUntil string
+	Channel     string
+	Message     OutgoingMessage
+	Err         error
+}
+
+var notifications []Notification
+var notifyTemplates map[string]MessageTemplate
+
+var defaultTemplates = map[string]map[string]MessageTemplate{
+	LANG_EN: {
+		EVENT_RECORD_CREATED: {
+			Subject: "Your check-up at {clinic} is booked",
+			Body: "Dear {name},\n\nYour {package} check-up is booked for {date} (record {record}).\n" +
+				"Please contact us if you need to change it.\n\n{clinic}\n{phone}",
+		},
+		EVENT_STATUS_CHANGED: {
+			Subject: "Update on your check-up at {clinic}",
+			Body:    "Dear {name},\n\nYour {package} check-up of {date} is now: {status}.\n\n{clinic}\n{phone}",
+		},
+		EVENT_RESULTS_READY: {
+			Subject: "Your check-up results are ready",
+			Body: "Dear {name},\n\nThe doctor has signed the results of your {package} check-up of {date}.\n" +
+				"Your result sheet can be collected at the clinic.\n\n{clinic}\n{phone}",
+		},
+	},
+	LANG_ID: {
+		EVENT_RECORD_CREATED: {
+			Subject: "Pemeriksaan Anda di {clinic} sudah terjadwal",
+			Body: "Yth. {name},\n\nPemeriksaan {package} Anda terjadwal pada {date} (rekam medis {record}).\n" +
+				"Silakan hubungi kami bila ingin mengubah jadwal.\n\n{clinic}\n{phone}",
+		},
+		EVENT_STATUS_CHANGED: {
+			Subject: "Kabar pemeriksaan Anda di {clinic}",
+			Body:    "Yth. {name},\n\nStatus pemeriksaan {package} Anda pada {date}: {status}.\n\n{clinic}\n{phone}",
+		},
+		EVENT_RESULTS_READY: {
+			Subject: "Hasil pemeriksaan Anda sudah siap",
+			Body: "Yth. {name},\n\nDokter sudah menandatangani hasil pemeriksaan {package} Anda pada {date}.\n" +
+				"Lembar hasil dapat diambil di klinik.\n\n{clinic}\n{phone}",
+		},
+	},
+}
+
+// Status functions
+// expectedExaminations are the examinations of the record's package. The
+// current package is preferred over the copy kept in the record.
+func expectedExaminations(r Record) []string {
+	if idx := binarySearchPackageByID(r.Package.ID); idx != -1 {
+		return packages.Daftar[idx].Examinations
+	}
+	return r.Package.Examinations
+}
+
+func recordStatus(r Record) string {
+	if isSignedOff(r) {
+		return STATUS_SIGNED
+	}
+	if len(r.Results) == 0 {
+		return STATUS_REGISTERED
+	}
+	expected := expectedExaminations(r)
+	if len(expected) == 0 {
+		return STATUS_IN_PROGRESS
+	}
+	for _, code := range expected {
+		found := false
+		for _, res := range r.Results {
+			if res.Code == code {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return STATUS_IN_PROGRESS
+		}
+	}
+	return STATUS_AWAITING_REVIEW
+}
+
+func statusName(status, lang string) string {
+	if names, ok := statusNames[lang]; ok {
+		return names[status]
+	}
+	return statusNames[LANG_EN][status]
+}
+
+// Template functions
+func templateKey(lang, event string) string {
+	return lang + "/" + event
+}
+
+// templateFor returns the clinic's own wording of an event, or the default
+func templateFor(lang, event string) MessageTemplate {
+	if t, ok := notifyTemplates[templateKey(lang, event)]; ok {
+		return t
+	}
+	if t, ok := defaultTemplates[lang][event]; ok {
+		return t
+	}
+	return defaultTemplates[LANG_EN][event]
+}
+
+// renderTemplate fills in the placeholders. Lines left empty at the end,
+// e.g. when the clinic has no phone number, are dropped.
+func renderTemplate(text string, fields map[string]string) string {
+	var pairs []string
+	for key, value := range fields {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	return strings.TrimRight(strings.NewReplacer(pairs...).Replace(text), "\n ")
+}
+
+func notificationFields(r Record, p Patient, lang string) map[string]string {
+	return map[string]string{
+		"name":    p.Name,
+		"record":  fmt.Sprintf("%d", r.ID),
+		"date":    r.Date,
+		"package": r.Package.Name,
+		"status":  statusName(recordStatus(r), lang),
+		"clinic":  clinicName(),
+		"phone":   resultTemplate.Phone,
+	}
+}
+
+// Queue functions
+func eventEnabled(event string) bool {
+	if notifierSettings.NotifyChannel == "" {
+		return false
+	}
+	for _, e := range notifierSettings.NotifyEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func getNextNotificationID() int {
+	if len(notifications) == 0 {
+		return 1
+	}
+	return notifications[len(notifications)-1].ID + 1
+}
+
+// queueNotification puts a message for the record's patient in the delivery
+// log. Nothing is sent yet, so it is safe to call while holding locks.
+func queueNotification(event string, r Record) {
+	if !eventEnabled(event) || isAnonymised(r) {
+		return
+	}
+	pIdx := binarySearchPatientByID(r.Patient.ID)
+	if pIdx == -1 {
+		return
+	}
+	p := patients.Daftar[pIdx]
+	lang := resultLanguage()
+	tpl := templateFor(lang, event)
+	fields := notificationFields(r, p, lang)
+	now := time.Now().Format(NOTIFY_TIME_FORMAT)
+
+	n := Notification{
+		ID:          getNextNotificationID(),
+		Event:       event,
+		PatientID:   p.ID,
+		RecordID:    r.ID,
+		Channel:     notifierSettings.NotifyChannel,
+		To:          recipient(p, notifierSettings.NotifyChannel),
+		Subject:     renderTemplate(tpl.Subject, fields),
+		Body:        renderTemplate(tpl.Body, fields),
+		Delivery:    DELIVERY_QUEUED,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+	if event == EVENT_STATUS_CHANGED {
+		n.RecordStatus = recordStatus(r)
+	}
+	if reason := skipReason(p, n.Channel); reason != "" {
+		n.Delivery, n.LastError, n.NextAttempt = DELIVERY_SKIPPED, reason, ""
+		auditLog(AUDIT_NOTIFY, "notification", n.PatientID, nil, notificationSummary(n))
+	}
+	notifications = append(notifications, n)
+}
+
+// skipReason tells why a patient cannot be notified on a channel, if so
+func skipReason(p Patient, channel string) string {
+	if p.NoNotify {
+		return "patient opted out"
+	}
+	if recipient(p, channel) == "" && channel != CHANNEL_OUTBOX {
+		return fmt.Sprintf("no %s contact", channel)
+	}
+	return ""
+}
+
+// queueRecordChange notifies the patient when the status of a record has
+// changed. Signing off is told as the results being ready.
+func queueRecordChange(before, after Record) {
+	from, to := recordStatus(before), recordStatus(after)
+	if from == to {
+		return
+	}
+	if to == STATUS_SIGNED {
+		queueNotification(EVENT_RESULTS_READY, after)
+	} else {
+		queueNotification(EVENT_STATUS_CHANGED, after)
+	}
+}
+
+// Delivery functions
+// retryDelay doubles the wait after every failed attempt
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return NOTIFY_RETRY_BASE << uint(attempts-1)
+}
+
+// notificationSummary is what the audit trail keeps of a notification; the
+// message itself stays in the delivery log
+func notificationSummary(n Notification) map[string]interface{} {
+	return map[string]interface{}{
+		"id": n.ID, "event": n.Event, "record_id": n.RecordID, "channel": n.Channel,
+		"delivery": n.Delivery, "attempts": n.Attempts, "error": n.LastError,
+	}
+}
+
+// deliverNotifications sends every queued notification whose next attempt
+// is due. They are claimed under the data lock and sent without it, so a
+// slow channel never holds up the other terminals.
+func deliverNotifications(now time.Time) (int, int) {
+	var claims []notificationClaim
+	conflicts, err := commitData(func() { claims = claimNotifications(now) })
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "Another terminal")
+	}
+	if err != nil {
+		return 0, 0
+	}
+	sendNotifications(claims, notifierSettings)
+	return applyNotifications(claims, now)
+}
+
+// claimNotifications leases up to DELIVERY_BATCH notifications that are
+// due, so that other terminals leave them alone while they are sent. It
+// runs under the data lock. A lease that runs out, e.g. after a crash,
+// makes them due again.
+func claimNotifications(now time.Time) []notificationClaim {
+	lease := now.Add(DELIVERY_LEASE).Format(NOTIFY_TIME_FORMAT)
+	var claims []notificationClaim
+
+	for i := range notifications {
+		n := &notifications[i]
+		if len(claims) == DELIVERY_BATCH {
+			break
+		}
+		if n.Delivery != DELIVERY_QUEUED || leaseHeld(n.LeasedUntil, now) {
+			continue
+		}
+		if next, err := time.ParseInLocation(NOTIFY_TIME_FORMAT, n.NextAttempt, time.Local); err == nil && now.Before(next) {
+			continue
+		}
+
+		// The patient may have opted out since the message was queued
+		if pIdx := binarySearchPatientByID(n.PatientID); pIdx == -1 || patients.Daftar[pIdx].NoNotify {
+			n.Delivery, n.NextAttempt, n.LeasedUntil, n.LastError = DELIVERY_SKIPPED, "", "", "patient opted out or removed"
+			auditLog(AUDIT_NOTIFY, "notification", n.PatientID, nil, notificationSummary(*n))
+			continue
+		}
+
+		n.LeasedUntil = lease
+		claims = append(claims, notificationClaim{ID: n.ID, LeasedUntil: lease, Channel: n.Channel,
+			Message: OutgoingMessage{Kind: "notification", PatientID: n.PatientID, To: n.To, Subject: n.Subject, Body: n.Body}})
+	}
+	return claims
+}
+
+// sendNotifications sends claimed notifications and notes each outcome in
+// the claim. It touches no shared data, so it can run in the background.
+func sendNotifications(claims []notificationClaim, settings NotifierSettings) {
+	notifiers := make(map[string]Notifier)
+	for i := range claims {
+		c := &claims[i]
+		notifier, ok := notifiers[c.Channel]
+		if !ok {
+			var err error
+			if notifier, err = newNotifier(c.Channel, settings); err != nil {
+				c.Err = err
+				continue
+			}
+			notifiers[c.Channel] = notifier
+		}
+		c.Err = notifier.Send(c.Message)
+	}
+}
+
+// applyNotifications records how claimed notifications went. Failures are
+// retried with a growing delay until NOTIFY_MAX_ATTEMPTS is reached. A
+// claim whose lease was taken over in the meantime is left alone.
+func applyNotifications(claims []notificationClaim, now time.Time) (int, int) {
+	sent, failed := 0, 0
+	for _, c := range claims {
+		var n *Notification
+		for i := range notifications {
+			if notifications[i].ID == c.ID && notifications[i].LeasedUntil == c.LeasedUntil {
+				n = &notifications[i]
+			}
+		}
+		if n == nil {
+			continue
+		}
+		n.LeasedUntil = ""
+		n.Attempts++
+
+		if c.Err == nil {
+			n.Delivery, n.NextAttempt, n.LastError = DELIVERY_SENT, "", ""
+			n.SentAt = now.Format(NOTIFY_TIME_FORMAT)
+			sent++
+		} else {
+			n.LastError = c.Err.Error()
+			if n.Attempts >= NOTIFY_MAX_ATTEMPTS {
+				n.Delivery, n.NextAttempt = DELIVERY_FAILED, ""
+			} else {
+				n.NextAttempt = now.Add(retryDelay(n.Attempts)).Format(NOTIFY_TIME_FORMAT)
+			}
+			failed++
+		}
+		if n.Delivery != DELIVERY_QUEUED {
+			auditLog(AUDIT_NOTIFY, "notification", n.PatientID, nil, notificationSummary(*n))
+		}
+	}
+	return sent, failed
+}
+
+// deliverNotificationsSoon has the notifications just queued sent in the
+// background from the main menu, instead of waiting for the next run
+func deliverNotificationsSoon() {
+	lastDeliveryRun = time.Time{}
+}
+
+// forgetNotifications removes a patient's notifications and their outbox files
+func forgetNotifications(patientID int) (int, int) {
+	kept := notifications[:0]
+	for _, n := range notifications {
+		if n.PatientID != patientID {
+			kept = append(kept, n)
+		}
+	}
+	removed := len(notifications) - len(kept)
+	notifications = kept
+	return removed, removeOutboxFiles("notification", patientID)
+}
+
+// Menu functions
+func viewDeliveryLog() {
+	printHeader("Delivery Log")
+
+	if !requirePermission(PERM_NOTIFY_MANAGE) {
+		return
+	}
+
+	if len(notifications) == 0 {
+		printWarning("No notifications yet.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "notification", 0, nil, nil)
+	fmt.Printf("%s%-5s %-19s %-10s %-7s %-25s %-7s %-8s %-3s %-19s%s\n", BOLD,
+		"ID", "Created", "Patient", "Record", "Event", "Channel", "Delivery", "Try", "Sent / Next Try", RESET)
+	fmt.Println(strings.Repeat("-", 112))
+	for _, n := range notifications {
+		when := n.SentAt
+		if n.Delivery == DELIVERY_QUEUED {
+			when = n.NextAttempt
+		}
+		fmt.Printf("%-5d %-19s %-10s %-7d %-25s %-7s %-8s %-3d %-19s\n", n.ID, n.CreatedAt, displayPatientID(n.PatientID),
+			n.RecordID, eventNames[n.Event], n.Channel, n.Delivery, n.Attempts, when)
+		if n.LastError != "" {
+			fmt.Printf("%s      %s%s\n", YELLOW, n.LastError, RESET)
+		}
+	}
+
+	id := getValidInt("\nEnter notification ID to read the message (0 to return): ", 0, 999999)
+	if id == 0 {
+		return
+	}
+	for _, n := range notifications {
+		if n.ID == id {
+			auditLog(AUDIT_VIEW, "notification", n.PatientID, nil, nil)
+			markPHIOnScreen()
+			fmt.Printf("\nTo: %s\nSubject: %s\n\n%s\n", n.To, n.Subject, n.Body)
+			pause()
+			return
+		}
+	}
+	printError("Notification not found.")
+	pause()
+}
+
+func sendQueuedNotifications() {
+	printHeader("Send Queued Notifications")
+
+	if !requirePermission(PERM_NOTIFY_MANAGE) {
+		return
+	}
+
+	// Sending by hand does not wait for the retry delay
+	queued := 0
+	for i := range notifications {
+		if notifications[i].Delivery == DELIVERY_QUEUED {
+			notifications[i].NextAttempt = ""
+			queued++
+		}
+	}
+	if queued == 0 {
+		printWarning("No notifications are waiting.")
+		pause()
+		return
+	}
+
+	sent, failed := deliverNotifications(time.Now())
+	printSuccess(fmt.Sprintf("%d of %d notifications sent, %d failed.", sent, queued, failed))
+	pause()
+}
+
+func retryFailedNotifications() {
+	printHeader("Retry Failed Notifications")
+
+	if !requirePermission(PERM_NOTIFY_MANAGE) {
+		return
+	}
+
+	retried := 0
+	for i := range notifications {
+		if notifications[i].Delivery == DELIVERY_FAILED {
+			notifications[i].Delivery, notifications[i].Attempts, notifications[i].NextAttempt = DELIVERY_QUEUED, 0, ""
+			retried++
+		}
+	}
+	if retried == 0 {
+		printWarning("No notifications have failed.")
+		pause()
+		return
+	}
+
+	sent, failed := deliverNotifications(time.Now())
+	printSuccess(fmt.Sprintf("%d of %d notifications sent, %d failed again.", sent, retried, failed))
+	pause()
+}
+
+func selectEvent() string {
+	for i, event := range EVENTS {
+		fmt.Printf("%d. %s\n", i+1, eventNames[event])
+	}
+	choice := getValidInt("Choose event (0 to cancel): ", 0, len(EVENTS))
+	if choice == 0 {
+		return ""
+	}
+	return EVENTS[choice-1]
+}
+
+func editMessageTemplates() {
+	printHeader("Message Templates")
+
+	if !requirePermission(PERM_CLINIC_MANAGE) {
+		return
+	}
+
+	fmt.Println("Language: 1. English  2. Bahasa Indonesia")
+	lang := LANG_EN
+	if getValidInt("Choose language: ", 1, 2) == 2 {
+		lang = LANG_ID
+	}
+	fmt.Println()
+	event := selectEvent()
+	if event == "" {
+		return
+	}
+
+	key := templateKey(lang, event)
+	before := templateFor(lang, event)
+	_, custom := notifyTemplates[key]
+	fmt.Printf("\n%s%s, %s%s", BOLD, eventNames[event], languageNames[lang], RESET)
+	if !custom {
+		fmt.Print(" (default)")
+	}
+	fmt.Printf("\nSubject: %s\n\n%s\n", before.Subject, before.Body)
+
+	fmt.Println("\n1. Edit  2. Reset to default  0. Cancel")
+	switch getValidInt("Choose option: ", 0, 2) {
+	case 0:
+		return
+	case 1:
+		fmt.Printf("\nPlaceholders: %s\n", strings.Join(TEMPLATE_FIELDS, " "))
+		t := MessageTemplate{Subject: getValidInput("Subject: ")}
+		t.Body = getLines("Message:")
+		if notifyTemplates == nil {
+			notifyTemplates = make(map[string]MessageTemplate)
+		}
+		notifyTemplates[key] = t
+		auditLog(AUDIT_UPDATE, "notification_template", 0, before, t)
+	case 2:
+		delete(notifyTemplates, key)
+		auditLog(AUDIT_UPDATE, "notification_template", 0, before, templateFor(lang, event))
+	}
+
+	printSuccess("Template saved. Messages already queued keep their wording.")
+	pause()
+}
+
+func editNotificationSettings() {
+	printHeader("Notification Settings")
+
+	if !requirePermission(PERM_CLINIC_MANAGE) {
+		return
+	}
+
+	s := &notifierSettings
+	before := map[string]interface{}{"channel": s.NotifyChannel, "events": append([]string(nil), s.NotifyEvents...)}
+	for {
+		channel := "off"
+		if s.NotifyChannel != "" {
+			channel = channelNames[s.NotifyChannel]
+		}
+		fmt.Printf("\nSend notifications by: %s\n", channel)
+		for i, event := range EVENTS {
+			state := "off"
+			if eventEnabled(event) {
+				state = "on"
+			}
+			fmt.Printf("%d. %s: %s\n", i+1, eventNames[event], state)
+		}
+		fmt.Printf("%d. Change channel\n", len(EVENTS)+1)
+
+		choice := getValidInt("\nChoose event to switch on/off, or option (0 to finish): ", 0, len(EVENTS)+1)
+		if choice == 0 {
+			break
+		}
+		if choice == len(EVENTS)+1 {
+			fmt.Println("\nSend by (0 switches notifications off):")
+			s.NotifyChannel = selectChannel()
+			if s.NotifyChannel != "" && len(s.NotifyEvents) == 0 {
+				s.NotifyEvents = append([]string(nil), EVENTS...)
+			}
+			continue
+		}
+
+		event := EVENTS[choice-1]
+		var events []string
+		for _, e := range s.NotifyEvents {
+			if e != event {
+				events = append(events, e)
+			}
+		}
+		if len(events) == len(s.NotifyEvents) {
+			events = append(events, event)
+		}
+		s.NotifyEvents = events
+	}
+
+	auditLog(AUDIT_UPDATE, "notifier_settings", 0, before, map[string]interface{}{"channel": s.NotifyChannel, "events": s.NotifyEvents})
+	printSuccess("Notification settings saved.")
+	pause()
+}
+
+func notificationManagement() {
+	for {
+		printHeader("Patient Notifications")
+		fmt.Printf("%s1.%s Delivery Log\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Send Queued Now\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Retry Failed\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Message Templates\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Notification Settings\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Channels (SMTP, SMS, outbox)\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 6)
+
+		switch choice {
+		case 1:
+			viewDeliveryLog()
+		case 2:
+			sendQueuedNotifications()
+		case 3:
+			retryFailedNotifications()
+		case 4:
+			editMessageTemplates()
+		case 5:
+			editNotificationSettings()
+		case 6:
+			editNotifierSettings()
+		case 0:
+			return
+		}
+	}
+}
+
+// notifyCommand runs "notify run" after login, so retries go out from a
+// scheduled job when nobody is using the program
+func notifyCommand(args []string) int {
+	if len(args) != 1 || args[0] != "run" {
+		fmt.Println("Usage: notify run")
+		return 2
+	}
+	if !checkPermission(PERM_NOTIFY_MANAGE) {
+		return 1
+	}
+
+	sent, failed := deliverNotifications(time.Now())
+	if err := saveData(); err != nil {
+		printError(fmt.Sprintf("Failed to save data: %v", err))
+		return 1
+	}
+	printSuccess(fmt.Sprintf("%d notifications sent, %d failed.", sent, failed))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Constants
+// A4 portrait in points, with the standard Helvetica fonts every PDF viewer has
+const (
+	PDF_PAGE_WIDTH  = 595.0
+	PDF_PAGE_HEIGHT = 842.0
+	PDF_MARGIN      = 40.0
+	PDF_CELL_PAD    = 4.0
+
+	PDF_FONT_TEXT    = 9.0
+	PDF_FONT_HEADING = 16.0
+	PDF_FONT_SUBHEAD = 12.0
+	PDF_LINE_HEIGHT  = 14.0
+)
+
+// helveticaWidths are the glyph widths of Helvetica for characters 32-126, in
+// thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// Data structures
+// pdfDocument lays out text and tables top to bottom over as many pages as
+// needed
+type pdfDocument struct {
+	pages     []*bytes.Buffer
+	y         float64
+	pageLabel string // footer format, given the page number and count
+}
+
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{pageLabel: "Page %d of %d"}
+	doc.newPage()
+	return doc
+}
+
+// Text functions
+func textWidth(text string, size float64) float64 {
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// pdfString encodes text as a PDF literal string in WinAnsi encoding.
+// Characters outside Latin-1 are replaced with '?'.
+func pdfString(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r <= 126:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// fitText shortens text with "..." so it fits in width
+func fitText(text string, size, width float64) string {
+	if textWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// wrapText breaks text into lines no wider than width, keeping line breaks
+func wrapText(text string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && textWidth(line+" "+word, size) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, fitText(line, size, width))
+	}
+	return lines
+}
+
+// Layout functions
+func (d *pdfDocument) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = PDF_PAGE_HEIGHT - PDF_MARGIN
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// ensure starts a new page when less than height is left
+func (d *pdfDocument) ensure(height float64) {
+	if d.y-height < PDF_MARGIN+PDF_LINE_HEIGHT {
+		d.newPage()
+	}
+}
+
+func (d *pdfDocument) drawText(x, y float64, font string, size float64, text string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfString(text))
+}
+
+func (d *pdfDocument) drawLine(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func (d *pdfDocument) Heading(text string) {
+	d.ensure(PDF_FONT_HEADING * 1.5)
+	d.y -= PDF_FONT_HEADING
+	d.drawText(PDF_MARGIN, d.y, "F2", PDF_FONT_HEADING, text)
+	d.y -= PDF_FONT_HEADING * 0.5
+}
+
+func (d *pdfDocument) Subheading(text string) {
+	d.ensure(PDF_FONT_SUBHEAD * 1.5)
+	d.y -= PDF_FONT_SUBHEAD
+	d.drawText(PDF_MARGIN, d.y, "F2", PDF_FONT_SUBHEAD, text)
+	d.y -= PDF_FONT_SUBHEAD * 0.5
+}
+
+func (d *pdfDocument) Text(text string) {
+	d.ensure(PDF_LINE_HEIGHT)
+	d.y -= PDF_LINE_HEIGHT
+	d.drawText(PDF_MARGIN, d.y, "F1", PDF_FONT_TEXT, text)
+}
+
+func (d *pdfDocument) Space() {
+	d.y -= PDF_LINE_HEIGHT
+}
+
+// Rule draws a line across the page
+func (d *pdfDocument) Rule() {
+	d.y -= PDF_LINE_HEIGHT / 2
+	d.drawLine(PDF_MARGIN, d.y, PDF_PAGE_WIDTH-PDF_MARGIN, d.y)
+}
+
+// Paragraph writes text wrapped to the page width
+func (d *pdfDocument) Paragraph(text string) {
+	for _, line := range wrapText(text, PDF_FONT_TEXT, PDF_PAGE_WIDTH-2*PDF_MARGIN) {
+		d.Text(line)
+	}
+}
+
+// Fields writes label/value pairs two to a line, labels in bold
+func (d *pdfDocument) Fields(pairs [][2]string) {
+	half := (PDF_PAGE_WIDTH - 2*PDF_MARGIN) / 2
+	labelWidth := 0.0
+	for _, pair := range pairs {
+		if w := textWidth(pair[0], PDF_FONT_TEXT) + 2*PDF_CELL_PAD; w > labelWidth {
+			labelWidth = w
+		}
+	}
+	if labelWidth > half/2 {
+		labelWidth = half / 2
+	}
+
+	for i, pair := range pairs {
+		x := PDF_MARGIN
+		if i%2 == 1 {
+			x += half
+		} else {
+			d.ensure(PDF_LINE_HEIGHT)
+			d.y -= PDF_LINE_HEIGHT
+		}
+		d.drawText(x, d.y, "F2", PDF_FONT_TEXT, fitText(pair[0], PDF_FONT_TEXT, labelWidth-PDF_CELL_PAD))
+		d.drawText(x+labelWidth, d.y, "F1", PDF_FONT_TEXT, fitText(pair[1], PDF_FONT_TEXT, half-labelWidth-PDF_CELL_PAD))
+	}
+}
+
+// Signature draws a signing line on the right with the label above it and
+// the name below
+func (d *pdfDocument) Signature(label, name string, details ...string) {
+	width := 180.0
+	x := PDF_PAGE_WIDTH - PDF_MARGIN - width
+	d.ensure(float64(5+len(details)) * PDF_LINE_HEIGHT)
+	d.y -= PDF_LINE_HEIGHT
+	d.drawText(x, d.y, "F1", PDF_FONT_TEXT, label)
+	d.y -= 3 * PDF_LINE_HEIGHT
+	d.drawLine(x, d.y, x+width, d.y)
+	d.y -= PDF_LINE_HEIGHT
+	d.drawText(x, d.y+4, "F2", PDF_FONT_TEXT, fitText(name, PDF_FONT_TEXT, width))
+	for _, line := range details {
+		d.y -= PDF_LINE_HEIGHT
+		d.drawText(x, d.y+4, "F1", PDF_FONT_TEXT, fitText(line, PDF_FONT_TEXT, width))
+	}
+}
+
+// tableWidths sizes columns to their content, scaled down to fit the page
+func tableWidths(headers []string, rows [][]string) []float64 {
+	widths := make([]float64, len(headers))
+	total := 0.0
+	for c, h := range headers {
+		widths[c] = textWidth(h, PDF_FONT_TEXT) + 2*PDF_CELL_PAD
+		for _, row := range rows {
+			if w := textWidth(row[c], PDF_FONT_TEXT) + 2*PDF_CELL_PAD; w > widths[c] {
+				widths[c] = w
+			}
+		}
+		total += widths[c]
+	}
+
+	available := PDF_PAGE_WIDTH - 2*PDF_MARGIN
+	if total > available {
+		for c := range widths {
+			widths[c] = widths[c] * available / total
+		}
+	}
+	return widths
+}
+
+func (d *pdfDocument) tableRow(cells []string, numeric []bool, widths []float64, font string) {
+	d.y -= PDF_LINE_HEIGHT
+	x := PDF_MARGIN
+	for c, cell := range cells {
+		text := fitText(cell, PDF_FONT_TEXT, widths[c]-2*PDF_CELL_PAD)
+		tx := x + PDF_CELL_PAD
+		if numeric[c] {
+			tx = x + widths[c] - PDF_CELL_PAD - textWidth(text, PDF_FONT_TEXT)
+		}
+		d.drawText(tx, d.y+4, font, PDF_FONT_TEXT, text)
+		x += widths[c]
+	}
+}
+
+// Table draws a table, repeating the header row at the top of each page
+func (d *pdfDocument) Table(headers []string, numeric []bool, rows [][]string) {
+	widths := tableWidths(headers, rows)
+	tableWidth := 0.0
+	for _, w := range widths {
+		tableWidth += w
+	}
+
+	header := func() {
+		d.tableRow(headers, numeric, widths, "F2")
+		d.drawLine(PDF_MARGIN, d.y, PDF_MARGIN+tableWidth, d.y)
+	}
+
+	d.ensure(2 * PDF_LINE_HEIGHT)
+	header()
+	if len(rows) == 0 {
+		d.Text("(none)")
+		return
+	}
+	for _, row := range rows {
+		if d.y-PDF_LINE_HEIGHT < PDF_MARGIN+PDF_LINE_HEIGHT {
+			d.newPage()
+			header()
+		}
+		d.tableRow(row, numeric, widths, "F1")
+	}
+}
+
+// Bytes assembles the pages, with page numbers, into a PDF file
+func (d *pdfDocument) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then adds a
+	// page object and its content stream
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range d.pages {
+		footer := fmt.Sprintf(d.pageLabel, i+1, len(d.pages))
+		content := page.String() + fmt.Sprintf("BT /F1 8.0 Tf %.2f %.2f Td %s Tj ET\n",
+			PDF_PAGE_WIDTH-PDF_MARGIN-textWidth(footer, 8), PDF_MARGIN/2, pdfString(footer))
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PDF_PAGE_WIDTH, PDF_PAGE_HEIGHT, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	PRACTITIONER_ID_START = 90001
+
+	// Stations a patient passes through during a check-up
+	STATION_PHYSICAL  = "Physical Exam"
+	STATION_LAB       = "Laboratory"
+	STATION_RADIOLOGY = "Radiology"
+	STATION_ECG       = "ECG"
+	STATION_VISION    = "Vision & Hearing"
+)
+
+var STATIONS = []string{STATION_PHYSICAL, STATION_LAB, STATION_RADIOLOGY, STATION_ECG, STATION_VISION}
+
+var WEEKDAYS = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// Data structures
+// Practitioner is a doctor or other examiner. Username links the user
+// account that signs conclusions as this practitioner.
+type Practitioner struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Specialty string   `json:"specialty"`
+	LicenseNo string   `json:"license_no"`
+	Username  string   `json:"username,omitempty"`
+	Station   string   `json:"station,omitempty"`
+	Days      []string `json:"days,omitempty"`
+	Hours     string   `json:"hours,omitempty"`
+	BranchID  int      `json:"branch_id,omitempty"`
+}
+
+type PractitionerArray struct {
+	Daftar [NMAX]Practitioner `json:"daftar"`
+	N      int                `json:"n"`
+}
+
+// StationExaminer assigns a practitioner to one station of one record
+type StationExaminer struct {
+	Station        string `json:"station"`
+	PractitionerID int    `json:"practitioner_id"`
+}
+
+var practitioners PractitionerArray
+
+// Search and ID functions
+func binarySearchPractitionerByID(id int) int {
+	left, right := 0, practitioners.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if practitioners.Daftar[mid].ID == id {
+			return mid
+		} else if practitioners.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func getNextPractitionerID() int {
+	maxID := max(PRACTITIONER_ID_START-1, branchSync.CentralIDs["practitioner"])
+	for i := 0; i < practitioners.N; i++ {
+		if practitioners.Daftar[i].ID > maxID {
+			maxID = practitioners.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func practitionerByUsername(username string) int {
+	for i := 0; i < practitioners.N; i++ {
+		if practitioners.Daftar[i].Username != "" && practitioners.Daftar[i].Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func practitionerByLicense(license string) int {
+	for i := 0; i < practitioners.N; i++ {
+		if strings.EqualFold(practitioners.Daftar[i].LicenseNo, license) {
+			return i
+		}
+	}
+	return -1
+}
+
+func practitionerName(id int) string {
+	if id == 0 {
+		return "-"
+	}
+	if idx := binarySearchPractitionerByID(id); idx != -1 {
+		return practitioners.Daftar[idx].Name
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+// Station and schedule functions
+// examinationStation returns the station of an examination; examinations
+// without one are done in the laboratory
+func examinationStation(code string) string {
+	if idx := sequentialSearchExaminationByCode(code); idx != -1 && examinations.Daftar[idx].Station != "" {
+		return examinations.Daftar[idx].Station
+	}
+	return STATION_LAB
+}
+
+// recordStations lists the stations a record passes through, in station order
+func recordStations(r Record) []string {
+	used := make(map[string]bool)
+	for _, code := range r.Package.Examinations {
+		used[examinationStation(code)] = true
+	}
+	for _, res := range r.Results {
+		used[examinationStation(res.Code)] = true
+	}
+	for _, e := range r.Examiners {
+		used[e.Station] = true
+	}
+
+	var stations []string
+	for _, s := range STATIONS {
+		if used[s] {
+			stations = append(stations, s)
+		}
+	}
+	return stations
+}
+
+// weekdayOf returns the schedule day (Mon-Sun) of a DD/MM/YYYY date
+func weekdayOf(date string) string {
+	t, err := time.Parse("02/01/2006", date)
+	if err != nil {
+		return ""
+	}
+	return WEEKDAYS[(int(t.Weekday())+6)%7]
+}
+
+// isScheduled reports whether a practitioner works on the given date. A
+// practitioner without working days is available every day.
+func isScheduled(p Practitioner, date string) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	day := weekdayOf(date)
+	for _, d := range p.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDays reads working days such as "Mon,Wed,Fri" or "Mon-Fri"
+func parseDays(input string) ([]string, error) {
+	dayIndex := func(s string) int {
+		for i, d := range WEEKDAYS {
+			if strings.EqualFold(d, strings.TrimSpace(s)) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	selected := make([]bool, len(WEEKDAYS))
+	for _, part := range strings.Split(input, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		from := dayIndex(bounds[0])
+		to := from
+		if len(bounds) == 2 {
+			to = dayIndex(bounds[1])
+		}
+		if from == -1 || to == -1 || to < from {
+			return nil, fmt.Errorf("%q is not a day or range of days (Mon-Sun)", strings.TrimSpace(part))
+		}
+		for i := from; i <= to; i++ {
+			selected[i] = true
+		}
+	}
+
+	var days []string
+	for i, ok := range selected {
+		if ok {
+			days = append(days, WEEKDAYS[i])
+		}
+	}
+	return days, nil
+}
+
+// daysText writes working days compactly, with runs of three or more days
+// as ranges: "Mon-Fri,Sun"
+func daysText(days []string) string {
+	selected := make([]bool, len(WEEKDAYS)+1)
+	for _, d := range days {
+		for i, w := range WEEKDAYS {
+			if d == w {
+				selected[i] = true
+			}
+		}
+	}
+
+	var parts []string
+	for i := 0; i < len(WEEKDAYS); i++ {
+		if !selected[i] {
+			continue
+		}
+		j := i
+		for selected[j+1] {
+			j++
+		}
+		switch {
+		case j-i >= 2:
+			parts = append(parts, WEEKDAYS[i]+"-"+WEEKDAYS[j])
+		case j > i:
+			parts = append(parts, WEEKDAYS[i], WEEKDAYS[j])
+		default:
+			parts = append(parts, WEEKDAYS[i])
+		}
+		i = j
+	}
+	return strings.Join(parts, ",")
+}
+
+func scheduleText(p Practitioner) string {
+	days := "Every day"
+	if len(p.Days) > 0 {
+		days = daysText(p.Days)
+	}
+	if p.Hours != "" {
+		return days + " " + p.Hours
+	}
+	return days
+}
+
+// Assignment functions
+// stationExaminer returns who examines a record at a station: the
+// practitioner assigned to the record, otherwise the one scheduled at that
+// station of the record's branch on its date. It returns 0 when nobody is.
+func stationExaminer(r Record, station string) int {
+	for _, e := range r.Examiners {
+		if e.Station == station {
+			return e.PractitionerID
+		}
+	}
+	for i := 0; i < practitioners.N; i++ {
+		p := practitioners.Daftar[i]
+		if p.Station == station && offeredAt(p.BranchID, r.BranchID) && isScheduled(p, r.Date) {
+			return p.ID
+		}
+	}
+	return 0
+}
+
+// setStationExaminer assigns a practitioner to a station of a record; 0
+// removes the assignment so the station default applies again
+func setStationExaminer(r *Record, station string, id int) {
+	for i := range r.Examiners {
+		if r.Examiners[i].Station == station {
+			if id == 0 {
+				r.Examiners = append(r.Examiners[:i], r.Examiners[i+1:]...)
+			} else {
+				r.Examiners[i].PractitionerID = id
+			}
+			return
+		}
+	}
+	if id != 0 {
+		r.Examiners = append(r.Examiners, StationExaminer{Station: station, PractitionerID: id})
+	}
+}
+
+// isSignedOff reports whether a doctor has signed the conclusion; the
+// results of a signed-off record are locked
+func isSignedOff(r Record) bool {
+	return r.ConcludedBy != ""
+}
+
+func practitionerInUse(id int) bool {
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if r.DoctorID == id || r.SignedByID == id {
+			return true
+		}
+		for _, e := range r.Examiners {
+			if e.PractitionerID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func selectStation(prompt string, allowNone bool) string {
+	for i, s := range STATIONS {
+		fmt.Printf("%d. %s\n", i+1, s)
+	}
+	min := 1
+	if allowNone {
+		fmt.Println("0. None")
+		min = 0
+	}
+	choice := getValidInt(prompt, min, len(STATIONS))
+	if choice == 0 {
+		return ""
+	}
+	return STATIONS[choice-1]
+}
+
+// selectPractitioner returns the ID of the chosen practitioner working at a
+// branch, or 0
+func selectPractitioner(prompt string, branch int) int {
+	var staff []Practitioner
+	for i := 0; i < practitioners.N; i++ {
+		if p := practitioners.Daftar[i]; offeredAt(p.BranchID, branch) {
+			staff = append(staff, p)
+			fmt.Printf("%d. %s - %s (ID: %d)\n", len(staff), p.Name, p.Specialty, p.ID)
+		}
+	}
+	choice := getValidInt(prompt, 0, len(staff))
+	if choice == 0 {
+		return 0
+	}
+	return staff[choice-1].ID
+}
+
+func getDays(prompt string) []string {
+	for {
+		input := getValidInput(prompt)
+		if input == "-" {
+			return nil
+		}
+		days, err := parseDays(input)
+		if err == nil {
+			return days
+		}
+		printError(err.Error())
+	}
+}
+
+// getLinkedUser asks for the user account of a practitioner; "-" for none
+func getLinkedUser(prompt string, practitionerID int) string {
+	for {
+		username := getValidInput(prompt)
+		if username == "-" {
+			return ""
+		}
+		if sequentialSearchUserByUsername(username) == -1 {
+			printError("No user account with that username.")
+			continue
+		}
+		if idx := practitionerByUsername(username); idx != -1 && practitioners.Daftar[idx].ID != practitionerID {
+			printError(fmt.Sprintf("That account is already linked to %s.", practitioners.Daftar[idx].Name))
+			continue
+		}
+		return username
+	}
+}
+
+// Practitioner management functions
+func addPractitioner() {
+	printHeader("Add Practitioner")
+
+	if !requirePermission(PERM_PRACTITIONER_MANAGE) {
+		return
+	}
+
+	if practitioners.N >= NMAX {
+		printError("Cannot add more practitioners. Maximum capacity reached.")
+		pause()
+		return
+	}
+
+	p := Practitioner{
+		ID:        getNextPractitionerID(),
+		Name:      getValidInput("Enter name (e.g. dr. Sari Wijaya): "),
+		Specialty: getValidInput("Enter specialty: "),
+	}
+	p.LicenseNo = getValidInput("Enter license number: ")
+	if practitionerByLicense(p.LicenseNo) != -1 {
+		printError("A practitioner with this license number already exists.")
+		pause()
+		return
+	}
+
+	fmt.Println("\nUsual station:")
+	p.Station = selectStation("Choose station: ", true)
+	p.Days = getDays("Working days (e.g. Mon-Fri or Mon,Wed; - for every day): ")
+	p.Hours = templateText("Working hours (e.g. 08:00-14:00; - for none): ")
+	p.Username = getLinkedUser("User account that signs as this practitioner (- for none): ", p.ID)
+	p.BranchID = activeBranch
+	if branches.N > 0 && activeBranch == 0 {
+		fmt.Println("\nWorks at:")
+		p.BranchID = selectBranch("Choose branch (0 for all branches): ", "All branches")
+	}
+
+	practitioners.Daftar[practitioners.N] = p
+	practitioners.N++
+	auditLog(AUDIT_CREATE, "practitioner", p.ID, nil, p)
+
+	printSuccess(fmt.Sprintf("Practitioner added successfully with ID: %d", p.ID))
+	pause()
+}
+
+func displayPractitioners() {
+	printHeader("Practitioners")
+
+	if !requirePermission(PERM_RECORD_VIEW) {
+		return
+	}
+
+	if practitioners.N == 0 {
+		printWarning("No practitioners found.")
+		pause()
+		return
+	}
+
+	fmt.Printf("%s%-8s %-25s %-18s %-14s %-17s %-22s %-12s %-15s%s\n", BOLD,
+		"ID", "Name", "Specialty", "License", "Station", "Schedule", "Account", "Branch", RESET)
+	fmt.Println(strings.Repeat("-", 138))
+
+	for i := 0; i < practitioners.N; i++ {
+		p := practitioners.Daftar[i]
+		if !branchVisible(p.BranchID) {
+			continue
+		}
+		station, account := p.Station, p.Username
+		if station == "" {
+			station = "-"
+		}
+		if account == "" {
+			account = "-"
+		}
+		fmt.Printf("%-8d %-25s %-18s %-14s %-17s %-22s %-12s %-15s\n",
+			p.ID, p.Name, p.Specialty, p.LicenseNo, station, scheduleText(p), account, branchName(p.BranchID))
+	}
+
+	pause()
+}
+
+func editPractitioner() {
+	printHeader("Edit Practitioner")
+
+	if !requirePermission(PERM_PRACTITIONER_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter practitioner ID: ", 1, 999999)
+	idx := binarySearchPractitionerByID(id)
+
+	if idx == -1 || !branchVisible(practitioners.Daftar[idx].BranchID) {
+		printError("Practitioner not found.")
+		pause()
+		return
+	}
+
+	p := &practitioners.Daftar[idx]
+	before := *p
+	fmt.Printf("1. Name: %s\n", p.Name)
+	fmt.Printf("2. Specialty: %s\n", p.Specialty)
+	fmt.Printf("3. License number: %s\n", p.LicenseNo)
+	fmt.Printf("4. Station: %s\n", p.Station)
+	fmt.Printf("5. Schedule: %s\n", scheduleText(*p))
+	fmt.Printf("6. User account: %s\n", p.Username)
+	fmt.Printf("7. Branch: %s\n", branchName(p.BranchID))
+
+	choice := getValidInt("\nChoose field to change (0 to return): ", 0, 7)
+	switch choice {
+	case 0:
+		return
+	case 1:
+		p.Name = getValidInput("Enter name: ")
+	case 2:
+		p.Specialty = getValidInput("Enter specialty: ")
+	case 3:
+		license := getValidInput("Enter license number: ")
+		if other := practitionerByLicense(license); other != -1 && other != idx {
+			printError("A practitioner with this license number already exists.")
+			pause()
+			return
+		}
+		p.LicenseNo = license
+	case 4:
+		p.Station = selectStation("Choose station: ", true)
+	case 5:
+		p.Days = getDays("Working days (e.g. Mon-Fri or Mon,Wed; - for every day): ")
+		p.Hours = templateText("Working hours (e.g. 08:00-14:00; - for none): ")
+	case 6:
+		p.Username = getLinkedUser("User account (- for none): ", p.ID)
+	case 7:
+		if branches.N == 0 {
+			printError("No branches available. Please add the branches first.")
+			pause()
+			return
+		}
+		fmt.Println("Works at:")
+		p.BranchID = selectBranch("Choose branch (0 for all branches): ", "All branches")
+	}
+
+	auditLog(AUDIT_UPDATE, "practitioner", p.ID, before, *p)
+	printSuccess("Practitioner updated.")
+	pause()
+}
+
+func deletePractitioner() {
+	printHeader("Delete Practitioner")
+
+	if !requirePermission(PERM_PRACTITIONER_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter practitioner ID to delete: ", 1, 999999)
+	idx := binarySearchPractitionerByID(id)
+
+	if idx == -1 {
+		printError("Practitioner not found.")
+		pause()
+		return
+	}
+
+	p := practitioners.Daftar[idx]
+	if practitionerInUse(p.ID) {
+		printError(fmt.Sprintf("%s is assigned to medical records and cannot be deleted. Clear their station and schedule instead.", p.Name))
+		pause()
+		return
+	}
+
+	confirm := getValidInput(fmt.Sprintf("\nAre you sure you want to delete %s? (y/N): ", p.Name))
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Deletion cancelled.")
+		pause()
+		return
+	}
+
+	// Shift elements to remove the practitioner
+	for i := idx; i < practitioners.N-1; i++ {
+		practitioners.Daftar[i] = practitioners.Daftar[i+1]
+	}
+	practitioners.N--
+	auditLog(AUDIT_DELETE, "practitioner", p.ID, p, nil)
+
+	printSuccess("Practitioner deleted successfully.")
+	pause()
+}
+
+// Record assignment functions
+func printRecordExaminers(r Record) {
+	fmt.Printf("Examining doctor: %s\n", practitionerName(r.DoctorID))
+	for _, station := range recordStations(r) {
+		note := ""
+		id := stationExaminer(r, station)
+		assigned := false
+		for _, e := range r.Examiners {
+			assigned = assigned || e.Station == station
+		}
+		if id != 0 && !assigned {
+			note = " (station default)"
+		}
+		fmt.Printf("  %-17s %s%s\n", station+":", practitionerName(id), note)
+	}
+	if isSignedOff(r) {
+		fmt.Printf("%sSigned off by %s on %s - results are locked%s\n", BOLD, signerName(r), r.ConcludedAt, RESET)
+	}
+}
+
+// signerName names the practitioner who signed a record, or the user
+// account for conclusions signed before practitioners were recorded
+func signerName(r Record) string {
+	if r.SignedByID != 0 {
+		return practitionerName(r.SignedByID)
+	}
+	return r.ConcludedBy
+}
+
+func assignRecordExaminers() {
+	printHeader("Assign Examiners")
+
+	if !requirePermission(PERM_RECORD_ASSIGN) {
+		return
+	}
+
+	if practitioners.N == 0 {
+		printError("No practitioners available. Please add a practitioner first.")
+		pause()
+		return
+	}
+
+	id := getValidInt("Enter record ID: ", 1, 999999)
+	idx := searchBranchRecordByID(id)
+
+	if idx == -1 {
+		printError("Record not found.")
+		pause()
+		return
+	}
+
+	r := &records.Daftar[idx]
+	if isSignedOff(*r) {
+		printError(fmt.Sprintf("Record %d is signed off; reopen it before changing examiners.", r.ID))
+		pause()
+		return
+	}
+
+	before := map[string]interface{}{"doctor_id": r.DoctorID, "examiners": append([]StationExaminer(nil), r.Examiners...)}
+	for {
+		fmt.Printf("\nRecord %d - %s, %s (%s, %s)\n", r.ID, r.Patient.Name, r.Package.Name, r.Date, weekdayOf(r.Date))
+		printRecordExaminers(*r)
+
+		fmt.Println("\n1. Set examining doctor")
+		fmt.Println("2. Set station examiner")
+		fmt.Println("0. Done")
+		choice := getValidInt("Choose option: ", 0, 2)
+		if choice == 0 {
+			break
+		}
+
+		if choice == 1 {
+			r.DoctorID = selectPractitioner("Select doctor (0 for none): ", r.BranchID)
+			continue
+		}
+		fmt.Println("\nStations:")
+		station := selectStation("Choose station: ", false)
+		setStationExaminer(r, station, selectPractitioner("Select examiner (0 for the station default): ", r.BranchID))
+	}
+
+	after := map[string]interface{}{"doctor_id": r.DoctorID, "examiners": r.Examiners}
+	if !sameValue(before, after) {
+		auditLog(AUDIT_UPDATE, "record_examiners", r.ID, before, after)
+		publishEvent(ENTITY_RECORD+"."+CHANGE_UPDATED, ENTITY_RECORD, r.ID, *r)
+		printSuccess("Examiners saved.")
+	}
+	pause()
+}
+
+func reopenSignedRecord() {
+	printHeader("Reopen Signed Record")
+
+	if !requirePermission(PERM_RESULT_SIGN) {
+		return
+	}
+
+	id := getValidInt("Enter record ID: ", 1, 999999)
+	idx := searchBranchRecordByID(id)
+
+	if idx == -1 {
+		printError("Record not found.")
+		pause()
+		return
+	}
+
+	r := &records.Daftar[idx]
+	if !isSignedOff(*r) {
+		printWarning("This record is not signed off.")
+		pause()
+		return
+	}
+
+	fmt.Printf("\nRecord %d was signed off by %s on %s.\n", r.ID, signerName(*r), r.ConcludedAt)
+	reason := getValidInput("Reason for reopening: ")
+	confirm := getValidInput("Reopen the record and unlock its results? (y/N): ")
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Record unchanged.")
+		pause()
+		return
+	}
+
+	before := map[string]interface{}{"by": r.ConcludedBy, "at": r.ConcludedAt, "signed_by_id": r.SignedByID}
+	beforeRecord := *r
+	r.ConcludedBy, r.ConcludedAt, r.SignedByID = "", "", 0
+	auditLog(AUDIT_UPDATE, "record_signoff", r.ID, before, map[string]string{"reopened_reason": reason})
+	publishChange(ENTITY_RECORD, r.ID, beforeRecord, *r)
+	queueRecordChange(beforeRecord, *r)
+
+	printSuccess("Record reopened. The conclusion is kept as a draft until it is signed again.")
+	deliverNotificationsSoon()
+	pause()
+}
+
+// Report functions
+// workloadReport counts, per practitioner, the records they were the
+// examining doctor for, the station examinations they did and the
+// conclusions they signed in one month (MM/YYYY), or all of them, at a
+// branch or (0) all of the user's branches
+func workloadReport(period string, branch int) Report {
+	report := newReport("workload", "Workload by Doctor")
+	report.Period = period
+	setReportBranch(&report, branch)
+
+	doctor := make(map[int]int)
+	stations := make(map[int]int)
+	signed := make(map[int]int)
+	count, unassigned, unsigned := 0, 0, 0
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if !inPeriod(r.Date, period) || !inBranch(r.BranchID, branch) {
+			continue
+		}
+		count++
+		doctor[r.DoctorID]++
+		for _, station := range recordStations(r) {
+			if id := stationExaminer(r, station); id != 0 {
+				stations[id]++
+			} else {
+				unassigned++
+			}
+		}
+		if r.SignedByID != 0 {
+			signed[r.SignedByID]++
+		} else if !isSignedOff(r) {
+			unsigned++
+		}
+	}
+
+	section := ReportSection{Title: "Workload by Doctor", Columns: []ReportColumn{
+		{Key: "id", Header: "ID", Numeric: true},
+		{Key: "name", Header: "Name"},
+		{Key: "specialty", Header: "Specialty"},
+		{Key: "records", Header: "Records", Numeric: true},
+		{Key: "station_exams", Header: "Station Exams", Numeric: true},
+		{Key: "signed", Header: "Signed Off", Numeric: true},
+		{Key: "percent", Header: "Records %", Numeric: true},
+	}}
+	for i := 0; i < practitioners.N; i++ {
+		p := practitioners.Daftar[i]
+		if !offeredAt(p.BranchID, branch) {
+			continue
+		}
+		section.Rows = append(section.Rows, []string{strconv.Itoa(p.ID), p.Name, p.Specialty,
+			strconv.Itoa(doctor[p.ID]), strconv.Itoa(stations[p.ID]), strconv.Itoa(signed[p.ID]),
+			percent(float64(doctor[p.ID]), float64(count))})
+	}
+
+	report.Sections = []ReportSection{
+		{Title: "Summary", Columns: summaryColumns, Summary: true, Rows: [][]string{
+			{"Total Records", strconv.Itoa(count)},
+			{"Records without Examining Doctor", strconv.Itoa(doctor[0])},
+			{"Station Exams without Examiner", strconv.Itoa(unassigned)},
+			{"Records not Signed Off", strconv.Itoa(unsigned)},
+		}},
+		section,
+	}
+	return report
+}
+
+func generateWorkloadReport() {
+	printHeader("Workload by Doctor")
+
+	if !requirePermission(PERM_PRACTITIONER_MANAGE) {
+		return
+	}
+
+	if practitioners.N == 0 {
+		printWarning("No practitioners found.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "report_workload", 0, nil, nil)
+
+	report := workloadReport(getPeriod(), getReportBranch())
+	printReport(report)
+	offerExport(report)
+}
+
+func practitionerManagement() {
+	for {
+		printHeader("Practitioners")
+		fmt.Printf("%s1.%s Add Practitioner\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Display Practitioners\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Edit Practitioner\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Delete Practitioner\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Workload by Doctor Report\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 5)
+
+		switch choice {
+		case 1:
+			addPractitioner()
+		case 2:
+			displayPractitioners()
+		case 3:
+			editPractitioner()
+		case 4:
+			deletePractitioner()
+		case 5:
+			generateWorkloadReport()
+		case 0:
+			return
+		}
+	}
+}
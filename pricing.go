@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constants
+const (
+	PRICE_HISTORY_START_DATE = "01/01/1900"
+)
+
+// Data structures
+type PriceVersion struct {
+	Version       int     `json:"version"`
+	EffectiveDate string  `json:"effective_date"`
+	Price         float64 `json:"price"`
+}
+
+// Price history functions
+func ensurePriceHistory(pkg *Package) {
+	if len(pkg.PriceHistory) == 0 {
+		pkg.PriceHistory = []PriceVersion{
+			{Version: 1, EffectiveDate: PRICE_HISTORY_START_DATE, Price: pkg.Price},
+		}
+	}
+}
+
+func getNextPriceVersion(pkg Package) int {
+	maxVersion := 0
+	for _, v := range pkg.PriceHistory {
+		if v.Version > maxVersion {
+			maxVersion = v.Version
+		}
+	}
+	return maxVersion + 1
+}
+
+// addPriceVersion keeps the history ordered by effective date (oldest first).
+// A second change on the same date corrects that version in place, so records
+// stamped with its number still find it.
+func addPriceVersion(pkg *Package, effectiveDate string, price float64) PriceVersion {
+	ensurePriceHistory(pkg)
+
+	for i := range pkg.PriceHistory {
+		if compareDates(pkg.PriceHistory[i].EffectiveDate, effectiveDate) == 0 {
+			pkg.PriceHistory[i].Price = price
+			refreshCurrentPrice(pkg)
+			return pkg.PriceHistory[i]
+		}
+	}
+
+	version := PriceVersion{
+		Version:       getNextPriceVersion(*pkg),
+		EffectiveDate: effectiveDate,
+		Price:         price,
+	}
+	pkg.PriceHistory = append(pkg.PriceHistory, version)
+
+	// Insertion sort by effective date
+	for i := 1; i < len(pkg.PriceHistory); i++ {
+		key := pkg.PriceHistory[i]
+		j := i - 1
+		for j >= 0 && compareDates(pkg.PriceHistory[j].EffectiveDate, key.EffectiveDate) > 0 {
+			pkg.PriceHistory[j+1] = pkg.PriceHistory[j]
+			j--
+		}
+		pkg.PriceHistory[j+1] = key
+	}
+
+	refreshCurrentPrice(pkg)
+	return version
+}
+
+// getPriceAtDate returns the price version in effect on the given date
+func getPriceAtDate(pkg Package, date string) (PriceVersion, bool) {
+	found := false
+	var result PriceVersion
+	for _, v := range pkg.PriceHistory {
+		if compareDates(v.EffectiveDate, date) <= 0 {
+			result = v
+			found = true
+		}
+	}
+
+	if !found && len(pkg.PriceHistory) == 0 {
+		return PriceVersion{Version: 0, EffectiveDate: PRICE_HISTORY_START_DATE, Price: pkg.Price}, true
+	}
+	return result, found
+}
+
+// refreshCurrentPrice keeps Package.Price equal to the price in effect today
+func refreshCurrentPrice(pkg *Package) {
+	if v, ok := getPriceAtDate(*pkg, todayDate()); ok {
+		pkg.Price = v.Price
+	}
+}
+
+func refreshAllCurrentPrices() {
+	for i := 0; i < packages.N; i++ {
+		ensurePriceHistory(&packages.Daftar[i])
+		refreshCurrentPrice(&packages.Daftar[i])
+	}
+}
+
+// recordPrice returns the price stamped on a record after any discount, falling
+// back to the embedded package price for records created before price versioning
+func recordPrice(r Record) float64 {
+	price := r.Price
+	if r.PriceVersion == 0 {
+		price = r.Package.Price
+	}
+	return price - r.DiscountAmount
+}
+
+func stampRecordPrice(r *Record) {
+	if v, ok := getPriceAtDate(r.Package, r.Date); ok {
+		r.PriceVersion = v.Version
+		r.Price = v.Price
+	} else {
+		r.PriceVersion = 0
+		r.Price = r.Package.Price
+	}
+}
+
+// Price history menu functions
+func displayPriceHistory() {
+	printHeader("Package Price History")
+
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
+	id := getValidInt("Enter package ID: ", 1, 999999)
+	idx := binarySearchPackageByID(id)
+
+	if idx == -1 {
+		printError("Package not found.")
+		pause()
+		return
+	}
+
+	p := packages.Daftar[idx]
+	auditLog(AUDIT_VIEW, "price_history", p.ID, nil, nil)
+	today := todayDate()
+	fmt.Printf("\n%sPrice history for %s (ID: %d):%s\n", BOLD, p.Name, p.ID, RESET)
+	fmt.Printf("\n%s%-10s %-16s %-12s %-10s%s\n", BOLD, "Version", "Effective Date", "Price", "Status", RESET)
+	fmt.Println(strings.Repeat("-", 52))
+
+	current, _ := getPriceAtDate(p, today)
+	for _, v := range p.PriceHistory {
+		status := "Past"
+		if v.Version == current.Version {
+			status = "Current"
+		} else if compareDates(v.EffectiveDate, today) > 0 {
+			status = "Scheduled"
+		}
+		fmt.Printf("%-10d %-16s $%-11.2f %-10s\n", v.Version, v.EffectiveDate, v.Price, status)
+	}
+
+	pause()
+}
+
+func lookupPriceAtDate() {
+	printHeader("Price Lookup by Date")
+
+	if !requirePermission(PERM_PACKAGE_VIEW) {
+		return
+	}
+
+	id := getValidInt("Enter package ID: ", 1, 999999)
+	idx := binarySearchPackageByID(id)
+
+	if idx == -1 {
+		printError("Package not found.")
+		pause()
+		return
+	}
+
+	p := packages.Daftar[idx]
+	date := getValidDate("Enter date")
+	auditLog(AUDIT_VIEW, "price_history", p.ID, nil, nil)
+
+	if v, ok := getPriceAtDate(p, date); ok {
+		fmt.Printf("\n%sPrice on %s:%s\n", GREEN, date, RESET)
+		fmt.Printf("Package: %s (ID: %d)\n", p.Name, p.ID)
+		fmt.Printf("Price: $%.2f\n", v.Price)
+		fmt.Printf("Version: %d (effective %s)\n", v.Version, v.EffectiveDate)
+	} else {
+		printError("No price was in effect on that date.")
+	}
+
+	pause()
+}
+
+func schedulePriceChange(pkg *Package) {
+	if !checkPermission(PERM_PRICE_EDIT) {
+		return
+	}
+
+	price := getValidFloat("Enter new price: ", 0.0)
+	fmt.Println("Effective from: 1. Today  2. Another date")
+	choice := getValidInt("Choose option: ", 1, 2)
+
+	effectiveDate := todayDate()
+	if choice == 2 {
+		effectiveDate = getValidDate("Enter effective date")
+	}
+
+	v := addPriceVersion(pkg, effectiveDate, price)
+	if compareDates(effectiveDate, todayDate()) > 0 {
+		printWarning(fmt.Sprintf("Price $%.2f scheduled as version %d, effective %s.", v.Price, v.Version, v.EffectiveDate))
+	}
+}
@@ -0,0 +1,904 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	RETENTION_PURGE     = "purge"
+	RETENTION_ANONYMISE = "anonymise"
+	ANONYMISED_NAME     = "[anonymised]"
+	ERASED_NAME         = "[erased]"
+	ERASURE_CONFLICT    = "erased, so changes made to it elsewhere were dropped"
+)
+
+// Data structures
+type RetentionPolicy struct {
+	Years   int    `json:"years"`
+	Mode    string `json:"mode"`
+	LastRun string `json:"last_run,omitempty"`
+}
+
+// Erasure is what a patient erasure leaves in the data file. Every merge
+// applies it again, so a copy of the patient that another terminal or branch
+// changed in the meantime does not bring the patient back.
+type Erasure struct {
+	PatientID      int    `json:"patient_id"`
+	Records        []int  `json:"records,omitempty"`
+	RecordsDeleted bool   `json:"records_deleted,omitempty"`
+	ErasedAt       string `json:"erased_at"`
+}
+
+var (
+	retentionPolicy RetentionPolicy
+	erasures        []Erasure
+)
+
+// Retention functions
+// retentionCutoff returns the first date that is still kept; records dated
+// before it have passed the retention period
+func retentionCutoff(today string, years int) string {
+	t, err := parseDate(today)
+	if err != nil {
+		return today
+	}
+	return t.AddDate(-years, 0, 0).Format("02/01/2006")
+}
+
+func isAnonymised(r Record) bool {
+	return r.Patient.ID == 0
+}
+
+// anonymiseRecord removes the patient's identity from a record. Gender, age
+// and results stay for statistics.
+func anonymiseRecord(r *Record) {
+	r.Patient = Patient{
+		Name:   ANONYMISED_NAME,
+		Gender: r.Patient.Gender,
+		Age:    r.Patient.Age,
+	}
+	r.ClaimNote = ""
+	r.Conclusion = ""
+	r.Findings = nil
+	r.Recommendations = ""
+}
+
+// eraseRecord removes everything about the patient from a record that is kept
+// for the accounts
+func eraseRecord(r *Record) {
+	r.Patient = Patient{Name: ERASED_NAME}
+	r.Results = nil
+	r.ClaimNote = ""
+	setConclusion(r, ConclusionDraft{})
+}
+
+func erasureKey(e Erasure) string { return fmt.Sprintf("%d-%s", e.PatientID, e.ErasedAt) }
+
+// maxErasedID is the highest patient or record ID erased. New items stay
+// above it, since the erasure would remove them.
+func maxErasedID(list []Erasure, entity string) int {
+	maxID := 0
+	for _, e := range list {
+		switch entity {
+		case ENTITY_PATIENT:
+			maxID = max(maxID, e.PatientID)
+		case ENTITY_RECORD:
+			for _, id := range e.Records {
+				maxID = max(maxID, id)
+			}
+		}
+	}
+	return maxID
+}
+
+// isErasedItem reports whether a patient or record was erased
+func isErasedItem(list []Erasure, entity, key string) bool {
+	id, _ := strconv.Atoi(key)
+	for _, e := range list {
+		if entity == ENTITY_PATIENT && id == e.PatientID {
+			return true
+		}
+		if entity == ENTITY_RECORD {
+			for _, rid := range e.Records {
+				if rid == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// applyErasures takes erased patients out of a merged store again. Each copy
+// it has to remove is reported in place of the conflicts the merge found
+// about the patient; the removed data is left out so it does not reach the
+// audit trail.
+func applyErasures(d *DataStore, conflicts *[]DataConflict) {
+	var drops []DataConflict
+	dropped := func(entity string, id int) {
+		drops = append(drops, DataConflict{Entity: entity, Key: strconv.Itoa(id), Reason: ERASURE_CONFLICT})
+	}
+
+	for _, e := range d.Erasures {
+		erased := make(map[int]bool)
+		for _, id := range e.Records {
+			erased[id] = true
+		}
+
+		kept := 0
+		for i := 0; i < d.Patients.N; i++ {
+			if p := d.Patients.Daftar[i]; p.ID == e.PatientID {
+				dropped(ENTITY_PATIENT, p.ID)
+				continue
+			}
+			d.Patients.Daftar[kept] = d.Patients.Daftar[i]
+			kept++
+		}
+		for i := kept; i < d.Patients.N; i++ {
+			d.Patients.Daftar[i] = Patient{}
+		}
+		d.Patients.N = kept
+
+		kept = 0
+		for i := 0; i < d.Records.N; i++ {
+			r := d.Records.Daftar[i]
+			if erased[r.ID] || r.Patient.ID == e.PatientID {
+				erased[r.ID] = true
+				if e.RecordsDeleted {
+					dropped(ENTITY_RECORD, r.ID)
+					continue
+				}
+				cleared := r
+				eraseRecord(&cleared)
+				if !sameContent(cleared, r) {
+					dropped(ENTITY_RECORD, r.ID)
+					d.Records.Daftar[i] = cleared
+				}
+			}
+			d.Records.Daftar[kept] = d.Records.Daftar[i]
+			kept++
+		}
+		for i := kept; i < d.Records.N; i++ {
+			d.Records.Daftar[i] = Record{}
+		}
+		d.Records.N = kept
+
+		for i := 0; i < d.Companies.N; i++ {
+			removeEmployee(&d.Companies.Daftar[i], e.PatientID)
+		}
+
+		kept = 0
+		for i := 0; i < d.Trash.N; i++ {
+			if !trashMentionsPatient(d.Trash.Daftar[i], e.PatientID) {
+				d.Trash.Daftar[kept] = d.Trash.Daftar[i]
+				kept++
+			}
+		}
+		for i := kept; i < d.Trash.N; i++ {
+			d.Trash.Daftar[i] = TrashItem{}
+		}
+		d.Trash.N = kept
+
+		for i := range d.Events.Events {
+			ev := &d.Events.Events[i]
+			if (ev.Entity == ENTITY_PATIENT && ev.EntityID == e.PatientID && ev.Type != ENTITY_PATIENT+"."+CHANGE_DELETED) ||
+				(ev.Entity == ENTITY_RECORD && erased[ev.EntityID]) {
+				ev.Data = json.RawMessage(fmt.Sprintf(`{"id":%d,"redacted":true}`, ev.EntityID))
+			}
+		}
+	}
+
+	// The copy of the last central sync and its open conflicts go as well,
+	// also from the central store settings a conflict reports
+	scrubErasedSync(&d.BranchSync, d.Erasures)
+	for i, c := range *conflicts {
+		for _, v := range []*interface{}{&c.Saved, &c.Local} {
+			if settings, ok := (*v).(BranchSyncSettings); ok {
+				scrubErasedSync(&settings, d.Erasures)
+				*v = settings
+			}
+		}
+		(*conflicts)[i] = c
+	}
+
+	var kept []DataConflict
+	for _, c := range *conflicts {
+		if c.NewID != 0 || !(isErasedItem(d.Erasures, c.Entity, c.Key) || erasedTrashConflict(d.Erasures, c)) {
+			kept = append(kept, c)
+			continue
+		}
+		reported := false
+		for _, drop := range drops {
+			reported = reported || (drop.Entity == c.Entity && drop.Key == c.Key)
+		}
+		if !reported {
+			drops = append(drops, DataConflict{Entity: c.Entity, Key: c.Key, Reason: ERASURE_CONFLICT})
+		}
+	}
+	*conflicts = append(kept, drops...)
+}
+
+// erasedRecordIDs lists the records of erased patients: those recorded with
+// the erasure and any of the given ones made for the patient elsewhere
+func erasedRecordIDs(list []Erasure, lists ...[]Record) map[int]bool {
+	erased := make(map[int]bool)
+	patientIDs := make(map[int]bool)
+	for _, e := range list {
+		patientIDs[e.PatientID] = true
+		for _, id := range e.Records {
+			erased[id] = true
+		}
+	}
+	for _, recs := range lists {
+		for _, r := range recs {
+			if patientIDs[r.Patient.ID] {
+				erased[r.ID] = true
+			}
+		}
+	}
+	return erased
+}
+
+// isErasedSyncConflict reports whether a sync conflict is about an erased
+// patient or one of the erased records
+func isErasedSyncConflict(list []Erasure, erasedRecords map[int]bool, c SyncConflict) bool {
+	return (c.Entity == ENTITY_RECORD && erasedRecords[c.ItemID]) || isErasedItem(list, c.Entity, strconv.Itoa(c.ItemID))
+}
+
+// scrubErasedSync takes erased patients out of the central store settings:
+// the copy of the last sync and the sync conflicts waiting to be resolved.
+// It returns the number of conflicts removed.
+func scrubErasedSync(s *BranchSyncSettings, list []Erasure) int {
+	if len(list) == 0 {
+		return 0
+	}
+	var baseRecords []Record
+	if s.Base != nil {
+		baseRecords = s.Base.Records
+	}
+	erasedRecords := erasedRecordIDs(list, baseRecords)
+
+	if s.Base != nil {
+		base := *s.Base
+		base.Patients = nil
+		for _, p := range s.Base.Patients {
+			if !isErasedItem(list, ENTITY_PATIENT, strconv.Itoa(p.ID)) {
+				base.Patients = append(base.Patients, p)
+			}
+		}
+		base.Records = nil
+		for _, r := range s.Base.Records {
+			if erasedRecords[r.ID] {
+				if recordsDeletedOnErasure(list, r) {
+					continue
+				}
+				eraseRecord(&r)
+			}
+			base.Records = append(base.Records, r)
+		}
+		s.Base = &base
+	}
+
+	var kept []SyncConflict
+	for _, c := range s.Conflicts {
+		if !isErasedSyncConflict(list, erasedRecords, c) {
+			kept = append(kept, c)
+		}
+	}
+	removed := len(s.Conflicts) - len(kept)
+	s.Conflicts = kept
+	return removed
+}
+
+// recordsDeletedOnErasure reports whether the erasure that covers a record
+// deleted the patient's records rather than keeping them for the accounts
+func recordsDeletedOnErasure(list []Erasure, r Record) bool {
+	for _, e := range list {
+		if r.Patient.ID == e.PatientID {
+			return e.RecordsDeleted
+		}
+		for _, id := range e.Records {
+			if id == r.ID {
+				return e.RecordsDeleted
+			}
+		}
+	}
+	return false
+}
+
+// erasedTrashConflict reports whether a conflict is about an erased
+// patient's item in the trash
+func erasedTrashConflict(list []Erasure, c DataConflict) bool {
+	for _, v := range []interface{}{c.Saved, c.Local} {
+		if item, ok := v.(TrashItem); ok {
+			for _, e := range list {
+				if trashMentionsPatient(item, e.PatientID) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func removeRecordAt(idx int) {
+	for i := idx; i < records.N-1; i++ {
+		records.Daftar[i] = records.Daftar[i+1]
+	}
+	records.N--
+}
+
+// expiredRecordIDs lists the records dated before the cutoff that the mode
+// still has work to do on
+func expiredRecordIDs(cutoff, mode string) []int {
+	var ids []int
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if compareDates(r.Date, cutoff) >= 0 {
+			continue
+		}
+		if mode == RETENTION_ANONYMISE && isAnonymised(r) {
+			continue
+		}
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// applyRetention purges or anonymises the given records and returns how many were changed
+func applyRetention(ids map[int]bool, mode string) int {
+	changed := 0
+	for i := records.N - 1; i >= 0; i-- {
+		if !ids[records.Daftar[i].ID] {
+			continue
+		}
+		id := records.Daftar[i].ID
+		if mode == RETENTION_PURGE {
+			removeRecordAt(i)
+			publishEvent(ENTITY_RECORD+"."+CHANGE_DELETED, ENTITY_RECORD, id, RecordEventData{ID: id})
+		} else {
+			anonymiseRecord(&records.Daftar[i])
+			publishEvent(ENTITY_RECORD+"."+CHANGE_UPDATED, ENTITY_RECORD, id, records.Daftar[i])
+		}
+		changed++
+	}
+	return changed
+}
+
+// recordMentioned reports whether an audit entry holds a copy of one of the records
+func recordMentioned(e AuditEntry, ids map[int]bool) bool {
+	return strings.HasPrefix(e.Entity, "record") && ids[e.EntityID]
+}
+
+func patientMentioned(e AuditEntry, patientID int) bool {
+	if (e.Entity == "patient" || e.Entity == "recall_reminder" || e.Entity == "notification") && e.EntityID == patientID {
+		return true
+	}
+	embedded := fmt.Sprintf(`"patient":{"id":%d,`, patientID)
+	return strings.Contains(plainAuditValue(e.Before), embedded) || strings.Contains(plainAuditValue(e.After), embedded)
+}
+
+// scrubClaimFiles removes patient details from the claim batch files kept for
+// the given records. Record IDs and amounts stay for the accounts.
+func scrubClaimFiles(ids map[int]bool) ([]string, error) {
+	var changed []string
+
+	jsonFiles, _ := filepath.Glob("CLM-*.json")
+	for _, name := range jsonFiles {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return changed, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		var batch ClaimBatch
+		if err := json.Unmarshal(content, &batch); err != nil {
+			continue
+		}
+		scrubbed := false
+		for i := range batch.Lines {
+			if ids[batch.Lines[i].RecordID] && batch.Lines[i].PatientName != ERASED_NAME {
+				batch.Lines[i].PatientID = 0
+				batch.Lines[i].PatientName = ERASED_NAME
+				batch.Lines[i].MemberNumber = ""
+				scrubbed = true
+			}
+		}
+		if scrubbed {
+			if err := writeClaimBatchJSON(batch, name); err != nil {
+				return changed, err
+			}
+			changed = append(changed, name)
+		}
+	}
+
+	csvFiles, _ := filepath.Glob("CLM-*.csv")
+	for _, name := range csvFiles {
+		file, err := os.Open(name)
+		if err != nil {
+			return changed, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		rows, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			continue
+		}
+		// Columns: batch_id, record_id, patient_id, member_number, patient_name, ...
+		scrubbed := false
+		for i, row := range rows {
+			if i == 0 || len(row) < 5 {
+				continue
+			}
+			if id, err := strconv.Atoi(row[1]); err == nil && ids[id] && row[4] != ERASED_NAME {
+				row[2], row[3], row[4] = "0", "", ERASED_NAME
+				scrubbed = true
+			}
+		}
+		if scrubbed {
+			if err := writeCSVFile(name, rows); err != nil {
+				return changed, err
+			}
+			changed = append(changed, name)
+		}
+	}
+
+	return changed, nil
+}
+
+// deleteResultSheets removes the result sheet PDFs printed for the given
+// records
+func deleteResultSheets(ids map[int]bool) ([]string, error) {
+	var deleted []string
+	files, _ := filepath.Glob("result-*.pdf")
+	for _, name := range files {
+		// Named result-<record>-<lang>-<time>.pdf
+		parts := strings.SplitN(strings.TrimPrefix(name, "result-"), "-", 2)
+		if id, err := strconv.Atoi(parts[0]); err != nil || !ids[id] {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %v", name, err)
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// fhirEntryMentions reports whether a bundle entry is the patient, one of
+// the records, or refers to either
+func fhirEntryMentions(entry FHIRBundleEntry, patientID int, ids map[int]bool) bool {
+	refs := []string{entry.FullURL}
+	var res struct {
+		Subject   *FHIRReference `json:"subject"`
+		Encounter *FHIRReference `json:"encounter"`
+	}
+	if json.Unmarshal(entry.Resource, &res) == nil {
+		if res.Subject != nil {
+			refs = append(refs, res.Subject.Reference)
+		}
+		if res.Encounter != nil {
+			refs = append(refs, res.Encounter.Reference)
+		}
+	}
+	for _, ref := range refs {
+		if ref == fmt.Sprintf("Patient/patient-%d", patientID) {
+			return true
+		}
+		if rid, ok := strings.CutPrefix(ref, "Encounter/record-"); ok {
+			if id, err := strconv.Atoi(rid); err == nil && ids[id] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scrubFHIRBundles takes the patient and the given records out of the FHIR
+// bundles exported earlier. A bundle left with no entries is deleted.
+func scrubFHIRBundles(patientID int, ids map[int]bool) (scrubbed, deleted []string, err error) {
+	files, _ := filepath.Glob("fhir-bundle-*.json")
+	for _, name := range files {
+		bundle, err := readFHIRBundle(name)
+		if err != nil {
+			continue
+		}
+		var kept []FHIRBundleEntry
+		for _, entry := range bundle.Entry {
+			if !fhirEntryMentions(entry, patientID, ids) {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == len(bundle.Entry) {
+			continue
+		}
+		if len(kept) == 0 {
+			if err := os.Remove(name); err != nil {
+				return scrubbed, deleted, fmt.Errorf("failed to delete %s: %v", name, err)
+			}
+			deleted = append(deleted, name)
+			continue
+		}
+		bundle.Entry = kept
+		if err := writeFHIRBundle(bundle, name); err != nil {
+			return scrubbed, deleted, err
+		}
+		scrubbed = append(scrubbed, name)
+	}
+	return scrubbed, deleted, nil
+}
+
+// reportsNaming lists the report exports that show the patient's name in
+// any of the encodings the export formats use. Reports mix many patients,
+// so they are not changed here.
+func reportsNaming(name string) []string {
+	if name == "" || name == ERASED_NAME || name == ANONYMISED_NAME {
+		return nil
+	}
+	quoted, _ := json.Marshal(name)
+	forms := []string{name, html.EscapeString(name), strings.Trim(string(quoted), `"`), strings.Trim(pdfString(name), "()")}
+
+	var found []string
+	files, _ := filepath.Glob("report-*.*")
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			found = append(found, file)
+			continue
+		}
+		for _, form := range forms {
+			if strings.Contains(string(content), form) {
+				found = append(found, file)
+				break
+			}
+		}
+	}
+	return found
+}
+
+func describeRetentionPolicy(p RetentionPolicy) string {
+	if p.Years == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s records older than %d years", p.Mode, p.Years)
+}
+
+func editRetentionPolicy() {
+	printHeader("Retention Policy")
+
+	if !requirePermission(PERM_PRIVACY_MANAGE) {
+		return
+	}
+
+	fmt.Printf("Current policy: %s\n\n", describeRetentionPolicy(retentionPolicy))
+	before := retentionPolicy
+
+	retentionPolicy.Years = getValidInt("Retention period in years (0 to disable): ", 0, 100)
+	if retentionPolicy.Years > 0 {
+		fmt.Println("When records expire: 1. Anonymise (keep for statistics)  2. Purge (delete)")
+		if getValidInt("Choose option: ", 1, 2) == 1 {
+			retentionPolicy.Mode = RETENTION_ANONYMISE
+		} else {
+			retentionPolicy.Mode = RETENTION_PURGE
+		}
+	}
+
+	auditLog(AUDIT_UPDATE, "retention_policy", 0, before, retentionPolicy)
+	printSuccess(fmt.Sprintf("Retention policy: %s.", describeRetentionPolicy(retentionPolicy)))
+	pause()
+}
+
+// runRetention applies the policy and scrubs the journal and claim files.
+// It returns a summary of what was done.
+func runRetention() []string {
+	cutoff := retentionCutoff(todayDate(), retentionPolicy.Years)
+	idList := expiredRecordIDs(cutoff, retentionPolicy.Mode)
+	ids := make(map[int]bool)
+	for _, id := range idList {
+		ids[id] = true
+	}
+
+	var summary []string
+	changed := applyRetention(ids, retentionPolicy.Mode)
+	summary = append(summary, fmt.Sprintf("Records dated before %s: %d %s", cutoff, changed, retentionPolicy.Mode+"d"))
+
+	// Expired records in the trash are purged whatever the mode
+	purged, trashedRecords := purgeTrash(func(item TrashItem) bool {
+		return item.Record != nil && compareDates(item.Record.Date, cutoff) < 0
+	})
+	for _, id := range trashedRecords {
+		ids[id] = true
+	}
+	if purged > 0 {
+		summary = append(summary, fmt.Sprintf("Expired records purged from the trash: %d", purged))
+	}
+	if changed+purged == 0 {
+		return summary
+	}
+	forgetUndoHistory(func(op UndoOp) bool { return op.Entity == ENTITY_RECORD && ids[op.ID] })
+	redactEvents(func(ev *DomainEvent) bool { return ev.Entity == ENTITY_RECORD && ids[ev.EntityID] })
+
+	if n, err := redactAuditLog(func(e AuditEntry) bool { return recordMentioned(e, ids) }); err != nil {
+		summary = append(summary, fmt.Sprintf("Audit log: %v", err))
+	} else {
+		summary = append(summary, fmt.Sprintf("Audit log entries redacted: %d", n))
+	}
+
+	if files, err := scrubClaimFiles(ids); err != nil {
+		summary = append(summary, fmt.Sprintf("Claim files: %v", err))
+	} else {
+		summary = append(summary, fmt.Sprintf("Claim files scrubbed: %d", len(files)))
+	}
+
+	auditLog(AUDIT_ERASE, "retention", 0, nil, map[string]interface{}{
+		"cutoff": cutoff, "mode": retentionPolicy.Mode, "records": idList,
+	})
+	return summary
+}
+
+func runRetentionJob() {
+	printHeader("Run Retention Job")
+
+	if !requirePermission(PERM_PRIVACY_MANAGE) {
+		return
+	}
+
+	if retentionPolicy.Years == 0 {
+		printWarning("No retention policy is set.")
+		pause()
+		return
+	}
+
+	cutoff := retentionCutoff(todayDate(), retentionPolicy.Years)
+	count := len(expiredRecordIDs(cutoff, retentionPolicy.Mode))
+	if count == 0 {
+		printSuccess(fmt.Sprintf("No records dated before %s need to be %sd.", cutoff, retentionPolicy.Mode))
+		pause()
+		return
+	}
+
+	confirm := getValidInput(fmt.Sprintf("%d records dated before %s will be %sd. Continue? (y/N): ",
+		count, cutoff, retentionPolicy.Mode))
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Retention job cancelled.")
+		pause()
+		return
+	}
+
+	for _, line := range runRetention() {
+		fmt.Println(line)
+	}
+	retentionPolicy.LastRun = todayDate()
+	if err := saveData(); err != nil {
+		printError(fmt.Sprintf("Failed to save data: %v", err))
+	} else {
+		printSuccess("Retention job complete.")
+	}
+	pause()
+}
+
+// runScheduledRetention applies the policy once a day after login
+func runScheduledRetention() {
+	if retentionPolicy.Years == 0 || retentionPolicy.LastRun == todayDate() || !hasPermission(PERM_PRIVACY_MANAGE) {
+		return
+	}
+
+	summary := runRetention()
+	retentionPolicy.LastRun = todayDate()
+	if err := saveData(); err != nil {
+		printError(fmt.Sprintf("Retention job: failed to save data: %v", err))
+		return
+	}
+	printSuccess("Retention job: " + summary[0])
+}
+
+// Erasure functions
+func forgetPatient() {
+	printHeader("Forget Patient (Erasure Request)")
+
+	if !requirePermission(PERM_PRIVACY_MANAGE) {
+		return
+	}
+
+	id := getValidInt("Enter patient ID: ", 1, 999999)
+	pIdx := binarySearchPatientByID(id)
+
+	recordIDs := make(map[int]bool)
+	var recordList []int
+	name := ""
+	for i := 0; i < records.N; i++ {
+		if records.Daftar[i].Patient.ID == id {
+			recordIDs[records.Daftar[i].ID] = true
+			recordList = append(recordList, records.Daftar[i].ID)
+			name = records.Daftar[i].Patient.Name
+		}
+	}
+	if pIdx != -1 {
+		name = patients.Daftar[pIdx].Name
+	}
+
+	trashed := 0
+	for i := 0; i < trash.N; i++ {
+		if trashMentionsPatient(trash.Daftar[i], id) {
+			trashed++
+			if name == "" && trash.Daftar[i].Patient != nil {
+				name = trash.Daftar[i].Patient.Name
+			}
+		}
+	}
+
+	if pIdx == -1 && len(recordList) == 0 && trashed == 0 {
+		printError("No patient or records found with this ID.")
+		pause()
+		return
+	}
+
+	var rosters []string
+	for i := 0; i < companies.N; i++ {
+		if isEmployee(companies.Daftar[i], id) {
+			rosters = append(rosters, companies.Daftar[i].Name)
+		}
+	}
+
+	fmt.Printf("\nPatient %d (%s):\n", id, name)
+	fmt.Printf("  Register entry: %v\n", pIdx != -1)
+	fmt.Printf("  Medical records: %d\n", len(recordList))
+	fmt.Printf("  Company rosters: %d\n", len(rosters))
+	fmt.Printf("  Items in trash: %d\n", trashed)
+
+	fmt.Println("\nRecords: 1. Delete them  2. Keep them for the accounts with the patient erased")
+	deleteRecords := getValidInt("Choose option: ", 1, 2) == 1
+
+	confirm := getValidInput("\nThis cannot be undone. Type ERASE to continue: ")
+	if confirm != "ERASE" {
+		printWarning("Erasure cancelled.")
+		pause()
+		return
+	}
+
+	report := []string{
+		fmt.Sprintf("Erasure report for patient %d", id),
+		fmt.Sprintf("Carried out by %s on %s", currentUser.Username, time.Now().Format("02/01/2006 15:04")),
+		"",
+	}
+
+	if pIdx != -1 {
+		for i := pIdx; i < patients.N-1; i++ {
+			patients.Daftar[i] = patients.Daftar[i+1]
+		}
+		patients.N--
+		report = append(report, "Patient register: entry removed")
+	} else {
+		report = append(report, "Patient register: no entry (already deleted)")
+	}
+
+	sort.Ints(recordList)
+	erasedFrom := eventLog.Last
+	for i := records.N - 1; i >= 0; i-- {
+		if !recordIDs[records.Daftar[i].ID] {
+			continue
+		}
+		rid := records.Daftar[i].ID
+		if deleteRecords {
+			removeRecordAt(i)
+			publishEvent(ENTITY_RECORD+"."+CHANGE_DELETED, ENTITY_RECORD, rid, RecordEventData{ID: rid})
+		} else {
+			eraseRecord(&records.Daftar[i])
+			publishEvent(ENTITY_RECORD+"."+CHANGE_UPDATED, ENTITY_RECORD, rid, records.Daftar[i])
+		}
+	}
+	action := "patient details and results erased, kept for the accounts"
+	if deleteRecords {
+		action = "deleted"
+	}
+	report = append(report, fmt.Sprintf("Medical records %v: %s", recordList, action))
+
+	for i := 0; i < companies.N; i++ {
+		removeEmployee(&companies.Daftar[i], id)
+	}
+	report = append(report, fmt.Sprintf("Company rosters: removed from %d", len(rosters)))
+
+	purged, trashedRecords := purgeTrash(func(item TrashItem) bool { return trashMentionsPatient(item, id) })
+	for _, rid := range trashedRecords {
+		recordIDs[rid] = true
+	}
+	forgetUndoHistory(func(op UndoOp) bool { return undoMentionsPatient(op, id) })
+	report = append(report, fmt.Sprintf("Trash: %d items purged, undo history cleared", purged))
+
+	notices, outboxFiles := forgetRecallNotices(id)
+	report = append(report, fmt.Sprintf("Reminders: %d history entries and %d outbox files removed", notices, outboxFiles))
+	sent, sentFiles := forgetNotifications(id)
+	report = append(report, fmt.Sprintf("Notifications: %d delivery log entries and %d outbox files removed", sent, sentFiles))
+
+	// Earlier events lose their data; subscribers are told the patient is gone
+	events := redactEvents(func(ev *DomainEvent) bool {
+		if ev.Offset > erasedFrom {
+			return false
+		}
+		return (ev.Entity == ENTITY_PATIENT && ev.EntityID == id) || (ev.Entity == ENTITY_RECORD && recordIDs[ev.EntityID])
+	})
+	publishEvent(ENTITY_PATIENT+"."+CHANGE_DELETED, ENTITY_PATIENT, id, PatientEventData{ID: id})
+	report = append(report, fmt.Sprintf("Event stream: %d events redacted, patient.deleted published", events))
+
+	// Changes to the patient saved elsewhere in the meantime are dropped when
+	// they meet this erasure
+	erasures = append(erasures, Erasure{PatientID: id, Records: recordList, RecordsDeleted: deleteRecords,
+		ErasedAt: time.Now().Format(time.RFC3339)})
+	report = append(report, "Other terminals and branches: the erasure is applied to their copies when they sync")
+	if scrubbed := scrubErasedSync(&branchSync, erasures); branchSync.Base != nil || scrubbed > 0 {
+		report = append(report, fmt.Sprintf("Central store sync: removed from the copy of the last sync, %d sync conflicts removed", scrubbed))
+	}
+
+	redacted, err := redactAuditLog(func(e AuditEntry) bool {
+		return patientMentioned(e, id) || recordMentioned(e, recordIDs)
+	})
+	if err != nil {
+		report = append(report, fmt.Sprintf("Audit log: FAILED - %v", err))
+	} else {
+		report = append(report, fmt.Sprintf("Audit log: %d entries redacted (digests kept, chain still verifiable)", redacted))
+	}
+
+	files, err := scrubClaimFiles(recordIDs)
+	if err != nil {
+		report = append(report, fmt.Sprintf("Claim files: FAILED - %v", err))
+	} else if len(files) > 0 {
+		report = append(report, fmt.Sprintf("Claim files scrubbed: %s", strings.Join(files, ", ")))
+	} else {
+		report = append(report, "Claim files: none held this patient")
+	}
+
+	sheets, err := deleteResultSheets(recordIDs)
+	if err != nil {
+		report = append(report, fmt.Sprintf("Result sheets: FAILED - %v", err))
+	} else {
+		report = append(report, fmt.Sprintf("Result sheets: %d deleted", len(sheets)))
+	}
+	bundles, emptied, err := scrubFHIRBundles(id, recordIDs)
+	if err != nil {
+		report = append(report, fmt.Sprintf("FHIR bundles: FAILED - %v", err))
+	} else {
+		report = append(report, fmt.Sprintf("FHIR bundles: patient removed from %d, %d left empty and deleted", len(bundles), len(emptied)))
+	}
+	remaining := reportsNaming(name)
+	if len(remaining) > 0 {
+		report = append(report, fmt.Sprintf("Report exports STILL HOLDING the patient (delete or export again): %s", strings.Join(remaining, ", ")))
+	} else {
+		report = append(report, "Report exports: none name this patient")
+	}
+
+	// The data file is rewritten in full, so the old copy of the patient is gone
+	if err := saveData(); err != nil {
+		report = append(report, fmt.Sprintf("Data file: FAILED to save - %v", err))
+	} else {
+		report = append(report, "Data file: rewritten without the patient")
+	}
+	report = append(report, "Research exports: contain only pseudonyms, not affected")
+
+	auditLog(AUDIT_ERASE, "patient", id, nil, map[string]interface{}{
+		"records": recordList, "deleted_records": deleteRecords, "audit_redacted": redacted, "claim_files": files,
+		"result_sheets": sheets, "fhir_bundles": append(bundles, emptied...), "reports_remaining": remaining,
+	})
+
+	filename := fmt.Sprintf("erasure-%d-%s.txt", id, time.Now().Format("20060102150405"))
+	if err := os.WriteFile(filename, []byte(strings.Join(report, "\n")+"\n"), 0600); err != nil {
+		printError(fmt.Sprintf("Failed to write report: %v", err))
+	}
+
+	fmt.Println()
+	for _, line := range report {
+		fmt.Println(line)
+	}
+	if len(remaining) > 0 {
+		printWarning(fmt.Sprintf("Patient erased from the data, but %d report exports still hold them. Report written to %s.", len(remaining), filename))
+	} else {
+		printSuccess(fmt.Sprintf("Patient erased. Report written to %s.", filename))
+	}
+	pause()
+}
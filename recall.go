@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	RECALL_DEFAULT_MONTHS  = 12
+	RECALL_DEFAULT_HORIZON = 30
+	RECALL_MAX_HORIZON     = 730
+	RECALL_RESEND_DAYS     = 14
+
+	// Why a patient is called back
+	RECALL_ANNUAL    = "annual"
+	RECALL_FOLLOW_UP = "follow_up"
+
+	// Reminder channels
+	CHANNEL_EMAIL  = "email"
+	CHANNEL_SMS    = "sms"
+	CHANNEL_OUTBOX = "outbox"
+
+	NOTICE_SENT   = "sent"
+	NOTICE_FAILED = "failed"
+
+	DEFAULT_OUTBOX_DIR = "outbox"
+	SMS_TIMEOUT        = 15 * time.Second
+	SMTP_TIMEOUT       = 30 * time.Second
+)
+
+var CHANNELS = []string{CHANNEL_EMAIL, CHANNEL_SMS, CHANNEL_OUTBOX}
+
+var channelNames = map[string]string{
+	CHANNEL_EMAIL:  "E-mail (SMTP)",
+	CHANNEL_SMS:    "SMS gateway",
+	CHANNEL_OUTBOX: "File outbox",
+}
+
+// Data structures
+// RecallItem is one patient on the recall worklist
+type RecallItem struct {
+	PatientID int
+	Name      string
+	RecordID  int
+	Package   string
+	LastDate  string
+	Reason    string
+	DueDate   string
+	LastSent  string
+}
+
+// RecallNotice records one reminder that was sent, or failed to send
+type RecallNotice struct {
+	PatientID int    `json:"patient_id"`
+	RecordID  int    `json:"record_id"`
+	Reason    string `json:"reason"`
+	DueDate   string `json:"due_date"`
+	Channel   string `json:"channel"`
+	To        string `json:"to"`
+	SentAt    string `json:"sent_at"`
+	SentBy    string `json:"sent_by"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NotifierSettings configures the reminder channels
+type NotifierSettings struct {
+	SMTPHost     string `json:"smtp_host,omitempty"`
+	SMTPPort     int    `json:"smtp_port,omitempty"`
+	SMTPFrom     string `json:"smtp_from,omitempty"`
+	SMTPUsername string `json:"smtp_username,omitempty"`
+	SMTPPassword string `json:"smtp_password,omitempty"`
+	SMSURL       string `json:"sms_url,omitempty"`
+	SMSToken     string `json:"sms_token,omitempty"`
+	SMSSender    string `json:"sms_sender,omitempty"`
+	OutboxDir    string `json:"outbox_dir,omitempty"`
+
+	// Patient notifications; no channel means they are off
+	NotifyChannel string   `json:"notify_channel,omitempty"`
+	NotifyEvents  []string `json:"notify_events,omitempty"`
+}
+
+// OutgoingMessage is a message ready to be sent to one patient. Kind names
+// the outbox file.
+type OutgoingMessage struct {
+	Kind      string
+	PatientID int
+	Name      string
+	To        string
+	Subject   string
+	Body      string
+}
+
+// Notifier delivers reminder messages over one channel
+type Notifier interface {
+	Channel() string
+	Send(msg OutgoingMessage) error
+}
+
+var recallNotices []RecallNotice
+var notifierSettings NotifierSettings
+
+var recallTexts = map[string]map[string]string{
+	LANG_EN: {
+		"annual_subject":    "Your annual check-up at %s is due",
+		"annual_body":       "Your last check-up (%s) was on %s. Your next check-up is due on %s.",
+		"follow_up_subject": "Follow-up visit at %s",
+		"follow_up_body":    "After your check-up on %s the doctor asked to see you again. Your follow-up visit is due on %s.",
+		"greeting":          "Dear %s,",
+		"book":              "Please contact us to book an appointment.",
+		"book_phone":        "Please call %s to book an appointment.",
+	},
+	LANG_ID: {
+		"annual_subject":    "Saatnya pemeriksaan kesehatan tahunan di %s",
+		"annual_body":       "Pemeriksaan terakhir Anda (%s) pada %s. Pemeriksaan berikutnya jatuh tempo pada %s.",
+		"follow_up_subject": "Kontrol ulang di %s",
+		"follow_up_body":    "Setelah pemeriksaan Anda pada %s, dokter meminta Anda untuk kontrol ulang. Kontrol ulang jatuh tempo pada %s.",
+		"greeting":          "Yth. %s,",
+		"book":              "Silakan hubungi kami untuk membuat janji.",
+		"book_phone":        "Silakan hubungi %s untuk membuat janji.",
+	},
+}
+
+// Notifiers
+// smtpNotifier sends e-mail through an SMTP server
+type smtpNotifier struct {
+	Host     string
+	Port     int
+	From     string
+	Username string
+	Password string
+}
+
+func (n smtpNotifier) Channel() string { return CHANNEL_EMAIL }
+
+// Send talks to the server itself rather than through smtp.SendMail, which
+// has no timeout: a server that stops answering fails the message after
+// SMTP_TIMEOUT instead of hanging the delivery run.
+func (n smtpNotifier) Send(msg OutgoingMessage) error {
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	conn, err := net.DialTimeout("tcp", addr, SMTP_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return err
+		}
+	}
+	if n.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("the SMTP server does not accept a login")
+		}
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildEmail(n.From, msg, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail writes a plain text message with CRLF line endings
+func buildEmail(from string, msg OutgoingMessage, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	// net/smtp escapes leading dots itself
+	for _, line := range strings.Split(msg.Body, "\n") {
+		b.WriteString(line + "\r\n")
+	}
+	return b.Bytes()
+}
+
+// smsNotifier posts messages as JSON to an HTTP SMS gateway
+type smsNotifier struct {
+	URL    string
+	Token  string
+	Sender string
+	Client *http.Client
+}
+
+func (n smsNotifier) Channel() string { return CHANNEL_SMS }
+
+func (n smsNotifier) Send(msg OutgoingMessage) error {
+	payload, err := json.Marshal(map[string]string{
+		"to":      msg.To,
+		"from":    n.Sender,
+		"message": msg.Subject + "\n" + msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("SMS gateway answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// outboxNotifier writes each message to a file for printing or sending by hand
+type outboxNotifier struct {
+	Dir string
+}
+
+func (n outboxNotifier) Channel() string { return CHANNEL_OUTBOX }
+
+func (n outboxNotifier) Send(msg OutgoingMessage) error {
+	if err := os.MkdirAll(n.Dir, 0755); err != nil {
+		return err
+	}
+	to := msg.To
+	if to == "" {
+		to = msg.Name
+	}
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", to, msg.Subject, msg.Body)
+	kind := msg.Kind
+	if kind == "" {
+		kind = "message"
+	}
+	filename := filepath.Join(n.Dir, fmt.Sprintf("%s-%d-%s.txt", kind, msg.PatientID, time.Now().Format("20060102150405")))
+	return os.WriteFile(filename, []byte(content), 0600)
+}
+
+func outboxDir(s NotifierSettings) string {
+	if s.OutboxDir == "" {
+		return DEFAULT_OUTBOX_DIR
+	}
+	return s.OutboxDir
+}
+
+// newNotifier sets up the notifier of a channel from the settings
+func newNotifier(channel string, s NotifierSettings) (Notifier, error) {
+	switch channel {
+	case CHANNEL_EMAIL:
+		if s.SMTPHost == "" || s.SMTPFrom == "" {
+			return nil, errors.New("the SMTP server and sender address are not set up")
+		}
+		port := s.SMTPPort
+		if port == 0 {
+			port = 25
+		}
+		return smtpNotifier{Host: s.SMTPHost, Port: port, From: s.SMTPFrom, Username: s.SMTPUsername, Password: s.SMTPPassword}, nil
+	case CHANNEL_SMS:
+		if s.SMSURL == "" {
+			return nil, errors.New("the SMS gateway URL is not set up")
+		}
+		return smsNotifier{URL: s.SMSURL, Token: s.SMSToken, Sender: s.SMSSender, Client: &http.Client{Timeout: SMS_TIMEOUT}}, nil
+	case CHANNEL_OUTBOX:
+		return outboxNotifier{Dir: outboxDir(s)}, nil
+	}
+	return nil, fmt.Errorf("unknown channel %q", channel)
+}
+
+// recipient returns the address of a patient on a channel; the outbox takes
+// whatever contact the patient has
+func recipient(p Patient, channel string) string {
+	switch channel {
+	case CHANNEL_EMAIL:
+		return p.Email
+	case CHANNEL_SMS:
+		return p.Phone
+	}
+	if p.Email != "" {
+		return p.Email
+	}
+	return p.Phone
+}
+
+// Recall engine
+// recallMonths is how long after a check-up the package should be repeated.
+// The current package is preferred over the copy kept in a record.
+func recallMonths(pkg Package) int {
+	months := pkg.RecallMonths
+	if idx := binarySearchPackageByID(pkg.ID); idx != -1 {
+		months = packages.Daftar[idx].RecallMonths
+	}
+	if months <= 0 {
+		return RECALL_DEFAULT_MONTHS
+	}
+	return months
+}
+
+// recallDue works out when and why a patient is due after a record. A
+// follow-up asked for by the doctor wins when it falls first.
+func recallDue(r Record) (string, string) {
+	due, reason := followUpDue(r.Date, recallMonths(r.Package)), RECALL_ANNUAL
+	if isSignedOff(r) && r.FollowUpMonths > 0 {
+		if followUp := followUpDue(r.Date, r.FollowUpMonths); compareDates(followUp, due) <= 0 {
+			due, reason = followUp, RECALL_FOLLOW_UP
+		}
+	}
+	return due, reason
+}
+
+func lastNotice(patientID, recordID int, reason string) string {
+	sent := ""
+	for _, n := range recallNotices {
+		if n.PatientID == patientID && n.RecordID == recordID && n.Reason == reason && n.Status == NOTICE_SENT {
+			sent = n.SentAt
+		}
+	}
+	return sent
+}
+
+// computeRecalls lists the patients whose next visit is due within horizon
+// days of today, overdue ones included. Only each patient's latest record
+// counts, so a new visit takes the patient off the list. Patients last seen
+// at a branch this session cannot see are left to that branch.
+func computeRecalls(today string, horizon int) []RecallItem {
+	latest := make(map[int]int)
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if isAnonymised(r) {
+			continue
+		}
+		if prev, seen := latest[r.Patient.ID]; !seen || compareDates(r.Date, records.Daftar[prev].Date) >= 0 {
+			latest[r.Patient.ID] = i
+		}
+	}
+
+	until := addDays(today, horizon)
+	var items []RecallItem
+	for i := 0; i < patients.N; i++ {
+		p := patients.Daftar[i]
+		idx, ok := latest[p.ID]
+		if !ok || p.NoRecall {
+			continue
+		}
+		r := records.Daftar[idx]
+		// The branch that saw the patient last calls them back
+		if !branchVisible(r.BranchID) {
+			continue
+		}
+		due, reason := recallDue(r)
+		if compareDates(due, until) > 0 {
+			continue
+		}
+		items = append(items, RecallItem{
+			PatientID: p.ID,
+			Name:      p.Name,
+			RecordID:  r.ID,
+			Package:   r.Package.Name,
+			LastDate:  r.Date,
+			Reason:    reason,
+			DueDate:   due,
+			LastSent:  lastNotice(p.ID, r.ID, reason),
+		})
+	}
+
+	sort.SliceStable(items, func(a, b int) bool {
+		return compareDates(items[a].DueDate, items[b].DueDate) < 0
+	})
+	return items
+}
+
+func recallReasonName(reason string) string {
+	if reason == RECALL_FOLLOW_UP {
+		return "Follow-up"
+	}
+	return "Annual"
+}
+
+// reminderMessage writes the reminder for a worklist item. It names no
+// findings; those stay on the result sheet.
+func reminderMessage(item RecallItem, to, lang string) OutgoingMessage {
+	text := recallTexts[LANG_EN]
+	if t, ok := recallTexts[lang]; ok {
+		text = t
+	}
+
+	var subject, body string
+	if item.Reason == RECALL_FOLLOW_UP {
+		subject = fmt.Sprintf(text["follow_up_subject"], clinicName())
+		body = fmt.Sprintf(text["follow_up_body"], item.LastDate, item.DueDate)
+	} else {
+		subject = fmt.Sprintf(text["annual_subject"], clinicName())
+		body = fmt.Sprintf(text["annual_body"], item.Package, item.LastDate, item.DueDate)
+	}
+	book := text["book"]
+	if resultTemplate.Phone != "" {
+		book = fmt.Sprintf(text["book_phone"], resultTemplate.Phone)
+	}
+
+	return OutgoingMessage{
+		Kind:      "reminder",
+		PatientID: item.PatientID,
+		Name:      item.Name,
+		To:        to,
+		Subject:   subject,
+		Body:      fmt.Sprintf(text["greeting"], item.Name) + "\n\n" + body + "\n" + book + "\n\n" + clinicName(),
+	}
+}
+
+// sendReminders sends a reminder for every item that has an address on the
+// channel and was not reminded in the last RECALL_RESEND_DAYS days
+func sendReminders(items []RecallItem, n Notifier, today string) (sent, skipped, failed int, report []string) {
+	lang := resultLanguage()
+	by := "-"
+	if currentUser != nil {
+		by = currentUser.Username
+	}
+
+	for _, item := range items {
+		if item.LastSent != "" && daysBetween(item.LastSent[:10], today) < RECALL_RESEND_DAYS {
+			skipped++
+			report = append(report, fmt.Sprintf("%s: reminded on %s", displayName(item.Name), item.LastSent))
+			continue
+		}
+		pIdx := binarySearchPatientByID(item.PatientID)
+		if pIdx == -1 {
+			continue
+		}
+		to := recipient(patients.Daftar[pIdx], n.Channel())
+		if to == "" && n.Channel() != CHANNEL_OUTBOX {
+			skipped++
+			report = append(report, fmt.Sprintf("%s: no %s contact", displayName(item.Name), n.Channel()))
+			continue
+		}
+
+		notice := RecallNotice{
+			PatientID: item.PatientID,
+			RecordID:  item.RecordID,
+			Reason:    item.Reason,
+			DueDate:   item.DueDate,
+			Channel:   n.Channel(),
+			To:        to,
+			SentAt:    time.Now().Format("02/01/2006 15:04"),
+			SentBy:    by,
+			Status:    NOTICE_SENT,
+		}
+		if err := n.Send(reminderMessage(item, to, lang)); err != nil {
+			notice.Status, notice.Error = NOTICE_FAILED, err.Error()
+			failed++
+			report = append(report, fmt.Sprintf("%s: %v", displayName(item.Name), err))
+		} else {
+			sent++
+		}
+		recallNotices = append(recallNotices, notice)
+		auditLog(AUDIT_NOTIFY, "recall_reminder", item.PatientID, nil, notice)
+	}
+	return sent, skipped, failed, report
+}
+
+// forgetRecallNotices removes a patient's reminder history and the reminders
+// still waiting in the outbox
+func forgetRecallNotices(patientID int) (int, int) {
+	kept := recallNotices[:0]
+	for _, n := range recallNotices {
+		if n.PatientID != patientID {
+			kept = append(kept, n)
+		}
+	}
+	removed := len(recallNotices) - len(kept)
+	recallNotices = kept
+	return removed, removeOutboxFiles("reminder", patientID)
+}
+
+// removeOutboxFiles deletes the outbox files of one kind for a patient
+func removeOutboxFiles(kind string, patientID int) int {
+	files, _ := filepath.Glob(filepath.Join(outboxDir(notifierSettings), fmt.Sprintf("%s-%d-*.txt", kind, patientID)))
+	deleted := 0
+	for _, name := range files {
+		if os.Remove(name) == nil {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Contact functions
+func isValidEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at > 0 && strings.Contains(email[at+1:], ".") && !strings.ContainsAny(email, " \t,;<>")
+}
+
+// isValidPhone accepts digits with an optional leading + and spaces or
+// dashes between groups
+func isValidPhone(phone string) bool {
+	digits := 0
+	for i, c := range phone {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '+' && i == 0:
+		case c == ' ' || c == '-':
+		default:
+			return false
+		}
+	}
+	return digits >= 8 && digits <= 15
+}
+
+// getContact asks for an optional contact; "-" leaves it empty
+func getContact(prompt string, valid func(string) bool) string {
+	for {
+		value := getValidInput(prompt)
+		if value == "-" {
+			return ""
+		}
+		if valid(value) {
+			return value
+		}
+		printError("That doesn't look right. Please try again, or enter - for none.")
+	}
+}
+
+// Menu functions
+func printRecallList(items []RecallItem) {
+	today := time.Now().Format("02/01/2006")
+	fmt.Printf("%s%-10s %-25s %-10s %-11s %-11s %-9s %-16s%s\n", BOLD,
+		"Patient", "Name", "Reason", "Last Visit", "Due", "", "Last Reminder", RESET)
+	fmt.Println(strings.Repeat("-", 98))
+	for _, item := range items {
+		status := fmt.Sprintf("%-9s", "")
+		if compareDates(item.DueDate, today) < 0 {
+			status = RED + fmt.Sprintf("%-9s", "overdue") + RESET
+		}
+		last := item.LastSent
+		if last == "" {
+			last = "-"
+		}
+		fmt.Printf("%-10s %-25s %-10s %-11s %-11s %s %-16s\n", displayPatientID(item.PatientID),
+			displayName(item.Name), recallReasonName(item.Reason), item.LastDate, item.DueDate, status, last)
+	}
+}
+
+func getHorizon() int {
+	return getValidInt(fmt.Sprintf("Include patients due within how many days? (e.g. %d): ", RECALL_DEFAULT_HORIZON), 0, RECALL_MAX_HORIZON)
+}
+
+func viewRecallWorklist() {
+	printHeader("Recall Worklist")
+
+	if !requirePermission(PERM_RECALL_MANAGE) {
+		return
+	}
+
+	items := computeRecalls(time.Now().Format("02/01/2006"), getHorizon())
+	auditLog(AUDIT_VIEW, "recall_worklist", 0, nil, nil)
+	if len(items) == 0 {
+		printWarning("No patients are due.")
+		pause()
+		return
+	}
+
+	fmt.Println()
+	printRecallList(items)
+	fmt.Printf("\n%d patients due.\n", len(items))
+	pause()
+}
+
+func selectChannel() string {
+	for i, channel := range CHANNELS {
+		fmt.Printf("%d. %s\n", i+1, channelNames[channel])
+	}
+	choice := getValidInt("Choose channel (0 to cancel): ", 0, len(CHANNELS))
+	if choice == 0 {
+		return ""
+	}
+	return CHANNELS[choice-1]
+}
+
+func sendRecallReminders() {
+	printHeader("Send Reminders")
+
+	if !requirePermission(PERM_RECALL_MANAGE) {
+		return
+	}
+
+	today := time.Now().Format("02/01/2006")
+	items := computeRecalls(today, getHorizon())
+	if len(items) == 0 {
+		printWarning("No patients are due.")
+		pause()
+		return
+	}
+	fmt.Printf("%d patients due.\n\nSend by:\n", len(items))
+
+	channel := selectChannel()
+	if channel == "" {
+		return
+	}
+	n, err := newNotifier(channel, notifierSettings)
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+
+	sent, skipped, failed, report := sendReminders(items, n, today)
+	for _, line := range report {
+		fmt.Println("  " + line)
+	}
+	printSuccess(fmt.Sprintf("%d reminders sent by %s, %d skipped, %d failed.", sent, channelNames[channel], skipped, failed))
+	pause()
+}
+
+func viewReminderHistory() {
+	printHeader("Reminder History")
+
+	if !requirePermission(PERM_RECALL_MANAGE) {
+		return
+	}
+
+	if len(recallNotices) == 0 {
+		printWarning("No reminders have been sent yet.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "recall_reminder", 0, nil, nil)
+	fmt.Printf("%s%-17s %-10s %-25s %-10s %-11s %-8s %-7s%s\n", BOLD,
+		"Sent", "Patient", "Name", "Reason", "Due", "Channel", "Status", RESET)
+	fmt.Println(strings.Repeat("-", 94))
+	for _, n := range recallNotices {
+		name := "-"
+		if idx := binarySearchPatientByID(n.PatientID); idx != -1 {
+			name = displayName(patients.Daftar[idx].Name)
+		}
+		fmt.Printf("%-17s %-10s %-25s %-10s %-11s %-8s %-7s\n", n.SentAt, displayPatientID(n.PatientID),
+			name, recallReasonName(n.Reason), n.DueDate, n.Channel, n.Status)
+		if n.Error != "" {
+			fmt.Printf("%s  %s%s\n", YELLOW, n.Error, RESET)
+		}
+	}
+	pause()
+}
+
+func editNotifierSettings() {
+	printHeader("Message Channels")
+
+	if !requirePermission(PERM_CLINIC_MANAGE) {
+		return
+	}
+
+	s := &notifierSettings
+	before := *s
+	before.SMTPPassword, before.SMSToken = "", ""
+	password, token := "(not set)", "(not set)"
+	if s.SMTPPassword != "" {
+		password = "(set)"
+	}
+	if s.SMSToken != "" {
+		token = "(set)"
+	}
+
+	fmt.Printf("1. SMTP server: %s:%d\n", s.SMTPHost, s.SMTPPort)
+	fmt.Printf("2. SMTP sender address: %s\n", s.SMTPFrom)
+	fmt.Printf("3. SMTP login: %s %s\n", s.SMTPUsername, password)
+	fmt.Printf("4. SMS gateway URL: %s\n", s.SMSURL)
+	fmt.Printf("5. SMS gateway token: %s\n", token)
+	fmt.Printf("6. SMS sender name: %s\n", s.SMSSender)
+	fmt.Printf("7. Outbox folder: %s\n", outboxDir(*s))
+
+	choice := getValidInt("\nChoose setting to change (0 to return): ", 0, 7)
+	switch choice {
+	case 0:
+		return
+	case 1:
+		s.SMTPHost = templateText("SMTP host (- for none): ")
+		s.SMTPPort = getValidInt("SMTP port (e.g. 25 or 587): ", 1, 65535)
+	case 2:
+		s.SMTPFrom = getContact("Sender address (- for none): ", isValidEmail)
+	case 3:
+		s.SMTPUsername = templateText("SMTP username (- for no login): ")
+		s.SMTPPassword = ""
+		if s.SMTPUsername != "" {
+			s.SMTPPassword = getValidSecret("SMTP password: ")
+		}
+	case 4:
+		s.SMSURL = templateText("SMS gateway URL (- for none): ")
+	case 5:
+		s.SMSToken = templateText("SMS gateway token (- for none): ")
+	case 6:
+		s.SMSSender = templateText("SMS sender name (- for none): ")
+	case 7:
+		s.OutboxDir = templateText("Outbox folder (- for the default): ")
+	}
+
+	// Secrets stay out of the audit trail
+	after := *s
+	after.SMTPPassword, after.SMSToken = "", ""
+	auditLog(AUDIT_UPDATE, "notifier_settings", 0, before, after)
+	printSuccess("Reminder channels updated.")
+	pause()
+}
+
+func recallManagement() {
+	for {
+		printHeader("Recall & Follow-up")
+		fmt.Printf("%s1.%s Recall Worklist\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Send Reminders\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Reminder History\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Message Channels\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 4)
+
+		switch choice {
+		case 1:
+			viewRecallWorklist()
+		case 2:
+			sendRecallReminders()
+		case 3:
+			viewReminderHistory()
+		case 4:
+			editNotifierSettings()
+		case 0:
+			return
+		}
+	}
+}
+
+// recallCommand runs "recall list [days]" and "recall send <channel> [days]"
+// after login, for scheduled jobs
+func recallCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "send") || (args[0] == "send" && len(args) < 2) {
+		fmt.Println("Usage: recall list [days] | recall send <email|sms|outbox> [days]")
+		return 2
+	}
+	if !checkPermission(PERM_RECALL_MANAGE) {
+		return 1
+	}
+
+	horizon := RECALL_DEFAULT_HORIZON
+	daysArg := 1
+	if args[0] == "send" {
+		daysArg = 2
+	}
+	if len(args) > daysArg {
+		days, err := strconv.Atoi(args[daysArg])
+		if err != nil || days < 0 || days > RECALL_MAX_HORIZON {
+			printError(fmt.Sprintf("days must be a number from 0 to %d", RECALL_MAX_HORIZON))
+			return 2
+		}
+		horizon = days
+	}
+
+	today := time.Now().Format("02/01/2006")
+	items := computeRecalls(today, horizon)
+	if args[0] == "list" {
+		auditLog(AUDIT_VIEW, "recall_worklist", 0, nil, nil)
+		printRecallList(items)
+		fmt.Printf("\n%d patients due.\n", len(items))
+		return 0
+	}
+
+	n, err := newNotifier(args[1], notifierSettings)
+	if err != nil {
+		printError(err.Error())
+		return 2
+	}
+	sent, skipped, failed, report := sendReminders(items, n, today)
+	for _, line := range report {
+		fmt.Println("  " + line)
+	}
+	if err := saveData(); err != nil {
+		printError(fmt.Sprintf("Failed to save data: %v", err))
+		return 1
+	}
+	printSuccess(fmt.Sprintf("%d reminders sent, %d skipped, %d failed.", sent, skipped, failed))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
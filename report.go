@@ -0,0 +1,697 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	FORMAT_CSV  = 1
+	FORMAT_JSON = 2
+	FORMAT_HTML = 3
+	FORMAT_PDF  = 4
+)
+
+var formatExtensions = map[int]string{
+	FORMAT_CSV:  "csv",
+	FORMAT_JSON: "json",
+	FORMAT_HTML: "html",
+	FORMAT_PDF:  "pdf",
+}
+
+// Data structures
+// ReportColumn defines a column once for every output format. Numeric
+// columns are right-aligned and written as numbers in JSON.
+type ReportColumn struct {
+	Key     string
+	Header  string
+	Numeric bool
+}
+
+// ReportSection is one table of a report. Summary sections hold label and
+// value pairs and are printed as "Label: value" on screen.
+type ReportSection struct {
+	Title   string
+	Columns []ReportColumn
+	Rows    [][]string
+	Summary bool
+}
+
+type Report struct {
+	Name        string
+	Title       string
+	Period      string
+	Branch      string
+	GeneratedAt string
+	GeneratedBy string
+	Sections    []ReportSection
+}
+
+var summaryColumns = []ReportColumn{
+	{Key: "metric", Header: "Metric"},
+	{Key: "value", Header: "Value", Numeric: true},
+}
+
+func newReport(name, title string) Report {
+	by := "-"
+	if currentUser != nil {
+		by = currentUser.Username
+	}
+	return Report{
+		Name:        name,
+		Title:       title,
+		GeneratedAt: time.Now().Format("02/01/2006 15:04"),
+		GeneratedBy: by,
+	}
+}
+
+func money(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
+
+func percent(part, total float64) string {
+	if total == 0 {
+		return "0.0"
+	}
+	return fmt.Sprintf("%.1f", part*100/total)
+}
+
+// inPeriod matches a DD/MM/YYYY date against a MM/YYYY period; an empty
+// period matches every date
+func inPeriod(date, period string) bool {
+	return period == "" || strings.HasSuffix(date, "/"+period)
+}
+
+// Report builders take a branch, or 0 to consolidate the user's branches
+func patientStatsReport(branch int) Report {
+	report := newReport("patient-statistics", "Patient Statistics Report")
+	setReportBranch(&report, branch)
+
+	covered := branchPatients(branch)
+	var maleCount, femaleCount int
+	var totalAge, minAge, maxAge int
+	minAge = 999
+	for _, p := range covered {
+		if p.Gender == "M" {
+			maleCount++
+		} else {
+			femaleCount++
+		}
+		totalAge += p.Age
+		if p.Age < minAge {
+			minAge = p.Age
+		}
+		if p.Age > maxAge {
+			maxAge = p.Age
+		}
+	}
+	if len(covered) == 0 {
+		minAge = 0
+	}
+
+	averageAge := 0.0
+	if len(covered) > 0 {
+		averageAge = float64(totalAge) / float64(len(covered))
+	}
+
+	report.Sections = []ReportSection{
+		{Title: "Patient Statistics", Columns: summaryColumns, Summary: true, Rows: [][]string{
+			{"Total Patients", strconv.Itoa(len(covered))},
+			{"Average Age (years)", fmt.Sprintf("%.1f", averageAge)},
+			{"Youngest Patient (years)", strconv.Itoa(minAge)},
+			{"Oldest Patient (years)", strconv.Itoa(maxAge)},
+		}},
+		{Title: "Patients by Gender", Columns: []ReportColumn{
+			{Key: "gender", Header: "Gender"},
+			{Key: "patients", Header: "Patients", Numeric: true},
+			{Key: "percent", Header: "Percent", Numeric: true},
+		}, Rows: [][]string{
+			{"Male", strconv.Itoa(maleCount), percent(float64(maleCount), float64(len(covered)))},
+			{"Female", strconv.Itoa(femaleCount), percent(float64(femaleCount), float64(len(covered)))},
+		}},
+	}
+	return report
+}
+
+func packageStatsReport(branch int) Report {
+	report := newReport("package-statistics", "Package Statistics Report")
+	setReportBranch(&report, branch)
+
+	offered := branchPackages(branch)
+	categoryCount := make(map[string]float64)
+	var totalPrice, minPrice, maxPrice float64
+	minPrice = 999999
+	for _, i := range offered {
+		p := packages.Daftar[i]
+		categoryCount[categoryLabel(p.CategoryID, p.Category)]++
+		totalPrice += p.Price
+		if p.Price < minPrice {
+			minPrice = p.Price
+		}
+		if p.Price > maxPrice {
+			maxPrice = p.Price
+		}
+	}
+	if len(offered) == 0 {
+		minPrice = 0
+	}
+
+	averagePrice := 0.0
+	if len(offered) > 0 {
+		averagePrice = totalPrice / float64(len(offered))
+	}
+
+	byCategory := ReportSection{Title: "Packages by Category", Columns: []ReportColumn{
+		{Key: "category", Header: "Category"},
+		{Key: "packages", Header: "Packages", Numeric: true},
+		{Key: "percent", Header: "Percent", Numeric: true},
+	}}
+	for _, category := range sortedKeys(categoryCount) {
+		count := categoryCount[category]
+		byCategory.Rows = append(byCategory.Rows, []string{category, fmt.Sprintf("%.0f", count),
+			percent(count, float64(len(offered)))})
+	}
+
+	report.Sections = []ReportSection{
+		{Title: "Package Statistics", Columns: summaryColumns, Summary: true, Rows: [][]string{
+			{"Total Packages", strconv.Itoa(len(offered))},
+			{"Average Price ($)", money(averagePrice)},
+			{"Cheapest Package ($)", money(minPrice)},
+			{"Most Expensive Package ($)", money(maxPrice)},
+		}},
+		byCategory,
+	}
+	return report
+}
+
+// revenueReport covers the records of one month (MM/YYYY), or all of them.
+// A consolidated report also breaks the revenue down by branch.
+func revenueReport(period string, branch int) Report {
+	report := newReport("revenue", "Revenue Report")
+	report.Period = period
+	setReportBranch(&report, branch)
+
+	var totalRevenue float64
+	count := 0
+	monthlyRevenue := make(map[string]float64)
+	categoryRevenue := make(map[string]float64)
+	branchRevenue := make(map[int]float64)
+	branchRecords := make(map[int]int)
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if !inPeriod(r.Date, period) || !inBranch(r.BranchID, branch) {
+			continue
+		}
+		price := recordPrice(r)
+		totalRevenue += price
+		count++
+
+		// Months are keyed YYYY/MM so they sort in order
+		dateParts := strings.Split(r.Date, "/")
+		if len(dateParts) == 3 {
+			monthlyRevenue[dateParts[2]+"/"+dateParts[1]] += price
+		}
+		categoryRevenue[recordCategory(r)] += price
+		branchRevenue[r.BranchID] += price
+		branchRecords[r.BranchID]++
+	}
+
+	averagePerRecord := 0.0
+	if count > 0 {
+		averagePerRecord = totalRevenue / float64(count)
+	}
+
+	byMonth := ReportSection{Title: "Revenue by Month", Columns: []ReportColumn{
+		{Key: "month", Header: "Month"},
+		{Key: "revenue", Header: "Revenue ($)", Numeric: true},
+	}}
+	for _, key := range sortedKeys(monthlyRevenue) {
+		parts := strings.Split(key, "/")
+		byMonth.Rows = append(byMonth.Rows, []string{parts[1] + "/" + parts[0], money(monthlyRevenue[key])})
+	}
+
+	byCategory := ReportSection{Title: "Revenue by Category", Columns: []ReportColumn{
+		{Key: "category", Header: "Category"},
+		{Key: "revenue", Header: "Revenue ($)", Numeric: true},
+		{Key: "percent", Header: "Percent", Numeric: true},
+	}}
+	for _, category := range sortedKeys(categoryRevenue) {
+		byCategory.Rows = append(byCategory.Rows, []string{category, money(categoryRevenue[category]),
+			percent(categoryRevenue[category], totalRevenue)})
+	}
+
+	report.Sections = []ReportSection{
+		{Title: "Revenue Statistics", Columns: summaryColumns, Summary: true, Rows: [][]string{
+			{"Total Revenue ($)", money(totalRevenue)},
+			{"Total Records", strconv.Itoa(count)},
+			{"Average Revenue per Record ($)", money(averagePerRecord)},
+		}},
+		byMonth,
+		byCategory,
+	}
+
+	if branch == 0 && branches.N > 0 {
+		byBranch := ReportSection{Title: "Revenue by Branch", Columns: []ReportColumn{
+			{Key: "branch", Header: "Branch"},
+			{Key: "records", Header: "Records", Numeric: true},
+			{Key: "revenue", Header: "Revenue ($)", Numeric: true},
+			{Key: "percent", Header: "Percent", Numeric: true},
+		}}
+		for _, id := range allowedBranches() {
+			byBranch.Rows = append(byBranch.Rows, []string{branchName(id), strconv.Itoa(branchRecords[id]),
+				money(branchRevenue[id]), percent(branchRevenue[id], totalRevenue)})
+		}
+		if branchRecords[0] > 0 {
+			byBranch.Rows = append(byBranch.Rows, []string{"No branch (before branches)", strconv.Itoa(branchRecords[0]),
+				money(branchRevenue[0]), percent(branchRevenue[0], totalRevenue)})
+		}
+		report.Sections = append(report.Sections, byBranch)
+	}
+	return report
+}
+
+// List builders follow the screen privacy mode, like the list views
+func patientListReport(branch int) Report {
+	report := newReport("patients", "Patient List")
+	setReportBranch(&report, branch)
+	section := ReportSection{Columns: []ReportColumn{
+		{Key: "id", Header: "ID"},
+		{Key: "name", Header: "Name"},
+		{Key: "gender", Header: "Gender"},
+		{Key: "age", Header: "Age", Numeric: true},
+	}}
+	for _, p := range branchPatients(branch) {
+		section.Rows = append(section.Rows, []string{displayPatientID(p.ID), displayName(p.Name), p.Gender, strconv.Itoa(p.Age)})
+	}
+	report.Sections = []ReportSection{section}
+	return report
+}
+
+func packageListReport(branch int) Report {
+	report := newReport("packages", "Package List")
+	setReportBranch(&report, branch)
+	section := ReportSection{Columns: []ReportColumn{
+		{Key: "id", Header: "ID", Numeric: true},
+		{Key: "name", Header: "Name"},
+		{Key: "category", Header: "Category"},
+		{Key: "price", Header: "Price ($)", Numeric: true},
+	}}
+	for _, i := range branchPackages(branch) {
+		p := packages.Daftar[i]
+		section.Rows = append(section.Rows, []string{strconv.Itoa(p.ID), p.Name, categoryLabel(p.CategoryID, p.Category), money(p.Price)})
+	}
+	report.Sections = []ReportSection{section}
+	return report
+}
+
+func recordListReport(period string, branch int) Report {
+	report := newReport("records", "Medical Records")
+	report.Period = period
+	setReportBranch(&report, branch)
+	section := ReportSection{Columns: []ReportColumn{
+		{Key: "id", Header: "ID", Numeric: true},
+		{Key: "patient", Header: "Patient"},
+		{Key: "package", Header: "Package"},
+		{Key: "category", Header: "Category"},
+		{Key: "date", Header: "Date"},
+		{Key: "price", Header: "Price ($)", Numeric: true},
+	}}
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		if !inPeriod(r.Date, period) || !inBranch(r.BranchID, branch) {
+			continue
+		}
+		section.Rows = append(section.Rows, []string{strconv.Itoa(r.ID), displayName(r.Patient.Name), r.Package.Name,
+			recordCategory(r), r.Date, money(recordPrice(r))})
+	}
+	report.Sections = []ReportSection{section}
+	return report
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Terminal output
+func printReport(report Report) {
+	if report.Branch != "" {
+		fmt.Printf("Branch: %s\n", report.Branch)
+	}
+	if report.Period != "" {
+		fmt.Printf("Period: %s\n", report.Period)
+	}
+	if report.Branch != "" || report.Period != "" {
+		fmt.Println()
+	}
+	for i, section := range report.Sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		if section.Title != "" {
+			fmt.Printf("%s%s:%s\n", BOLD, section.Title, RESET)
+		}
+		if section.Summary {
+			for _, row := range section.Rows {
+				fmt.Printf("%s: %s\n", row[0], row[1])
+			}
+			continue
+		}
+		if len(section.Rows) == 0 {
+			fmt.Println("(none)")
+			continue
+		}
+
+		widths := columnWidths(section)
+		fmt.Print(BOLD)
+		for c, col := range section.Columns {
+			fmt.Print(padCell(col.Header, widths[c], col.Numeric) + " ")
+		}
+		fmt.Println(RESET)
+		for _, row := range section.Rows {
+			for c, col := range section.Columns {
+				fmt.Print(padCell(row[c], widths[c], col.Numeric) + " ")
+			}
+			fmt.Println()
+		}
+	}
+}
+
+func columnWidths(section ReportSection) []int {
+	widths := make([]int, len(section.Columns))
+	for c, col := range section.Columns {
+		widths[c] = len([]rune(col.Header))
+		for _, row := range section.Rows {
+			if n := len([]rune(row[c])); n > widths[c] {
+				widths[c] = n
+			}
+		}
+	}
+	return widths
+}
+
+func padCell(value string, width int, right bool) string {
+	pad := strings.Repeat(" ", width-len([]rune(value)))
+	if right {
+		return pad + value
+	}
+	return value + pad
+}
+
+// File writers
+func writeReportCSV(report Report, filename string) error {
+	rows := [][]string{{report.Title}, {"Generated", report.GeneratedAt, report.GeneratedBy}}
+	if report.Branch != "" {
+		rows = append(rows, []string{"Branch", report.Branch})
+	}
+	if report.Period != "" {
+		rows = append(rows, []string{"Period", report.Period})
+	}
+	for _, section := range report.Sections {
+		rows = append(rows, []string{})
+		if section.Title != "" {
+			rows = append(rows, []string{section.Title})
+		}
+		var header []string
+		for _, col := range section.Columns {
+			header = append(header, col.Header)
+		}
+		rows = append(rows, header)
+		rows = append(rows, section.Rows...)
+	}
+	return writeCSVFile(filename, rows)
+}
+
+func writeReportJSON(report Report, filename string) error {
+	type jsonSection struct {
+		Title string                   `json:"title,omitempty"`
+		Rows  []map[string]interface{} `json:"rows"`
+	}
+	out := struct {
+		Title       string        `json:"title"`
+		Period      string        `json:"period,omitempty"`
+		Branch      string        `json:"branch,omitempty"`
+		GeneratedAt string        `json:"generated_at"`
+		GeneratedBy string        `json:"generated_by"`
+		Sections    []jsonSection `json:"sections"`
+	}{report.Title, report.Period, report.Branch, report.GeneratedAt, report.GeneratedBy, nil}
+
+	for _, section := range report.Sections {
+		js := jsonSection{Title: section.Title, Rows: []map[string]interface{}{}}
+		for _, row := range section.Rows {
+			obj := make(map[string]interface{})
+			for c, col := range section.Columns {
+				obj[col.Key] = row[c]
+				if col.Numeric {
+					if n, err := strconv.ParseFloat(row[c], 64); err == nil {
+						obj[col.Key] = n
+					}
+				}
+			}
+			js.Rows = append(js.Rows, obj)
+		}
+		out.Sections = append(out.Sections, js)
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(out); err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	return nil
+}
+
+// writeReportHTML writes a standalone page that can be attached to an email
+func writeReportHTML(report Report, filename string) error {
+	var b strings.Builder
+	esc := html.EscapeString
+
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", esc(report.Title))
+	b.WriteString("<style>\n" +
+		"body { font-family: Helvetica, Arial, sans-serif; margin: 2em; color: #222; }\n" +
+		"h1 { font-size: 1.5em; margin-bottom: 0.2em; }\n" +
+		"h2 { font-size: 1.1em; margin-top: 1.5em; }\n" +
+		".meta { color: #666; font-size: 0.9em; }\n" +
+		"table { border-collapse: collapse; margin-top: 0.5em; }\n" +
+		"th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }\n" +
+		"th { background: #f0f0f0; }\n" +
+		"td.num, th.num { text-align: right; }\n" +
+		"</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", esc(report.Title))
+	fmt.Fprintf(&b, "<p class=\"meta\">Generated %s by %s", esc(report.GeneratedAt), esc(report.GeneratedBy))
+	if report.Branch != "" {
+		fmt.Fprintf(&b, " &middot; Branch %s", esc(report.Branch))
+	}
+	if report.Period != "" {
+		fmt.Fprintf(&b, " &middot; Period %s", esc(report.Period))
+	}
+	b.WriteString("</p>\n")
+
+	for _, section := range report.Sections {
+		if section.Title != "" {
+			fmt.Fprintf(&b, "<h2>%s</h2>\n", esc(section.Title))
+		}
+		b.WriteString("<table>\n<tr>")
+		for _, col := range section.Columns {
+			fmt.Fprintf(&b, "<th%s>%s</th>", numClass(col), esc(col.Header))
+		}
+		b.WriteString("</tr>\n")
+		for _, row := range section.Rows {
+			b.WriteString("<tr>")
+			for c, col := range section.Columns {
+				fmt.Fprintf(&b, "<td%s>%s</td>", numClass(col), esc(row[c]))
+			}
+			b.WriteString("</tr>\n")
+		}
+		b.WriteString("</table>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	if err := os.WriteFile(filename, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filename, err)
+	}
+	return nil
+}
+
+func numClass(col ReportColumn) string {
+	if col.Numeric {
+		return ` class="num"`
+	}
+	return ""
+}
+
+func writeReportPDF(report Report, filename string) error {
+	doc := newPDFDocument()
+	doc.Heading(report.Title)
+	meta := fmt.Sprintf("Generated %s by %s", report.GeneratedAt, report.GeneratedBy)
+	if report.Branch != "" {
+		meta += " - Branch " + report.Branch
+	}
+	if report.Period != "" {
+		meta += " - Period " + report.Period
+	}
+	doc.Text(meta)
+
+	for _, section := range report.Sections {
+		doc.Space()
+		if section.Title != "" {
+			doc.Subheading(section.Title)
+		}
+		var headers []string
+		var numeric []bool
+		for _, col := range section.Columns {
+			headers = append(headers, col.Header)
+			numeric = append(numeric, col.Numeric)
+		}
+		doc.Table(headers, numeric, section.Rows)
+	}
+
+	if err := os.WriteFile(filename, doc.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filename, err)
+	}
+	return nil
+}
+
+// Export functions
+func exportReport(report Report, format int) (string, error) {
+	filename := fmt.Sprintf("report-%s-%s.%s", report.Name, time.Now().Format("20060102150405"), formatExtensions[format])
+
+	var err error
+	switch format {
+	case FORMAT_CSV:
+		err = writeReportCSV(report, filename)
+	case FORMAT_JSON:
+		err = writeReportJSON(report, filename)
+	case FORMAT_HTML:
+		err = writeReportHTML(report, filename)
+	case FORMAT_PDF:
+		err = writeReportPDF(report, filename)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	auditLog(AUDIT_EXPORT, "report", 0, nil, map[string]interface{}{
+		"report": report.Name, "period": report.Period, "branch": report.Branch, "file": filename,
+	})
+	return filename, nil
+}
+
+// offerExport asks whether to save the report just shown
+func offerExport(report Report) {
+	fmt.Println("\nExport: 1. CSV  2. JSON  3. HTML  4. PDF  0. Back")
+	format := getValidInt("Choose format: ", 0, 4)
+	if format == 0 {
+		return
+	}
+
+	filename, err := exportReport(report, format)
+	if err != nil {
+		printError(err.Error())
+	} else {
+		printSuccess(fmt.Sprintf("Report exported to %s.", filename))
+	}
+	pause()
+}
+
+// getPeriod asks for a MM/YYYY month; "all" gives an empty period
+func getPeriod() string {
+	for {
+		input := strings.ToLower(getValidInput("Period (MM/YYYY, or 'all'): "))
+		if input == "all" {
+			return ""
+		}
+		if _, err := time.Parse("01/2006", input); err == nil {
+			return input
+		}
+		printError("Please enter a month as MM/YYYY or 'all'.")
+	}
+}
+
+func exportLists() {
+	printHeader("Export Lists & Reports")
+
+	fmt.Println("1. Patient List")
+	fmt.Println("2. Package List")
+	fmt.Println("3. Medical Records")
+	fmt.Println("4. Patient Statistics Report")
+	fmt.Println("5. Package Statistics Report")
+	fmt.Println("6. Revenue Report")
+	fmt.Println("7. Workload by Doctor")
+	choice := getValidInt("Choose what to export (0 to cancel): ", 0, 7)
+
+	var report Report
+	switch choice {
+	case 0:
+		return
+	case 1:
+		if !requirePermission(PERM_PATIENT_VIEW) {
+			return
+		}
+		report = patientListReport(getReportBranch())
+	case 2:
+		if !requirePermission(PERM_PACKAGE_VIEW) {
+			return
+		}
+		report = packageListReport(getReportBranch())
+	case 3:
+		if !requirePermission(PERM_RECORD_VIEW) {
+			return
+		}
+		report = recordListReport(getPeriod(), getReportBranch())
+	case 4:
+		if !requirePermission(PERM_REPORT_PATIENT) {
+			return
+		}
+		report = patientStatsReport(getReportBranch())
+	case 5:
+		if !requirePermission(PERM_REPORT_PACKAGE) {
+			return
+		}
+		report = packageStatsReport(getReportBranch())
+	case 6:
+		if !requirePermission(PERM_REPORT_REVENUE) {
+			return
+		}
+		report = revenueReport(getPeriod(), getReportBranch())
+	case 7:
+		if !requirePermission(PERM_PRACTITIONER_MANAGE) {
+			return
+		}
+		report = workloadReport(getPeriod(), getReportBranch())
+	}
+
+	fmt.Println("File format: 1. CSV  2. JSON  3. HTML  4. PDF")
+	format := getValidInt("Choose format: ", 1, 4)
+
+	filename, err := exportReport(report, format)
+	if err != nil {
+		printError(err.Error())
+	} else {
+		rows := 0
+		for _, section := range report.Sections {
+			rows += len(section.Rows)
+		}
+		printSuccess(fmt.Sprintf("%s exported to %s (%d rows).", report.Title, filename, rows))
+	}
+	pause()
+}
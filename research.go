@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	RESEARCH_K_DEFAULT  = 5
+	DATE_SHIFT_MAX_DAYS = 180
+)
+
+// Data structures
+type ResearchPatient struct {
+	ID      string `json:"id"`
+	AgeBand string `json:"age_band"`
+	Gender  string `json:"gender"`
+}
+
+type ResearchRecord struct {
+	ID        string `json:"id"`
+	PatientID string `json:"patient_id"`
+	Package   string `json:"package"`
+	Category  string `json:"category"`
+	Date      string `json:"date"`
+}
+
+type ResearchResult struct {
+	RecordID    string  `json:"record_id"`
+	Code        string  `json:"code"`
+	Examination string  `json:"examination"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit"`
+	Flag        string  `json:"flag"`
+}
+
+type ResearchDataset struct {
+	GeneratedAt        string            `json:"generated_at"`
+	K                  int               `json:"k"`
+	Patients           []ResearchPatient `json:"patients"`
+	Records            []ResearchRecord  `json:"records"`
+	Results            []ResearchResult  `json:"results"`
+	SuppressedPatients int               `json:"suppressed_patients"`
+	SuppressedRecords  int               `json:"suppressed_records"`
+}
+
+// researchKey is the secret for pseudonyms and date shifts. It is kept in the
+// encrypted data file so repeated exports use the same pseudonyms.
+var researchKey string
+
+// Pseudonymisation functions
+func newResearchKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func keyedHash(key, message string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// pseudonym replaces an ID with a keyed hash; without the key it cannot be
+// reversed or recomputed
+func pseudonym(key, prefix string, id int) string {
+	sum := keyedHash(key, fmt.Sprintf("%s:%d", prefix, id))
+	return prefix + "-" + hex.EncodeToString(sum[:8])
+}
+
+// dateShiftDays gives every patient their own offset, so intervals between a
+// patient's visits are kept while the real dates are hidden
+func dateShiftDays(key string, patientID int) int {
+	sum := keyedHash(key, fmt.Sprintf("shift:%d", patientID))
+	n := int(binary.BigEndian.Uint32(sum[:4]) % uint32(2*DATE_SHIFT_MAX_DAYS+1))
+	return n - DATE_SHIFT_MAX_DAYS
+}
+
+// suppressSmallGroups drops patients whose age band and gender combination is
+// shared by fewer than k patients. It returns the patients kept and the
+// pseudonyms of those removed.
+func suppressSmallGroups(list []ResearchPatient, k int) ([]ResearchPatient, map[string]bool) {
+	groupSize := make(map[string]int)
+	for _, p := range list {
+		groupSize[p.AgeBand+"|"+p.Gender]++
+	}
+
+	var kept []ResearchPatient
+	suppressed := make(map[string]bool)
+	for _, p := range list {
+		if groupSize[p.AgeBand+"|"+p.Gender] < k {
+			suppressed[p.ID] = true
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	return kept, suppressed
+}
+
+// Dataset functions
+func buildResearchDataset(key string, k int) ResearchDataset {
+	dataset := ResearchDataset{
+		GeneratedAt: time.Now().Format("02/01/2006 15:04"),
+		K:           k,
+	}
+
+	// Patients removed from the register still appear through their records
+	seen := make(map[int]bool)
+	var list []ResearchPatient
+	addPatient := func(p Patient) {
+		if seen[p.ID] {
+			return
+		}
+		seen[p.ID] = true
+		list = append(list, ResearchPatient{
+			ID:      pseudonym(key, "P", p.ID),
+			AgeBand: ageBand(p.Age),
+			Gender:  p.Gender,
+		})
+	}
+	for i := 0; i < patients.N; i++ {
+		addPatient(patients.Daftar[i])
+	}
+	for i := 0; i < records.N; i++ {
+		if !isAnonymised(records.Daftar[i]) {
+			addPatient(records.Daftar[i].Patient)
+		}
+	}
+
+	var suppressed map[string]bool
+	dataset.Patients, suppressed = suppressSmallGroups(list, k)
+	dataset.SuppressedPatients = len(suppressed)
+
+	for i := 0; i < records.N; i++ {
+		r := records.Daftar[i]
+		patientID := pseudonym(key, "P", r.Patient.ID)
+		// Anonymised records can't be grouped by patient, so they are left out
+		if isAnonymised(r) || suppressed[patientID] {
+			dataset.SuppressedRecords++
+			continue
+		}
+
+		recordID := pseudonym(key, "R", r.ID)
+		dataset.Records = append(dataset.Records, ResearchRecord{
+			ID:        recordID,
+			PatientID: patientID,
+			Package:   r.Package.Name,
+			Category:  recordCategory(r),
+			Date:      addDays(r.Date, dateShiftDays(key, r.Patient.ID)),
+		})
+
+		for _, res := range r.Results {
+			unit := ""
+			if idx := sequentialSearchExaminationByCode(res.Code); idx != -1 {
+				unit = examinations.Daftar[idx].Unit
+			}
+			dataset.Results = append(dataset.Results, ResearchResult{
+				RecordID:    recordID,
+				Code:        res.Code,
+				Examination: examinationName(res.Code),
+				Value:       res.Value,
+				Unit:        unit,
+				Flag:        res.Flag,
+			})
+		}
+	}
+
+	return dataset
+}
+
+func writeResearchJSON(dataset ResearchDataset, filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(dataset); err != nil {
+		return fmt.Errorf("failed to encode dataset: %v", err)
+	}
+	return nil
+}
+
+// csvCell keeps a spreadsheet from running a cell as a formula: text that
+// starts with =, +, -, @, a tab or a carriage return gets a leading quote.
+// Numbers are left as they are.
+func csvCell(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+func writeCSVFile(filename string, rows [][]string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = csvCell(value)
+		}
+		writer.Write(cells)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filename, err)
+	}
+	return nil
+}
+
+// writeResearchCSV writes one file each for patients, records and results
+func writeResearchCSV(dataset ResearchDataset, base string) ([]string, error) {
+	patientRows := [][]string{{"patient_id", "age_band", "gender"}}
+	for _, p := range dataset.Patients {
+		patientRows = append(patientRows, []string{p.ID, p.AgeBand, p.Gender})
+	}
+
+	recordRows := [][]string{{"record_id", "patient_id", "package", "category", "date"}}
+	for _, r := range dataset.Records {
+		recordRows = append(recordRows, []string{r.ID, r.PatientID, r.Package, r.Category, r.Date})
+	}
+
+	resultRows := [][]string{{"record_id", "code", "examination", "value", "unit", "flag"}}
+	for _, r := range dataset.Results {
+		resultRows = append(resultRows, []string{r.RecordID, r.Code, r.Examination,
+			strconv.FormatFloat(r.Value, 'f', -1, 64), r.Unit, r.Flag})
+	}
+
+	files := []string{base + "-patients.csv", base + "-records.csv", base + "-results.csv"}
+	for i, rows := range [][][]string{patientRows, recordRows, resultRows} {
+		if err := writeCSVFile(files[i], rows); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func exportResearchDataset() {
+	printHeader("De-identified Research Export")
+
+	if !requirePermission(PERM_RESEARCH_EXPORT) {
+		return
+	}
+
+	if records.N == 0 && patients.N == 0 {
+		printWarning("No data available for export.")
+		pause()
+		return
+	}
+
+	if researchKey == "" {
+		researchKey = newResearchKey()
+	}
+
+	fmt.Println("Pseudonyms: 1. Same as earlier exports (linkable)  2. New for this export only (unlinkable)")
+	key := researchKey
+	if getValidInt("Choose option: ", 1, 2) == 2 {
+		key = newResearchKey()
+	}
+
+	k := getValidInt(fmt.Sprintf("Minimum group size k (2-100, usually %d): ", RESEARCH_K_DEFAULT), 2, 100)
+	dataset := buildResearchDataset(key, k)
+
+	fmt.Println("File format: 1. JSON  2. CSV")
+	format := getValidInt("Choose format: ", 1, 2)
+
+	base := "research-" + time.Now().Format("20060102150405")
+	var files []string
+	var err error
+	if format == 1 {
+		files = []string{base + ".json"}
+		err = writeResearchJSON(dataset, files[0])
+	} else {
+		files, err = writeResearchCSV(dataset, base)
+	}
+
+	if err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_EXPORT, "research_dataset", 0, nil, map[string]interface{}{
+		"files": files, "k": k, "patients": len(dataset.Patients), "records": len(dataset.Records),
+		"suppressed_patients": dataset.SuppressedPatients,
+	})
+
+	printSuccess(fmt.Sprintf("Exported %d patients, %d records and %d results.",
+		len(dataset.Patients), len(dataset.Records), len(dataset.Results)))
+	for _, f := range files {
+		fmt.Printf("  %s\n", f)
+	}
+	if dataset.SuppressedPatients > 0 {
+		printWarning(fmt.Sprintf("%d patients (%d records) suppressed: their age band and gender group is smaller than %d.",
+			dataset.SuppressedPatients, dataset.SuppressedRecords, k))
+	}
+	fmt.Printf("Dates are shifted by up to %d days per patient; ages are given as bands.\n", DATE_SHIFT_MAX_DAYS)
+	pause()
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Constants
+const (
+	LANG_EN = "en"
+	LANG_ID = "id"
+
+	DEFAULT_CLINIC_NAME = "Medical Check-Up Clinic"
+)
+
+// Data structures
+// ResultTemplate holds the clinic header and wording of the printed result sheet
+type ResultTemplate struct {
+	ClinicName string `json:"clinic_name,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Phone      string `json:"phone,omitempty"`
+	Footer     string `json:"footer,omitempty"`
+	Language   string `json:"language,omitempty"`
+}
+
+var resultTemplate ResultTemplate
+
+var languageNames = map[string]string{
+	LANG_EN: "English",
+	LANG_ID: "Bahasa Indonesia",
+}
+
+// resultLabels is the wording of the result sheet in each language
+var resultLabels = map[string]map[string]string{
+	LANG_EN: {
+		"title":             "Medical Check-Up Result",
+		"name":              "Name",
+		"patient_id":        "Patient ID",
+		"gender":            "Gender",
+		"age":               "Age",
+		"years":             "years",
+		"M":                 "Male",
+		"F":                 "Female",
+		"record_id":         "Record No.",
+		"date":              "Exam Date",
+		"package":           "Package",
+		"company":           "Company",
+		"results":           "Examination Results",
+		"examination":       "Examination",
+		"result":            "Result",
+		"unit":              "Unit",
+		"reference":         "Reference Range",
+		"flag":              "Flag",
+		"H":                 "High *",
+		"L":                 "Low *",
+		"N":                 "Normal",
+		"no_results":        "No examination results recorded.",
+		"legend":            "* Outside the reference range",
+		"conclusion":        "Doctor's Conclusion",
+		"no_conclusion":     "No conclusion recorded yet.",
+		"fitness":           "Fitness for Work",
+		"findings":          "Findings",
+		"advice":            "Recommendations",
+		"follow_up":         "Follow-up",
+		"follow_up_in":      "in %d months (by %s)",
+		"fit":               "Fit",
+		"fit_with_notes":    "Fit with notes",
+		"temporarily_unfit": "Temporarily unfit",
+		"unfit":             "Unfit",
+		"doctor":            "Examining Doctor",
+		"license":           "License No.",
+		"printed":           "Printed",
+		"page":              "Page %d of %d",
+	},
+	LANG_ID: {
+		"title":             "Hasil Pemeriksaan Kesehatan",
+		"name":              "Nama",
+		"patient_id":        "No. Pasien",
+		"gender":            "Jenis Kelamin",
+		"age":               "Usia",
+		"years":             "tahun",
+		"M":                 "Laki-laki",
+		"F":                 "Perempuan",
+		"record_id":         "No. Rekam Medis",
+		"date":              "Tgl. Periksa",
+		"package":           "Paket",
+		"company":           "Perusahaan",
+		"results":           "Hasil Pemeriksaan",
+		"examination":       "Pemeriksaan",
+		"result":            "Hasil",
+		"unit":              "Satuan",
+		"reference":         "Nilai Rujukan",
+		"flag":              "Keterangan",
+		"H":                 "Tinggi *",
+		"L":                 "Rendah *",
+		"N":                 "Normal",
+		"no_results":        "Belum ada hasil pemeriksaan.",
+		"legend":            "* Di luar nilai rujukan",
+		"conclusion":        "Kesimpulan Dokter",
+		"no_conclusion":     "Belum ada kesimpulan.",
+		"fitness":           "Kelaikan Kerja",
+		"findings":          "Temuan",
+		"advice":            "Saran",
+		"follow_up":         "Kontrol Ulang",
+		"follow_up_in":      "dalam %d bulan (sebelum %s)",
+		"fit":               "Laik kerja",
+		"fit_with_notes":    "Laik kerja dengan catatan",
+		"temporarily_unfit": "Tidak laik kerja sementara",
+		"unfit":             "Tidak laik kerja",
+		"doctor":            "Dokter Pemeriksa",
+		"license":           "No. SIP",
+		"printed":           "Dicetak",
+		"page":              "Halaman %d dari %d",
+	},
+}
+
+// Template functions
+func sheetLabel(lang, key string) string {
+	if text, ok := resultLabels[lang][key]; ok {
+		return text
+	}
+	return resultLabels[LANG_EN][key]
+}
+
+func resultLanguage() string {
+	if _, ok := resultLabels[resultTemplate.Language]; ok {
+		return resultTemplate.Language
+	}
+	return LANG_EN
+}
+
+func clinicName() string {
+	if resultTemplate.ClinicName == "" {
+		return DEFAULT_CLINIC_NAME
+	}
+	return resultTemplate.ClinicName
+}
+
+func referenceRange(code string) string {
+	if idx := sequentialSearchExaminationByCode(code); idx != -1 && hasReferenceRange(examinations.Daftar[idx]) {
+		e := examinations.Daftar[idx]
+		return fmt.Sprintf("%.2f - %.2f", e.RefLow, e.RefHigh)
+	}
+	return "-"
+}
+
+func examinationUnit(code string) string {
+	if idx := sequentialSearchExaminationByCode(code); idx != -1 && examinations.Daftar[idx].Unit != "-" {
+		return examinations.Daftar[idx].Unit
+	}
+	return ""
+}
+
+// buildResultSheet lays out the result sheet of a record in the given language
+func buildResultSheet(r Record, lang string) *pdfDocument {
+	doc := newPDFDocument()
+	doc.pageLabel = sheetLabel(lang, "page")
+
+	// Clinic header
+	doc.Heading(clinicName())
+	for _, line := range []string{resultTemplate.Address, resultTemplate.Phone} {
+		if line != "" {
+			doc.Text(line)
+		}
+	}
+	doc.Rule()
+	doc.Space()
+	doc.Subheading(sheetLabel(lang, "title"))
+
+	// Patient and visit
+	company := "-"
+	if idx := binarySearchCompanyByID(r.CompanyID); r.CompanyID != 0 && idx != -1 {
+		company = companies.Daftar[idx].Name
+	}
+	doc.Fields([][2]string{
+		{sheetLabel(lang, "name"), r.Patient.Name},
+		{sheetLabel(lang, "record_id"), fmt.Sprintf("%d", r.ID)},
+		{sheetLabel(lang, "patient_id"), fmt.Sprintf("%d", r.Patient.ID)},
+		{sheetLabel(lang, "date"), r.Date},
+		{sheetLabel(lang, "gender"), sheetLabel(lang, r.Patient.Gender)},
+		{sheetLabel(lang, "package"), r.Package.Name},
+		{sheetLabel(lang, "age"), fmt.Sprintf("%d %s", r.Patient.Age, sheetLabel(lang, "years"))},
+		{sheetLabel(lang, "company"), company},
+	})
+	doc.Space()
+
+	// Results with reference ranges and flags
+	doc.Subheading(sheetLabel(lang, "results"))
+	if len(r.Results) == 0 {
+		doc.Text(sheetLabel(lang, "no_results"))
+	} else {
+		var rows [][]string
+		abnormal := false
+		for _, res := range r.Results {
+			flag := ""
+			if res.Flag != "" {
+				flag = sheetLabel(lang, res.Flag)
+			}
+			abnormal = abnormal || isAbnormal(res)
+			rows = append(rows, []string{
+				examinationName(res.Code), fmt.Sprintf("%.2f", res.Value),
+				examinationUnit(res.Code), referenceRange(res.Code), flag,
+			})
+		}
+		doc.Table([]string{
+			sheetLabel(lang, "examination"), sheetLabel(lang, "result"), sheetLabel(lang, "unit"),
+			sheetLabel(lang, "reference"), sheetLabel(lang, "flag"),
+		}, []bool{false, true, false, false, false}, rows)
+		if abnormal {
+			doc.Text(sheetLabel(lang, "legend"))
+		}
+	}
+	doc.Space()
+
+	// Conclusion and sign-off
+	doc.Subheading(sheetLabel(lang, "conclusion"))
+	if !hasConclusion(r) {
+		doc.Text(sheetLabel(lang, "no_conclusion"))
+	} else if r.Conclusion != "" {
+		doc.Paragraph(r.Conclusion)
+	}
+	if r.Fitness != "" {
+		fields := [][2]string{{sheetLabel(lang, "fitness"), sheetLabel(lang, r.Fitness)}}
+		if due := followUpDue(r.Date, r.FollowUpMonths); due != "" {
+			fields = append(fields, [2]string{sheetLabel(lang, "follow_up"),
+				fmt.Sprintf(sheetLabel(lang, "follow_up_in"), r.FollowUpMonths, due)})
+		}
+		doc.Fields(fields)
+	}
+	if len(r.Findings) > 0 {
+		doc.Text(sheetLabel(lang, "findings") + ":")
+		for _, f := range r.Findings {
+			doc.Text(fmt.Sprintf("  %s  %s", f.Code, f.Text))
+		}
+	}
+	if r.Recommendations != "" {
+		doc.Text(sheetLabel(lang, "advice") + ":")
+		doc.Paragraph(r.Recommendations)
+	}
+	doc.Space()
+	if !isSignedOff(r) {
+		doc.Signature(sheetLabel(lang, "doctor"), "")
+	} else if idx := binarySearchPractitionerByID(r.SignedByID); r.SignedByID != 0 && idx != -1 {
+		p := practitioners.Daftar[idx]
+		doc.Signature(sheetLabel(lang, "doctor"), p.Name,
+			fmt.Sprintf("%s %s", sheetLabel(lang, "license"), p.LicenseNo), r.ConcludedAt)
+	} else {
+		doc.Signature(sheetLabel(lang, "doctor"), signerName(r), r.ConcludedAt)
+	}
+
+	doc.Space()
+	if resultTemplate.Footer != "" {
+		doc.Paragraph(resultTemplate.Footer)
+	}
+	doc.Text(fmt.Sprintf("%s: %s", sheetLabel(lang, "printed"), time.Now().Format("02/01/2006 15:04")))
+	return doc
+}
+
+// Result sheet functions
+func printResultSheet() {
+	printHeader("Print Result Sheet")
+
+	if !requirePermission(PERM_RECORD_VIEW) {
+		return
+	}
+
+	id := getValidInt("Enter record ID: ", 1, 999999)
+	idx := searchBranchRecordByID(id)
+
+	if idx == -1 {
+		printError("Record not found.")
+		pause()
+		return
+	}
+
+	r := records.Daftar[idx]
+	lang := resultLanguage()
+	fmt.Printf("Language: 1. English  2. Bahasa Indonesia  0. Default (%s)\n", languageNames[lang])
+	switch getValidInt("Choose option: ", 0, 2) {
+	case 1:
+		lang = LANG_EN
+	case 2:
+		lang = LANG_ID
+	}
+
+	if r.Conclusion == "" {
+		printWarning("This record has no doctor's conclusion yet.")
+	}
+
+	filename := fmt.Sprintf("result-%d-%s-%s.pdf", r.ID, lang, time.Now().Format("20060102150405"))
+	if err := os.WriteFile(filename, buildResultSheet(r, lang).Bytes(), 0600); err != nil {
+		printError(fmt.Sprintf("failed to write %s: %v", filename, err))
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_EXPORT, "record_result_sheet", r.ID, nil, map[string]interface{}{
+		"language": lang, "file": filename,
+	})
+	printSuccess(fmt.Sprintf("Result sheet saved to %s.", filename))
+	pause()
+}
+
+func enterConclusion() {
+	printHeader("Doctor's Conclusion")
+
+	if !requirePermission(PERM_RESULT_SIGN) {
+		return
+	}
+
+	id := getValidInt("Enter record ID: ", 1, 999999)
+	idx := searchBranchRecordByID(id)
+
+	if idx == -1 {
+		printError("Record not found.")
+		pause()
+		return
+	}
+
+	r := &records.Daftar[idx]
+	if isSignedOff(*r) {
+		printError(fmt.Sprintf("Record %d is already signed off by %s. Reopen it to change the conclusion.", r.ID, signerName(*r)))
+		pause()
+		return
+	}
+
+	// The conclusion is signed as the practitioner linked to this account
+	pIdx := practitionerByUsername(currentUser.Username)
+	if pIdx == -1 {
+		printError("Your account is not linked to a practitioner. Ask a manager to link it under Practitioners.")
+		pause()
+		return
+	}
+	signer := practitioners.Daftar[pIdx]
+
+	auditLog(AUDIT_VIEW, "record_results", r.ID, nil, nil)
+	markPHIOnScreen()
+	fmt.Printf("\nRecord %d - %s, %s (%s)\n\n", r.ID, r.Patient.Name, r.Package.Name, r.Date)
+	printRecordResults(*r)
+
+	draft := conclusionOf(*r)
+	if hasConclusion(*r) {
+		fmt.Printf("\n%sDraft conclusion:%s\n", BOLD, RESET)
+		printConclusion(*r)
+		fmt.Println("\n1. Sign the draft as it is  2. Edit it  0. Cancel")
+		switch getValidInt("Choose option: ", 0, 2) {
+		case 0:
+			printWarning("Conclusion unchanged.")
+			pause()
+			return
+		case 2:
+			draft = getConclusionDraft(draft)
+		}
+	} else {
+		draft = getConclusionDraft(draft)
+	}
+
+	// An incomplete conclusion is kept as a draft so nothing typed is lost
+	if err := checkConclusion(draft); err != nil {
+		if !sameValue(draft, conclusionOf(*r)) {
+			auditLog(AUDIT_UPDATE, "record_conclusion", r.ID, conclusionOf(*r), draft)
+			beforeRecord := *r
+			setConclusion(r, draft)
+			publishChange(ENTITY_RECORD, r.ID, beforeRecord, *r)
+		}
+		printWarning(fmt.Sprintf("Saved as a draft, not signed: %s.", err))
+		pause()
+		return
+	}
+
+	signConclusion(r, signer, draft)
+	deliverNotificationsSoon()
+	pause()
+}
+
+// signConclusion signs a record as the given practitioner and locks its
+// results. Station examiners are fixed at the ones in place now, so later
+// schedule changes don't rewrite who examined the patient.
+func signConclusion(r *Record, signer Practitioner, conclusion ConclusionDraft) {
+	before := map[string]interface{}{"conclusion": conclusionOf(*r), "by": r.ConcludedBy, "at": r.ConcludedAt,
+		"signed_by_id": r.SignedByID, "doctor_id": r.DoctorID, "examiners": append([]StationExaminer(nil), r.Examiners...)}
+	beforeRecord := *r
+
+	for _, station := range recordStations(*r) {
+		setStationExaminer(r, station, stationExaminer(*r, station))
+	}
+	if r.DoctorID == 0 {
+		r.DoctorID = signer.ID
+	}
+	setConclusion(r, conclusion)
+	r.ConcludedBy = currentUser.Username
+	r.ConcludedAt = time.Now().Format("02/01/2006 15:04")
+	r.SignedByID = signer.ID
+
+	auditLog(AUDIT_UPDATE, "record_conclusion", r.ID, before, map[string]interface{}{"conclusion": conclusion,
+		"by": r.ConcludedBy, "at": r.ConcludedAt, "signed_by_id": r.SignedByID, "doctor_id": r.DoctorID, "examiners": r.Examiners})
+	publishEvent(ENTITY_RECORD+"."+CHANGE_COMPLETED, ENTITY_RECORD, r.ID, *r)
+	queueRecordChange(beforeRecord, *r)
+	printSuccess(fmt.Sprintf("Conclusion signed by %s. The results are now locked.", signer.Name))
+}
+
+func editResultTemplate() {
+	printHeader("Result Sheet Template")
+
+	if !requirePermission(PERM_CLINIC_MANAGE) {
+		return
+	}
+
+	before := resultTemplate
+	fmt.Printf("1. Clinic name: %s\n", clinicName())
+	fmt.Printf("2. Address: %s\n", resultTemplate.Address)
+	fmt.Printf("3. Phone: %s\n", resultTemplate.Phone)
+	fmt.Printf("4. Footer note: %s\n", resultTemplate.Footer)
+	fmt.Printf("5. Default language: %s\n", languageNames[resultLanguage()])
+
+	choice := getValidInt("\nChoose field to change (0 to return): ", 0, 5)
+	switch choice {
+	case 0:
+		return
+	case 1:
+		resultTemplate.ClinicName = getValidInput("Enter clinic name: ")
+	case 2:
+		resultTemplate.Address = templateText("Enter address (- for none): ")
+	case 3:
+		resultTemplate.Phone = templateText("Enter phone (- for none): ")
+	case 4:
+		resultTemplate.Footer = templateText("Enter footer note (- for none): ")
+	case 5:
+		fmt.Println("Language: 1. English  2. Bahasa Indonesia")
+		if getValidInt("Choose option: ", 1, 2) == 1 {
+			resultTemplate.Language = LANG_EN
+		} else {
+			resultTemplate.Language = LANG_ID
+		}
+	}
+
+	auditLog(AUDIT_UPDATE, "result_template", 0, before, resultTemplate)
+	printSuccess("Result sheet template updated.")
+	pause()
+}
+
+// templateText reads an optional template line; "-" clears it
+func templateText(prompt string) string {
+	text := getValidInput(prompt)
+	if text == "-" {
+		return ""
+	}
+	return text
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Copy of audit chain functions from audit.go for testing
+const (
+	AUDIT_GENESIS_HASH = "0000000000000000000000000000000000000000000000000000000000000000"
+	AUDIT_REDACTED     = "[redacted]"
+	AUDIT_REANCHOR     = "reanchor"
+
+	AUDIT_FORMAT_LEGACY  = 0
+	AUDIT_FORMAT_DIGEST  = 1
+	AUDIT_FORMAT_KEYED   = 2
+	AUDIT_FORMAT_CURRENT = AUDIT_FORMAT_KEYED
+
+	AUDIT_SEALED_PREFIX = "sealed:"
+)
+
+var auditHead AuditHead
+
+// Data structures
+type AuditEntry struct {
+	Seq       int    `json:"seq"`
+	Timestamp string `json:"timestamp"`
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Entity    string `json:"entity"`
+	EntityID  int    `json:"entity_id"`
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+	Format    int    `json:"format,omitempty"`
+
+	// Set when personal data is erased; the digests keep the chain verifiable.
+	// Key is removed at the same time, so the values cannot be guessed back
+	// from their digests.
+	BeforeDigest string `json:"before_digest,omitempty"`
+	AfterDigest  string `json:"after_digest,omitempty"`
+	Key          string `json:"key,omitempty"`
+}
+
+// AuditHead is the last audit entry at the time of saving. It is kept in the
+// data file so that entries cut off the end of the log can be detected.
+type AuditHead struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Hash chain functions
+func auditDigest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditKeyedDigest is the HMAC of a before/after value under the entry's key
+func auditKeyedDigest(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	secret, _ := hex.DecodeString(key)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAuditKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// auditContentDigest gives the digest of a before/after value, using the
+// stored digest once the value has been redacted. A sealed value is digested
+// as it was before sealing.
+func auditContentDigest(e AuditEntry, value, digest string) string {
+	switch {
+	case digest != "":
+		return digest
+	case e.Format == AUDIT_FORMAT_KEYED:
+		return auditKeyedDigest(e.Key, plainAuditValue(value))
+	}
+	return auditDigest(plainAuditValue(value))
+}
+
+// computeAuditHash covers the digests of the before/after values rather than
+// the values themselves, so they can be redacted without breaking the chain
+func computeAuditHash(e AuditEntry) string {
+	return hashAuditContent(e, auditContentDigest(e, e.Before, e.BeforeDigest), auditContentDigest(e, e.After, e.AfterDigest))
+}
+
+// computePlainAuditHash is the hash of entries from before redaction existed,
+// which covers the before/after values themselves
+func computePlainAuditHash(e AuditEntry) string {
+	return hashAuditContent(e, plainAuditValue(e.Before), plainAuditValue(e.After))
+}
+
+func hashAuditContent(e AuditEntry, before, after string) string {
+	content := fmt.Sprintf("%d|%s|%s|%s|%s|%d|%s|%s|%s",
+		e.Seq, e.Timestamp, e.User, e.Action, e.Entity, e.EntityID, before, after, e.PrevHash)
+	return auditDigest(content)
+}
+
+// Sealing functions
+// sealAuditValue encrypts a before/after value with the data key, so the
+// audit log holds no patient data in the clear. Hashes cover the value
+// before sealing, so it can be sealed again under a new key.
+func sealAuditValue(value string) string {
+	if value == "" || value == AUDIT_REDACTED || strings.HasPrefix(value, AUDIT_SEALED_PREFIX) || dataKey == nil {
+		return value
+	}
+	gcm, err := newGCM(dataKey.Key)
+	if err != nil {
+		return value
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return value
+	}
+	check := keyCheck(dataKey.Key)
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(check))
+	return AUDIT_SEALED_PREFIX + check + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// openAuditValue decrypts a sealed value with the data key, or with one of
+// keys when it was sealed before a key rotation. Values written before
+// sealing are returned as they are.
+func openAuditValue(value string, keys ...[]byte) (string, error) {
+	if !strings.HasPrefix(value, AUDIT_SEALED_PREFIX) {
+		return value, nil
+	}
+	check, encoded, _ := strings.Cut(strings.TrimPrefix(value, AUDIT_SEALED_PREFIX), ":")
+	if dataKey != nil {
+		keys = append(keys, dataKey.Key)
+	}
+	for _, key := range keys {
+		if key == nil || keyCheck(key) != check {
+			continue
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sealed) < gcm.NonceSize() {
+			return "", fmt.Errorf("sealed value is damaged")
+		}
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(check))
+		if err != nil {
+			return "", fmt.Errorf("sealed value is damaged")
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("sealed with a key that is not loaded")
+}
+
+// plainAuditValue is the value a hash covers. A value that cannot be opened
+// is left sealed, so its entry no longer verifies.
+func plainAuditValue(value string) string {
+	if plain, err := openAuditValue(value); err == nil {
+		return plain
+	}
+	return value
+}
+
+// isAuditHashValid checks an entry's hash under the format it was written in
+func isAuditHashValid(e AuditEntry) bool {
+	switch e.Format {
+	case AUDIT_FORMAT_DIGEST, AUDIT_FORMAT_KEYED:
+		return computeAuditHash(e) == e.Hash
+	case AUDIT_FORMAT_LEGACY:
+		if e.BeforeDigest == "" && e.AfterDigest == "" && computePlainAuditHash(e) == e.Hash {
+			return true
+		}
+		return computeAuditHash(e) == e.Hash
+	}
+	return false
+}
+
+// isAuditRedactable reports whether an entry still holds content to redact
+func isAuditRedactable(e AuditEntry) bool {
+	return (e.Before != "" && e.BeforeDigest == "") || (e.After != "" && e.AfterDigest == "")
+}
+
+func isRedactionValid(value, digest string) bool {
+	return digest == "" || value == "" || value == AUDIT_REDACTED
+}
+
+// redactAuditEntry replaces the before/after values with their digests,
+// destroys the key they were made with and reports whether anything was left
+// to redact
+func redactAuditEntry(e *AuditEntry) bool {
+	changed := false
+	if e.Before != "" && e.BeforeDigest == "" {
+		e.BeforeDigest = auditContentDigest(*e, e.Before, "")
+		e.Before = AUDIT_REDACTED
+		changed = true
+	}
+	if e.After != "" && e.AfterDigest == "" {
+		e.AfterDigest = auditContentDigest(*e, e.After, "")
+		e.After = AUDIT_REDACTED
+		changed = true
+	}
+	e.Key = ""
+	return changed
+}
+
+// hasAuditDigest reports whether an entry has been redacted
+func hasAuditDigest(e AuditEntry) bool {
+	return e.BeforeDigest != "" || e.AfterDigest != ""
+}
+
+// verifyAuditChain returns the sequence number of the first entry that breaks
+// the chain, or 0 when every entry is intact
+func verifyAuditChain(entries []AuditEntry) (int, string) {
+	prevHash := AUDIT_GENESIS_HASH
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			return e.Seq, fmt.Sprintf("expected sequence %d, found %d (entry missing or reordered)", i+1, e.Seq)
+		}
+		if e.PrevHash != prevHash {
+			return e.Seq, "previous hash does not match (entry removed or inserted before it)"
+		}
+		if !isRedactionValid(e.Before, e.BeforeDigest) || !isRedactionValid(e.After, e.AfterDigest) {
+			return e.Seq, "redacted entry still has content (entry modified)"
+		}
+		for _, value := range []string{e.Before, e.After} {
+			if _, err := openAuditValue(value); err != nil {
+				return e.Seq, err.Error()
+			}
+		}
+		if !isAuditHashValid(e) {
+			return e.Seq, "content does not match its hash (entry modified)"
+		}
+		prevHash = e.Hash
+	}
+	return 0, ""
+}
+
+// verifyAuditHead checks that the log still reaches the entry recorded in the
+// encrypted data file. Nothing read from the log itself can stand in for it:
+// a re-anchored log is only accepted once its new head has been saved.
+func verifyAuditHead(entries []AuditEntry, head AuditHead) bool {
+	if head.Seq == 0 {
+		return true
+	}
+	return head.Seq <= len(entries) && entries[head.Seq-1].Hash == head.Hash
+}
+
+// reanchorAuditLog rewrites entries from older hash formats in the current
+// one, so they can be redacted. Digests of entries redacted before entries
+// had keys are keyed with a key that is not kept. Only an intact chain is
+// re-anchored, and a reanchor entry records the old and new hash of the last
+// entry.
+func reanchorAuditLog(entries []AuditEntry) ([]AuditEntry, error) {
+	if badSeq, reason := verifyAuditChain(entries); badSeq != 0 {
+		return nil, fmt.Errorf("entry %d does not verify (%s), so the log cannot be re-anchored", badSeq, reason)
+	}
+
+	oldHead := AuditHead{Seq: len(entries), Hash: entries[len(entries)-1].Hash}
+	renamed := make(map[string]string)
+	prevHash := AUDIT_GENESIS_HASH
+	for i := range entries {
+		e := &entries[i]
+		e.PrevHash = prevHash
+		if e.Format != AUDIT_FORMAT_KEYED {
+			key := newAuditKey()
+			e.BeforeDigest = auditKeyedDigest(key, e.BeforeDigest)
+			e.AfterDigest = auditKeyedDigest(key, e.AfterDigest)
+			if isAuditRedactable(*e) {
+				e.Key = key
+			}
+			e.Format = AUDIT_FORMAT_KEYED
+		}
+		old := e.Hash
+		e.Hash = computeAuditHash(*e)
+		renamed[old] = e.Hash
+		prevHash = e.Hash
+	}
+
+	newHead := AuditHead{Seq: oldHead.Seq, Hash: prevHash}
+	entry := newAuditEntry(newHead.Seq+1, newHead.Hash, AUDIT_REANCHOR, "audit", 0, oldHead, newHead)
+
+	// The head this terminal saves next refers to the new hashes
+	if h, ok := renamed[auditHead.Hash]; ok {
+		auditHead.Hash = h
+	}
+	return append(entries, entry), nil
+}
+
+// newAuditEntry builds the entry that continues the chain after prevHash
+func newAuditEntry(seq int, prevHash, action, entity string, entityID int, before, after interface{}) AuditEntry {
+	user := "-"
+	if currentUser != nil {
+		user = currentUser.Username
+	}
+
+	entry := AuditEntry{
+		Seq:       seq,
+		Timestamp: time.Now().Format(time.RFC3339),
+		User:      user,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Before:    sealAuditValue(auditSnapshot(before)),
+		After:     sealAuditValue(auditSnapshot(after)),
+		PrevHash:  prevHash,
+		Format:    AUDIT_FORMAT_CURRENT,
+	}
+	if isAuditRedactable(entry) {
+		entry.Key = newAuditKey()
+	}
+	entry.Hash = computeAuditHash(entry)
+	return entry
+}
+
+func auditSnapshot(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+func buildAuditChain(n int) []AuditEntry {
+	var entries []AuditEntry
+	prevHash := AUDIT_GENESIS_HASH
+	for i := 1; i <= n; i++ {
+		e := AuditEntry{
+			Seq:       i,
+			Timestamp: "2025-01-15T08:00:00+07:00",
+			User:      "admin",
+			Action:    "update",
+			Entity:    "patient",
+			EntityID:  10000 + i,
+			Before:    `{"age":30}`,
+			After:     `{"age":31}`,
+			PrevHash:  prevHash,
+			Format:    AUDIT_FORMAT_CURRENT,
+			Key:       newAuditKey(),
+		}
+		e.Hash = computeAuditHash(e)
+		prevHash = e.Hash
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Test audit chain verification
+func TestVerifyAuditChain(t *testing.T) {
+	if seq, _ := verifyAuditChain(buildAuditChain(5)); seq != 0 {
+		t.Errorf("Intact chain reported broken at entry %d", seq)
+	}
+
+	if seq, _ := verifyAuditChain(nil); seq != 0 {
+		t.Errorf("Empty chain reported broken at entry %d", seq)
+	}
+
+	// Editing an entry's content breaks its own hash
+	modified := buildAuditChain(5)
+	modified[2].After = `{"age":99}`
+	if seq, _ := verifyAuditChain(modified); seq != 3 {
+		t.Errorf("Modified entry: expected break at 3, got %d", seq)
+	}
+
+	// Recomputing the edited entry's hash breaks the link to the next entry
+	rehashed := buildAuditChain(5)
+	rehashed[2].After = `{"age":99}`
+	rehashed[2].Hash = computeAuditHash(rehashed[2])
+	if seq, _ := verifyAuditChain(rehashed); seq != 4 {
+		t.Errorf("Rehashed entry: expected break at 4, got %d", seq)
+	}
+
+	// Removing an entry leaves a gap in the sequence
+	removed := buildAuditChain(5)
+	removed = append(removed[:1], removed[2:]...)
+	if seq, _ := verifyAuditChain(removed); seq != 3 {
+		t.Errorf("Removed entry: expected break at 3, got %d", seq)
+	}
+
+	// Truncating the tail keeps the remaining chain valid
+	if seq, _ := verifyAuditChain(buildAuditChain(5)[:3]); seq != 0 {
+		t.Errorf("Truncated chain reported broken at entry %d", seq)
+	}
+}
+
+// Test that erasure redaction keeps the chain verifiable
+func TestRedactAuditEntry(t *testing.T) {
+	entries := buildAuditChain(4)
+	if !redactAuditEntry(&entries[1]) {
+		t.Fatal("Entry with content was not redacted")
+	}
+	if redactAuditEntry(&entries[1]) {
+		t.Error("Redacting twice reported a change")
+	}
+	if entries[1].Before != AUDIT_REDACTED || entries[1].After != AUDIT_REDACTED {
+		t.Error("Redacted entry still holds its content")
+	}
+	if seq, reason := verifyAuditChain(entries); seq != 0 {
+		t.Errorf("Redacted chain reported broken at %d: %s", seq, reason)
+	}
+
+	// The key is gone, so the digest cannot be matched against guessed values
+	if entries[1].Key != "" {
+		t.Error("Redacted entry still holds its key")
+	}
+	if entries[1].AfterDigest == auditDigest(`{"age":31}`) {
+		t.Error("Redacted digest is the plain SHA-256 of the value")
+	}
+
+	// Putting different content back under the kept digest is detected
+	forged := append([]AuditEntry(nil), entries...)
+	forged[1].After = `{"age":99}`
+	if seq, _ := verifyAuditChain(forged); seq != 2 {
+		t.Errorf("Forged redacted entry: expected break at 2, got %d", seq)
+	}
+
+	// Swapping the digest is detected too
+	swapped := append([]AuditEntry(nil), entries...)
+	swapped[1].AfterDigest = auditDigest(`{"age":99}`)
+	if seq, _ := verifyAuditChain(swapped); seq != 2 {
+		t.Errorf("Swapped digest: expected break at 2, got %d", seq)
+	}
+}
+
+// Test that logs written before the hash format was recorded still verify
+func TestVerifyLegacyAuditChain(t *testing.T) {
+	entries := buildAuditChain(4)
+	prevHash := AUDIT_GENESIS_HASH
+	for i := range entries {
+		entries[i].PrevHash = prevHash
+		entries[i].Format = AUDIT_FORMAT_LEGACY
+		entries[i].Key = ""
+		if i < 2 {
+			entries[i].Hash = computePlainAuditHash(entries[i]) // before redaction existed
+		} else {
+			entries[i].Hash = computeAuditHash(entries[i])
+		}
+		prevHash = entries[i].Hash
+	}
+	if seq, reason := verifyAuditChain(entries); seq != 0 {
+		t.Fatalf("Legacy chain reported broken at %d: %s", seq, reason)
+	}
+
+	// A plain entry cannot be redacted in place
+	redacted := append([]AuditEntry(nil), entries...)
+	redactAuditEntry(&redacted[0])
+	if seq, _ := verifyAuditChain(redacted); seq != 1 {
+		t.Errorf("Redacted plain entry: expected break at 1, got %d", seq)
+	}
+}
+
+// Test re-anchoring a legacy log so its entries can be redacted
+func TestReanchorAuditLog(t *testing.T) {
+	entries := buildAuditChain(3)
+	prevHash := AUDIT_GENESIS_HASH
+	for i := range entries {
+		entries[i].PrevHash = prevHash
+		entries[i].Format = AUDIT_FORMAT_LEGACY
+		entries[i].Key = ""
+		entries[i].Hash = computePlainAuditHash(entries[i])
+		if i == 1 {
+			// Redacted by an earlier version, with an unkeyed digest
+			redactAuditEntry(&entries[i])
+			entries[i].Hash = computeAuditHash(entries[i])
+		}
+		prevHash = entries[i].Hash
+	}
+	oldHead := AuditHead{Seq: 3, Hash: entries[2].Hash}
+	auditHead = oldHead
+	defer func() { auditHead = AuditHead{} }()
+
+	anchored, err := reanchorAuditLog(append([]AuditEntry(nil), entries...))
+	if err != nil {
+		t.Fatalf("reanchorAuditLog() error: %v", err)
+	}
+	if len(anchored) != 4 || anchored[3].Action != AUDIT_REANCHOR {
+		t.Fatalf("Got %d entries, want the log and a reanchor entry", len(anchored))
+	}
+	redactAuditEntry(&anchored[0])
+	if seq, reason := verifyAuditChain(anchored); seq != 0 {
+		t.Errorf("Re-anchored chain reported broken at %d: %s", seq, reason)
+	}
+	if anchored[1].AfterDigest == entries[1].AfterDigest || anchored[1].Key != "" {
+		t.Error("An unkeyed digest from an earlier erasure was kept")
+	}
+	if anchored[2].Key == "" {
+		t.Error("An entry with content was re-anchored without a key")
+	}
+	if auditHead.Hash != anchored[2].Hash {
+		t.Error("The head to save was not moved to the new hash")
+	}
+	if verifyAuditHead(anchored, oldHead) {
+		t.Error("A head saved before re-anchoring should not be reached until the new head is saved")
+	}
+	if !verifyAuditHead(anchored, auditHead) {
+		t.Error("The new head should be reached")
+	}
+	if verifyAuditHead(anchored, AuditHead{Seq: 3, Hash: entries[1].Hash}) {
+		t.Error("A head that was never in the log should not be reached")
+	}
+
+	// A rewritten log with a reanchor entry naming the saved head is not
+	// accepted on its word
+	forged := buildAuditChain(3)
+	reanchor := AuditEntry{Seq: 4, Action: AUDIT_REANCHOR, Before: fmt.Sprintf(`{"seq":%d,"hash":%q}`, oldHead.Seq, oldHead.Hash), PrevHash: forged[2].Hash, Format: AUDIT_FORMAT_CURRENT}
+	forged = append(forged, reanchor)
+	if verifyAuditHead(forged, oldHead) {
+		t.Error("A reanchor entry read from the log should not vouch for a saved head")
+	}
+
+	// A tampered log is not re-anchored
+	entries[1].After = `{"age":99}`
+	if _, err := reanchorAuditLog(entries); err == nil {
+		t.Error("A broken chain should not be re-anchored")
+	}
+}
+
+// Test that before/after values are sealed with the data key, and that the
+// chain still verifies after they are sealed again under a new key
+func TestSealAuditValue(t *testing.T) {
+	dataKey = testKey(4)
+	defer func() { dataKey = nil }()
+
+	patient := map[string]interface{}{"id": 20001, "name": "Budi Hartono", "conclusion": "Diabetes suspected"}
+	entries := []AuditEntry{newAuditEntry(1, AUDIT_GENESIS_HASH, "update", "patient", 20001, nil, patient)}
+	entries = append(entries, newAuditEntry(2, entries[0].Hash, "view", "audit", 0, nil, nil))
+	if strings.Contains(entries[0].After, "Budi") || !strings.HasPrefix(entries[0].After, AUDIT_SEALED_PREFIX) {
+		t.Fatalf("Value written in the clear: %s", entries[0].After)
+	}
+	if plain, err := openAuditValue(entries[0].After); err != nil || !strings.Contains(plain, "Budi Hartono") {
+		t.Errorf("openAuditValue() = %q, %v", plain, err)
+	}
+	if seq, reason := verifyAuditChain(entries); seq != 0 {
+		t.Fatalf("Sealed chain reported broken at %d: %s", seq, reason)
+	}
+
+	// Sealed again under a new key, the hashes still match
+	oldKey := dataKey
+	dataKey = testKey(5)
+	if seq, _ := verifyAuditChain(entries); seq != 1 {
+		t.Errorf("Values sealed with a key that is not loaded: expected break at 1, got %d", seq)
+	}
+	plain, err := openAuditValue(entries[0].After, oldKey.Key)
+	if err != nil {
+		t.Fatalf("openAuditValue() with the old key error: %v", err)
+	}
+	resealed := append([]AuditEntry(nil), entries...)
+	resealed[0].After = sealAuditValue(plain)
+	if resealed[0].After == entries[0].After {
+		t.Error("Value was not sealed again")
+	}
+	if seq, reason := verifyAuditChain(resealed); seq != 0 {
+		t.Errorf("Resealed chain reported broken at %d: %s", seq, reason)
+	}
+
+	// A sealed value put back from another entry does not verify
+	swapped := append([]AuditEntry(nil), resealed...)
+	swapped[0].After = sealAuditValue(`{"id":20001,"name":"Someone Else"}`)
+	if seq, _ := verifyAuditChain(swapped); seq != 1 {
+		t.Errorf("Replaced sealed value: expected break at 1, got %d", seq)
+	}
+
+	// Redaction digests the value as it was before sealing
+	if !redactAuditEntry(&resealed[0]) || resealed[0].After != AUDIT_REDACTED {
+		t.Fatal("Sealed entry was not redacted")
+	}
+	if seq, reason := verifyAuditChain(resealed); seq != 0 {
+		t.Errorf("Redacted sealed chain reported broken at %d: %s", seq, reason)
+	}
+
+	// Values from before sealing are read as they are
+	if plain, err := openAuditValue(`{"age":31}`); err != nil || plain != `{"age":31}` {
+		t.Errorf("openAuditValue() of a plain value = %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"testing"
+)
+
+// Copy of password and permission functions from auth.go for testing
+const PASSWORD_ITERATIONS = 100000
+
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Salt         string `json:"salt"`
+	Role         string `json:"role"`
+	Active       bool   `json:"active"`
+	Branches     []int  `json:"branches,omitempty"`
+}
+
+var rolePermissions = map[string][]string{
+	"receptionist": {"patient.view", "patient.create", "patient.update", "record.create"},
+	"cashier":      {"patient.view", "billing.manage", "report.revenue"},
+}
+
+func hashPassword(password, salt string) string {
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), PASSWORD_ITERATIONS, 32)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(key)
+}
+
+func checkPassword(u User, password string) bool {
+	hash := hashPassword(password, u.Salt)
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(u.PasswordHash)) == 1
+}
+
+func roleHasPermission(role, perm string) bool {
+	if role == "admin" {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Test password hashing
+func TestCheckPassword(t *testing.T) {
+	u := User{Username: "nurse1", Salt: "0123456789abcdef"}
+	u.PasswordHash = hashPassword("correct horse", u.Salt)
+
+	if u.PasswordHash == "correct horse" || u.PasswordHash == "" {
+		t.Fatal("Password was not hashed")
+	}
+	if !checkPassword(u, "correct horse") {
+		t.Error("checkPassword rejected the correct password")
+	}
+	if checkPassword(u, "wrong horse") {
+		t.Error("checkPassword accepted a wrong password")
+	}
+
+	other := User{Salt: "fedcba9876543210"}
+	if hashPassword("correct horse", other.Salt) == u.PasswordHash {
+		t.Error("Different salts produced the same hash")
+	}
+}
+
+// Test role permissions
+func TestRoleHasPermission(t *testing.T) {
+	tests := []struct {
+		role     string
+		perm     string
+		expected bool
+	}{
+		{"receptionist", "patient.create", true},
+		{"receptionist", "patient.delete", false},
+		{"receptionist", "report.revenue", false},
+		{"cashier", "report.revenue", true},
+		{"cashier", "patient.delete", false},
+		{"admin", "patient.delete", true}, // Admin has every permission
+		{"unknown", "patient.view", false},
+	}
+
+	for _, test := range tests {
+		result := roleHasPermission(test.role, test.perm)
+		if result != test.expected {
+			t.Errorf("roleHasPermission(%s, %s) = %v; want %v", test.role, test.perm, result, test.expected)
+		}
+	}
+}
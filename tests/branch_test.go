@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+type BranchSnapshot struct {
+	Patients []Patient `json:"patients,omitempty"`
+	Records  []Record  `json:"records,omitempty"`
+}
+
+var currentUser *User
+
+// Copy of the branch access and sync functions from branch.go for testing
+func userCanUseBranch(u User, id int) bool {
+	if len(u.Branches) == 0 {
+		return true
+	}
+	for _, b := range u.Branches {
+		if b == id {
+			return true
+		}
+	}
+	return false
+}
+
+func canUseBranch(id int) bool {
+	return currentUser == nil || userCanUseBranch(*currentUser, id)
+}
+
+func inBranch(id, branch int) bool {
+	if branch != 0 {
+		return id == branch
+	}
+	return id == 0 || canUseBranch(id)
+}
+
+func offeredAt(id, branch int) bool {
+	return id == 0 || inBranch(id, branch)
+}
+
+func patientKey(p Patient) string { return strconv.Itoa(p.ID) }
+
+func recordKey(r Record) string { return strconv.Itoa(r.ID) }
+
+func claimLegacyRecords(central *BranchSnapshot, ours BranchSnapshot, home int) {
+	own := indexByKey(ours.Records, recordKey)
+	for i := range central.Records {
+		r := &central.Records[i]
+		if r.BranchID != 0 || own[recordKey(*r)] == nil {
+			continue
+		}
+		claimed := *r
+		claimed.BranchID = home
+		if sameContent(claimed, *own[recordKey(*r)]) {
+			*r = claimed
+		}
+	}
+}
+
+func TestUserCanUseBranch(t *testing.T) {
+	everywhere := User{Username: "admin"}
+	bandung := User{Username: "kasir", Branches: []int{2}}
+
+	if !userCanUseBranch(everywhere, 1) || !userCanUseBranch(everywhere, 3) {
+		t.Error("A user without branches should work at every branch")
+	}
+	if !userCanUseBranch(bandung, 2) {
+		t.Error("User should work at their own branch")
+	}
+	if userCanUseBranch(bandung, 1) {
+		t.Error("User should not work at another branch")
+	}
+}
+
+func TestInBranch(t *testing.T) {
+	defer func() { currentUser = nil }()
+	currentUser = &User{Username: "kasir", Branches: []int{1, 2}}
+
+	tests := []struct {
+		id, branch int
+		want       bool
+	}{
+		{1, 1, true},
+		{2, 1, false},
+		{0, 1, false}, // records from before branches belong to none
+		{1, 0, true},
+		{2, 0, true},
+		{3, 0, false}, // consolidated covers the user's branches only
+		{0, 0, true},
+	}
+	for _, tt := range tests {
+		if got := inBranch(tt.id, tt.branch); got != tt.want {
+			t.Errorf("inBranch(%d, %d) = %v, want %v", tt.id, tt.branch, got, tt.want)
+		}
+	}
+
+	// Packages and practitioners of branch 0 are offered everywhere
+	if !offeredAt(0, 1) || !offeredAt(1, 1) || offeredAt(2, 1) || offeredAt(3, 0) {
+		t.Error("offeredAt() does not follow the package's branch")
+	}
+
+	currentUser = &User{Username: "admin"}
+	if !inBranch(3, 0) {
+		t.Error("Consolidated should cover every branch for a user without branches")
+	}
+}
+
+func TestClaimLegacyRecords(t *testing.T) {
+	budi := Patient{ID: 20001, Name: "Budi"}
+	ours := BranchSnapshot{Records: []Record{
+		{ID: 30001, Patient: budi, Date: "01/02/2025", BranchID: 1},
+		{ID: 30002, Patient: budi, Date: "05/02/2025", BranchID: 1},
+	}}
+	central := BranchSnapshot{Records: []Record{
+		{ID: 30001, Patient: budi, Date: "01/02/2025"},              // the same record from before branches
+		{ID: 30002, Patient: budi, Date: "06/02/2025"},              // changed elsewhere
+		{ID: 30003, Patient: budi, Date: "07/02/2025"},              // not held here
+		{ID: 30004, Patient: budi, Date: "08/02/2025", BranchID: 2}, // another branch's
+	}}
+
+	claimLegacyRecords(&central, ours, 1)
+
+	want := []int{1, 0, 0, 2}
+	for i, r := range central.Records {
+		if r.BranchID != want[i] {
+			t.Errorf("Record %d branch = %d, want %d", r.ID, r.BranchID, want[i])
+		}
+	}
+}
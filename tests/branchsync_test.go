@@ -0,0 +1,790 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	ErrCentralUnreachable = errors.New("central store not reachable")
+	ErrCentralChanged     = errors.New("central store changed during the sync")
+)
+
+const (
+	BRANCH_SYNC_PATH     = "/branch-sync/store"
+	BRANCH_SYNC_MAX_SIZE = 64 << 20
+	SYNC_WHOLE_ITEM      = "*"
+	AUDIT_CONFLICT       = "conflict"
+)
+
+// SyncConflict is a field of a patient or record that this branch and
+// another one both changed since the last sync. The value of the later change
+// was kept everywhere; the other one waits here until someone resolves it.
+// A signed-off record is never merged field by field: its conflict is about
+// the whole record (field SYNC_WHOLE_ITEM) and the signed version is kept.
+type SyncConflict struct {
+	ID       int    `json:"id"`
+	Entity   string `json:"entity"`
+	ItemID   int    `json:"item_id"`
+	Field    string `json:"field"`
+	Kept     string `json:"kept,omitempty"`
+	Other    string `json:"other,omitempty"`
+	KeptFrom string `json:"kept_from"`
+	KeptAt   string `json:"kept_at,omitempty"`
+	OtherAt  string `json:"other_at,omitempty"`
+	FoundAt  string `json:"found_at"`
+}
+
+type serverStore struct {
+	url    string
+	client *http.Client
+	tag    string
+}
+
+type BranchSyncSettings struct {
+	Base      *BranchSnapshot `json:"base,omitempty"`
+	Conflicts []SyncConflict  `json:"conflicts,omitempty"`
+}
+
+var (
+	branchSync BranchSyncSettings
+	dataKey    *DataKey
+
+	// The audit trail is not under test here; queued conflicts are logged to
+	// it
+	auditedConflicts int
+)
+
+func auditLog(action, entity string, id int, before, after interface{}) {
+	if action == AUDIT_CONFLICT {
+		auditedConflicts++
+	}
+}
+
+// Copy of the field merge, central server and client from branchsync.go for
+// testing
+func mergeFields[T any](base, ours, theirs T, oursLater bool) (merged T, conflicts []string, err error) {
+	b, err1 := fieldsOf(base)
+	o, err2 := fieldsOf(ours)
+	t, err3 := fieldsOf(theirs)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return merged, nil, err
+	}
+
+	result := make(map[string]json.RawMessage)
+	take := func(k string, v json.RawMessage) {
+		if v != nil {
+			result[k] = v
+		}
+	}
+	for _, k := range fieldNames(b, o, t) {
+		switch {
+		case k == "version" || k == "updated_at":
+		case bytes.Equal(o[k], b[k]):
+			take(k, t[k])
+		case bytes.Equal(t[k], b[k]) || bytes.Equal(o[k], t[k]):
+			take(k, o[k])
+		case oursLater:
+			take(k, o[k])
+			conflicts = append(conflicts, k)
+		default:
+			take(k, t[k])
+			conflicts = append(conflicts, k)
+		}
+	}
+
+	result["version"] = json.RawMessage(strconv.Itoa(max(versionOf(ours), versionOf(theirs)) + 1))
+	if oursLater {
+		take("updated_at", o["updated_at"])
+	} else {
+		take("updated_at", t["updated_at"])
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return merged, nil, err
+	}
+	err = json.Unmarshal(content, &merged)
+	return merged, conflicts, err
+}
+
+// mergeChangedItems merges items changed on both sides field by field.
+// Items that signed is true for on either side, or before, are kept whole:
+// the signed one, or the later change when both or neither are signed.
+func mergeChangedItems[T any](entity string, base, ours, theirs []T, key func(T) string, signed func(T) bool) ([]SyncConflict, error) {
+	baseIndex, theirIndex := indexByKey(base, key), indexByKey(theirs, key)
+	now := time.Now().Format("02/01/2006 15:04")
+	var conflicts []SyncConflict
+
+	for i := range ours {
+		k := key(ours[i])
+		b, t := baseIndex[k], theirIndex[k]
+		if b == nil || t == nil || sameContent(ours[i], *b) || sameContent(*t, *b) || sameContent(ours[i], *t) {
+			continue
+		}
+
+		oursLater := changedLater(updatedAt(ours[i]), updatedAt(*t))
+		var merged T
+		var fields []string
+		var err error
+		if signed != nil && (signed(*b) || signed(ours[i]) || signed(*t)) {
+			if signed(ours[i]) != signed(*t) {
+				oursLater = signed(ours[i])
+			}
+			merged, fields, err = keepWhole(ours[i], *t, oursLater)
+		} else {
+			merged, fields, err = mergeFields(*b, ours[i], *t, oursLater)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s %s: %v", entity, k, err)
+		}
+
+		kept, other, from := ours[i], *t, "this branch"
+		if !oursLater {
+			kept, other, from = *t, ours[i], "another branch"
+		}
+		keptFields, _ := fieldsOf(kept)
+		otherFields, _ := fieldsOf(other)
+		id, _ := strconv.Atoi(k)
+		for _, field := range fields {
+			c := SyncConflict{
+				Entity: entity, ItemID: id, Field: field,
+				Kept: string(keptFields[field]), Other: string(otherFields[field]),
+				KeptFrom: from, KeptAt: updatedAt(kept), OtherAt: updatedAt(other), FoundAt: now,
+			}
+			if field == SYNC_WHOLE_ITEM {
+				keptItem, _ := json.Marshal(kept)
+				otherItem, _ := json.Marshal(other)
+				c.Kept, c.Other = string(keptItem), string(otherItem)
+			}
+			conflicts = append(conflicts, c)
+		}
+		ours[i], *t = merged, merged
+	}
+	return conflicts, nil
+}
+
+// keepWhole keeps one side's item as it is, as a new version of both
+func keepWhole[T any](ours, theirs T, keepOurs bool) (T, []string, error) {
+	kept := theirs
+	if keepOurs {
+		kept = ours
+	}
+	version := strconv.Itoa(max(versionOf(ours), versionOf(theirs)) + 1)
+	err := setField(&kept, "version", version)
+	return kept, []string{SYNC_WHOLE_ITEM}, err
+}
+
+// setField replaces one field of an item with a JSON value; an empty value
+// clears it
+func setField[T any](item *T, field, raw string) error {
+	fields, err := fieldsOf(*item)
+	if err != nil {
+		return err
+	}
+	if raw == "" {
+		delete(fields, field)
+	} else {
+		fields[field] = json.RawMessage(raw)
+	}
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var updated T
+	if err := json.Unmarshal(content, &updated); err != nil {
+		return err
+	}
+	*item = updated
+	return nil
+}
+
+// isSignedOff reports whether a doctor has signed the conclusion; the
+// results of a signed-off record are locked
+func isSignedOff(r Record) bool {
+	return r.ConcludedBy != ""
+}
+
+func fieldsOf(v interface{}) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(content, &fields)
+}
+
+func fieldNames(maps ...map[string]json.RawMessage) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func changedLater(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	switch {
+	case errA != nil:
+		return false
+	case errB != nil:
+		return true
+	}
+	return ta.After(tb)
+}
+
+func (s *serverStore) Save(content []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", s.tag)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		s.tag = resp.Header.Get("ETag")
+		return nil
+	case http.StatusPreconditionFailed:
+		return ErrCentralChanged
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("central server answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// storeTag identifies one version of a data file
+func storeTag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// centralHandler serves the central data file to branch installations. A
+// branch fetches it, merges its changes in and sends it back; a store that
+// changed since it was fetched is refused, and the branch merges again. The
+// store travels encrypted with the data key all branches share, and a store
+// that key does not open is refused.
+func centralHandler(path string, key []byte, report func(string)) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(BRANCH_SYNC_PATH, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := acquireLock(path); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer releaseLock(path)
+
+		content, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, "cannot read the central store", http.StatusInternalServerError)
+			return
+		}
+		tag := storeTag(content)
+
+		if r.Method == http.MethodGet {
+			w.Header().Set("ETag", tag)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(content)
+			report(fmt.Sprintf("%s fetched the store", r.RemoteAddr))
+			return
+		}
+
+		if r.Header.Get("If-Match") != tag {
+			http.Error(w, ErrCentralChanged.Error(), http.StatusPreconditionFailed)
+			report(fmt.Sprintf("%s sent an outdated store; it syncs again", r.RemoteAddr))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, BRANCH_SYNC_MAX_SIZE))
+		if err != nil {
+			http.Error(w, "store too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		store, encrypted := parseEncryptedStore(body)
+		if !encrypted {
+			http.Error(w, "the store must be encrypted with the data key", http.StatusBadRequest)
+			return
+		}
+		plaintext, err := decryptStore(store, key)
+		if err != nil {
+			http.Error(w, "the store is not encrypted with this store's data key", http.StatusForbidden)
+			report(fmt.Sprintf("%s sent a store encrypted with another key; refused", r.RemoteAddr))
+			return
+		}
+		var data DataStore
+		if err := json.Unmarshal(plaintext, &data); err != nil {
+			http.Error(w, "the store cannot be read", http.StatusBadRequest)
+			return
+		}
+		if err := writeDataFile(path, body); err != nil {
+			http.Error(w, "cannot write the central store", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", storeTag(body))
+		w.WriteHeader(http.StatusNoContent)
+		report(fmt.Sprintf("%s saved revision %d", r.RemoteAddr, data.Revision))
+	})
+	return mux
+}
+
+func (s *serverStore) Load() (DataStore, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return DataStore{}, fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, BRANCH_SYNC_MAX_SIZE))
+	if err != nil {
+		return DataStore{}, fmt.Errorf("%w: %v", ErrCentralUnreachable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return DataStore{}, fmt.Errorf("central server answered %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	s.tag = resp.Header.Get("ETag")
+	return decodeDataStore(content, dataKey.Key)
+}
+
+// mergeChangedFields merges the patients and records that both this branch
+// and the central store changed since the last sync, so that the item merge
+// that follows sees the same version on both sides. Fields that both changed
+// are returned as conflicts.
+func mergeChangedFields(base *BranchSnapshot, ours, theirs *BranchSnapshot) ([]SyncConflict, error) {
+	if base == nil {
+		return nil, nil
+	}
+	var conflicts []SyncConflict
+	patientConflicts, err := mergeChangedItems(ENTITY_PATIENT, base.Patients, ours.Patients, theirs.Patients, patientKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	conflicts = append(conflicts, patientConflicts...)
+	recordConflicts, err := mergeChangedItems(ENTITY_RECORD, base.Records, ours.Records, theirs.Records, recordKey, isSignedOff)
+	if err != nil {
+		return nil, err
+	}
+	return append(conflicts, recordConflicts...), nil
+}
+
+// queueSyncConflicts adds conflicts to the ones waiting for resolution; a
+// newer conflict on the same field replaces the older one
+func queueSyncConflicts(conflicts []SyncConflict) {
+	for _, c := range conflicts {
+		next := 1
+		queue := branchSync.Conflicts[:0]
+		for _, q := range branchSync.Conflicts {
+			next = max(next, q.ID+1)
+			if q.Entity != c.Entity || q.ItemID != c.ItemID || q.Field != c.Field {
+				queue = append(queue, q)
+			}
+		}
+		c.ID = next
+		branchSync.Conflicts = append(queue, c)
+		auditLog(AUDIT_CONFLICT, c.Entity, c.ItemID, map[string]string{c.Field: c.Other}, map[string]string{c.Field: c.Kept})
+	}
+}
+
+// decodeDataStore reads a store from the content of a data file
+func decodeDataStore(content []byte, key []byte) (DataStore, error) {
+	var data DataStore
+	if len(content) == 0 {
+		return data, nil
+	}
+
+	// Older data files are plain JSON; they are encrypted on the next save
+	if store, encrypted := parseEncryptedStore(content); encrypted {
+		var err error
+		if content, err = decryptStore(store, key); err != nil {
+			return data, err
+		}
+	}
+
+	if err := json.Unmarshal(content, &data); err != nil {
+		return data, fmt.Errorf("failed to decode data: %v", err)
+	}
+	return data, nil
+}
+
+// writeDataFile replaces a data file in one step, so a failed write never
+// leaves a half-written store behind
+func writeDataFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write data file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace data file: %v", err)
+	}
+	return nil
+}
+
+// syncBranch syncs a branch with the central server the way
+// syncWithCentralOnce does: fetch the store, merge what both sides changed
+// field by field, take what only this branch changed and send the store back
+func syncBranch(url string, base *BranchSnapshot, ours BranchSnapshot) (BranchSnapshot, error) {
+	store := &serverStore{url: url + BRANCH_SYNC_PATH, client: &http.Client{Timeout: 5 * time.Second}}
+	central, err := store.Load()
+	if err != nil {
+		return BranchSnapshot{}, err
+	}
+	theirs := BranchSnapshot{
+		Patients: append([]Patient(nil), central.Patients.Daftar[:central.Patients.N]...),
+		Records:  append([]Record(nil), central.Records.Daftar[:central.Records.N]...),
+	}
+	fields, err := mergeChangedFields(base, &ours, &theirs)
+	if err != nil {
+		return BranchSnapshot{}, err
+	}
+
+	if base == nil {
+		base = &BranchSnapshot{}
+	}
+	merged := BranchSnapshot{
+		Patients: takeOurChanges(base.Patients, ours.Patients, theirs.Patients, patientKey),
+		Records:  takeOurChanges(base.Records, ours.Records, theirs.Records, recordKey),
+	}
+	central.Patients.N = copy(central.Patients.Daftar[:], merged.Patients)
+	central.Records.N = copy(central.Records.Daftar[:], merged.Records)
+	central.Revision++
+	plaintext, err := json.Marshal(central)
+	if err != nil {
+		return BranchSnapshot{}, err
+	}
+	content, err := encryptStore(plaintext, dataKey)
+	if err != nil {
+		return BranchSnapshot{}, err
+	}
+	if err := store.Save(content); err != nil {
+		return BranchSnapshot{}, err
+	}
+	queueSyncConflicts(fields)
+	return merged, nil
+}
+
+// takeOurChanges stands in for the item merge: the central items, with ours
+// where only this branch changed them
+func takeOurChanges[T any](base, ours, theirs []T, key func(T) string) []T {
+	baseIndex, theirIndex := indexByKey(base, key), indexByKey(theirs, key)
+	merged := append([]T(nil), theirs...)
+	for _, item := range ours {
+		b, t := baseIndex[key(item)], theirIndex[key(item)]
+		switch {
+		case t == nil:
+			merged = append(merged, item)
+		case b == nil || !sameContent(item, *b):
+			for i := range merged {
+				if key(merged[i]) == key(item) {
+					merged[i] = item
+				}
+			}
+		}
+	}
+	return merged
+}
+
+func TestMergeFields(t *testing.T) {
+	base := Patient{ID: 20001, Name: "Budi", Gender: "L", Age: 30, Version: 2}
+	ours := Patient{ID: 20001, Name: "Budi Santoso", Gender: "L", Age: 31, Version: 3, UpdatedAt: "2025-03-01T10:05:00Z"}
+	theirs := Patient{ID: 20001, Name: "Budi", Gender: "P", Age: 32, Version: 4, UpdatedAt: "2025-03-01T10:00:00Z"}
+
+	merged, conflicts, err := mergeFields(base, ours, theirs, true)
+	if err != nil {
+		t.Fatalf("mergeFields() error: %v", err)
+	}
+	want := Patient{ID: 20001, Name: "Budi Santoso", Gender: "P", Age: 31, Version: 5, UpdatedAt: "2025-03-01T10:05:00Z"}
+	if merged != want {
+		t.Errorf("Merged %+v, want %+v", merged, want)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "age" {
+		t.Errorf("Conflicts %v, want [age]", conflicts)
+	}
+
+	// The other side's later change wins the field both changed
+	merged, _, _ = mergeFields(base, ours, theirs, false)
+	if merged.Age != 32 || merged.Name != "Budi Santoso" || merged.UpdatedAt != theirs.UpdatedAt {
+		t.Errorf("Merged %+v, want age 32 from the later change", merged)
+	}
+
+	// The same change on both sides is no conflict
+	theirs.Age = 31
+	if _, conflicts, _ := mergeFields(base, ours, theirs, false); len(conflicts) != 0 {
+		t.Errorf("Conflicts %v for the same change on both sides", conflicts)
+	}
+}
+
+func TestChangedLater(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2025-03-01T10:05:00Z", "2025-03-01T10:00:00Z", true},
+		{"2025-03-01T10:00:00Z", "2025-03-01T10:05:00Z", false},
+		{"2025-03-01T10:00:00Z", "2025-03-01T10:00:00Z", false},
+		{"2025-03-01T12:00:00+07:00", "2025-03-01T06:00:00Z", false}, // 05:00 UTC
+		{"2025-03-01T10:00:00Z", "", true},
+		{"", "2025-03-01T10:00:00Z", false},
+	}
+	for _, tt := range tests {
+		if got := changedLater(tt.a, tt.b); got != tt.want {
+			t.Errorf("changedLater(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestServerStoreSave(t *testing.T) {
+	tag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Match") != tag {
+			http.Error(w, ErrCentralChanged.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		tag = `"v2"`
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	store := &serverStore{url: server.URL, client: server.Client(), tag: `"v1"`}
+
+	if err := store.Save([]byte("{}")); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	if store.tag != `"v2"` {
+		t.Errorf("Tag after saving = %s, want \"v2\"", store.tag)
+	}
+
+	// Another branch saved in between
+	store.tag = `"v1"`
+	if err := store.Save([]byte("{}")); !errors.Is(err, ErrCentralChanged) {
+		t.Errorf("Save() over a newer store = %v, want ErrCentralChanged", err)
+	}
+
+	// The central server is down: the branch works offline
+	server.Close()
+	if err := store.Save([]byte("{}")); !errors.Is(err, ErrCentralUnreachable) {
+		t.Errorf("Save() without a server = %v, want ErrCentralUnreachable", err)
+	}
+}
+
+func TestMergeChangedItemsSignedOff(t *testing.T) {
+	budi := Patient{ID: 20001, Name: "Budi"}
+	base := []Record{{ID: 30001, Patient: budi, Date: "01/03/2025", Version: 2}}
+	// Signed here; the date changed on another branch a little later
+	ours := []Record{{ID: 30001, Patient: budi, Date: "01/03/2025", ConcludedBy: "dr. Sari", Version: 3, UpdatedAt: "2025-03-01T10:00:00Z"}}
+	theirs := []Record{{ID: 30001, Patient: budi, Date: "02/03/2025", Version: 3, UpdatedAt: "2025-03-01T10:05:00Z"}}
+
+	conflicts, err := mergeChangedItems("record", base, ours, theirs, recordKey, isSignedOff)
+	if err != nil {
+		t.Fatalf("mergeChangedItems() error: %v", err)
+	}
+	want := Record{ID: 30001, Patient: budi, Date: "01/03/2025", ConcludedBy: "dr. Sari", Version: 4, UpdatedAt: "2025-03-01T10:00:00Z"}
+	if !sameValue(ours[0], want) || !sameValue(theirs[0], want) {
+		t.Errorf("Merged %+v, want the signed record kept whole", ours[0])
+	}
+	if len(conflicts) != 1 || conflicts[0].Field != SYNC_WHOLE_ITEM || conflicts[0].KeptFrom != "this branch" {
+		t.Errorf("Conflicts %+v, want one whole-record conflict", conflicts)
+	}
+
+	// Records nobody signed are still merged field by field
+	base = []Record{{ID: 30002, Patient: budi, Date: "01/03/2025", Price: 100}}
+	ours = []Record{{ID: 30002, Patient: budi, Date: "05/03/2025", Price: 100}}
+	theirs = []Record{{ID: 30002, Patient: budi, Date: "01/03/2025", Price: 150}}
+	conflicts, _ = mergeChangedItems("record", base, ours, theirs, recordKey, isSignedOff)
+	if len(conflicts) != 0 || ours[0].Date != "05/03/2025" || ours[0].Price != 150 {
+		t.Errorf("Merged %+v with conflicts %v, want both changes", ours[0], conflicts)
+	}
+}
+
+// Test two branches syncing through the central server: the field both
+// changed is kept from the later change everywhere and the other value
+// reaches the resolution queue
+func TestCentralServerSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	dataKey = testKey(7)
+	budi := Patient{ID: 20001, Name: "Budi", Gender: "L", Age: 30, Version: 1}
+	var initial DataStore
+	initial.Patients.Daftar[0], initial.Patients.N = budi, 1
+	initial.Records.Daftar[0], initial.Records.N = Record{ID: 30001, Patient: budi, Date: "01/03/2025", Version: 1}, 1
+	plaintext, _ := json.Marshal(initial)
+	content, err := encryptStore(plaintext, dataKey)
+	if err != nil {
+		t.Fatalf("encryptStore() error: %v", err)
+	}
+	if err := writeDataFile(path, content); err != nil {
+		t.Fatalf("writeDataFile() error: %v", err)
+	}
+
+	var reports []string
+	server := httptest.NewServer(centralHandler(path, dataKey.Key, func(s string) { reports = append(reports, s) }))
+	defer server.Close()
+
+	base := &BranchSnapshot{Patients: []Patient{budi}, Records: []Record{initial.Records.Daftar[0]}}
+
+	// Branch 1 corrects the age
+	branchSync = BranchSyncSettings{}
+	ours := BranchSnapshot{Patients: []Patient{budi}, Records: base.Records}
+	ours.Patients[0].Age, ours.Patients[0].Version, ours.Patients[0].UpdatedAt = 31, 2, "2025-03-01T10:00:00Z"
+	if _, err := syncBranch(server.URL, base, ours); err != nil {
+		t.Fatalf("Sync of branch 1 failed: %v", err)
+	}
+	if len(branchSync.Conflicts) != 0 {
+		t.Errorf("Branch 1 conflicts %+v, want none", branchSync.Conflicts)
+	}
+
+	// Branch 2 changed the name and, a little later, the age as well
+	branchSync = BranchSyncSettings{}
+	auditedConflicts = 0
+	ours = BranchSnapshot{Patients: []Patient{budi}, Records: base.Records}
+	ours.Patients[0].Name, ours.Patients[0].Age = "Budi Santoso", 32
+	ours.Patients[0].Version, ours.Patients[0].UpdatedAt = 2, "2025-03-01T10:05:00Z"
+	share, err := syncBranch(server.URL, base, ours)
+	if err != nil {
+		t.Fatalf("Sync of branch 2 failed: %v", err)
+	}
+	want := Patient{ID: 20001, Name: "Budi Santoso", Gender: "L", Age: 32, Version: 3, UpdatedAt: "2025-03-01T10:05:00Z"}
+	if len(share.Patients) != 1 || share.Patients[0] != want {
+		t.Errorf("Branch 2 patients %+v, want %+v", share.Patients, want)
+	}
+	if len(branchSync.Conflicts) != 1 {
+		t.Fatalf("Branch 2 conflicts %+v, want one on the age", branchSync.Conflicts)
+	}
+	c := branchSync.Conflicts[0]
+	if c.ID != 1 || c.Entity != ENTITY_PATIENT || c.ItemID != 20001 || c.Field != "age" ||
+		c.Kept != "32" || c.Other != "31" || c.KeptFrom != "this branch" {
+		t.Errorf("Queued conflict %+v, want age 32 kept over 31", c)
+	}
+	if auditedConflicts != 1 {
+		t.Errorf("Audited conflicts = %d, want 1", auditedConflicts)
+	}
+
+	// The central store holds the merged patient
+	content, _ = os.ReadFile(path)
+	central, err := decodeDataStore(content, dataKey.Key)
+	if err != nil {
+		t.Fatalf("decodeDataStore() error: %v", err)
+	}
+	if central.Revision != 2 || central.Patients.N != 1 || central.Patients.Daftar[0] != want {
+		t.Errorf("Central revision %d patients %+v, want revision 2 with %+v", central.Revision, central.Patients.Daftar[:central.Patients.N], want)
+	}
+	if len(reports) != 4 || !strings.HasSuffix(reports[3], "saved revision 2") {
+		t.Errorf("Server reports %q", reports)
+	}
+
+	// A branch that fetched before the last save merges again
+	stale := &serverStore{url: server.URL + BRANCH_SYNC_PATH, client: server.Client(), tag: storeTag([]byte("old"))}
+	if err := stale.Save(content); !errors.Is(err, ErrCentralChanged) {
+		t.Errorf("Save() of an outdated store = %v, want ErrCentralChanged", err)
+	}
+}
+
+// Test that erasing a patient after a sync also takes them out of the copy of
+// the last sync and the open sync conflicts, so the saved data file no longer
+// holds them
+func TestEraseAfterSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	dataKey = testKey(7)
+	budi := Patient{ID: 20001, Name: "Budi Hartono", Gender: "L", Age: 30, Version: 1}
+	siti := Patient{ID: 20002, Name: "Siti", Gender: "P", Age: 41, Version: 1}
+	visit := Record{ID: 30001, Patient: budi, Date: "01/03/2025", Version: 1,
+		Results: []Result{{Code: "GLU", Value: 182, Flag: "H"}}, Conclusion: "Diabetes suspected"}
+	var initial DataStore
+	initial.Patients.Daftar[0], initial.Patients.Daftar[1], initial.Patients.N = budi, siti, 2
+	initial.Records.Daftar[0], initial.Records.N = visit, 1
+	plaintext, _ := json.Marshal(initial)
+	content, _ := encryptStore(plaintext, dataKey)
+	if err := writeDataFile(path, content); err != nil {
+		t.Fatalf("writeDataFile() error: %v", err)
+	}
+	server := httptest.NewServer(centralHandler(path, dataKey.Key, func(string) {}))
+	defer server.Close()
+	base := &BranchSnapshot{Patients: []Patient{budi, siti}, Records: []Record{visit}}
+
+	// Another branch corrects the age first, then this one changes it too
+	branchSync = BranchSyncSettings{}
+	other := BranchSnapshot{Patients: []Patient{budi, siti}, Records: base.Records}
+	other.Patients[0].Age, other.Patients[0].Version, other.Patients[0].UpdatedAt = 31, 2, "2025-03-01T10:00:00Z"
+	if _, err := syncBranch(server.URL, base, other); err != nil {
+		t.Fatalf("Sync of the other branch failed: %v", err)
+	}
+	branchSync = BranchSyncSettings{}
+	ours := BranchSnapshot{Patients: []Patient{budi, siti}, Records: base.Records}
+	ours.Patients[0].Age, ours.Patients[0].Version, ours.Patients[0].UpdatedAt = 32, 2, "2025-03-01T10:05:00Z"
+	share, err := syncBranch(server.URL, base, ours)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	branchSync.Base = &share
+	branchSync.Conflicts = append(branchSync.Conflicts, SyncConflict{ID: 2, Entity: ENTITY_PATIENT, ItemID: 20002, Field: "age"})
+	if len(branchSync.Conflicts) != 2 {
+		t.Fatalf("Conflicts %+v, want the age of both patients", branchSync.Conflicts)
+	}
+
+	// Budi is erased; his record is kept for the accounts
+	erasures := []Erasure{{PatientID: 20001, Records: []int{30001}, ErasedAt: "2025-03-02T09:00:00Z"}}
+	if removed := scrubErasedSync(&branchSync, erasures); removed != 1 {
+		t.Errorf("scrubErasedSync() removed %d conflicts, want 1", removed)
+	}
+
+	saved := filepath.Join(t.TempDir(), "saved.json")
+	plaintext, _ = json.Marshal(struct {
+		BranchSync BranchSyncSettings `json:"branch_sync"`
+	}{branchSync})
+	if err := writeDataFile(saved, plaintext); err != nil {
+		t.Fatalf("writeDataFile() error: %v", err)
+	}
+	content, _ = os.ReadFile(saved)
+	for _, leak := range []string{"Budi", "Diabetes", "GLU", `"item_id":20001`} {
+		if bytes.Contains(content, []byte(leak)) {
+			t.Errorf("Saved store still holds %q: %s", leak, content)
+		}
+	}
+
+	s := branchSync.Base
+	if len(s.Patients) != 1 || s.Patients[0].ID != 20002 {
+		t.Errorf("Base patients %+v, want Siti only", s.Patients)
+	}
+	if len(s.Records) != 1 || s.Records[0].ID != 30001 || s.Records[0].Patient.Name != ERASED_NAME {
+		t.Errorf("Base records %+v, want record 30001 kept with the patient erased", s.Records)
+	}
+	if len(branchSync.Conflicts) != 1 || branchSync.Conflicts[0].ItemID != 20002 {
+		t.Errorf("Conflicts %+v, want Siti's only", branchSync.Conflicts)
+	}
+
+	// With the records deleted, they leave the copy as well
+	branchSync.Base = &share
+	erasures[0].RecordsDeleted = true
+	scrubErasedSync(&branchSync, erasures)
+	if len(branchSync.Base.Records) != 0 {
+		t.Errorf("Base records %+v, want none", branchSync.Base.Records)
+	}
+}
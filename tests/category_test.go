@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Copy of category functions from category.go for testing
+const (
+	CATEGORY_ID_START = 70001
+	AUDIT_UPDATE      = "update"
+)
+
+var categories CategoryArray
+
+type Category struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	SortOrder int    `json:"sort_order"`
+	Retired   bool   `json:"retired"`
+}
+
+type CategoryArray struct {
+	Daftar [NMAX]Category `json:"daftar"`
+	N      int            `json:"n"`
+}
+
+func binarySearchCategoryByID(id int) int {
+	left, right := 0, categories.N-1
+	for left <= right {
+		mid := (left + right) / 2
+		if categories.Daftar[mid].ID == id {
+			return mid
+		} else if categories.Daftar[mid].ID < id {
+			left = mid + 1
+		} else {
+			right = mid - 1
+		}
+	}
+	return -1
+}
+
+func sequentialSearchCategoryByName(name string) int {
+	for i := 0; i < categories.N; i++ {
+		if strings.EqualFold(categories.Daftar[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func getNextCategoryID() int {
+	maxID := CATEGORY_ID_START - 1
+	for i := 0; i < categories.N; i++ {
+		if categories.Daftar[i].ID > maxID {
+			maxID = categories.Daftar[i].ID
+		}
+	}
+	return maxID + 1
+}
+
+func getNextSortOrder() int {
+	maxOrder := 0
+	for i := 0; i < categories.N; i++ {
+		if categories.Daftar[i].SortOrder > maxOrder {
+			maxOrder = categories.Daftar[i].SortOrder
+		}
+	}
+	return maxOrder + 1
+}
+
+func appendCategory(name string, retired bool) Category {
+	cat := Category{
+		ID:        getNextCategoryID(),
+		Name:      name,
+		SortOrder: getNextSortOrder(),
+		Retired:   retired,
+	}
+	categories.Daftar[categories.N] = cat
+	categories.N++
+	return cat
+}
+
+// categoryLabel gives the current name for a category ID, so records booked
+// before a rename are reported under the same category as new ones
+func categoryLabel(id int, fallback string) string {
+	if idx := binarySearchCategoryByID(id); idx != -1 {
+		c := categories.Daftar[idx]
+		if c.Retired {
+			return c.Name + " (retired)"
+		}
+		return c.Name
+	}
+	return fallback
+}
+
+// sortedCategories returns the categories ordered by sort order using insertion sort
+func sortedCategories(includeRetired bool) []Category {
+	var list []Category
+	for i := 0; i < categories.N; i++ {
+		if includeRetired || !categories.Daftar[i].Retired {
+			list = append(list, categories.Daftar[i])
+		}
+	}
+
+	for i := 1; i < len(list); i++ {
+		key := list[i]
+		j := i - 1
+		for j >= 0 && list[j].SortOrder > key.SortOrder {
+			list[j+1] = list[j]
+			j--
+		}
+		list[j+1] = key
+	}
+	return list
+}
+
+// parseValidCategory accepts the same active categories selectCategory offers
+func parseValidCategory(name string) (Category, error) {
+	idx := sequentialSearchCategoryByName(strings.TrimSpace(name))
+	if idx == -1 {
+		return Category{}, fmt.Errorf("unknown category %q", strings.TrimSpace(name))
+	}
+	if categories.Daftar[idx].Retired {
+		return Category{}, fmt.Errorf("category %q is retired", categories.Daftar[idx].Name)
+	}
+	return categories.Daftar[idx], nil
+}
+
+func seedDefaultCategories() {
+	defaults := []string{"Basic", "Standard", "Premium", "Executive"}
+	for _, name := range defaults {
+		if sequentialSearchCategoryByName(name) == -1 && categories.N < NMAX {
+			appendCategory(name, false)
+		}
+	}
+}
+
+// resolveCategoryID finds the category for a name, creating it when an older
+// data file uses a name that isn't in the list yet
+func resolveCategoryID(name string, retired bool) int {
+	if idx := sequentialSearchCategoryByName(name); idx != -1 {
+		return categories.Daftar[idx].ID
+	}
+	if name == "" || categories.N >= NMAX {
+		return 0
+	}
+	return appendCategory(name, retired).ID
+}
+
+// migrateCategories links packages and records from older data files to category IDs
+func migrateCategories() {
+	if categories.N == 0 {
+		seedDefaultCategories()
+	}
+
+	for i := 0; i < packages.N; i++ {
+		p := &packages.Daftar[i]
+		if p.CategoryID == 0 {
+			p.CategoryID = resolveCategoryID(p.Category, false)
+		}
+	}
+
+	// Categories only seen on records are no longer in use
+	for i := 0; i < records.N; i++ {
+		r := &records.Daftar[i]
+		if r.Package.CategoryID == 0 {
+			r.Package.CategoryID = resolveCategoryID(r.Package.Category, true)
+		}
+	}
+}
+
+func moveCategoryPackages(from Category, to Category) int {
+	moved := 0
+	for i := 0; i < packages.N; i++ {
+		p := &packages.Daftar[i]
+		if p.CategoryID == from.ID {
+			before := map[string]interface{}{"category_id": p.CategoryID, "category": p.Category}
+			p.CategoryID = to.ID
+			p.Category = to.Name
+			auditLog(AUDIT_UPDATE, "package_category", p.ID, before,
+				map[string]interface{}{"category_id": p.CategoryID, "category": p.Category})
+			moved++
+		}
+	}
+	return moved
+}
+
+func resetCategories() {
+	categories = CategoryArray{}
+	packages = PackageArray{}
+	records = RecordArray{}
+}
+
+// Test adding categories after the defaults
+func TestAppendCategory(t *testing.T) {
+	resetCategories()
+	seedDefaultCategories()
+	if categories.N != 4 {
+		t.Fatalf("Expected 4 default categories, got %d", categories.N)
+	}
+
+	cat := appendCategory("Pre-Employment", false)
+	if cat.ID != CATEGORY_ID_START+4 || cat.SortOrder != 5 {
+		t.Errorf("New category got ID %d and sort order %d, expected %d and 5", cat.ID, cat.SortOrder, CATEGORY_ID_START+4)
+	}
+	if idx := sequentialSearchCategoryByName("pre-employment"); idx != 4 {
+		t.Errorf("Search by name is case-insensitive: got index %d, expected 4", idx)
+	}
+
+	// Seeding again does not duplicate the defaults
+	seedDefaultCategories()
+	if categories.N != 5 {
+		t.Errorf("Expected 5 categories after seeding again, got %d", categories.N)
+	}
+}
+
+// Test that records booked before a rename report the new name
+func TestRenameCategory(t *testing.T) {
+	resetCategories()
+	seedDefaultCategories()
+	premium := categories.Daftar[sequentialSearchCategoryByName("Premium")]
+
+	categories.Daftar[binarySearchCategoryByID(premium.ID)].Name = "Premium Plus"
+	if label := categoryLabel(premium.ID, "Premium"); label != "Premium Plus" {
+		t.Errorf("categoryLabel() after rename = %s, expected Premium Plus", label)
+	}
+	if _, err := parseValidCategory("Premium"); err == nil {
+		t.Error("The old name should no longer be accepted")
+	}
+	if cat, err := parseValidCategory(" premium plus "); err != nil || cat.ID != premium.ID {
+		t.Errorf("parseValidCategory(new name) = %v, %v", cat, err)
+	}
+
+	// A category that is not in the list keeps the name stored on the record
+	if label := categoryLabel(99999, "Legacy"); label != "Legacy" {
+		t.Errorf("categoryLabel(unknown) = %s, expected Legacy", label)
+	}
+}
+
+// Test retiring a category and moving its packages
+func TestRetireCategory(t *testing.T) {
+	resetCategories()
+	seedDefaultCategories()
+	basic := categories.Daftar[sequentialSearchCategoryByName("Basic")]
+	standard := categories.Daftar[sequentialSearchCategoryByName("Standard")]
+	packages.Daftar[0] = Package{ID: 10001, Name: "Basic Check", Category: basic.Name, CategoryID: basic.ID}
+	packages.Daftar[1] = Package{ID: 10002, Name: "Standard Check", Category: standard.Name, CategoryID: standard.ID}
+	packages.N = 2
+
+	categories.Daftar[binarySearchCategoryByID(basic.ID)].Retired = true
+	for _, c := range sortedCategories(false) {
+		if c.ID == basic.ID {
+			t.Error("A retired category should not be offered")
+		}
+	}
+	if len(sortedCategories(true)) != 4 {
+		t.Error("A retired category should still be listed with includeRetired")
+	}
+	if _, err := parseValidCategory("Basic"); err == nil || !strings.Contains(err.Error(), "retired") {
+		t.Errorf("parseValidCategory(retired) error = %v", err)
+	}
+	if label := categoryLabel(basic.ID, ""); label != "Basic (retired)" {
+		t.Errorf("categoryLabel(retired) = %s, expected Basic (retired)", label)
+	}
+
+	if moved := moveCategoryPackages(basic, standard); moved != 1 {
+		t.Errorf("Moved %d packages, expected 1", moved)
+	}
+	if p := packages.Daftar[0]; p.CategoryID != standard.ID || p.Category != "Standard" {
+		t.Errorf("Moved package is in %d/%s, expected %d/Standard", p.CategoryID, p.Category, standard.ID)
+	}
+}
+
+// Test that categories are sorted by sort order
+func TestSortedCategories(t *testing.T) {
+	resetCategories()
+	seedDefaultCategories()
+	categories.Daftar[0].SortOrder = 10
+
+	list := sortedCategories(true)
+	var names []string
+	for _, c := range list {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "Standard,Premium,Executive,Basic" {
+		t.Errorf("Sorted categories %v", names)
+	}
+}
+
+// Test linking packages and records from older data files to category IDs
+func TestMigrateCategories(t *testing.T) {
+	resetCategories()
+	packages.Daftar[0] = Package{ID: 10001, Name: "Basic Check", Category: "basic"}
+	packages.Daftar[1] = Package{ID: 10002, Name: "Pre-Employment", Category: "Occupational"}
+	packages.Daftar[2] = Package{ID: 10003, Name: "Uncategorised"}
+	packages.N = 3
+	records.Daftar[0] = Record{ID: 30001, Package: Package{ID: 10009, Category: "VIP"}}
+	records.Daftar[1] = Record{ID: 30002, Package: Package{ID: 10002, Category: "Occupational"}}
+	records.N = 2
+
+	migrateCategories()
+
+	basic := sequentialSearchCategoryByName("Basic")
+	if basic == -1 || packages.Daftar[0].CategoryID != categories.Daftar[basic].ID {
+		t.Errorf("Legacy category names should match the defaults regardless of case")
+	}
+	occupational := sequentialSearchCategoryByName("Occupational")
+	if occupational == -1 || categories.Daftar[occupational].Retired {
+		t.Fatalf("A category used by a package should be created active")
+	}
+	if records.Daftar[1].Package.CategoryID != categories.Daftar[occupational].ID {
+		t.Errorf("Records should link to the same category as the package")
+	}
+	vip := sequentialSearchCategoryByName("VIP")
+	if vip == -1 || !categories.Daftar[vip].Retired || records.Daftar[0].Package.CategoryID != categories.Daftar[vip].ID {
+		t.Errorf("A category only seen on records should be created retired")
+	}
+	if packages.Daftar[2].CategoryID != 0 {
+		t.Errorf("A package without a category should stay unlinked, got %d", packages.Daftar[2].CategoryID)
+	}
+	if categories.N != 6 {
+		t.Errorf("Expected 4 defaults plus 2 legacy categories, got %d", categories.N)
+	}
+
+	// Running the migration again changes nothing
+	migrateCategories()
+	if categories.N != 6 {
+		t.Errorf("Migration is not idempotent: %d categories", categories.N)
+	}
+}
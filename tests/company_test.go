@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Copy of company and examination helpers from company.go and examination.go for testing
+const MIN_SUMMARY_GROUP = 3
+
+type Examination struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Unit    string  `json:"unit"`
+	RefLow  float64 `json:"ref_low"`
+	RefHigh float64 `json:"ref_high"`
+}
+
+func hasReferenceRange(e Examination) bool {
+	return e.RefLow != 0 || e.RefHigh != 0
+}
+
+func getResultFlag(e Examination, value float64) string {
+	if !hasReferenceRange(e) {
+		return ""
+	}
+	if value < e.RefLow {
+		return "L"
+	}
+	if value > e.RefHigh {
+		return "H"
+	}
+	return "N"
+}
+
+func suppressedCount(count int) string {
+	if count > 0 && count < MIN_SUMMARY_GROUP {
+		return fmt.Sprintf("<%d", MIN_SUMMARY_GROUP)
+	}
+	return fmt.Sprintf("%d", count)
+}
+
+func isSmallCell(count, total int) bool {
+	rest := total - count
+	return (count > 0 && count < MIN_SUMMARY_GROUP) || (rest > 0 && rest < MIN_SUMMARY_GROUP)
+}
+
+// smallCells marks the counts of a breakdown that identify individuals:
+// those below MIN_SUMMARY_GROUP, and the smallest other one when only one
+// would be hidden, since the total would give it away
+func smallCells(counts []int) []bool {
+	hidden := make([]bool, len(counts))
+	n := 0
+	for i, count := range counts {
+		if count > 0 && count < MIN_SUMMARY_GROUP {
+			hidden[i] = true
+			n++
+		}
+	}
+	if n == 1 {
+		next := -1
+		for i, count := range counts {
+			if !hidden[i] && count > 0 && (next == -1 || count < counts[next]) {
+				next = i
+			}
+		}
+		if next != -1 {
+			hidden[next] = true
+		}
+	}
+	return hidden
+}
+
+func ageBand(age int) string {
+	switch {
+	case age < 20:
+		return "<20"
+	case age < 30:
+		return "20-29"
+	case age < 40:
+		return "30-39"
+	case age < 50:
+		return "40-49"
+	case age < 60:
+		return "50-59"
+	default:
+		return "60+"
+	}
+}
+
+// Test examination result flags
+func TestGetResultFlag(t *testing.T) {
+	glucose := Examination{Code: "GLU", RefLow: 70, RefHigh: 100}
+	noRange := Examination{Code: "ECG"}
+
+	tests := []struct {
+		exam     Examination
+		value    float64
+		expected string
+	}{
+		{glucose, 85, "N"},
+		{glucose, 70, "N"},  // Lower limit is within range
+		{glucose, 100, "N"}, // Upper limit is within range
+		{glucose, 69.9, "L"},
+		{glucose, 126, "H"},
+		{noRange, 1, ""}, // No reference range, no flag
+	}
+
+	for _, test := range tests {
+		result := getResultFlag(test.exam, test.value)
+		if result != test.expected {
+			t.Errorf("getResultFlag(%s, %.1f) = %q; want %q", test.exam.Code, test.value, result, test.expected)
+		}
+	}
+}
+
+// Test company summary privacy helpers
+func TestSuppressedCount(t *testing.T) {
+	tests := []struct {
+		count    int
+		expected string
+	}{
+		{0, "0"},
+		{1, "<3"},
+		{2, "<3"},
+		{3, "3"},
+		{25, "25"},
+	}
+
+	for _, test := range tests {
+		result := suppressedCount(test.count)
+		if result != test.expected {
+			t.Errorf("suppressedCount(%d) = %s; want %s", test.count, result, test.expected)
+		}
+	}
+}
+
+// Test that a breakdown hides small counts, and one more when a single hidden
+// count could be worked out from the total
+func TestSmallCells(t *testing.T) {
+	tests := []struct {
+		counts   []int
+		expected []bool
+	}{
+		{[]int{5, 4, 0}, []bool{false, false, false}},
+		{[]int{5, 4, 1}, []bool{false, true, true}},
+		{[]int{5, 2, 1}, []bool{false, true, true}},
+		{[]int{2}, []bool{true}},
+		{[]int{0, 0}, []bool{false, false}},
+	}
+
+	for _, test := range tests {
+		result := smallCells(test.counts)
+		if fmt.Sprint(result) != fmt.Sprint(test.expected) {
+			t.Errorf("smallCells(%v) = %v; want %v", test.counts, result, test.expected)
+		}
+	}
+}
+
+// Test that a row is suppressed when a cell or the rest of its group is small
+func TestIsSmallCell(t *testing.T) {
+	tests := []struct {
+		count    int
+		total    int
+		expected bool
+	}{
+		{0, 10, false},
+		{1, 10, true},
+		{3, 10, false},
+		{8, 10, true}, // 2 normal results
+		{10, 10, false},
+		{5, 6, true},
+	}
+
+	for _, test := range tests {
+		if result := isSmallCell(test.count, test.total); result != test.expected {
+			t.Errorf("isSmallCell(%d, %d) = %v; want %v", test.count, test.total, result, test.expected)
+		}
+	}
+}
+
+func TestAgeBand(t *testing.T) {
+	tests := []struct {
+		age      int
+		expected string
+	}{
+		{0, "<20"},
+		{19, "<20"},
+		{20, "20-29"},
+		{39, "30-39"},
+		{59, "50-59"},
+		{60, "60+"},
+		{150, "60+"},
+	}
+
+	for _, test := range tests {
+		result := ageBand(test.age)
+		if result != test.expected {
+			t.Errorf("ageBand(%d) = %s; want %s", test.age, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+// Copy of discount calculation from discount.go for testing
+const (
+	DISCOUNT_PERCENTAGE  = "Percentage"
+	DISCOUNT_FIXED       = "Fixed"
+	DISCOUNT_BUY_X_GET_Y = "BuyXGetY"
+)
+
+type Promotion struct {
+	Type      string  `json:"type"`
+	Value     float64 `json:"value"`
+	BuyX      int     `json:"buy_x,omitempty"`
+	GetY      int     `json:"get_y,omitempty"`
+	StartDate string  `json:"start_date,omitempty"`
+	EndDate   string  `json:"end_date,omitempty"`
+}
+
+func calculateDiscount(promo Promotion, price float64, bundleCount int) float64 {
+	var discount float64
+
+	switch promo.Type {
+	case DISCOUNT_PERCENTAGE:
+		discount = price * promo.Value / 100
+	case DISCOUNT_FIXED:
+		discount = promo.Value
+	case DISCOUNT_BUY_X_GET_Y:
+		cycle := promo.BuyX + promo.GetY
+		if promo.BuyX > 0 && promo.GetY > 0 && bundleCount%cycle >= promo.BuyX {
+			discount = price
+		}
+	}
+
+	if discount > price {
+		discount = price
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// inPromotionPeriod reports whether a date falls between a promotion's start
+// and end dates; either may be open
+func inPromotionPeriod(promo Promotion, date string) bool {
+	if promo.StartDate != "" && compareDates(date, promo.StartDate) < 0 {
+		return false
+	}
+	return promo.EndDate == "" || compareDates(date, promo.EndDate) <= 0
+}
+
+// Test discount calculation
+func TestCalculateDiscount(t *testing.T) {
+	percentage := Promotion{Type: DISCOUNT_PERCENTAGE, Value: 20}
+	fixed := Promotion{Type: DISCOUNT_FIXED, Value: 50}
+	buy2get1 := Promotion{Type: DISCOUNT_BUY_X_GET_Y, BuyX: 2, GetY: 1}
+
+	tests := []struct {
+		name        string
+		promo       Promotion
+		price       float64
+		bundleCount int
+		expected    float64
+	}{
+		{"20% off", percentage, 200, 0, 40},
+		{"Fixed amount", fixed, 200, 0, 50},
+		{"Fixed larger than price", fixed, 30, 0, 30},
+		{"Buy 2 get 1: first booking paid", buy2get1, 100, 0, 0},
+		{"Buy 2 get 1: second booking paid", buy2get1, 100, 1, 0},
+		{"Buy 2 get 1: third booking free", buy2get1, 100, 2, 100},
+		{"Buy 2 get 1: cycle restarts", buy2get1, 100, 3, 0},
+		{"Buy 2 get 1: second cycle free", buy2get1, 100, 5, 100},
+	}
+
+	for _, test := range tests {
+		result := calculateDiscount(test.promo, test.price, test.bundleCount)
+		if result != test.expected {
+			t.Errorf("%s: calculateDiscount = %.2f; want %.2f", test.name, result, test.expected)
+		}
+	}
+}
+
+// Test that bundle bookings only count within the promotion's dates
+func TestInPromotionPeriod(t *testing.T) {
+	promo := Promotion{StartDate: "01/06/2025", EndDate: "30/06/2025"}
+	tests := []struct {
+		date     string
+		expected bool
+	}{
+		{"31/05/2025", false},
+		{"01/06/2025", true},
+		{"15/06/2025", true},
+		{"30/06/2025", true},
+		{"01/07/2025", false},
+	}
+	for _, test := range tests {
+		if result := inPromotionPeriod(promo, test.date); result != test.expected {
+			t.Errorf("inPromotionPeriod(%s) = %v, expected %v", test.date, result, test.expected)
+		}
+	}
+
+	// Open-ended promotions
+	if !inPromotionPeriod(Promotion{StartDate: "01/06/2025"}, "01/01/2030") {
+		t.Error("A promotion without an end date should run on")
+	}
+	if !inPromotionPeriod(Promotion{}, "01/01/2000") {
+		t.Error("A promotion without dates should always apply")
+	}
+}
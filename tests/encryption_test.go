@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Copy of cipher functions from encryption.go for testing
+const (
+	ENCRYPTION_FORMAT     = "mcu-encrypted-v1"
+	ENCRYPTION_KDF        = "pbkdf2-sha256"
+	KEY_SOURCE_PASSPHRASE = "passphrase"
+	KEY_SOURCE_FILE       = "keyfile"
+)
+
+var (
+	ErrWrongKey  = errors.New("wrong key: the data file was encrypted with a different passphrase or key file")
+	ErrCorrupted = errors.New("data file is corrupted: the encrypted data failed its integrity check")
+	ErrNoDataKey = errors.New("no encryption key is loaded")
+)
+
+type EncryptedStore struct {
+	Format     string `json:"format"`
+	KeySource  string `json:"key_source"`
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       string `json:"salt,omitempty"`
+	KeyCheck   string `json:"key_check"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type DataKey struct {
+	Key        []byte
+	Source     string
+	Salt       string
+	Iterations int
+}
+
+// keyCheck identifies a key without revealing it, so a wrong key can be told
+// apart from a damaged file
+func keyCheck(key []byte) string {
+	sum := sha256.Sum256(append([]byte("mcu-key-check:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+func storeAdditionalData(s EncryptedStore) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%s|%s|%d|%s", s.Format, s.KeySource, s.KDF, s.Salt, s.Iterations, s.KeyCheck))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptStore seals the plaintext with AES-256-GCM. The header fields are
+// authenticated along with the data.
+func encryptStore(plaintext []byte, k *DataKey) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNoDataKey
+	}
+
+	gcm, err := newGCM(k.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up cipher: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	store := EncryptedStore{
+		Format:    ENCRYPTION_FORMAT,
+		KeySource: k.Source,
+		KeyCheck:  keyCheck(k.Key),
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+	}
+	if k.Source == KEY_SOURCE_PASSPHRASE {
+		store.KDF = ENCRYPTION_KDF
+		store.Salt = k.Salt
+		store.Iterations = k.Iterations
+	}
+
+	sealed := gcm.Seal(nil, nonce, plaintext, storeAdditionalData(store))
+	store.Ciphertext = base64.StdEncoding.EncodeToString(sealed)
+
+	return json.MarshalIndent(store, "", "  ")
+}
+
+func decryptStore(store EncryptedStore, key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoDataKey
+	}
+	if subtle.ConstantTimeCompare([]byte(keyCheck(key)), []byte(store.KeyCheck)) != 1 {
+		return nil, ErrWrongKey
+	}
+
+	nonce, err1 := base64.StdEncoding.DecodeString(store.Nonce)
+	sealed, err2 := base64.StdEncoding.DecodeString(store.Ciphertext)
+	if err1 != nil || err2 != nil {
+		return nil, ErrCorrupted
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up cipher: %v", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrCorrupted
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, storeAdditionalData(store))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return plaintext, nil
+}
+
+// parseEncryptedStore reports whether the file content is an encrypted store.
+// Plain JSON from older versions is not.
+func parseEncryptedStore(content []byte) (EncryptedStore, bool) {
+	var store EncryptedStore
+	if err := json.Unmarshal(content, &store); err != nil || store.Format != ENCRYPTION_FORMAT {
+		return store, false
+	}
+	return store, true
+}
+
+func testKey(b byte) *DataKey {
+	return &DataKey{Key: bytes.Repeat([]byte{b}, 32), Source: KEY_SOURCE_FILE}
+}
+
+// Test encrypting and decrypting the data store
+func TestEncryptStore(t *testing.T) {
+	plaintext := []byte(`{"patients":{"daftar":[{"id":10001,"name":"Budi"}],"n":1}}`)
+	key := testKey(1)
+
+	content, err := encryptStore(plaintext, key)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if bytes.Contains(content, []byte("Budi")) {
+		t.Error("Encrypted file contains plaintext")
+	}
+
+	store, ok := parseEncryptedStore(content)
+	if !ok {
+		t.Fatal("Encrypted file not recognised")
+	}
+	if _, ok := parseEncryptedStore(plaintext); ok {
+		t.Error("Plain JSON recognised as encrypted")
+	}
+
+	decrypted, err := decryptStore(store, key.Key)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Round trip failed: %v", err)
+	}
+
+	// A different key is reported as a wrong key, not as corruption
+	if _, err := decryptStore(store, testKey(2).Key); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected wrong key error, got %v", err)
+	}
+
+	// Changing one byte of the ciphertext fails authentication
+	sealed, _ := base64.StdEncoding.DecodeString(store.Ciphertext)
+	sealed[5] ^= 0x01
+	tampered := store
+	tampered.Ciphertext = base64.StdEncoding.EncodeToString(sealed)
+	if _, err := decryptStore(tampered, key.Key); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected corruption error for modified ciphertext, got %v", err)
+	}
+
+	// The header is authenticated too
+	header := store
+	header.KeySource = KEY_SOURCE_PASSPHRASE
+	if _, err := decryptStore(header, key.Key); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected corruption error for modified header, got %v", err)
+	}
+
+	// A passphrase key records how it was derived, and that header is
+	// authenticated as well
+	passphraseKey := &DataKey{Key: key.Key, Source: KEY_SOURCE_PASSPHRASE, Salt: "c2FsdA==", Iterations: 600000}
+	derived, _ := encryptStore(plaintext, passphraseKey)
+	derivedStore, _ := parseEncryptedStore(derived)
+	if derivedStore.KDF != ENCRYPTION_KDF || derivedStore.Salt != passphraseKey.Salt || derivedStore.Iterations != passphraseKey.Iterations {
+		t.Errorf("Passphrase header = %q/%q/%d, want the KDF, salt and iterations", derivedStore.KDF, derivedStore.Salt, derivedStore.Iterations)
+	}
+	if store.KDF != "" || store.Salt != "" || store.Iterations != 0 {
+		t.Error("Key file store carries passphrase settings")
+	}
+	fewer := derivedStore
+	fewer.Iterations = 1
+	if _, err := decryptStore(fewer, key.Key); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected corruption error for modified iterations, got %v", err)
+	}
+
+	// Re-encrypting under a new key (rotation) keeps the data
+	rotated, _ := encryptStore(decrypted, testKey(3))
+	rotatedStore, _ := parseEncryptedStore(rotated)
+	if again, err := decryptStore(rotatedStore, testKey(3).Key); err != nil || !bytes.Equal(again, plaintext) {
+		t.Errorf("Rotated store did not decrypt: %v", err)
+	}
+	if _, err := decryptStore(rotatedStore, key.Key); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Old key still opens rotated store: %v", err)
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	FHIR_SYSTEM_PATIENT     = "urn:medicalcheckup:patient"
+	FHIR_SYSTEM_PACKAGE     = "urn:medicalcheckup:package"
+	FHIR_SYSTEM_RECORD      = "urn:medicalcheckup:record"
+	FHIR_SYSTEM_EXAMINATION = "urn:medicalcheckup:examination"
+	FHIR_SYSTEM_CATEGORY    = "urn:medicalcheckup:package-category"
+	FHIR_EXT_AGE            = "urn:medicalcheckup:fhir:age"
+	FHIR_EXT_PRICE          = "urn:medicalcheckup:fhir:price"
+
+	FHIR_ACT_CODE       = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+	FHIR_INTERPRETATION = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+)
+
+var fhirGenders = map[string]string{"M": "male", "F": "female"}
+
+type FHIRIdentifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value"`
+}
+
+type FHIRCoding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type FHIRReference struct {
+	Reference string `json:"reference"`
+}
+
+type FHIRHumanName struct {
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+type FHIRExtension struct {
+	URL          string   `json:"url"`
+	ValueInteger *int     `json:"valueInteger,omitempty"`
+	ValueDecimal *float64 `json:"valueDecimal,omitempty"`
+}
+
+type FHIRQuantity struct {
+	Value *float64 `json:"value,omitempty"`
+	Unit  string   `json:"unit,omitempty"`
+}
+
+type FHIRRange struct {
+	Low  *FHIRQuantity `json:"low,omitempty"`
+	High *FHIRQuantity `json:"high,omitempty"`
+}
+
+type FHIRPeriod struct {
+	Start string `json:"start,omitempty"`
+}
+
+type FHIRPatient struct {
+	ResourceType string           `json:"resourceType"`
+	ID           string           `json:"id,omitempty"`
+	Extension    []FHIRExtension  `json:"extension,omitempty"`
+	Identifier   []FHIRIdentifier `json:"identifier,omitempty"`
+	Name         []FHIRHumanName  `json:"name,omitempty"`
+	Gender       string           `json:"gender,omitempty"`
+	BirthDate    string           `json:"birthDate,omitempty"`
+}
+
+type FHIREncounter struct {
+	ResourceType string                `json:"resourceType"`
+	ID           string                `json:"id,omitempty"`
+	Identifier   []FHIRIdentifier      `json:"identifier,omitempty"`
+	Status       string                `json:"status,omitempty"`
+	Class        *FHIRCoding           `json:"class,omitempty"`
+	Type         []FHIRCodeableConcept `json:"type,omitempty"`
+	Subject      *FHIRReference        `json:"subject,omitempty"`
+	Period       *FHIRPeriod           `json:"period,omitempty"`
+}
+
+type FHIRObservation struct {
+	ResourceType      string                `json:"resourceType"`
+	ID                string                `json:"id,omitempty"`
+	Status            string                `json:"status,omitempty"`
+	Code              *FHIRCodeableConcept  `json:"code,omitempty"`
+	Subject           *FHIRReference        `json:"subject,omitempty"`
+	Encounter         *FHIRReference        `json:"encounter,omitempty"`
+	EffectiveDateTime string                `json:"effectiveDateTime,omitempty"`
+	ValueQuantity     *FHIRQuantity         `json:"valueQuantity,omitempty"`
+	Interpretation    []FHIRCodeableConcept `json:"interpretation,omitempty"`
+	ReferenceRange    []FHIRRange           `json:"referenceRange,omitempty"`
+}
+
+type Result struct {
+	Code  string  `json:"code"`
+	Value float64 `json:"value"`
+	Flag  string  `json:"flag"`
+}
+
+// Copy of FHIR conversion functions from fhir.go for testing
+func fhirDate(date string) string {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return date
+	}
+	return parts[2] + "-" + parts[1] + "-" + parts[0]
+}
+
+func dateFromFHIR(value string) (string, error) {
+	if len(value) < 10 {
+		return "", fmt.Errorf("invalid date %q", value)
+	}
+	t, err := time.Parse("2006-01-02", value[:10])
+	if err != nil || !isValidDate(t.Day(), int(t.Month()), t.Year()) {
+		return "", fmt.Errorf("invalid date %q", value)
+	}
+	return t.Format("02/01/2006"), nil
+}
+
+func fhirReference(resourceType, id string) *FHIRReference {
+	return &FHIRReference{Reference: resourceType + "/" + id}
+}
+
+func ownIdentifier(identifiers []FHIRIdentifier, system string) int {
+	for _, identifier := range identifiers {
+		if identifier.System == system {
+			if id, err := strconv.Atoi(identifier.Value); err == nil {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+func findCoding(concept FHIRCodeableConcept, system string) (FHIRCoding, bool) {
+	for _, coding := range concept.Coding {
+		if coding.System == system {
+			return coding, true
+		}
+	}
+	return FHIRCoding{}, false
+}
+
+func missingElements(missing []string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing required element %s", strings.Join(missing, ", "))
+}
+
+func fhirPatient(p Patient) FHIRPatient {
+	age := p.Age
+	name := FHIRHumanName{Text: p.Name}
+	if words := strings.Fields(p.Name); len(words) > 1 {
+		name.Family = words[len(words)-1]
+		name.Given = words[:len(words)-1]
+	} else {
+		name.Family = p.Name
+	}
+
+	return FHIRPatient{
+		ResourceType: "Patient",
+		ID:           fmt.Sprintf("patient-%d", p.ID),
+		Extension:    []FHIRExtension{{URL: FHIR_EXT_AGE, ValueInteger: &age}},
+		Identifier:   []FHIRIdentifier{{System: FHIR_SYSTEM_PATIENT, Value: strconv.Itoa(p.ID)}},
+		Name:         []FHIRHumanName{name},
+		Gender:       fhirGenders[p.Gender],
+	}
+}
+
+func patientFromFHIR(fp FHIRPatient, thisYear int) (Patient, error) {
+	var missing []string
+	p := Patient{ID: ownIdentifier(fp.Identifier, FHIR_SYSTEM_PATIENT)}
+
+	if len(fp.Name) > 0 {
+		p.Name = fp.Name[0].Text
+		if p.Name == "" {
+			p.Name = strings.TrimSpace(strings.Join(append(append([]string(nil), fp.Name[0].Given...), fp.Name[0].Family), " "))
+		}
+	}
+	if p.Name == "" {
+		missing = append(missing, "Patient.name")
+	}
+
+	switch fp.Gender {
+	case "male":
+		p.Gender = "M"
+	case "female":
+		p.Gender = "F"
+	case "":
+		missing = append(missing, "Patient.gender")
+	}
+
+	p.Age = -1
+	for _, ext := range fp.Extension {
+		if ext.URL == FHIR_EXT_AGE && ext.ValueInteger != nil {
+			p.Age = *ext.ValueInteger
+		}
+	}
+	if p.Age == -1 && len(fp.BirthDate) >= 4 {
+		if year, err := strconv.Atoi(fp.BirthDate[:4]); err == nil {
+			p.Age = thisYear - year
+		}
+	}
+	if p.Age == -1 {
+		missing = append(missing, "Patient.birthDate")
+	}
+
+	if err := missingElements(missing); err != nil {
+		return p, err
+	}
+	if p.Gender == "" {
+		return p, fmt.Errorf("Patient.gender %q is not supported, only male or female", fp.Gender)
+	}
+	if p.Age < 0 || p.Age > 150 {
+		return p, fmt.Errorf("Patient age %d is out of range", p.Age)
+	}
+	return p, nil
+}
+
+// Record conversion
+// encounterStatus follows the record: booked for a later day, under way until
+// a doctor signs it off, then finished
+func encounterStatus(r Record, today string) string {
+	switch {
+	case isSignedOff(r):
+		return "finished"
+	case compareDates(r.Date, today) > 0:
+		return "planned"
+	}
+	return "in-progress"
+}
+
+func todayDate() string {
+	return time.Now().Format("02/01/2006")
+}
+
+func fhirEncounter(r Record) FHIREncounter {
+	return FHIREncounter{
+		ResourceType: "Encounter",
+		ID:           fmt.Sprintf("record-%d", r.ID),
+		Identifier:   []FHIRIdentifier{{System: FHIR_SYSTEM_RECORD, Value: strconv.Itoa(r.ID)}},
+		Status:       encounterStatus(r, todayDate()),
+		Class:        &FHIRCoding{System: FHIR_ACT_CODE, Code: "AMB", Display: "ambulatory"},
+		Type: []FHIRCodeableConcept{{
+			Coding: []FHIRCoding{{System: FHIR_SYSTEM_PACKAGE, Code: strconv.Itoa(r.Package.ID), Display: r.Package.Name}},
+			Text:   r.Package.Name,
+		}},
+		Subject: fhirReference("Patient", fmt.Sprintf("patient-%d", r.Patient.ID)),
+		Period:  &FHIRPeriod{Start: fhirDate(r.Date)},
+	}
+}
+
+func encounterFromFHIR(enc FHIREncounter) (Record, string, error) {
+	var missing []string
+	r := Record{ID: ownIdentifier(enc.Identifier, FHIR_SYSTEM_RECORD)}
+	if enc.Status == "" {
+		missing = append(missing, "Encounter.status")
+	}
+	if enc.Class == nil || enc.Class.Code == "" {
+		missing = append(missing, "Encounter.class")
+	}
+
+	subject := ""
+	if enc.Subject != nil {
+		subject = enc.Subject.Reference
+	}
+	if subject == "" {
+		missing = append(missing, "Encounter.subject")
+	}
+
+	if len(enc.Type) > 0 {
+		r.Package.Name = enc.Type[0].Text
+		if coding, ok := findCoding(enc.Type[0], FHIR_SYSTEM_PACKAGE); ok {
+			r.Package.ID, _ = strconv.Atoi(coding.Code)
+			if r.Package.Name == "" {
+				r.Package.Name = coding.Display
+			}
+		}
+	}
+	if r.Package.ID == 0 && r.Package.Name == "" {
+		missing = append(missing, "Encounter.type")
+	}
+
+	if enc.Period == nil || enc.Period.Start == "" {
+		missing = append(missing, "Encounter.period.start")
+	}
+	if err := missingElements(missing); err != nil {
+		return r, subject, err
+	}
+
+	date, err := dateFromFHIR(enc.Period.Start)
+	if err != nil {
+		return r, subject, fmt.Errorf("Encounter.period.start: %v", err)
+	}
+	r.Date = date
+	return r, subject, nil
+}
+
+// Result conversion
+func fhirObservation(r Record, res Result, e Examination) FHIRObservation {
+	name := e.Name
+	if name == "" {
+		name = res.Code
+	}
+	value := res.Value
+	// Results may still change until the record is signed off
+	status := "preliminary"
+	if isSignedOff(r) {
+		status = "final"
+	}
+	obs := FHIRObservation{
+		ResourceType: "Observation",
+		ID:           fmt.Sprintf("result-%d-%s", r.ID, strings.ToLower(res.Code)),
+		Status:       status,
+		Code: &FHIRCodeableConcept{
+			Coding: []FHIRCoding{{System: FHIR_SYSTEM_EXAMINATION, Code: res.Code, Display: name}},
+			Text:   name,
+		},
+		Subject:           fhirReference("Patient", fmt.Sprintf("patient-%d", r.Patient.ID)),
+		Encounter:         fhirReference("Encounter", fmt.Sprintf("record-%d", r.ID)),
+		EffectiveDateTime: fhirDate(r.Date),
+		ValueQuantity:     &FHIRQuantity{Value: &value, Unit: e.Unit},
+	}
+	if res.Flag != "" {
+		obs.Interpretation = []FHIRCodeableConcept{{Coding: []FHIRCoding{{System: FHIR_INTERPRETATION, Code: res.Flag}}}}
+	}
+	if hasReferenceRange(e) {
+		low, high := e.RefLow, e.RefHigh
+		obs.ReferenceRange = []FHIRRange{{
+			Low:  &FHIRQuantity{Value: &low, Unit: e.Unit},
+			High: &FHIRQuantity{Value: &high, Unit: e.Unit},
+		}}
+	}
+	return obs
+}
+
+func observationFromFHIR(obs FHIRObservation) (Result, Examination, string, error) {
+	var missing []string
+	var res Result
+	var e Examination
+
+	if obs.Status == "" {
+		missing = append(missing, "Observation.status")
+	}
+	if obs.Code != nil {
+		coding, ok := findCoding(*obs.Code, FHIR_SYSTEM_EXAMINATION)
+		if !ok && len(obs.Code.Coding) > 0 {
+			coding = obs.Code.Coding[0]
+		}
+		e.Code = strings.ToUpper(coding.Code)
+		e.Name = obs.Code.Text
+		if e.Name == "" {
+			e.Name = coding.Display
+		}
+	}
+	if e.Code == "" {
+		missing = append(missing, "Observation.code")
+	}
+
+	encounter := ""
+	if obs.Encounter != nil {
+		encounter = obs.Encounter.Reference
+	}
+	if encounter == "" {
+		missing = append(missing, "Observation.encounter")
+	}
+	if obs.ValueQuantity == nil || obs.ValueQuantity.Value == nil {
+		missing = append(missing, "Observation.valueQuantity")
+	}
+	if err := missingElements(missing); err != nil {
+		return res, e, encounter, err
+	}
+
+	if e.Name == "" {
+		e.Name = e.Code
+	}
+	e.Unit = obs.ValueQuantity.Unit
+	if len(obs.ReferenceRange) > 0 {
+		if low := obs.ReferenceRange[0].Low; low != nil && low.Value != nil {
+			e.RefLow = *low.Value
+		}
+		if high := obs.ReferenceRange[0].High; high != nil && high.Value != nil {
+			e.RefHigh = *high.Value
+		}
+	}
+
+	res = Result{Code: e.Code, Value: *obs.ValueQuantity.Value}
+	for _, concept := range obs.Interpretation {
+		if coding, ok := findCoding(concept, FHIR_INTERPRETATION); ok {
+			res.Flag = coding.Code
+		}
+	}
+	return res, e, encounter, nil
+}
+
+// roundTrip encodes a resource to JSON and decodes it again, as export and
+// import do
+func roundTrip(t *testing.T, in, out interface{}) {
+	content, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if err := json.Unmarshal(content, out); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+}
+
+// Test date conversion
+func TestFHIRDate(t *testing.T) {
+	if d := fhirDate("05/03/2025"); d != "2025-03-05" {
+		t.Errorf("fhirDate = %s, expected 2025-03-05", d)
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{"2025-03-05", "05/03/2025", true},
+		{"2025-03-05T08:30:00+07:00", "05/03/2025", true},
+		{"2024-02-29", "29/02/2024", true},
+		{"2025-02-29", "", false},
+		{"2025", "", false},
+	}
+	for _, test := range tests {
+		result, err := dateFromFHIR(test.value)
+		if (err == nil) != test.valid || result != test.expected {
+			t.Errorf("dateFromFHIR(%q) = %q, %v", test.value, result, err)
+		}
+	}
+}
+
+// Test Patient export and import
+func TestFHIRPatientRoundTrip(t *testing.T) {
+	patients := []Patient{
+		{ID: 20001, Name: "Budi Santoso", Gender: "M", Age: 40},
+		{ID: 20002, Name: "Siti", Gender: "F", Age: 0},
+		{ID: 20003, Name: "Maria de la Cruz", Gender: "F", Age: 150},
+	}
+
+	for _, p := range patients {
+		var fp FHIRPatient
+		roundTrip(t, fhirPatient(p), &fp)
+		result, err := patientFromFHIR(fp, 2025)
+		if err != nil {
+			t.Errorf("Patient %d: %v", p.ID, err)
+			continue
+		}
+		if result != p {
+			t.Errorf("Round trip changed patient: %+v -> %+v", p, result)
+		}
+	}
+}
+
+// Test Patient resources from other systems
+func TestFHIRPatientImport(t *testing.T) {
+	fp := FHIRPatient{
+		ResourceType: "Patient",
+		Identifier:   []FHIRIdentifier{{System: "http://hospital.example/mrn", Value: "A-77"}},
+		Name:         []FHIRHumanName{{Family: "Wijaya", Given: []string{"Andi", "Putra"}}},
+		Gender:       "male",
+		BirthDate:    "1990-05-01",
+	}
+	p, err := patientFromFHIR(fp, 2025)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.ID != 0 || p.Name != "Andi Putra Wijaya" || p.Gender != "M" || p.Age != 35 {
+		t.Errorf("Unexpected patient %+v", p)
+	}
+
+	if _, err := patientFromFHIR(FHIRPatient{ResourceType: "Patient"}, 2025); err == nil ||
+		!strings.Contains(err.Error(), "Patient.name") || !strings.Contains(err.Error(), "Patient.gender") {
+		t.Errorf("Expected missing name and gender, got %v", err)
+	}
+
+	fp.Gender = "unknown"
+	if _, err := patientFromFHIR(fp, 2025); err == nil {
+		t.Error("Expected gender 'unknown' to be rejected")
+	}
+}
+
+// Test Record export and import as an Encounter
+func TestFHIREncounterRoundTrip(t *testing.T) {
+	r := Record{
+		ID:      30001,
+		Patient: Patient{ID: 20001},
+		Package: Package{ID: 10001, Name: "Basic & Check"},
+		Date:    "01/02/2025",
+	}
+
+	var enc FHIREncounter
+	roundTrip(t, fhirEncounter(r), &enc)
+	result, subject, err := encounterFromFHIR(enc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.ID != r.ID || result.Date != r.Date || result.Package.ID != 10001 || result.Package.Name != "Basic & Check" {
+		t.Errorf("Round trip changed record: %+v", result)
+	}
+	if subject != "Patient/patient-20001" {
+		t.Errorf("Expected subject Patient/patient-20001, got %s", subject)
+	}
+
+	if enc.Status != "in-progress" {
+		t.Errorf("Expected a past record that is not signed off to be in-progress, got %s", enc.Status)
+	}
+
+	enc.Class = nil
+	enc.Period = nil
+	if _, _, err := encounterFromFHIR(enc); err == nil ||
+		!strings.Contains(err.Error(), "Encounter.class") || !strings.Contains(err.Error(), "Encounter.period.start") {
+		t.Errorf("Expected missing class and period, got %v", err)
+	}
+}
+
+// Test the Encounter status follows the record
+func TestFHIREncounterStatus(t *testing.T) {
+	today := "15/06/2025"
+	tests := []struct {
+		date     string
+		signedBy string
+		expected string
+	}{
+		{"20/06/2025", "", "planned"},
+		{"15/06/2025", "", "in-progress"},
+		{"01/06/2025", "", "in-progress"},
+		{"01/06/2025", "drsari", "finished"},
+	}
+	for _, test := range tests {
+		r := Record{Date: test.date, ConcludedBy: test.signedBy}
+		if result := encounterStatus(r, today); result != test.expected {
+			t.Errorf("encounterStatus(%s, %q) = %s, expected %s", test.date, test.signedBy, result, test.expected)
+		}
+	}
+}
+
+// Test results export and import as Observations
+func TestFHIRObservationRoundTrip(t *testing.T) {
+	r := Record{ID: 30001, Patient: Patient{ID: 20001}, Date: "01/02/2025"}
+	e := Examination{Code: "GLU", Name: "Glucose", Unit: "mg/dL", RefLow: 70, RefHigh: 110}
+	res := Result{Code: "GLU", Value: 130.5, Flag: "H"}
+
+	var obs FHIRObservation
+	roundTrip(t, fhirObservation(r, res, e), &obs)
+	resultBack, examBack, encounter, err := observationFromFHIR(obs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resultBack != res {
+		t.Errorf("Round trip changed result: %+v -> %+v", res, resultBack)
+	}
+	if examBack != e {
+		t.Errorf("Round trip changed examination: %+v -> %+v", e, examBack)
+	}
+	if encounter != "Encounter/record-30001" {
+		t.Errorf("Expected encounter Encounter/record-30001, got %s", encounter)
+	}
+	if obs.Status != "preliminary" {
+		t.Errorf("Expected a result that is not signed off to be preliminary, got %s", obs.Status)
+	}
+	r.ConcludedBy = "drsari"
+	if status := fhirObservation(r, res, e).Status; status != "final" {
+		t.Errorf("Expected a signed-off result to be final, got %s", status)
+	}
+	r.ConcludedBy = ""
+
+	// Without a reference range or flag nothing is invented on the way back
+	e = Examination{Code: "BMI", Name: "Body Mass Index", Unit: "kg/m2"}
+	res = Result{Code: "BMI", Value: 22.4}
+	var plain FHIRObservation
+	roundTrip(t, fhirObservation(r, res, e), &plain)
+	resultBack, examBack, _, err = observationFromFHIR(plain)
+	if err != nil || resultBack != res || examBack != e {
+		t.Errorf("Round trip without range failed: %+v %+v %v", resultBack, examBack, err)
+	}
+
+	if _, _, _, err := observationFromFHIR(FHIRObservation{ResourceType: "Observation"}); err == nil ||
+		!strings.Contains(err.Error(), "Observation.code") || !strings.Contains(err.Error(), "Observation.valueQuantity") {
+		t.Errorf("Expected missing code and value, got %v", err)
+	}
+}
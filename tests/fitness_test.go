@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	FITNESS_FIT        = "fit"
+	FITNESS_FIT_NOTES  = "fit_with_notes"
+	FITNESS_TEMP_UNFIT = "temporarily_unfit"
+	FITNESS_UNFIT      = "unfit"
+
+	MAX_FOLLOW_UP_MONTHS = 60
+)
+
+var fitnessNames = map[string]string{
+	FITNESS_FIT:        "Fit",
+	FITNESS_FIT_NOTES:  "Fit with notes",
+	FITNESS_TEMP_UNFIT: "Temporarily unfit",
+	FITNESS_UNFIT:      "Unfit",
+}
+
+type Finding struct {
+	Code string
+	Text string
+}
+
+type ConclusionDraft struct {
+	Text            string
+	Fitness         string
+	Findings        []Finding
+	Recommendations string
+	FollowUpMonths  int
+}
+
+// Copy of conclusion functions from fitness.go for testing
+func parseDate(date string) (time.Time, error) {
+	return time.Parse("02/01/2006", date)
+}
+
+// Conclusion functions
+func fitnessName(category string) string {
+	if name, ok := fitnessNames[category]; ok {
+		return name
+	}
+	return "Not concluded"
+}
+
+// isValidICD10 checks the shape of an ICD-10 code: a letter, two digits and
+// an optional subdivision after a dot, e.g. "I10" or "E78.5"
+func isValidICD10(code string) bool {
+	head, sub, hasSub := strings.Cut(code, ".")
+	if len(head) != 3 || head[0] < 'A' || head[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < 3; i++ {
+		if head[i] < '0' || head[i] > '9' {
+			return false
+		}
+	}
+	if !hasSub {
+		return true
+	}
+	if len(sub) == 0 || len(sub) > 4 {
+		return false
+	}
+	for i := 0; i < len(sub); i++ {
+		c := sub[i]
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// followUpDue returns the date a follow-up is due, or "" when none is set
+func followUpDue(date string, months int) string {
+	if months <= 0 {
+		return ""
+	}
+	t, err := parseDate(date)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, months, 0).Format("02/01/2006")
+}
+
+// checkConclusion tells whether a conclusion is complete enough to sign
+func checkConclusion(c ConclusionDraft) error {
+	if _, ok := fitnessNames[c.Fitness]; !ok {
+		return errors.New("a fitness category is required")
+	}
+	if c.Fitness != FITNESS_FIT && len(c.Findings) == 0 && strings.TrimSpace(c.Recommendations) == "" {
+		return fmt.Errorf("%q needs at least one finding or recommendation", fitnessName(c.Fitness))
+	}
+	if c.Fitness == FITNESS_TEMP_UNFIT && c.FollowUpMonths == 0 {
+		return errors.New("a temporarily unfit patient needs a follow-up interval")
+	}
+	if c.FollowUpMonths < 0 || c.FollowUpMonths > MAX_FOLLOW_UP_MONTHS {
+		return fmt.Errorf("the follow-up interval must be 0 to %d months", MAX_FOLLOW_UP_MONTHS)
+	}
+	return nil
+}
+
+// Test ICD-10 code format
+func TestICD10(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected bool
+	}{
+		{"I10", true},
+		{"E78.5", true},
+		{"S72.001A", true},
+		{"e78.5", false},
+		{"E7", false},
+		{"E78.", false},
+		{"78.5", false},
+		{"E7A", false},
+		{"E78.12345", false},
+	}
+
+	for _, test := range tests {
+		if result := isValidICD10(test.code); result != test.expected {
+			t.Errorf("isValidICD10(%q) = %v, expected %v", test.code, result, test.expected)
+		}
+	}
+}
+
+// Test follow-up due dates
+func TestFollowUpDue(t *testing.T) {
+	tests := []struct {
+		date     string
+		months   int
+		expected string
+	}{
+		{"03/03/2025", 6, "03/09/2025"},
+		{"15/11/2025", 3, "15/02/2026"},
+		{"03/03/2025", 0, ""},
+		{"not a date", 6, ""},
+	}
+
+	for _, test := range tests {
+		if result := followUpDue(test.date, test.months); result != test.expected {
+			t.Errorf("followUpDue(%q, %d) = %q, expected %q", test.date, test.months, result, test.expected)
+		}
+	}
+}
+
+// Test which conclusions can be signed
+func TestCheckConclusion(t *testing.T) {
+	finding := []Finding{{"E78.5", "Hyperlipidaemia, unspecified"}}
+	tests := []struct {
+		name  string
+		draft ConclusionDraft
+		valid bool
+	}{
+		{"fit without notes", ConclusionDraft{Fitness: FITNESS_FIT}, true},
+		{"no category", ConclusionDraft{Text: "Healthy"}, false},
+		{"unknown category", ConclusionDraft{Fitness: "maybe"}, false},
+		{"notes without findings", ConclusionDraft{Fitness: FITNESS_FIT_NOTES}, false},
+		{"notes with a finding", ConclusionDraft{Fitness: FITNESS_FIT_NOTES, Findings: finding}, true},
+		{"unfit with advice", ConclusionDraft{Fitness: FITNESS_UNFIT, Recommendations: "Refer to cardiology"}, true},
+		{"temporarily unfit without follow-up", ConclusionDraft{Fitness: FITNESS_TEMP_UNFIT, Findings: finding}, false},
+		{"temporarily unfit with follow-up", ConclusionDraft{Fitness: FITNESS_TEMP_UNFIT, Findings: finding, FollowUpMonths: 3}, true},
+		{"follow-up too long", ConclusionDraft{Fitness: FITNESS_FIT, FollowUpMonths: 61}, false},
+	}
+
+	for _, test := range tests {
+		err := checkConclusion(test.draft)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: checkConclusion() = %v, expected valid = %v", test.name, err, test.valid)
+		}
+	}
+
+	if name := fitnessName(""); name != "Not concluded" {
+		t.Errorf("Expected an empty category to read Not concluded, got %s", name)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Copy of HL7 parsing functions from hl7.go for testing
+const (
+	MLLP_START_BLOCK = 0x0B
+	MLLP_END_BLOCK   = 0x1C
+	MLLP_MAX_MESSAGE = 1 << 20
+)
+
+var ErrMLLPTooLarge = errors.New("MLLP message too large")
+
+type HL7Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+var defaultHL7Delimiters = HL7Delimiters{'|', '^', '~', '\\', '&'}
+
+type HL7Segment struct {
+	Name   string
+	fields []string // fields[0] is the segment name
+	delims HL7Delimiters
+}
+
+type HL7Message struct {
+	Delims   HL7Delimiters
+	Segments []HL7Segment
+}
+
+// HL7Observation is one OBX result line
+type HL7Observation struct {
+	SetID  string
+	Code   string
+	Text   string
+	Value  string
+	Units  string
+	Status string
+}
+
+// HL7Order is one OBR with the OBX results that follow it
+type HL7Order struct {
+	RecordID     string
+	Observations []HL7Observation
+}
+
+func splitSegments(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+	var lines []string
+	for _, line := range strings.Split(text, "\r") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseHL7 reads a message using the delimiters declared in its MSH segment
+func parseHL7(text string) (HL7Message, error) {
+	var msg HL7Message
+	lines := splitSegments(text)
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "MSH") || len(lines[0]) < 8 {
+		return msg, errors.New("message does not start with an MSH segment")
+	}
+
+	header := lines[0]
+	encoding := strings.SplitN(header[4:], header[3:4], 2)[0]
+	if len(encoding) < 4 {
+		return msg, errors.New("MSH-2 must hold four encoding characters")
+	}
+	msg.Delims = HL7Delimiters{
+		Field:        header[3],
+		Component:    encoding[0],
+		Repetition:   encoding[1],
+		Escape:       encoding[2],
+		Subcomponent: encoding[3],
+	}
+
+	for _, line := range lines {
+		fields := strings.Split(line, string(msg.Delims.Field))
+		if len(fields[0]) != 3 {
+			return msg, fmt.Errorf("invalid segment %q", fields[0])
+		}
+		msg.Segments = append(msg.Segments, HL7Segment{Name: fields[0], fields: fields, delims: msg.Delims})
+	}
+	return msg, nil
+}
+
+// Segment returns the first segment with the given name
+func (m HL7Message) Segment(name string) (HL7Segment, bool) {
+	for _, s := range m.Segments {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return HL7Segment{}, false
+}
+
+// rawField returns field n as numbered in the HL7 spec. In MSH the field
+// separator itself is MSH-1.
+func (s HL7Segment) rawField(n int) string {
+	if s.Name == "MSH" {
+		if n == 1 {
+			return string(s.delims.Field)
+		}
+		n--
+	}
+	if n <= 0 || n >= len(s.fields) {
+		return ""
+	}
+	return s.fields[n]
+}
+
+// Repeats returns the number of repetitions of field n
+func (s HL7Segment) Repeats(n int) int {
+	raw := s.rawField(n)
+	if raw == "" {
+		return 0
+	}
+	if s.Name == "MSH" && n <= 2 {
+		return 1
+	}
+	return strings.Count(raw, string(s.delims.Repetition)) + 1
+}
+
+// Get returns component comp (1-based; 0 for the whole repetition) of
+// repetition rep (0-based) of field n, unescaped
+func (s HL7Segment) Get(n, rep, comp int) string {
+	raw := s.rawField(n)
+	if s.Name == "MSH" && n <= 2 {
+		return raw
+	}
+	reps := strings.Split(raw, string(s.delims.Repetition))
+	if rep >= len(reps) {
+		return ""
+	}
+	value := reps[rep]
+	if comp > 0 {
+		components := strings.Split(value, string(s.delims.Component))
+		if comp > len(components) {
+			return ""
+		}
+		value = components[comp-1]
+	}
+	return hl7Unescape(value, s.delims)
+}
+
+// Value returns component comp of the first repetition of field n
+func (s HL7Segment) Value(n, comp int) string {
+	return s.Get(n, 0, comp)
+}
+
+// hl7Unescape replaces escape sequences with the characters they stand for
+func hl7Unescape(value string, d HL7Delimiters) string {
+	esc := string(d.Escape)
+	if !strings.Contains(value, esc) {
+		return value
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(value, esc)
+		if start == -1 {
+			b.WriteString(value)
+			break
+		}
+		end := strings.Index(value[start+1:], esc)
+		if end == -1 {
+			b.WriteString(value)
+			break
+		}
+		b.WriteString(value[:start])
+		seq := value[start+1 : start+1+end]
+		switch {
+		case seq == "F":
+			b.WriteByte(d.Field)
+		case seq == "S":
+			b.WriteByte(d.Component)
+		case seq == "T":
+			b.WriteByte(d.Subcomponent)
+		case seq == "R":
+			b.WriteByte(d.Repetition)
+		case seq == "E":
+			b.WriteByte(d.Escape)
+		case seq == ".br":
+			b.WriteByte('\n')
+		case strings.HasPrefix(seq, "X") && len(seq)%2 == 1:
+			for i := 1; i+1 < len(seq); i += 2 {
+				if n, err := strconv.ParseUint(seq[i:i+2], 16, 8); err == nil {
+					b.WriteByte(byte(n))
+				}
+			}
+		default:
+			// Formatting sequences we don't render are dropped
+		}
+		value = value[start+end+2:]
+	}
+	return b.String()
+}
+
+// hl7Escape escapes delimiter characters in text written into a message
+func hl7Escape(value string, d HL7Delimiters) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case d.Escape:
+			b.WriteString(string(d.Escape) + "E" + string(d.Escape))
+		case d.Field:
+			b.WriteString(string(d.Escape) + "F" + string(d.Escape))
+		case d.Component:
+			b.WriteString(string(d.Escape) + "S" + string(d.Escape))
+		case d.Subcomponent:
+			b.WriteString(string(d.Escape) + "T" + string(d.Escape))
+		case d.Repetition:
+			b.WriteString(string(d.Escape) + "R" + string(d.Escape))
+		case '\r', '\n':
+			b.WriteString(string(d.Escape) + ".br" + string(d.Escape))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitHL7Batch splits a file holding several messages at each MSH segment.
+// MLLP framing characters and FHS/BHS/BTS/FTS batch segments are skipped.
+func splitHL7Batch(text string) []string {
+	text = strings.NewReplacer(string(rune(MLLP_START_BLOCK)), "", string(rune(MLLP_END_BLOCK)), "").Replace(text)
+	var messages []string
+	var current []string
+	for _, line := range splitSegments(text) {
+		switch {
+		case strings.HasPrefix(line, "MSH"):
+			if len(current) > 0 {
+				messages = append(messages, strings.Join(current, "\r"))
+			}
+			current = []string{line}
+		case strings.HasPrefix(line, "FHS"), strings.HasPrefix(line, "BHS"),
+			strings.HasPrefix(line, "BTS"), strings.HasPrefix(line, "FTS"):
+		case len(current) > 0:
+			current = append(current, line)
+		}
+	}
+	if len(current) > 0 {
+		messages = append(messages, strings.Join(current, "\r"))
+	}
+	return messages
+}
+
+// parseORU checks that the message is an ORU^R01 and collects its orders
+func parseORU(msg HL7Message) ([]HL7Order, error) {
+	msh := msg.Segments[0]
+	if msh.Value(9, 1) != "ORU" || msh.Value(9, 2) != "R01" {
+		return nil, fmt.Errorf("unsupported message type %s^%s", msh.Value(9, 1), msh.Value(9, 2))
+	}
+
+	var orders []HL7Order
+	for _, s := range msg.Segments {
+		switch s.Name {
+		case "OBR":
+			// The placer order number is our record ID; fall back to the filler's
+			recordID := s.Value(2, 1)
+			if recordID == "" {
+				recordID = s.Value(3, 1)
+			}
+			orders = append(orders, HL7Order{RecordID: recordID})
+		case "OBX":
+			if len(orders) == 0 {
+				return nil, errors.New("OBX segment before any OBR segment")
+			}
+			order := &orders[len(orders)-1]
+			order.Observations = append(order.Observations, HL7Observation{
+				SetID:  s.Value(1, 0),
+				Code:   s.Value(3, 1),
+				Text:   s.Value(3, 2),
+				Value:  s.Value(5, 0),
+				Units:  s.Value(6, 1),
+				Status: s.Value(11, 0),
+			})
+		}
+	}
+	if len(orders) == 0 {
+		return nil, errors.New("message has no OBR segment")
+	}
+	return orders, nil
+}
+
+// buildACK answers a message with an acknowledgment
+func buildACK(msg HL7Message, code, text, controlID string, now time.Time) string {
+	d := msg.Delims
+	msh, _ := msg.Segment("MSH")
+	version := msh.Value(12, 0)
+	if version == "" {
+		version = "2.5"
+	}
+	sep := string(d.Field)
+	encoding := msh.rawField(2)
+	if encoding == "" {
+		encoding = string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent})
+	}
+
+	header := strings.Join([]string{
+		"MSH", encoding, msh.rawField(5), msh.rawField(6), msh.rawField(3), msh.rawField(4),
+		now.Format("20060102150405"), "",
+		"ACK" + string(d.Component) + msh.Value(9, 2) + string(d.Component) + "ACK",
+		controlID, msh.rawField(11), version,
+	}, sep)
+	ack := strings.Join([]string{"MSA", code, msh.rawField(10), hl7Escape(text, d)}, sep)
+	return header + "\r" + ack + "\r"
+}
+
+// MLLP functions
+func writeMLLP(w io.Writer, message string) error {
+	frame := make([]byte, 0, len(message)+3)
+	frame = append(frame, MLLP_START_BLOCK)
+	frame = append(frame, message...)
+	frame = append(frame, MLLP_END_BLOCK, '\r')
+	_, err := w.Write(frame)
+	return err
+}
+
+// readMLLP reads one framed message, skipping anything before the start block
+func readMLLP(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == MLLP_START_BLOCK {
+			break
+		}
+	}
+
+	var message []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == MLLP_END_BLOCK {
+			if next, err := r.ReadByte(); err == nil && next != '\r' {
+				r.UnreadByte()
+			}
+			return string(message), nil
+		}
+		if len(message) >= MLLP_MAX_MESSAGE {
+			return "", ErrMLLPTooLarge
+		}
+		message = append(message, b)
+	}
+}
+
+const sampleORU = "MSH|^~\\&|ANALYZER|LAB|MCU|CLINIC|20250315101500||ORU^R01|MSG0001|P|2.5\r" +
+	"PID|||20001||Santoso^Budi\r" +
+	"OBR|1|30001||PANEL^Basic Panel\r" +
+	"OBX|1|NM|GLU^Glucose||95|mg/dL|70-110|N|||F\r" +
+	"OBX|2|NM|CHOL^Cholesterol~TC^Total||210|mg/dL|0-200|H|||C\r"
+
+// Test delimiters, fields, repetitions and components
+func TestParseHL7(t *testing.T) {
+	msg, err := parseHL7(sampleORU)
+	if err != nil {
+		t.Fatalf("parseHL7 failed: %v", err)
+	}
+	if msg.Delims != defaultHL7Delimiters {
+		t.Errorf("Expected default delimiters, got %+v", msg.Delims)
+	}
+	if len(msg.Segments) != 5 {
+		t.Fatalf("Expected 5 segments, got %d", len(msg.Segments))
+	}
+
+	msh := msg.Segments[0]
+	if msh.Value(1, 0) != "|" || msh.Value(2, 0) != "^~\\&" {
+		t.Errorf("MSH-1/MSH-2 = %q/%q", msh.Value(1, 0), msh.Value(2, 0))
+	}
+	if msh.Value(3, 0) != "ANALYZER" || msh.Value(10, 0) != "MSG0001" {
+		t.Errorf("MSH-3/MSH-10 = %q/%q", msh.Value(3, 0), msh.Value(10, 0))
+	}
+
+	pid, _ := msg.Segment("PID")
+	if pid.Value(5, 2) != "Budi" || pid.Value(5, 3) != "" {
+		t.Errorf("PID-5.2/PID-5.3 = %q/%q", pid.Value(5, 2), pid.Value(5, 3))
+	}
+
+	obx := msg.Segments[4]
+	if obx.Repeats(3) != 2 || obx.Get(3, 1, 1) != "TC" || obx.Get(3, 2, 1) != "" {
+		t.Errorf("OBX-3 repetitions: %d, %q", obx.Repeats(3), obx.Get(3, 1, 1))
+	}
+
+	// Non-standard delimiters are taken from MSH
+	custom, err := parseHL7("MSH#*!/%#A\rPID###1*2")
+	if err != nil {
+		t.Fatalf("parseHL7 with custom delimiters failed: %v", err)
+	}
+	if custom.Segments[1].Value(3, 2) != "2" {
+		t.Errorf("Expected PID-3.2 = 2 with custom delimiters, got %q", custom.Segments[1].Value(3, 2))
+	}
+
+	for _, bad := range []string{"", "PID|||1", "MSH|^~"} {
+		if _, err := parseHL7(bad); err == nil {
+			t.Errorf("Expected parseHL7(%q) to fail", bad)
+		}
+	}
+}
+
+// Test escape sequences both ways
+func TestHL7Escape(t *testing.T) {
+	d := defaultHL7Delimiters
+	tests := []struct {
+		raw     string
+		escaped string
+	}{
+		{"Normal", "Normal"},
+		{"A|B", "A\\F\\B"},
+		{"1^2~3&4", "1\\S\\2\\R\\3\\T\\4"},
+		{"C:\\lab", "C:\\E\\lab"},
+		{"line1\nline2", "line1\\.br\\line2"},
+	}
+
+	for _, test := range tests {
+		if result := hl7Escape(test.raw, d); result != test.escaped {
+			t.Errorf("hl7Escape(%q) = %q, expected %q", test.raw, result, test.escaped)
+		}
+		if result := hl7Unescape(test.escaped, d); result != test.raw {
+			t.Errorf("hl7Unescape(%q) = %q, expected %q", test.escaped, result, test.raw)
+		}
+	}
+
+	if result := hl7Unescape("\\X4142\\C\\H\\bold\\N\\", d); result != "ABCbold" {
+		t.Errorf("Expected hex and formatting sequences to be handled, got %q", result)
+	}
+}
+
+// Test ORU parsing and batch splitting
+func TestParseORU(t *testing.T) {
+	batch := "FHS|^~\\&\rBHS|^~\\&\r" + sampleORU + "\x0b" + strings.Replace(sampleORU, "OBR|1|30001", "OBR|1||30002", 1) + "\x1c\rBTS|2\rFTS|1\r"
+	messages := splitHL7Batch(batch)
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages in batch, got %d", len(messages))
+	}
+
+	var records []string
+	for _, text := range messages {
+		msg, err := parseHL7(text)
+		if err != nil {
+			t.Fatalf("parseHL7 failed: %v", err)
+		}
+		orders, err := parseORU(msg)
+		if err != nil {
+			t.Fatalf("parseORU failed: %v", err)
+		}
+		if len(orders) != 1 || len(orders[0].Observations) != 2 {
+			t.Fatalf("Expected 1 order with 2 observations, got %+v", orders)
+		}
+		records = append(records, orders[0].RecordID)
+	}
+	if records[0] != "30001" || records[1] != "30002" {
+		t.Errorf("Expected records 30001 and 30002 (filler fallback), got %v", records)
+	}
+
+	msg, _ := parseHL7(sampleORU)
+	orders, _ := parseORU(msg)
+	obx := orders[0].Observations[1]
+	if obx.Code != "CHOL" || obx.Text != "Cholesterol" || obx.Value != "210" || obx.Units != "mg/dL" || obx.Status != "C" {
+		t.Errorf("Unexpected observation %+v", obx)
+	}
+
+	adt, _ := parseHL7("MSH|^~\\&|A|B|C|D|2025||ADT^A01|1|P|2.5\r")
+	if _, err := parseORU(adt); err == nil {
+		t.Error("Expected ADT^A01 to be rejected")
+	}
+	orphan, _ := parseHL7("MSH|^~\\&|A|B|C|D|2025||ORU^R01|1|P|2.5\rOBX|1|NM|GLU||5\r")
+	if _, err := parseORU(orphan); err == nil {
+		t.Error("Expected OBX before OBR to be rejected")
+	}
+}
+
+// Test acknowledgments and MLLP framing
+func TestACKAndMLLP(t *testing.T) {
+	msg, _ := parseHL7(sampleORU)
+	now := time.Date(2025, 3, 15, 10, 16, 0, 0, time.UTC)
+	ack := buildACK(msg, "AE", "code GLU|2 unknown", "ACK1", now)
+	expected := "MSH|^~\\&|MCU|CLINIC|ANALYZER|LAB|20250315101600||ACK^R01^ACK|ACK1|P|2.5\r" +
+		"MSA|AE|MSG0001|code GLU\\F\\2 unknown\r"
+	if ack != expected {
+		t.Errorf("buildACK =\n%q\nexpected\n%q", ack, expected)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("noise")
+	writeMLLP(&buf, sampleORU)
+	writeMLLP(&buf, ack)
+	r := bufio.NewReader(&buf)
+	for _, want := range []string{sampleORU, ack} {
+		got, err := readMLLP(r)
+		if err != nil || got != want {
+			t.Errorf("readMLLP = %q, %v; expected %q", got, err, want)
+		}
+	}
+	if _, err := readMLLP(r); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last frame, got %v", err)
+	}
+
+	big := bufio.NewReader(strings.NewReader("\x0b" + strings.Repeat("A", MLLP_MAX_MESSAGE+1)))
+	if _, err := readMLLP(big); err != ErrMLLPTooLarge {
+		t.Errorf("Expected ErrMLLPTooLarge, got %v", err)
+	}
+}
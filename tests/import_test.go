@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Copy of import functions from import.go for testing
+type ImportField struct {
+	Name    string
+	Aliases []string
+}
+
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+func autoMapColumns(header []string, fields []ImportField) []int {
+	mapping := make([]int, len(fields))
+	for i, field := range fields {
+		mapping[i] = -1
+		for col, h := range header {
+			name := strings.ToLower(strings.TrimSpace(h))
+			if name == field.Name {
+				mapping[i] = col
+				break
+			}
+			for _, alias := range field.Aliases {
+				if name == alias {
+					mapping[i] = col
+				}
+			}
+			if mapping[i] != -1 {
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// Test spreadsheet cell references
+func TestXLSXColumn(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected int
+	}{
+		{"A1", 0},
+		{"C12", 2},
+		{"Z3", 25},
+		{"AA7", 26},
+		{"AB100", 27},
+	}
+
+	for _, test := range tests {
+		if result := xlsxColumn(test.ref); result != test.expected {
+			t.Errorf("xlsxColumn(%s) = %d, expected %d", test.ref, result, test.expected)
+		}
+	}
+}
+
+// Test column mapping by header name and alias
+func TestAutoMapColumns(t *testing.T) {
+	fields := []ImportField{
+		{Name: "name", Aliases: []string{"patient name", "nama"}},
+		{Name: "gender", Aliases: []string{"sex", "jenis kelamin"}},
+		{Name: "age", Aliases: []string{"umur"}},
+	}
+
+	tests := []struct {
+		header   []string
+		expected []int
+	}{
+		{[]string{"Name", "Gender", "Age"}, []int{0, 1, 2}},
+		{[]string{"Umur", " Jenis Kelamin ", "Nama"}, []int{2, 1, 0}},
+		{[]string{"NIK", "Patient Name", "Sex"}, []int{1, 2, -1}},
+	}
+
+	for _, test := range tests {
+		result := autoMapColumns(test.header, fields)
+		for i := range fields {
+			if result[i] != test.expected[i] {
+				t.Errorf("autoMapColumns(%v) = %v, expected %v", test.header, result, test.expected)
+				break
+			}
+		}
+	}
+}
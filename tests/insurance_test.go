@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+// Copy of coverage calculation from insurance.go for testing
+type CoverageRule struct {
+	Category      string   `json:"category"`
+	Percent       float64  `json:"percent"`
+	Cap           float64  `json:"cap"`
+	ExcludedExams []string `json:"excluded_exams,omitempty"`
+}
+
+func splitCoverage(rule CoverageRule, price, excluded float64) (float64, float64) {
+	coverable := price - excluded
+	if coverable < 0 {
+		coverable = 0
+	}
+
+	covered := coverable * rule.Percent / 100
+	if rule.Cap > 0 && covered > rule.Cap {
+		covered = rule.Cap
+	}
+
+	return covered, price - covered
+}
+
+// Test payer/copay split
+func TestSplitCoverage(t *testing.T) {
+	tests := []struct {
+		rule            CoverageRule
+		price, excluded float64
+		expectedCovered float64
+		expectedCopay   float64
+	}{
+		{CoverageRule{Percent: 100}, 200, 0, 200, 0},            // Fully covered
+		{CoverageRule{Percent: 80}, 200, 0, 160, 40},            // Percentage
+		{CoverageRule{Percent: 80, Cap: 100}, 200, 0, 100, 100}, // Capped
+		{CoverageRule{Percent: 100}, 200, 50, 150, 50},          // Excluded examination paid by patient
+		{CoverageRule{Percent: 50}, 200, 50, 75, 125},           // Percentage of the coverable part
+		{CoverageRule{Percent: 100}, 100, 150, 0, 100},          // Exclusions larger than the price
+		{CoverageRule{Percent: 0}, 100, 0, 0, 100},              // No coverage
+	}
+
+	for _, test := range tests {
+		covered, copay := splitCoverage(test.rule, test.price, test.excluded)
+		if covered != test.expectedCovered || copay != test.expectedCopay {
+			t.Errorf("splitCoverage(%.0f%%, cap %.2f, %.2f, %.2f) = (%.2f, %.2f); want (%.2f, %.2f)",
+				test.rule.Percent, test.rule.Cap, test.price, test.excluded,
+				covered, copay, test.expectedCovered, test.expectedCopay)
+		}
+		if covered+copay != test.price {
+			t.Errorf("covered + copay = %.2f; want %.2f", covered+copay, test.price)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The program waits 30 seconds for a lock; the test does not need to
+const (
+	LOCK_WAIT  = 300 * time.Millisecond
+	LOCK_STALE = 2 * time.Minute
+	LOCK_RETRY = 20 * time.Millisecond
+)
+
+var ErrLocked = errors.New("locked by another terminal")
+
+type DataConflict struct {
+	Entity string
+	Key    string
+	Reason string
+	NewID  int
+	Saved  interface{}
+	Local  interface{}
+}
+
+// Copy of the lock and merge functions from locking.go for testing
+func acquireLock(path string) error {
+	lock := path + ".lock"
+	deadline := time.Now().Add(LOCK_WAIT)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "test pid %d since %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+			file.Close()
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > LOCK_STALE {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			holder, _ := os.ReadFile(lock)
+			return fmt.Errorf("%s is %w (%s)", path, ErrLocked, strings.TrimSpace(string(holder)))
+		}
+		time.Sleep(LOCK_RETRY)
+	}
+}
+
+func releaseLock(path string) {
+	os.Remove(path + ".lock")
+}
+
+func updatedAt(v interface{}) string {
+	switch e := v.(type) {
+	case Patient:
+		return e.UpdatedAt
+	case Record:
+		return e.UpdatedAt
+	}
+	return ""
+}
+
+func versionOf(v interface{}) int {
+	switch e := v.(type) {
+	case Patient:
+		return e.Version
+	case Package:
+		return e.Version
+	case Record:
+		return e.Version
+	}
+	return 0
+}
+
+func sameContent(a, b interface{}) bool {
+	return sameValue(unversioned(a), unversioned(b))
+}
+
+func unversioned(v interface{}) interface{} {
+	switch e := v.(type) {
+	case Patient:
+		e.Version, e.UpdatedAt = 0, ""
+		return e
+	case Package:
+		e.Version = 0
+		return e
+	case Record:
+		e.Version, e.UpdatedAt = 0, ""
+		return e
+	}
+	return v
+}
+
+func indexByKey[T any](list []T, key func(T) string) map[string]*T {
+	index := make(map[string]*T, len(list))
+	for i := range list {
+		index[key(list[i])] = &list[i]
+	}
+	return index
+}
+
+func sameItem[T any](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return sameContent(*a, *b)
+}
+
+func mergeItem[T any](base, ours, saved *T) (*T, string) {
+	switch {
+	case sameItem(ours, base):
+		return saved, ""
+	case sameItem(saved, base) || sameItem(ours, saved):
+		return ours, ""
+	case base == nil:
+		return saved, "added with the same ID on another terminal"
+	case ours == nil:
+		return saved, "changed on another terminal after you deleted it"
+	case saved == nil:
+		return nil, "deleted on another terminal while you changed it"
+	}
+	reason := "changed on another terminal at the same time"
+	if v := versionOf(*saved); v > 0 {
+		reason = fmt.Sprintf("changed on another terminal (now version %d)", v)
+		if b := versionOf(*base); b > 0 {
+			reason += fmt.Sprintf(" while you edited version %d", b)
+		}
+	}
+	return saved, reason
+}
+
+func conflictValue[T any](v *T) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func mergeList[T any](entity string, base, ours, saved []T, key func(T) string, conflicts *[]DataConflict) []T {
+	baseIndex, ourIndex := indexByKey(base, key), indexByKey(ours, key)
+	seen := make(map[string]bool)
+	var merged []T
+
+	settle := func(k string, s *T) {
+		seen[k] = true
+		result, reason := mergeItem(baseIndex[k], ourIndex[k], s)
+		if reason != "" {
+			*conflicts = append(*conflicts, DataConflict{Entity: entity, Key: k, Reason: reason,
+				Saved: conflictValue(s), Local: conflictValue(ourIndex[k])})
+		}
+		if result != nil {
+			merged = append(merged, *result)
+		}
+	}
+
+	for i := range saved {
+		settle(key(saved[i]), &saved[i])
+	}
+	for i := range base {
+		if k := key(base[i]); !seen[k] {
+			settle(k, nil)
+		}
+	}
+	for i := range ours {
+		if k := key(ours[i]); !seen[k] {
+			settle(k, nil)
+		}
+	}
+	return merged
+}
+
+func lockTestKey(p Patient) string { return fmt.Sprint(p.ID) }
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	if err := acquireLock(path); err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+	if err := acquireLock(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("Second acquireLock() error = %v, want ErrLocked", err)
+	}
+
+	releaseLock(path)
+	if err := acquireLock(path); err != nil {
+		t.Fatalf("acquireLock() after release error = %v", err)
+	}
+
+	// A lock left behind by a crashed terminal is taken over
+	old := time.Now().Add(-LOCK_STALE - time.Minute)
+	os.Chtimes(path+".lock", old, old)
+	if err := acquireLock(path); err != nil {
+		t.Errorf("acquireLock() over a stale lock error = %v", err)
+	}
+	releaseLock(path)
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("releaseLock() left the lock file behind")
+	}
+}
+
+func TestMergeItem(t *testing.T) {
+	base := &Patient{ID: 20001, Name: "Budi", Age: 40, Version: 1}
+	ours := &Patient{ID: 20001, Name: "Budi", Age: 41, Version: 1}
+	saved := &Patient{ID: 20001, Name: "Budi Santoso", Age: 40, Version: 2}
+
+	tests := []struct {
+		name       string
+		base       *Patient
+		ours       *Patient
+		saved      *Patient
+		want       *Patient
+		wantReason string
+	}{
+		{"only saved changed", base, base, saved, saved, ""},
+		{"only ours changed", base, ours, base, ours, ""},
+		{"both made the same change", base, ours, ours, ours, ""},
+		{"both changed", base, ours, saved, saved, "changed on another terminal (now version 2) while you edited version 1"},
+		{"we deleted, saved unchanged", base, nil, base, nil, ""},
+		{"we deleted, saved changed", base, nil, saved, saved, "changed on another terminal after you deleted it"},
+		{"saved deleted, we changed", base, ours, nil, nil, "deleted on another terminal while you changed it"},
+		{"new here", nil, ours, nil, ours, ""},
+		{"new on both with the same ID", nil, ours, saved, saved, "added with the same ID on another terminal"},
+	}
+
+	for _, tt := range tests {
+		got, reason := mergeItem(tt.base, tt.ours, tt.saved)
+		if !sameItem(got, tt.want) {
+			t.Errorf("%s: mergeItem() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if reason != tt.wantReason {
+			t.Errorf("%s: reason = %q, want %q", tt.name, reason, tt.wantReason)
+		}
+	}
+}
+
+func TestMergeList(t *testing.T) {
+	base := []Patient{
+		{ID: 20001, Name: "Budi", Version: 1},
+		{ID: 20002, Name: "Siti", Version: 1},
+		{ID: 20003, Name: "Ani", Version: 1},
+		{ID: 20004, Name: "Dewi", Version: 1},
+	}
+	// Here: 20001 renamed, 20002 edited, 20004 deleted, 20006 added
+	ours := []Patient{
+		{ID: 20001, Name: "Budi Santoso", Version: 1},
+		{ID: 20002, Name: "Siti A.", Version: 1},
+		{ID: 20003, Name: "Ani", Version: 1},
+		{ID: 20006, Name: "Eko", Version: 0},
+	}
+	// Elsewhere: 20002 edited, 20003 changed, 20005 added
+	saved := []Patient{
+		{ID: 20001, Name: "Budi", Version: 1},
+		{ID: 20002, Name: "Siti Aminah", Version: 2},
+		{ID: 20003, Name: "Ani Lestari", Version: 2},
+		{ID: 20004, Name: "Dewi", Version: 1},
+		{ID: 20005, Name: "Fajar", Version: 1},
+	}
+
+	var conflicts []DataConflict
+	merged := mergeList("patient", base, ours, saved, lockTestKey, &conflicts)
+
+	want := []string{"20001 Budi Santoso", "20002 Siti Aminah", "20003 Ani Lestari", "20005 Fajar", "20006 Eko"}
+	var got []string
+	for _, p := range merged {
+		got = append(got, fmt.Sprintf("%d %s", p.ID, p.Name))
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("merged = %v, want %v", got, want)
+	}
+
+	if len(conflicts) != 1 || conflicts[0].Key != "20002" {
+		t.Fatalf("conflicts = %+v, want only patient 20002", conflicts)
+	}
+	if local, ok := conflicts[0].Local.(Patient); !ok || local.Name != "Siti A." {
+		t.Errorf("conflict keeps local = %+v, want our version", conflicts[0].Local)
+	}
+}
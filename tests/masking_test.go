@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	PRIVACY_IDLE_TIMEOUT = 60 * time.Second
+
+	PRIVACY_ALWAYS      = "always"
+	PRIVACY_DEFAULT_ON  = "default_on"
+	PRIVACY_DEFAULT_OFF = "default_off"
+)
+
+var (
+	privacyMu    sync.Mutex
+	privacyMode  = PRIVACY_ALWAYS
+	maskingOn    = true
+	phiOnScreen  bool
+	lastActivity = time.Now()
+)
+
+// Copy of masking functions from masking.go for testing
+func maskName(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		runes := []rune(w)
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+func maskIdentifier(id string) string {
+	runes := []rune(id)
+	if len(runes) <= 2 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-2:])
+}
+
+func isIdle(last, now time.Time) bool {
+	return now.Sub(last) >= PRIVACY_IDLE_TIMEOUT
+}
+
+// Test name masking
+func TestMaskName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Budi Santoso", "B*** S******"},
+		{"  Siti   Aminah ", "S*** A*****"},
+		{"A", "A"},
+		{"Élodie", "É*****"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if result := maskName(test.name); result != test.expected {
+			t.Errorf("maskName(%q) = %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
+
+// Test identifier masking
+func TestMaskIdentifier(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{"10023", "***23"},
+		{"123", "*23"},
+		{"12", "**"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if result := maskIdentifier(test.id); result != test.expected {
+			t.Errorf("maskIdentifier(%q) = %q, expected %q", test.id, result, test.expected)
+		}
+	}
+}
+
+// checkIdle restores masking once the screen has been left alone for
+// PRIVACY_IDLE_TIMEOUT, and reports whether details shown on it should be
+// hidden. The prompt waiting for input does the hiding, so nothing is
+// written over it from elsewhere.
+func checkIdle(now time.Time) bool {
+	privacyMu.Lock()
+	defer privacyMu.Unlock()
+	if !isIdle(lastActivity, now) {
+		return false
+	}
+	if privacyMode != PRIVACY_DEFAULT_OFF {
+		maskingOn = true
+	}
+	hide := phiOnScreen
+	phiOnScreen = false
+	return hide
+}
+
+// Test the idle timeout boundary
+func TestIsIdle(t *testing.T) {
+	last := time.Date(2025, 6, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		elapsed  time.Duration
+		expected bool
+	}{
+		{0, false},
+		{59 * time.Second, false},
+		{60 * time.Second, true},
+		{5 * time.Minute, true},
+	}
+
+	for _, test := range tests {
+		if result := isIdle(last, last.Add(test.elapsed)); result != test.expected {
+			t.Errorf("isIdle after %s = %v, expected %v", test.elapsed, result, test.expected)
+		}
+	}
+}
+
+// Test that an idle screen is hidden once and masking comes back
+func TestCheckIdle(t *testing.T) {
+	start := time.Date(2025, 6, 15, 9, 0, 0, 0, time.UTC)
+	privacyMode, maskingOn, phiOnScreen, lastActivity = PRIVACY_DEFAULT_ON, false, true, start
+
+	if checkIdle(start.Add(30*time.Second)) || maskingOn {
+		t.Error("Screen hidden before the idle timeout")
+	}
+	if !checkIdle(start.Add(PRIVACY_IDLE_TIMEOUT)) {
+		t.Error("Details on an idle screen were not hidden")
+	}
+	if !maskingOn {
+		t.Error("Masking was not restored after the idle timeout")
+	}
+	if checkIdle(start.Add(2 * PRIVACY_IDLE_TIMEOUT)) {
+		t.Error("A screen already hidden was hidden again")
+	}
+
+	// Roles that default to unmasked keep their choice
+	privacyMode, maskingOn, phiOnScreen = PRIVACY_DEFAULT_OFF, false, true
+	checkIdle(start.Add(PRIVACY_IDLE_TIMEOUT))
+	if maskingOn {
+		t.Error("Masking was switched on for a role that defaults to off")
+	}
+}
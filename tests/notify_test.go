@@ -0,0 +1,145 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	EVENT_RECORD_CREATED = "record_created"
+	EVENT_STATUS_CHANGED = "status_changed"
+	EVENT_RESULTS_READY  = "results_ready"
+
+	NOTIFY_RETRY_BASE = 5 * time.Minute
+)
+
+var EVENTS = []string{EVENT_RECORD_CREATED, EVENT_STATUS_CHANGED, EVENT_RESULTS_READY}
+
+var TEMPLATE_FIELDS = []string{"{name}", "{record}", "{date}", "{package}", "{status}", "{clinic}", "{phone}"}
+
+type MessageTemplate struct {
+	Subject string
+	Body    string
+}
+
+// Copy of the default templates from notify.go for testing
+var defaultTemplates = map[string]map[string]MessageTemplate{
+	LANG_EN: {
+		EVENT_RECORD_CREATED: {
+			Subject: "Your check-up at {clinic} is booked",
+			Body: "Dear {name},\n\nYour {package} check-up is booked for {date} (record {record}).\n" +
+				"Please contact us if you need to change it.\n\n{clinic}\n{phone}",
+		},
+		EVENT_STATUS_CHANGED: {
+			Subject: "Update on your check-up at {clinic}",
+			Body:    "Dear {name},\n\nYour {package} check-up of {date} is now: {status}.\n\n{clinic}\n{phone}",
+		},
+		EVENT_RESULTS_READY: {
+			Subject: "Your check-up results are ready",
+			Body: "Dear {name},\n\nThe doctor has signed the results of your {package} check-up of {date}.\n" +
+				"Your result sheet can be collected at the clinic.\n\n{clinic}\n{phone}",
+		},
+	},
+	LANG_ID: {
+		EVENT_RECORD_CREATED: {
+			Subject: "Pemeriksaan Anda di {clinic} sudah terjadwal",
+			Body: "Yth. {name},\n\nPemeriksaan {package} Anda terjadwal pada {date} (rekam medis {record}).\n" +
+				"Silakan hubungi kami bila ingin mengubah jadwal.\n\n{clinic}\n{phone}",
+		},
+		EVENT_STATUS_CHANGED: {
+			Subject: "Kabar pemeriksaan Anda di {clinic}",
+			Body:    "Yth. {name},\n\nStatus pemeriksaan {package} Anda pada {date}: {status}.\n\n{clinic}\n{phone}",
+		},
+		EVENT_RESULTS_READY: {
+			Subject: "Hasil pemeriksaan Anda sudah siap",
+			Body: "Yth. {name},\n\nDokter sudah menandatangani hasil pemeriksaan {package} Anda pada {date}.\n" +
+				"Lembar hasil dapat diambil di klinik.\n\n{clinic}\n{phone}",
+		},
+	},
+}
+
+// Copy of template and retry functions from notify.go for testing
+func renderTemplate(text string, fields map[string]string) string {
+	var pairs []string
+	for key, value := range fields {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	return strings.TrimRight(strings.NewReplacer(pairs...).Replace(text), "\n ")
+}
+
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return NOTIFY_RETRY_BASE << uint(attempts-1)
+}
+
+func TestNotifyTemplates(t *testing.T) {
+	known := make(map[string]bool)
+	for _, field := range TEMPLATE_FIELDS {
+		known[field] = true
+	}
+	placeholder := regexp.MustCompile(`\{[a-z]+\}`)
+
+	for _, lang := range []string{LANG_EN, LANG_ID} {
+		for _, event := range EVENTS {
+			tpl, ok := defaultTemplates[lang][event]
+			if !ok || tpl.Subject == "" || tpl.Body == "" {
+				t.Errorf("%s template for %s is missing", lang, event)
+				continue
+			}
+			for _, p := range placeholder.FindAllString(tpl.Subject+tpl.Body, -1) {
+				if !known[p] {
+					t.Errorf("%s template for %s uses unknown placeholder %s", lang, event, p)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tpl := defaultTemplates[LANG_EN][EVENT_RECORD_CREATED]
+	fields := map[string]string{
+		"name": "Budi Santoso", "record": "30005", "date": "19/10/2026", "package": "Basic",
+		"status": "booked", "clinic": "Klinik Sehat", "phone": "",
+	}
+
+	body := renderTemplate(tpl.Body, fields)
+	if !strings.Contains(body, "Dear Budi Santoso,") || !strings.Contains(body, "booked for 19/10/2026 (record 30005)") {
+		t.Errorf("placeholders not filled in:\n%s", body)
+	}
+	if strings.Contains(body, "{") {
+		t.Errorf("placeholder left in message:\n%s", body)
+	}
+	if !strings.HasSuffix(body, "Klinik Sehat") {
+		t.Errorf("empty phone line should be dropped, got %q", body[len(body)-20:])
+	}
+
+	fields["phone"] = "021-555-0100"
+	if body := renderTemplate(tpl.Body, fields); !strings.HasSuffix(body, "Klinik Sehat\n021-555-0100") {
+		t.Errorf("phone line missing:\n%s", body)
+	}
+	if s := renderTemplate("Hello {nobody}", fields); s != "Hello {nobody}" {
+		t.Errorf("unknown placeholder should be left alone, got %q", s)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{4, 40 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
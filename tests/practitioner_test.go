@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var WEEKDAYS = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+type Practitioner struct {
+	ID        int
+	Name      string
+	Specialty string
+	LicenseNo string
+	Username  string
+	Station   string
+	Days      []string
+	Hours     string
+}
+
+// Copy of schedule functions from practitioner.go for testing
+// weekdayOf returns the schedule day (Mon-Sun) of a DD/MM/YYYY date
+func weekdayOf(date string) string {
+	t, err := time.Parse("02/01/2006", date)
+	if err != nil {
+		return ""
+	}
+	return WEEKDAYS[(int(t.Weekday())+6)%7]
+}
+
+// isScheduled reports whether a practitioner works on the given date. A
+// practitioner without working days is available every day.
+func isScheduled(p Practitioner, date string) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	day := weekdayOf(date)
+	for _, d := range p.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDays reads working days such as "Mon,Wed,Fri" or "Mon-Fri"
+func parseDays(input string) ([]string, error) {
+	dayIndex := func(s string) int {
+		for i, d := range WEEKDAYS {
+			if strings.EqualFold(d, strings.TrimSpace(s)) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	selected := make([]bool, len(WEEKDAYS))
+	for _, part := range strings.Split(input, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		from := dayIndex(bounds[0])
+		to := from
+		if len(bounds) == 2 {
+			to = dayIndex(bounds[1])
+		}
+		if from == -1 || to == -1 || to < from {
+			return nil, fmt.Errorf("%q is not a day or range of days (Mon-Sun)", strings.TrimSpace(part))
+		}
+		for i := from; i <= to; i++ {
+			selected[i] = true
+		}
+	}
+
+	var days []string
+	for i, ok := range selected {
+		if ok {
+			days = append(days, WEEKDAYS[i])
+		}
+	}
+	return days, nil
+}
+
+// daysText writes working days compactly, with runs of three or more days
+// as ranges: "Mon-Fri,Sun"
+func daysText(days []string) string {
+	selected := make([]bool, len(WEEKDAYS)+1)
+	for _, d := range days {
+		for i, w := range WEEKDAYS {
+			if d == w {
+				selected[i] = true
+			}
+		}
+	}
+
+	var parts []string
+	for i := 0; i < len(WEEKDAYS); i++ {
+		if !selected[i] {
+			continue
+		}
+		j := i
+		for selected[j+1] {
+			j++
+		}
+		switch {
+		case j-i >= 2:
+			parts = append(parts, WEEKDAYS[i]+"-"+WEEKDAYS[j])
+		case j > i:
+			parts = append(parts, WEEKDAYS[i], WEEKDAYS[j])
+		default:
+			parts = append(parts, WEEKDAYS[i])
+		}
+		i = j
+	}
+	return strings.Join(parts, ",")
+}
+
+// Test weekdays and schedules
+func TestSchedule(t *testing.T) {
+	tests := []struct {
+		date     string
+		expected string
+	}{
+		{"05/02/2025", "Wed"},
+		{"09/02/2025", "Sun"},
+		{"10/02/2025", "Mon"},
+		{"31/02/2025", ""},
+	}
+
+	for _, test := range tests {
+		if result := weekdayOf(test.date); result != test.expected {
+			t.Errorf("weekdayOf(%q) = %q, expected %q", test.date, result, test.expected)
+		}
+	}
+
+	weekdays := Practitioner{Days: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}}
+	if !isScheduled(weekdays, "05/02/2025") || isScheduled(weekdays, "09/02/2025") {
+		t.Error("Expected a Mon-Fri practitioner to work on Wednesday but not on Sunday")
+	}
+	if !isScheduled(Practitioner{}, "09/02/2025") {
+		t.Error("Expected a practitioner without working days to be available every day")
+	}
+}
+
+// Test reading and writing working days
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		text     string
+	}{
+		{"Mon-Fri", "Mon Tue Wed Thu Fri", "Mon-Fri"},
+		{"mon, wed ,FRI", "Mon Wed Fri", "Mon,Wed,Fri"},
+		{"Sat-Sun,Mon", "Mon Sat Sun", "Mon,Sat,Sun"},
+		{"Mon-Wed,Fri-Sun", "Mon Tue Wed Fri Sat Sun", "Mon-Wed,Fri-Sun"},
+	}
+
+	for _, test := range tests {
+		days, err := parseDays(test.input)
+		if err != nil {
+			t.Errorf("parseDays(%q) failed: %v", test.input, err)
+			continue
+		}
+		if result := strings.Join(days, " "); result != test.expected {
+			t.Errorf("parseDays(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+		if result := daysText(days); result != test.text {
+			t.Errorf("daysText(%v) = %q, expected %q", days, result, test.text)
+		}
+	}
+
+	for _, bad := range []string{"Fri-Mon", "Monday", "Mon-"} {
+		if _, err := parseDays(bad); err == nil {
+			t.Errorf("Expected parseDays(%q) to fail", bad)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Copy of price history functions from pricing.go for testing
+const PRICE_HISTORY_START_DATE = "01/01/1900"
+
+type PriceVersion struct {
+	Version       int     `json:"version"`
+	EffectiveDate string  `json:"effective_date"`
+	Price         float64 `json:"price"`
+}
+
+func dateKey(date string) string {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return date
+	}
+	return parts[2] + parts[1] + parts[0]
+}
+
+func compareDates(a, b string) int {
+	keyA, keyB := dateKey(a), dateKey(b)
+	if keyA < keyB {
+		return -1
+	} else if keyA > keyB {
+		return 1
+	}
+	return 0
+}
+
+func ensurePriceHistory(pkg *Package) {
+	if len(pkg.PriceHistory) == 0 {
+		pkg.PriceHistory = []PriceVersion{
+			{Version: 1, EffectiveDate: PRICE_HISTORY_START_DATE, Price: pkg.Price},
+		}
+	}
+}
+
+func getNextPriceVersion(pkg Package) int {
+	maxVersion := 0
+	for _, v := range pkg.PriceHistory {
+		if v.Version > maxVersion {
+			maxVersion = v.Version
+		}
+	}
+	return maxVersion + 1
+}
+
+func addPriceVersion(pkg *Package, effectiveDate string, price float64) PriceVersion {
+	ensurePriceHistory(pkg)
+
+	for i := range pkg.PriceHistory {
+		if compareDates(pkg.PriceHistory[i].EffectiveDate, effectiveDate) == 0 {
+			pkg.PriceHistory[i].Price = price
+			return pkg.PriceHistory[i]
+		}
+	}
+
+	version := PriceVersion{
+		Version:       getNextPriceVersion(*pkg),
+		EffectiveDate: effectiveDate,
+		Price:         price,
+	}
+	pkg.PriceHistory = append(pkg.PriceHistory, version)
+
+	for i := 1; i < len(pkg.PriceHistory); i++ {
+		key := pkg.PriceHistory[i]
+		j := i - 1
+		for j >= 0 && compareDates(pkg.PriceHistory[j].EffectiveDate, key.EffectiveDate) > 0 {
+			pkg.PriceHistory[j+1] = pkg.PriceHistory[j]
+			j--
+		}
+		pkg.PriceHistory[j+1] = key
+	}
+
+	return version
+}
+
+func getPriceAtDate(pkg Package, date string) (PriceVersion, bool) {
+	found := false
+	var result PriceVersion
+	for _, v := range pkg.PriceHistory {
+		if compareDates(v.EffectiveDate, date) <= 0 {
+			result = v
+			found = true
+		}
+	}
+
+	if !found && len(pkg.PriceHistory) == 0 {
+		return PriceVersion{Version: 0, EffectiveDate: PRICE_HISTORY_START_DATE, Price: pkg.Price}, true
+	}
+	return result, found
+}
+
+// Test price history functions
+func TestCompareDates(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"15/06/2023", "15/06/2023", 0},
+		{"31/12/2022", "01/01/2023", -1}, // Year matters more than day
+		{"01/02/2023", "31/01/2023", 1},  // Month matters more than day
+		{"10/06/2023", "20/06/2023", -1},
+	}
+
+	for _, test := range tests {
+		result := compareDates(test.a, test.b)
+		if result != test.expected {
+			t.Errorf("compareDates(%s, %s) = %d; want %d", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestGetPriceAtDate(t *testing.T) {
+	pkg := Package{ID: 10001, Name: "Basic Health Check", Category: "Basic", Price: 100.0}
+	ensurePriceHistory(&pkg)
+	addPriceVersion(&pkg, "01/07/2023", 150.0)
+	addPriceVersion(&pkg, "01/01/2023", 120.0) // Added out of order
+
+	tests := []struct {
+		date          string
+		expectedPrice float64
+		expectedVer   int
+	}{
+		{"15/06/2022", 100.0, 1},
+		{"01/01/2023", 120.0, 3}, // Effective on the day itself
+		{"30/06/2023", 120.0, 3},
+		{"01/07/2023", 150.0, 2},
+		{"15/03/2030", 150.0, 2},
+	}
+
+	for _, test := range tests {
+		v, ok := getPriceAtDate(pkg, test.date)
+		if !ok || v.Price != test.expectedPrice || v.Version != test.expectedVer {
+			t.Errorf("getPriceAtDate(%s) = %.2f (v%d, %v); want %.2f (v%d)",
+				test.date, v.Price, v.Version, ok, test.expectedPrice, test.expectedVer)
+		}
+	}
+
+	for i := 1; i < len(pkg.PriceHistory); i++ {
+		if compareDates(pkg.PriceHistory[i-1].EffectiveDate, pkg.PriceHistory[i].EffectiveDate) > 0 {
+			t.Errorf("Price history not ordered by effective date at index %d", i)
+		}
+	}
+}
+
+func TestAddPriceVersionSameDateReplaces(t *testing.T) {
+	pkg := Package{ID: 10001, Price: 100.0}
+	first := addPriceVersion(&pkg, "01/07/2023", 150.0)
+	second := addPriceVersion(&pkg, "01/07/2023", 175.0)
+
+	if len(pkg.PriceHistory) != 2 {
+		t.Fatalf("Price history length = %d; want 2", len(pkg.PriceHistory))
+	}
+
+	v, _ := getPriceAtDate(pkg, "02/07/2023")
+	if v.Price != 175.0 {
+		t.Errorf("Price after same-day correction = %.2f; want 175.00", v.Price)
+	}
+	if second.Version != first.Version || v.Version != first.Version {
+		t.Errorf("Same-day correction version = %d; want %d kept", second.Version, first.Version)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Copy of retention functions from privacy.go for testing
+func retentionCutoff(today string, years int) string {
+	t, err := time.Parse("02/01/2006", today)
+	if err != nil {
+		return today
+	}
+	return t.AddDate(-years, 0, 0).Format("02/01/2006")
+}
+
+const (
+	ENTITY_PATIENT = "patient"
+	ENTITY_RECORD  = "record"
+	ERASED_NAME    = "[erased]"
+)
+
+// Copy of erasure functions from privacy.go for testing
+
+// Erasure is what a patient erasure leaves in the data file. Every merge
+// applies it again, so a copy of the patient that another terminal or branch
+// changed in the meantime does not bring the patient back.
+type Erasure struct {
+	PatientID      int    `json:"patient_id"`
+	Records        []int  `json:"records,omitempty"`
+	RecordsDeleted bool   `json:"records_deleted,omitempty"`
+	ErasedAt       string `json:"erased_at"`
+}
+
+// maxErasedID is the highest patient or record ID erased. New items stay
+// above it, since the erasure would remove them.
+func maxErasedID(list []Erasure, entity string) int {
+	maxID := 0
+	for _, e := range list {
+		switch entity {
+		case ENTITY_PATIENT:
+			maxID = max(maxID, e.PatientID)
+		case ENTITY_RECORD:
+			for _, id := range e.Records {
+				maxID = max(maxID, id)
+			}
+		}
+	}
+	return maxID
+}
+
+// isErasedItem reports whether a patient or record was erased
+func isErasedItem(list []Erasure, entity, key string) bool {
+	id, _ := strconv.Atoi(key)
+	for _, e := range list {
+		if entity == ENTITY_PATIENT && id == e.PatientID {
+			return true
+		}
+		if entity == ENTITY_RECORD {
+			for _, rid := range e.Records {
+				if rid == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// eraseRecord removes everything about the patient from a record that is kept
+// for the accounts
+func eraseRecord(r *Record) {
+	r.Patient = Patient{Name: ERASED_NAME}
+	r.Results = nil
+	r.ClaimNote = ""
+	setConclusion(r, ConclusionDraft{})
+}
+
+// erasedRecordIDs lists the records of erased patients: those recorded with
+// the erasure and any of the given ones made for the patient elsewhere
+func erasedRecordIDs(list []Erasure, lists ...[]Record) map[int]bool {
+	erased := make(map[int]bool)
+	patientIDs := make(map[int]bool)
+	for _, e := range list {
+		patientIDs[e.PatientID] = true
+		for _, id := range e.Records {
+			erased[id] = true
+		}
+	}
+	for _, recs := range lists {
+		for _, r := range recs {
+			if patientIDs[r.Patient.ID] {
+				erased[r.ID] = true
+			}
+		}
+	}
+	return erased
+}
+
+// isErasedSyncConflict reports whether a sync conflict is about an erased
+// patient or one of the erased records
+func isErasedSyncConflict(list []Erasure, erasedRecords map[int]bool, c SyncConflict) bool {
+	return (c.Entity == ENTITY_RECORD && erasedRecords[c.ItemID]) || isErasedItem(list, c.Entity, strconv.Itoa(c.ItemID))
+}
+
+// scrubErasedSync takes erased patients out of the central store settings:
+// the copy of the last sync and the sync conflicts waiting to be resolved.
+// It returns the number of conflicts removed.
+func scrubErasedSync(s *BranchSyncSettings, list []Erasure) int {
+	if len(list) == 0 {
+		return 0
+	}
+	var baseRecords []Record
+	if s.Base != nil {
+		baseRecords = s.Base.Records
+	}
+	erasedRecords := erasedRecordIDs(list, baseRecords)
+
+	if s.Base != nil {
+		base := *s.Base
+		base.Patients = nil
+		for _, p := range s.Base.Patients {
+			if !isErasedItem(list, ENTITY_PATIENT, strconv.Itoa(p.ID)) {
+				base.Patients = append(base.Patients, p)
+			}
+		}
+		base.Records = nil
+		for _, r := range s.Base.Records {
+			if erasedRecords[r.ID] {
+				if recordsDeletedOnErasure(list, r) {
+					continue
+				}
+				eraseRecord(&r)
+			}
+			base.Records = append(base.Records, r)
+		}
+		s.Base = &base
+	}
+
+	var kept []SyncConflict
+	for _, c := range s.Conflicts {
+		if !isErasedSyncConflict(list, erasedRecords, c) {
+			kept = append(kept, c)
+		}
+	}
+	removed := len(s.Conflicts) - len(kept)
+	s.Conflicts = kept
+	return removed
+}
+
+// recordsDeletedOnErasure reports whether the erasure that covers a record
+// deleted the patient's records rather than keeping them for the accounts
+func recordsDeletedOnErasure(list []Erasure, r Record) bool {
+	for _, e := range list {
+		if r.Patient.ID == e.PatientID {
+			return e.RecordsDeleted
+		}
+		for _, id := range e.Records {
+			if id == r.ID {
+				return e.RecordsDeleted
+			}
+		}
+	}
+	return false
+}
+
+func setConclusion(r *Record, c ConclusionDraft) {
+	r.Conclusion = c.Text
+	r.Fitness = c.Fitness
+	r.Findings = c.Findings
+	r.Recommendations = c.Recommendations
+	r.FollowUpMonths = c.FollowUpMonths
+}
+
+type FHIRBundleEntry struct {
+	FullURL  string          `json:"fullUrl,omitempty"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+// fhirEntryMentions reports whether a bundle entry is the patient, one of
+// the records, or refers to either
+func fhirEntryMentions(entry FHIRBundleEntry, patientID int, ids map[int]bool) bool {
+	refs := []string{entry.FullURL}
+	var res struct {
+		Subject   *FHIRReference `json:"subject"`
+		Encounter *FHIRReference `json:"encounter"`
+	}
+	if json.Unmarshal(entry.Resource, &res) == nil {
+		if res.Subject != nil {
+			refs = append(refs, res.Subject.Reference)
+		}
+		if res.Encounter != nil {
+			refs = append(refs, res.Encounter.Reference)
+		}
+	}
+	for _, ref := range refs {
+		if ref == fmt.Sprintf("Patient/patient-%d", patientID) {
+			return true
+		}
+		if rid, ok := strings.CutPrefix(ref, "Encounter/record-"); ok {
+			if id, err := strconv.Atoi(rid); err == nil && ids[id] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Test the retention cutoff date
+func TestRetentionCutoff(t *testing.T) {
+	tests := []struct {
+		today    string
+		years    int
+		expected string
+	}{
+		{"15/06/2025", 5, "15/06/2020"},
+		{"01/01/2025", 1, "01/01/2024"},
+		{"29/02/2024", 1, "01/03/2023"},
+		{"15/06/2025", 0, "15/06/2025"},
+	}
+
+	for _, test := range tests {
+		if result := retentionCutoff(test.today, test.years); result != test.expected {
+			t.Errorf("retentionCutoff(%s, %d) = %s, expected %s", test.today, test.years, result, test.expected)
+		}
+	}
+}
+
+// Test that erased patients and their records are recognised, so that the
+// erasure wins over changes made to them elsewhere
+func TestErasures(t *testing.T) {
+	list := []Erasure{
+		{PatientID: 3, Records: []int{10, 12}, ErasedAt: "2025-06-15T10:00:00Z"},
+		{PatientID: 8, ErasedAt: "2025-06-16T10:00:00Z"},
+	}
+
+	tests := []struct {
+		entity   string
+		key      string
+		expected bool
+	}{
+		{ENTITY_PATIENT, "3", true},
+		{ENTITY_PATIENT, "8", true},
+		{ENTITY_PATIENT, "10", false},
+		{ENTITY_RECORD, "12", true},
+		{ENTITY_RECORD, "3", false},
+		{"package", "3", false},
+	}
+	for _, test := range tests {
+		if result := isErasedItem(list, test.entity, test.key); result != test.expected {
+			t.Errorf("isErasedItem(%s, %s) = %v, expected %v", test.entity, test.key, result, test.expected)
+		}
+	}
+
+	// New IDs stay above the erased ones
+	if id := maxErasedID(list, ENTITY_PATIENT); id != 8 {
+		t.Errorf("maxErasedID(patient) = %d, expected 8", id)
+	}
+	if id := maxErasedID(list, ENTITY_RECORD); id != 12 {
+		t.Errorf("maxErasedID(record) = %d, expected 12", id)
+	}
+}
+
+// Test which entries of an exported FHIR bundle an erasure removes
+func TestFHIREntryMentions(t *testing.T) {
+	ids := map[int]bool{10: true}
+	resource := func(v interface{}) json.RawMessage {
+		content, _ := json.MarshalIndent(v, "", "  ")
+		return content
+	}
+
+	tests := []struct {
+		entry    FHIRBundleEntry
+		expected bool
+	}{
+		{FHIRBundleEntry{FullURL: "Patient/patient-3"}, true},
+		{FHIRBundleEntry{FullURL: "Patient/patient-30"}, false},
+		{FHIRBundleEntry{FullURL: "Encounter/record-10"}, true},
+		{FHIRBundleEntry{FullURL: "Encounter/record-11"}, false},
+		{FHIRBundleEntry{FullURL: "Observation/record-10-1",
+			Resource: resource(map[string]interface{}{"encounter": fhirReference("Encounter", "record-10")})}, true},
+		{FHIRBundleEntry{FullURL: "Observation/record-11-1",
+			Resource: resource(map[string]interface{}{"subject": fhirReference("Patient", "patient-3")})}, true},
+		{FHIRBundleEntry{FullURL: "Observation/record-11-2",
+			Resource: resource(map[string]interface{}{"subject": fhirReference("Patient", "patient-4")})}, false},
+		{FHIRBundleEntry{FullURL: "PlanDefinition/package-10"}, false},
+	}
+	for _, test := range tests {
+		if result := fhirEntryMentions(test.entry, 3, ids); result != test.expected {
+			t.Errorf("fhirEntryMentions(%s) = %v, expected %v", test.entry.FullURL, result, test.expected)
+		}
+	}
+}
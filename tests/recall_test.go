@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type OutgoingMessage struct {
+	Kind      string
+	PatientID int
+	Name      string
+	To        string
+	Subject   string
+	Body      string
+}
+
+// SMTP_TIMEOUT is a constant in recall.go; tests shorten it
+var SMTP_TIMEOUT = 30 * time.Second
+
+// Copy of the notifiers from recall.go for testing
+type smtpNotifier struct {
+	Host     string
+	Port     int
+	From     string
+	Username string
+	Password string
+}
+
+func (n smtpNotifier) Send(msg OutgoingMessage) error {
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	conn, err := net.DialTimeout("tcp", addr, SMTP_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return err
+		}
+	}
+	if n.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("the SMTP server does not accept a login")
+		}
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildEmail(n.From, msg, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func buildEmail(from string, msg OutgoingMessage, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	for _, line := range strings.Split(msg.Body, "\n") {
+		b.WriteString(line + "\r\n")
+	}
+	return b.Bytes()
+}
+
+type outboxNotifier struct {
+	Dir string
+}
+
+func (n outboxNotifier) Send(msg OutgoingMessage) error {
+	if err := os.MkdirAll(n.Dir, 0755); err != nil {
+		return err
+	}
+	to := msg.To
+	if to == "" {
+		to = msg.Name
+	}
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", to, msg.Subject, msg.Body)
+	kind := msg.Kind
+	if kind == "" {
+		kind = "message"
+	}
+	filename := filepath.Join(n.Dir, fmt.Sprintf("%s-%d-%s.txt", kind, msg.PatientID, time.Now().Format("20060102150405")))
+	return os.WriteFile(filename, []byte(content), 0600)
+}
+
+func isValidEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at > 0 && strings.Contains(email[at+1:], ".") && !strings.ContainsAny(email, " \t,;<>")
+}
+
+func isValidPhone(phone string) bool {
+	digits := 0
+	for i, c := range phone {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '+' && i == 0:
+		case c == ' ' || c == '-':
+		default:
+			return false
+		}
+	}
+	return digits >= 8 && digits <= 15
+}
+
+// fakeSMTP accepts one message and hands over what the client sent
+func fakeSMTP(t *testing.T) (int, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	lines := make(chan []string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+
+		var got []string
+		inData := false
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			got = append(got, line)
+			if inData {
+				if line == "." {
+					inData = false
+					reply("250 queued")
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(line); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250-fake")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(cmd, "DATA"):
+				inData = true
+				reply("354 go ahead")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				lines <- got
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		lines <- got
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, lines
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	port, lines := fakeSMTP(t)
+	n := smtpNotifier{Host: "127.0.0.1", Port: port, From: "clinic@example.com"}
+	msg := OutgoingMessage{
+		PatientID: 20001,
+		To:        "budi@example.com",
+		Subject:   "Your annual check-up is due",
+		Body:      "Dear Budi,\n\n.Your check-up is due on 04/03/2026.",
+	}
+
+	if err := n.Send(msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	var got []string
+	select {
+	case got = <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake SMTP server got no message")
+	}
+	session := strings.Join(got, "\n")
+
+	for _, want := range []string{
+		"MAIL FROM:<clinic@example.com>",
+		"RCPT TO:<budi@example.com>",
+		"Subject: Your annual check-up is due",
+		"Content-Type: text/plain; charset=UTF-8",
+		"..Your check-up is due on 04/03/2026.",
+	} {
+		if !strings.Contains(session, want) {
+			t.Errorf("SMTP session is missing %q:\n%s", want, session)
+		}
+	}
+}
+
+func TestSMTPNotifierRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	n := smtpNotifier{Host: "127.0.0.1", Port: port, From: "clinic@example.com"}
+	if err := n.Send(OutgoingMessage{To: "budi@example.com", Subject: "x", Body: "y"}); err == nil {
+		t.Error("Send() to a closed port should fail")
+	}
+}
+
+func TestSMTPNotifierTimeout(t *testing.T) {
+	defer func(d time.Duration) { SMTP_TIMEOUT = d }(SMTP_TIMEOUT)
+	SMTP_TIMEOUT = 200 * time.Millisecond
+
+	// The server takes the connection but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	n := smtpNotifier{Host: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, From: "clinic@example.com"}
+	start := time.Now()
+	if err := n.Send(OutgoingMessage{To: "budi@example.com", Subject: "x", Body: "y"}); err == nil {
+		t.Error("Send() to a silent server should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send() gave up after %v, want about %v", elapsed, SMTP_TIMEOUT)
+	}
+}
+
+func TestOutboxNotifier(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	n := outboxNotifier{Dir: dir}
+
+	if err := n.Send(OutgoingMessage{Kind: "reminder", PatientID: 20002, Name: "Siti Aminah", Subject: "Reminder", Body: "Please book."}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "reminder-20002-*.txt"))
+	if len(files) != 1 {
+		t.Fatalf("got %d outbox files, want 1", len(files))
+	}
+	content, _ := os.ReadFile(files[0])
+	if !strings.HasPrefix(string(content), "To: Siti Aminah\nSubject: Reminder\n\nPlease book.") {
+		t.Errorf("outbox file = %q", content)
+	}
+}
+
+func TestIsValidContact(t *testing.T) {
+	emails := []struct {
+		email string
+		want  bool
+	}{
+		{"budi@example.com", true},
+		{"siti.aminah@klinik.co.id", true},
+		{"budi@localhost", false},
+		{"@example.com", false},
+		{"budi example@example.com", false},
+		{"a@b.com; c@d.com", false},
+	}
+	for _, tt := range emails {
+		if got := isValidEmail(tt.email); got != tt.want {
+			t.Errorf("isValidEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+
+	phones := []struct {
+		phone string
+		want  bool
+	}{
+		{"081234567890", true},
+		{"+62 812-3456-7890", true},
+		{"0812 345", false},
+		{"62+812345678", false},
+		{"0812-3456-789a", false},
+		{"1234567890123456", false},
+	}
+	for _, tt := range phones {
+		if got := isValidPhone(tt.phone); got != tt.want {
+			t.Errorf("isValidPhone(%q) = %v, want %v", tt.phone, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// Copy of report functions from report.go and pdf.go for testing
+func textWidth(text string, size float64) float64 {
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+func pdfString(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r <= 126:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func fitText(text string, size, width float64) string {
+	if textWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+func percent(part, total float64) string {
+	if total == 0 {
+		return "0.0"
+	}
+	return fmt.Sprintf("%.1f", part*100/total)
+}
+
+func inPeriod(date, period string) bool {
+	return period == "" || strings.HasSuffix(date, "/"+period)
+}
+
+// Test PDF string encoding
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Budi Santoso", "(Budi Santoso)"},
+		{"Check (Basic)", "(Check \\(Basic\\))"},
+		{"C:\\data", "(C:\\\\data)"},
+		{"Jörg", "(J\\366rg)"},
+		{"日本", "(??)"},
+		{"", "()"},
+	}
+
+	for _, test := range tests {
+		result := pdfString(test.text)
+		if result != test.expected {
+			t.Errorf("pdfString(%q) = %q, expected %q", test.text, result, test.expected)
+		}
+	}
+}
+
+// Test text width and truncation
+func TestFitText(t *testing.T) {
+	if w := textWidth("Hi", 10); w < 9.43 || w > 9.45 {
+		t.Errorf("textWidth(\"Hi\", 10) = %v, expected 9.44", w)
+	}
+
+	if result := fitText("Short", 9, 200); result != "Short" {
+		t.Errorf("Expected text that fits to be unchanged, got %q", result)
+	}
+
+	long := "A very long package name that will not fit"
+	result := fitText(long, 9, 60)
+	if !strings.HasSuffix(result, "...") {
+		t.Errorf("Expected truncated text to end with '...', got %q", result)
+	}
+	if textWidth(result, 9) > 60 {
+		t.Errorf("Truncated text %q is wider than 60", result)
+	}
+}
+
+// Test report period filter and percentages
+func TestInPeriod(t *testing.T) {
+	tests := []struct {
+		date     string
+		period   string
+		expected bool
+	}{
+		{"15/03/2025", "03/2025", true},
+		{"15/03/2025", "04/2025", false},
+		{"15/03/2024", "03/2025", false},
+		{"15/03/2025", "", true},
+	}
+
+	for _, test := range tests {
+		result := inPeriod(test.date, test.period)
+		if result != test.expected {
+			t.Errorf("inPeriod(%q, %q) = %v, expected %v", test.date, test.period, result, test.expected)
+		}
+	}
+
+	if p := percent(1, 3); p != "33.3" {
+		t.Errorf("percent(1, 3) = %s, expected 33.3", p)
+	}
+	if p := percent(5, 0); p != "0.0" {
+		t.Errorf("percent(5, 0) = %s, expected 0.0", p)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Copy of de-identification functions from research.go for testing
+const DATE_SHIFT_MAX_DAYS = 180
+
+type ResearchPatient struct {
+	ID      string `json:"id"`
+	AgeBand string `json:"age_band"`
+	Gender  string `json:"gender"`
+}
+
+func keyedHash(key, message string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func pseudonym(key, prefix string, id int) string {
+	sum := keyedHash(key, fmt.Sprintf("%s:%d", prefix, id))
+	return prefix + "-" + hex.EncodeToString(sum[:8])
+}
+
+func dateShiftDays(key string, patientID int) int {
+	sum := keyedHash(key, fmt.Sprintf("shift:%d", patientID))
+	n := int(binary.BigEndian.Uint32(sum[:4]) % uint32(2*DATE_SHIFT_MAX_DAYS+1))
+	return n - DATE_SHIFT_MAX_DAYS
+}
+
+func suppressSmallGroups(list []ResearchPatient, k int) ([]ResearchPatient, map[string]bool) {
+	groupSize := make(map[string]int)
+	for _, p := range list {
+		groupSize[p.AgeBand+"|"+p.Gender]++
+	}
+
+	var kept []ResearchPatient
+	suppressed := make(map[string]bool)
+	for _, p := range list {
+		if groupSize[p.AgeBand+"|"+p.Gender] < k {
+			suppressed[p.ID] = true
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	return kept, suppressed
+}
+
+// Test keyed pseudonyms
+// csvCell keeps a spreadsheet from running a cell as a formula: text that
+// starts with =, +, -, @, a tab or a carriage return gets a leading quote.
+// Numbers are left as they are.
+func csvCell(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+func TestPseudonym(t *testing.T) {
+	a := pseudonym("key-one", "P", 10001)
+	if a != pseudonym("key-one", "P", 10001) {
+		t.Error("Pseudonym is not stable for the same key")
+	}
+	if a == pseudonym("key-two", "P", 10001) {
+		t.Error("Pseudonym does not depend on the key")
+	}
+	if a == pseudonym("key-one", "P", 10002) {
+		t.Error("Different patients share a pseudonym")
+	}
+	if a == pseudonym("key-one", "R", 10001) {
+		t.Error("Patient and record with the same ID share a pseudonym")
+	}
+	if a == "P-10001" || len(a) != 18 {
+		t.Errorf("Unexpected pseudonym format: %s", a)
+	}
+}
+
+// Test per-patient date shifts
+func TestDateShiftDays(t *testing.T) {
+	distinct := make(map[int]bool)
+	for id := 10001; id <= 10050; id++ {
+		shift := dateShiftDays("key-one", id)
+		if shift < -DATE_SHIFT_MAX_DAYS || shift > DATE_SHIFT_MAX_DAYS {
+			t.Errorf("Shift %d for patient %d out of range", shift, id)
+		}
+		if shift != dateShiftDays("key-one", id) {
+			t.Errorf("Shift for patient %d is not stable", id)
+		}
+		distinct[shift] = true
+	}
+	if len(distinct) < 10 {
+		t.Errorf("Shifts are not spread out: only %d distinct values", len(distinct))
+	}
+}
+
+// Test k-anonymity suppression
+func TestSuppressSmallGroups(t *testing.T) {
+	list := []ResearchPatient{
+		{"P-1", "30-39", "M"}, {"P-2", "30-39", "M"}, {"P-3", "30-39", "M"},
+		{"P-4", "30-39", "F"}, {"P-5", "30-39", "F"},
+		{"P-6", "60+", "F"},
+	}
+
+	kept, suppressed := suppressSmallGroups(list, 3)
+	if len(kept) != 3 || len(suppressed) != 3 {
+		t.Errorf("k=3: expected 3 kept and 3 suppressed, got %d and %d", len(kept), len(suppressed))
+	}
+	for _, id := range []string{"P-4", "P-5", "P-6"} {
+		if !suppressed[id] {
+			t.Errorf("k=3: %s should be suppressed", id)
+		}
+	}
+
+	kept, suppressed = suppressSmallGroups(list, 2)
+	if len(kept) != 5 || !suppressed["P-6"] {
+		t.Errorf("k=2: expected only P-6 suppressed, kept %d", len(kept))
+	}
+
+	if kept, _ := suppressSmallGroups(list, 10); len(kept) != 0 {
+		t.Errorf("k=10: expected everyone suppressed, kept %d", len(kept))
+	}
+}
+
+// Test that exported cells a spreadsheet would run as formulas are quoted
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"Budi", "Budi"},
+		{"", ""},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+cmd|' /C calc'!A0", "'+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"-12.5", "-12.5"},
+		{"+3", "+3"},
+		{"a=b", "a=b"},
+	}
+
+	for _, test := range tests {
+		if result := csvCell(test.value); result != test.expected {
+			t.Errorf("csvCell(%q) = %q, expected %q", test.value, result, test.expected)
+		}
+	}
+}
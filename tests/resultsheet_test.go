@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	LANG_EN = "en"
+	LANG_ID = "id"
+)
+
+var resultLabels = map[string]map[string]string{
+	LANG_EN: {
+		"title":             "Medical Check-Up Result",
+		"name":              "Name",
+		"patient_id":        "Patient ID",
+		"gender":            "Gender",
+		"age":               "Age",
+		"years":             "years",
+		"M":                 "Male",
+		"F":                 "Female",
+		"record_id":         "Record No.",
+		"date":              "Exam Date",
+		"package":           "Package",
+		"company":           "Company",
+		"results":           "Examination Results",
+		"examination":       "Examination",
+		"result":            "Result",
+		"unit":              "Unit",
+		"reference":         "Reference Range",
+		"flag":              "Flag",
+		"H":                 "High *",
+		"L":                 "Low *",
+		"N":                 "Normal",
+		"no_results":        "No examination results recorded.",
+		"legend":            "* Outside the reference range",
+		"conclusion":        "Doctor's Conclusion",
+		"no_conclusion":     "No conclusion recorded yet.",
+		"fitness":           "Fitness for Work",
+		"findings":          "Findings",
+		"advice":            "Recommendations",
+		"follow_up":         "Follow-up",
+		"follow_up_in":      "in %d months (by %s)",
+		"fit":               "Fit",
+		"fit_with_notes":    "Fit with notes",
+		"temporarily_unfit": "Temporarily unfit",
+		"unfit":             "Unfit",
+		"doctor":            "Examining Doctor",
+		"license":           "License No.",
+		"printed":           "Printed",
+		"page":              "Page %d of %d",
+	},
+	LANG_ID: {
+		"title":             "Hasil Pemeriksaan Kesehatan",
+		"name":              "Nama",
+		"patient_id":        "No. Pasien",
+		"gender":            "Jenis Kelamin",
+		"age":               "Usia",
+		"years":             "tahun",
+		"M":                 "Laki-laki",
+		"F":                 "Perempuan",
+		"record_id":         "No. Rekam Medis",
+		"date":              "Tgl. Periksa",
+		"package":           "Paket",
+		"company":           "Perusahaan",
+		"results":           "Hasil Pemeriksaan",
+		"examination":       "Pemeriksaan",
+		"result":            "Hasil",
+		"unit":              "Satuan",
+		"reference":         "Nilai Rujukan",
+		"flag":              "Keterangan",
+		"H":                 "Tinggi *",
+		"L":                 "Rendah *",
+		"N":                 "Normal",
+		"no_results":        "Belum ada hasil pemeriksaan.",
+		"legend":            "* Di luar nilai rujukan",
+		"conclusion":        "Kesimpulan Dokter",
+		"no_conclusion":     "Belum ada kesimpulan.",
+		"fitness":           "Kelaikan Kerja",
+		"findings":          "Temuan",
+		"advice":            "Saran",
+		"follow_up":         "Kontrol Ulang",
+		"follow_up_in":      "dalam %d bulan (sebelum %s)",
+		"fit":               "Laik kerja",
+		"fit_with_notes":    "Laik kerja dengan catatan",
+		"temporarily_unfit": "Tidak laik kerja sementara",
+		"unfit":             "Tidak laik kerja",
+		"doctor":            "Dokter Pemeriksa",
+		"license":           "No. SIP",
+		"printed":           "Dicetak",
+		"page":              "Halaman %d dari %d",
+	},
+}
+
+// Copy of result sheet functions from resultsheet.go and pdf.go for testing
+func sheetLabel(lang, key string) string {
+	if text, ok := resultLabels[lang][key]; ok {
+		return text
+	}
+	return resultLabels[LANG_EN][key]
+}
+
+func wrapText(text string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && textWidth(line+" "+word, size) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, fitText(line, size, width))
+	}
+	return lines
+}
+
+// Test that every label has an Indonesian translation
+func TestResultLabels(t *testing.T) {
+	for key := range resultLabels[LANG_EN] {
+		if _, ok := resultLabels[LANG_ID][key]; !ok {
+			t.Errorf("Label %q has no Indonesian translation", key)
+		}
+	}
+	for key := range resultLabels[LANG_ID] {
+		if _, ok := resultLabels[LANG_EN][key]; !ok {
+			t.Errorf("Label %q has no English text", key)
+		}
+	}
+
+	if l := sheetLabel(LANG_ID, "M"); l != "Laki-laki" {
+		t.Errorf("Expected Laki-laki, got %s", l)
+	}
+	if l := sheetLabel("fr", "title"); l != "Medical Check-Up Result" {
+		t.Errorf("Expected unknown language to fall back to English, got %s", l)
+	}
+}
+
+// Test paragraph wrapping
+func TestWrapText(t *testing.T) {
+	text := "Fasting glucose is raised, repeat the test in three months and keep to a low sugar diet."
+	lines := wrapText(text, 9, 150)
+	if len(lines) < 2 {
+		t.Fatalf("Expected text to wrap onto several lines, got %v", lines)
+	}
+	for _, line := range lines {
+		if textWidth(line, 9) > 150 {
+			t.Errorf("Line %q is wider than 150", line)
+		}
+	}
+	if strings.Join(lines, " ") != text {
+		t.Errorf("Wrapping lost words: %v", lines)
+	}
+
+	lines = wrapText("First line\nSecond line", 9, 500)
+	if len(lines) != 2 || lines[1] != "Second line" {
+		t.Errorf("Expected line breaks to be kept, got %v", lines)
+	}
+}
@@ -158,6 +158,106 @@ echo.
 echo Testing Data Persistence...
 go test -run=TestSaveAndLoadData -v ./tests/
 
+echo.
+echo Testing Price History...
+go test -run="TestCompareDates|TestGetPriceAtDate|TestAddPriceVersion" -v ./tests/
+
+echo.
+echo Testing Corporate Client Helpers...
+go test -run="TestGetResultFlag|TestSuppressedCount|TestIsSmallCell|TestSmallCells|TestAgeBand" -v ./tests/
+
+echo.
+echo Testing Insurance Coverage...
+go test -run=TestSplitCoverage -v ./tests/
+
+echo.
+echo Testing Discounts...
+go test -run="TestCalculateDiscount|TestInPromotionPeriod" -v ./tests/
+
+echo.
+echo Testing Package Categories...
+go test -run="TestAppendCategory|TestRenameCategory|TestRetireCategory|TestSortedCategories|TestMigrateCategories" -v ./tests/
+
+echo.
+echo Testing Accounts and Permissions...
+go test -run="TestCheckPassword|TestRoleHasPermission" -v ./tests/
+
+echo.
+echo Testing Audit Trail...
+go test -run="TestVerifyAuditChain|TestRedactAuditEntry|TestVerifyLegacyAuditChain|TestReanchorAuditLog|TestSealAuditValue" -v ./tests/
+
+echo.
+echo Testing Data Encryption...
+go test -run=TestEncryptStore -v ./tests/
+
+echo.
+echo Testing Research Export...
+go test -run="TestPseudonym|TestDateShiftDays|TestSuppressSmallGroups|TestCSVCell" -v ./tests/
+
+echo.
+echo Testing Retention...
+go test -run="TestRetentionCutoff|TestErasures|TestEraseAfterSync|TestFHIREntryMentions" -v ./tests/
+
+echo.
+echo Testing Screen Privacy...
+go test -run="TestMaskName|TestMaskIdentifier|TestIsIdle|TestCheckIdle" -v ./tests/
+
+echo.
+echo Testing Trash Bin...
+go test -run="TestTrashExpired|TestSameValue" -v ./tests/
+
+echo.
+echo Testing Bulk Import...
+go test -run="TestXLSXColumn|TestAutoMapColumns" -v ./tests/
+
+echo.
+echo Testing Report Export...
+go test -run="TestPDFString|TestFitText|TestInPeriod" -v ./tests/
+
+echo.
+echo Testing Result Sheet...
+go test -run="TestResultLabels|TestWrapText" -v ./tests/
+
+echo.
+echo Testing FHIR Export and Import...
+go test -run="TestFHIR" -v ./tests/
+
+echo.
+echo Testing HL7 Result Ingestion...
+go test -run="TestParseHL7|TestHL7Escape|TestParseORU|TestACKAndMLLP" -v ./tests/
+
+echo.
+echo Testing Practitioner Schedules...
+go test -run="TestSchedule|TestParseDays" -v ./tests/
+
+echo.
+echo Testing Fitness Conclusions...
+go test -run="TestICD10|TestFollowUpDue|TestCheckConclusion" -v ./tests/
+
+echo.
+echo Testing Recall Reminders...
+go test -run="TestSMTPNotifier|TestOutboxNotifier|TestIsValidContact" -v ./tests/
+
+echo.
+echo Testing Patient Notifications...
+go test -run="TestNotifyTemplates|TestRenderTemplate|TestRetryDelay" -v ./tests/
+
+echo.
+echo Testing Webhooks...
+go test -run="TestEventMatches|TestSignWebhook|TestIsValidWebhookURL|TestPostWebhook" -v ./tests/
+
+echo.
+echo Testing Concurrent Access...
+go test -run="TestAcquireLock|TestMergeItem|TestMergeList" -v ./tests/
+
+echo.
+echo Testing Branches...
+go test -run="TestUserCanUseBranch|TestInBranch|TestClaimLegacyRecords" -v ./tests/
+
+echo.
+echo Testing Offline Branch Sync...
+go test -run="TestMergeFields|TestMergeChangedItemsSignedOff|TestChangedLater|TestServerStoreSave|TestCentralServerSync" -v ./tests/
+
 echo.
 echo Testing Integration Workflow...
 go test -run=TestCompleteWorkflow -v ./tests/
@@ -251,6 +351,34 @@ echo   [OK] selectionSortPackagesByPrice()
 echo   [OK] insertionSortRecordsByDate()
 echo   [OK] saveData()
 echo   [OK] loadData()
+echo   [OK] compareDates()
+echo   [OK] getPriceAtDate() / addPriceVersion()
+echo   [OK] getResultFlag()
+echo   [OK] suppressedCount() / isSmallCell() / smallCells() / ageBand()
+echo   [OK] splitCoverage()
+echo   [OK] calculateDiscount() / inPromotionPeriod()
+echo   [OK] appendCategory() / categoryLabel() / moveCategoryPackages() / migrateCategories()
+echo   [OK] checkPassword() / roleHasPermission()
+echo   [OK] verifyAuditChain() / redactAuditEntry() / reanchorAuditLog() / sealAuditValue()
+echo   [OK] encryptStore() / decryptStore()
+echo   [OK] pseudonym() / dateShiftDays() / suppressSmallGroups() / csvCell()
+echo   [OK] retentionCutoff() / isErasedItem() / maxErasedID() / scrubErasedSync() / fhirEntryMentions()
+echo   [OK] maskName() / maskIdentifier() / isIdle() / checkIdle()
+echo   [OK] trashExpired() / sameValue()
+echo   [OK] xlsxColumn() / autoMapColumns()
+echo   [OK] pdfString() / fitText() / inPeriod()
+echo   [OK] sheetLabel() / wrapText()
+echo   [OK] FHIR Patient / Encounter / Observation round trips
+echo   [OK] encounterStatus()
+echo   [OK] parseHL7() / parseORU() / buildACK() / readMLLP()
+echo   [OK] weekdayOf() / isScheduled() / parseDays() / daysText()
+echo   [OK] isValidICD10() / followUpDue() / checkConclusion()
+echo   [OK] SMTP (with timeout) and outbox notifiers / isValidEmail() / isValidPhone()
+echo   [OK] Notification templates / renderTemplate() / retryDelay()
+echo   [OK] eventMatches() / signWebhook() / isValidWebhookURL() / postWebhook() / checkWebhookRedirect()
+echo   [OK] acquireLock() / mergeItem() / mergeList()
+echo   [OK] userCanUseBranch() / inBranch() / claimLegacyRecords()
+echo   [OK] mergeFields() / mergeChangedItems() / changedLater() / serverStore.Save() / centralHandler()
 echo   [OK] Complete workflow integration
 echo   [OK] Edge cases and boundary conditions
 echo   [OK] Performance benchmarks
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Copy of trash functions from trash.go for testing
+func trashExpired(deletedAt string, now time.Time, days int) bool {
+	t, err := time.Parse(time.RFC3339, deletedAt)
+	if err != nil {
+		return false
+	}
+	return !now.Before(t.AddDate(0, 0, days))
+}
+
+func sameValue(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// Test the trash purge period
+func TestTrashExpired(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		deletedAt string
+		days      int
+		expected  bool
+	}{
+		{"2025-06-01T12:00:00Z", 30, false},
+		{"2025-05-31T12:00:00Z", 30, true},
+		{"2025-05-31T12:00:01Z", 30, false},
+		{"2025-06-29T12:00:00Z", 1, true},
+		{"not a date", 1, false},
+	}
+
+	for _, test := range tests {
+		if result := trashExpired(test.deletedAt, now, test.days); result != test.expected {
+			t.Errorf("trashExpired(%s, %d days) = %v, expected %v", test.deletedAt, test.days, result, test.expected)
+		}
+	}
+}
+
+// Test that undo only applies to an unchanged item
+func TestSameValue(t *testing.T) {
+	type item struct {
+		ID     int      `json:"id"`
+		Name   string   `json:"name"`
+		Values []string `json:"values,omitempty"`
+	}
+
+	tests := []struct {
+		a, b     interface{}
+		expected bool
+	}{
+		{item{1, "Budi", nil}, item{1, "Budi", nil}, true},
+		{item{1, "Budi", nil}, item{1, "Budi", []string{}}, true},
+		{item{1, "Budi", nil}, item{1, "Budi S", nil}, false},
+		{item{1, "Budi", []string{"a"}}, item{1, "Budi", []string{"b"}}, false},
+	}
+
+	for i, test := range tests {
+		if result := sameValue(test.a, test.b); result != test.expected {
+			t.Errorf("case %d: sameValue = %v, expected %v", i, result, test.expected)
+		}
+	}
+}
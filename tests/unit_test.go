@@ -21,24 +21,43 @@ const (
 
 // Test data structures
 type Patient struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	Gender string `json:"gender"`
-	Age    int    `json:"age"`
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Gender    string `json:"gender"`
+	Age       int    `json:"age"`
+	Version   int    `json:"version,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
 }
 
 type Package struct {
-	ID       int     `json:"id"`
-	Name     string  `json:"name"`
-	Category string  `json:"category"`
-	Price    float64 `json:"price"`
+	ID           int            `json:"id"`
+	Name         string         `json:"name"`
+	Category     string         `json:"category"`
+	CategoryID   int            `json:"category_id,omitempty"`
+	Price        float64        `json:"price"`
+	PriceHistory []PriceVersion `json:"price_history,omitempty"`
+	Version      int            `json:"version,omitempty"`
 }
 
 type Record struct {
-	ID      int     `json:"id"`
-	Patient Patient `json:"patient"`
-	Package Package `json:"package"`
-	Date    string  `json:"date"`
+	ID           int      `json:"id"`
+	Patient      Patient  `json:"patient"`
+	Package      Package  `json:"package"`
+	Date         string   `json:"date"`
+	PriceVersion int      `json:"price_version,omitempty"`
+	Price        float64  `json:"price,omitempty"`
+	Results      []Result `json:"results,omitempty"`
+	ClaimNote    string   `json:"claim_note,omitempty"`
+	ConcludedBy  string   `json:"concluded_by,omitempty"`
+	Version      int      `json:"version,omitempty"`
+	UpdatedAt    string   `json:"updated_at,omitempty"`
+	BranchID     int      `json:"branch_id,omitempty"`
+
+	Conclusion      string    `json:"conclusion,omitempty"`
+	Fitness         string    `json:"fitness,omitempty"`
+	Findings        []Finding `json:"findings,omitempty"`
+	Recommendations string    `json:"recommendations,omitempty"`
+	FollowUpMonths  int       `json:"follow_up_months,omitempty"`
 }
 
 type PatientArray struct {
@@ -60,6 +79,7 @@ type DataStore struct {
 	Patients PatientArray `json:"patients"`
 	Packages PackageArray `json:"packages"`
 	Records  RecordArray  `json:"records"`
+	Revision int          `json:"revision"`
 }
 
 // Global test variables
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	WEBHOOK_MAX_REDIRECTS = 10
+	WEBHOOK_SIGNATURE_KEY = "X-Webhook-Signature"
+)
+
+var EVENT_TYPES = []string{
+	"patient.created", "patient.updated", "patient.deleted",
+	"package.created", "package.updated", "package.deleted",
+	"record.created", "record.updated", "record.deleted", "record.completed",
+}
+
+type DomainEvent struct {
+	Offset   int             `json:"offset"`
+	Type     string          `json:"type"`
+	Entity   string          `json:"entity"`
+	EntityID int             `json:"entity_id"`
+	Time     string          `json:"time"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type Webhook struct {
+	ID     int
+	URL    string
+	Secret string
+	Events []string
+}
+
+// Copy of the webhook functions from webhook.go for testing
+func eventMatches(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" || p == eventType || (strings.HasSuffix(p, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	return "sha256=" + hex.EncodeToString(keyedHash(secret, timestamp+"."+string(body)))
+}
+
+// isValidWebhookURL accepts https addresses, and plain http only to this
+// machine, since events carry patient data
+func isValidWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "https" || (u.Scheme == "http" && isLoopbackHost(u.Hostname()))
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func checkWebhookRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= WEBHOOK_MAX_REDIRECTS {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	if !isValidWebhookURL(req.URL.String()) {
+		return fmt.Errorf("redirected to %s, which is not an https address", req.URL.Redacted())
+	}
+	return nil
+}
+
+func postWebhook(client *http.Client, w Webhook, ev DomainEvent, now time.Time) error {
+	// Webhooks registered before https was required are not sent in the clear
+	if !isValidWebhookURL(w.URL) {
+		return fmt.Errorf("%s is not an https address; change the webhook's URL", w.URL)
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", ev.Type)
+	req.Header.Set("X-Webhook-Offset", strconv.Itoa(ev.Offset))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set(WEBHOOK_SIGNATURE_KEY, signWebhook(w.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+func TestEventMatches(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		event    string
+		want     bool
+	}{
+		{nil, "patient.created", true},
+		{[]string{"*"}, "record.deleted", true},
+		{[]string{"record.*"}, "record.completed", true},
+		{[]string{"record.*"}, "patient.created", false},
+		{[]string{"patient.created"}, "patient.created", true},
+		{[]string{"patient.created"}, "patient.updated", false},
+		{[]string{"package.*", "patient.deleted"}, "patient.deleted", true},
+		{[]string{"rec.*"}, "record.created", false},
+	}
+	for _, tt := range tests {
+		if got := eventMatches(tt.patterns, tt.event); got != tt.want {
+			t.Errorf("eventMatches(%v, %q) = %v, want %v", tt.patterns, tt.event, got, tt.want)
+		}
+	}
+
+	for _, eventType := range EVENT_TYPES {
+		entity := strings.SplitN(eventType, ".", 2)[0]
+		if !eventMatches([]string{entity + ".*"}, eventType) {
+			t.Errorf("%q is not matched by %q", eventType, entity+".*")
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"offset":1}`)
+	sig := signWebhook("secret", "1700000000", body)
+
+	if !strings.HasPrefix(sig, "sha256=") || len(sig) != len("sha256=")+64 {
+		t.Fatalf("signWebhook() = %q, want sha256= and 64 hex digits", sig)
+	}
+	if sig != signWebhook("secret", "1700000000", body) {
+		t.Error("signWebhook() is not deterministic")
+	}
+	if sig == signWebhook("other", "1700000000", body) {
+		t.Error("A different secret gave the same signature")
+	}
+	if sig == signWebhook("secret", "1700000001", body) {
+		t.Error("A different timestamp gave the same signature")
+	}
+	if sig == signWebhook("secret", "1700000000", []byte(`{"offset":2}`)) {
+		t.Error("A different body gave the same signature")
+	}
+}
+
+func TestIsValidWebhookURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://partner.example.com/hooks", true},
+		{"https://10.0.0.5:8443/hooks", true},
+		{"http://partner.example.com/hooks", false},
+		{"http://10.0.0.5/hooks", false},
+		{"http://localhost:9000/hooks", true},
+		{"http://LOCALHOST/hooks", true},
+		{"http://127.0.0.1:9000/hooks", true},
+		{"http://[::1]:9000/hooks", true},
+		{"http://127.0.0.1.example.com/hooks", false},
+		{"ftp://partner.example.com/hooks", false},
+		{"https://", false},
+		{"partner.example.com/hooks", false},
+	}
+	for _, tt := range tests {
+		if got := isValidWebhookURL(tt.url); got != tt.want {
+			t.Errorf("isValidWebhookURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+
+	// A webhook registered before https was required is not sent
+	err := postWebhook(&http.Client{Timeout: time.Second}, Webhook{URL: "http://partner.example.com/hooks"}, DomainEvent{Offset: 1}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "https") {
+		t.Errorf("postWebhook() over plain http error = %v, want a refusal", err)
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	const secret = "3f9a1c"
+	var gotEvent, gotOffset string
+	var verified bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotEvent = r.Header.Get("X-Webhook-Event")
+		gotOffset = r.Header.Get("X-Webhook-Offset")
+		verified = r.Header.Get(WEBHOOK_SIGNATURE_KEY) == signWebhook(secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	ev := DomainEvent{Offset: 7, Type: "record.created", Entity: "record", EntityID: 30001,
+		Time: "2026-03-04T09:00:00Z", Data: json.RawMessage(`{"id":30001,"status":"registered"}`)}
+
+	if err := postWebhook(client, Webhook{ID: 1, URL: server.URL, Secret: secret}, ev, time.Now()); err != nil {
+		t.Fatalf("postWebhook() error = %v", err)
+	}
+	if !verified {
+		t.Error("Receiver could not verify the signature")
+	}
+	if gotEvent != "record.created" || gotOffset != "7" {
+		t.Errorf("Headers = %q / %q, want record.created / 7", gotEvent, gotOffset)
+	}
+
+	// A wrong secret on the sending side must not verify
+	verified = true
+	postWebhook(client, Webhook{ID: 1, URL: server.URL, Secret: "wrong"}, ev, time.Now())
+	if verified {
+		t.Error("Signature with the wrong secret was accepted")
+	}
+}
+
+func TestPostWebhookFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	err := postWebhook(client, Webhook{URL: server.URL, Secret: "s"}, DomainEvent{Offset: 1, Type: "patient.created"}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("postWebhook() error = %v, want the 503 status and reply", err)
+	}
+}
+
+// Test a redirect cannot take the signed event off https
+func TestPostWebhookRedirect(t *testing.T) {
+	var received bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+
+	redirectTo := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, redirectTo, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second, CheckRedirect: checkWebhookRedirect}
+	ev := DomainEvent{Offset: 1, Type: "patient.created"}
+
+	// Plain http to another machine is refused before anything is sent
+	redirectTo = "http://partner.example.com/hooks"
+	err := postWebhook(client, Webhook{URL: server.URL, Secret: "s"}, ev, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "redirected to http://partner.example.com/hooks") {
+		t.Errorf("postWebhook() error = %v, want the redirect refused", err)
+	}
+
+	// A redirect this machine would have been allowed as the address is followed
+	redirectTo = target.URL
+	if err := postWebhook(client, Webhook{URL: server.URL, Secret: "s"}, ev, time.Now()); err != nil || !received {
+		t.Errorf("postWebhook() error = %v, received = %v, want the loopback redirect followed", err, received)
+	}
+}
@@ -0,0 +1,677 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	TRASH_PURGE_DAYS_DEFAULT = 30
+	UNDO_LIMIT               = 20
+
+	// Entities that can be trashed and undone
+	ENTITY_PATIENT = "patient"
+	ENTITY_PACKAGE = "package"
+	ENTITY_RECORD  = "record"
+)
+
+var (
+	ErrNotInTrash    = errors.New("item is no longer in the trash")
+	ErrChangedSince  = errors.New("item was changed since, undo it from its own menu")
+	ErrIDInUse       = errors.New("an item with this ID already exists")
+	ErrCapacityFull  = errors.New("maximum capacity reached")
+	ErrUnknownEntity = errors.New("unknown entity")
+)
+
+// Data structures
+// TrashItem holds one deleted patient, package or record until it is
+// restored or purged
+type TrashItem struct {
+	Entity    string   `json:"entity"`
+	DeletedAt string   `json:"deleted_at"`
+	DeletedBy string   `json:"deleted_by"`
+	Patient   *Patient `json:"patient,omitempty"`
+	Package   *Package `json:"package,omitempty"`
+	Record    *Record  `json:"record,omitempty"`
+}
+
+type TrashArray struct {
+	Daftar    [NMAX]TrashItem `json:"daftar"`
+	N         int             `json:"n"`
+	PurgeDays int             `json:"purge_days,omitempty"`
+}
+
+// UndoOp is one change that can be undone. Before and After are the item's
+// values on either side of the change; nil means it was not in its list.
+type UndoOp struct {
+	Action string
+	Entity string
+	ID     int
+	Before interface{}
+	After  interface{}
+}
+
+var (
+	trash     TrashArray
+	undoStack []UndoOp
+	redoStack []UndoOp
+)
+
+// Item functions
+func entityID(value interface{}) int {
+	switch v := value.(type) {
+	case Patient:
+		return v.ID
+	case Package:
+		return v.ID
+	case Record:
+		return v.ID
+	}
+	return 0
+}
+
+func (t TrashItem) value() interface{} {
+	switch {
+	case t.Patient != nil:
+		return *t.Patient
+	case t.Package != nil:
+		return *t.Package
+	case t.Record != nil:
+		return *t.Record
+	}
+	return nil
+}
+
+func (t TrashItem) id() int {
+	return entityID(t.value())
+}
+
+func (t TrashItem) describe() string {
+	switch {
+	case t.Patient != nil:
+		return displayName(t.Patient.Name)
+	case t.Package != nil:
+		return t.Package.Name
+	case t.Record != nil:
+		return fmt.Sprintf("%s - %s (%s)", displayName(t.Record.Patient.Name), t.Record.Package.Name, t.Record.Date)
+	}
+	return ""
+}
+
+// sameValue compares two items by their stored form
+func sameValue(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// entityPermission is the permission needed to make or undo a change
+func entityPermission(entity, action string) string {
+	switch entity {
+	case ENTITY_PATIENT:
+		switch action {
+		case AUDIT_CREATE:
+			return PERM_PATIENT_CREATE
+		case AUDIT_UPDATE:
+			return PERM_PATIENT_UPDATE
+		}
+		return PERM_PATIENT_DELETE
+	case ENTITY_PACKAGE:
+		return PERM_PACKAGE_MANAGE
+	case ENTITY_RECORD:
+		if action == AUDIT_CREATE {
+			return PERM_RECORD_CREATE
+		}
+		return PERM_RECORD_DELETE
+	}
+	return PERM_PRIVACY_MANAGE
+}
+
+func entityViewPermission(entity string) string {
+	switch entity {
+	case ENTITY_PATIENT:
+		return PERM_PATIENT_VIEW
+	case ENTITY_PACKAGE:
+		return PERM_PACKAGE_VIEW
+	}
+	return PERM_RECORD_VIEW
+}
+
+// List functions
+// findActive returns the item with this ID from its list, or -1
+func findActive(entity string, id int) (interface{}, int) {
+	switch entity {
+	case ENTITY_PATIENT:
+		for i := 0; i < patients.N; i++ {
+			if patients.Daftar[i].ID == id {
+				return patients.Daftar[i], i
+			}
+		}
+	case ENTITY_PACKAGE:
+		for i := 0; i < packages.N; i++ {
+			if packages.Daftar[i].ID == id {
+				return packages.Daftar[i], i
+			}
+		}
+	case ENTITY_RECORD:
+		for i := 0; i < records.N; i++ {
+			if records.Daftar[i].ID == id {
+				return records.Daftar[i], i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// insertActive puts an item back into its list. Patients and packages are
+// kept in ID order for the binary search.
+func insertActive(value interface{}) error {
+	switch v := value.(type) {
+	case Patient:
+		if patients.N >= NMAX {
+			return ErrCapacityFull
+		}
+		pos := patients.N
+		for i := 0; i < patients.N; i++ {
+			if patients.Daftar[i].ID > v.ID {
+				pos = i
+				break
+			}
+		}
+		for i := patients.N; i > pos; i-- {
+			patients.Daftar[i] = patients.Daftar[i-1]
+		}
+		patients.Daftar[pos] = v
+		patients.N++
+	case Package:
+		if packages.N >= NMAX {
+			return ErrCapacityFull
+		}
+		pos := packages.N
+		for i := 0; i < packages.N; i++ {
+			if packages.Daftar[i].ID > v.ID {
+				pos = i
+				break
+			}
+		}
+		for i := packages.N; i > pos; i-- {
+			packages.Daftar[i] = packages.Daftar[i-1]
+		}
+		packages.Daftar[pos] = v
+		packages.N++
+	case Record:
+		if records.N >= NMAX {
+			return ErrCapacityFull
+		}
+		records.Daftar[records.N] = v
+		records.N++
+	default:
+		return ErrUnknownEntity
+	}
+	return nil
+}
+
+func activeAt(entity string, idx int) interface{} {
+	switch entity {
+	case ENTITY_PATIENT:
+		return patients.Daftar[idx]
+	case ENTITY_PACKAGE:
+		return packages.Daftar[idx]
+	case ENTITY_RECORD:
+		return records.Daftar[idx]
+	}
+	return nil
+}
+
+func removeActive(entity string, idx int) {
+	switch entity {
+	case ENTITY_PATIENT:
+		for i := idx; i < patients.N-1; i++ {
+			patients.Daftar[i] = patients.Daftar[i+1]
+		}
+		patients.N--
+	case ENTITY_PACKAGE:
+		for i := idx; i < packages.N-1; i++ {
+			packages.Daftar[i] = packages.Daftar[i+1]
+		}
+		packages.N--
+	case ENTITY_RECORD:
+		removeRecordAt(idx)
+	}
+}
+
+func replaceActive(idx int, value interface{}) {
+	switch v := value.(type) {
+	case Patient:
+		patients.Daftar[idx] = v
+	case Package:
+		packages.Daftar[idx] = v
+	case Record:
+		records.Daftar[idx] = v
+	}
+}
+
+// Trash functions
+func findTrash(entity string, id int) int {
+	for i := 0; i < trash.N; i++ {
+		if trash.Daftar[i].Entity == entity && trash.Daftar[i].id() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// maxTrashID keeps new IDs from reusing those of trashed items
+func maxTrashID(entity string) int {
+	maxID := 0
+	for i := 0; i < trash.N; i++ {
+		if trash.Daftar[i].Entity == entity && trash.Daftar[i].id() > maxID {
+			maxID = trash.Daftar[i].id()
+		}
+	}
+	return maxID
+}
+
+func removeTrashAt(idx int) {
+	for i := idx; i < trash.N-1; i++ {
+		trash.Daftar[i] = trash.Daftar[i+1]
+	}
+	trash.Daftar[trash.N-1] = TrashItem{}
+	trash.N--
+}
+
+func trashPurgeDays() int {
+	if trash.PurgeDays == 0 {
+		return TRASH_PURGE_DAYS_DEFAULT
+	}
+	return trash.PurgeDays
+}
+
+// moveToTrash takes the item at idx out of its list. When the trash is full
+// the oldest item is purged to make room.
+func moveToTrash(entity string, idx int) {
+	value := activeAt(entity, idx)
+	item := TrashItem{
+		Entity:    entity,
+		DeletedAt: time.Now().Format(time.RFC3339),
+		DeletedBy: currentUser.Username,
+	}
+	switch v := value.(type) {
+	case Patient:
+		item.Patient = &v
+	case Package:
+		item.Package = &v
+	case Record:
+		item.Record = &v
+	}
+
+	if trash.N >= NMAX {
+		oldest := trash.Daftar[0]
+		auditLog(AUDIT_PURGE, oldest.Entity, oldest.id(), oldest.value(), nil)
+		removeTrashAt(0)
+	}
+	trash.Daftar[trash.N] = item
+	trash.N++
+	removeActive(entity, idx)
+}
+
+// restoreFromTrash puts a trashed item back into its list
+func restoreFromTrash(entity string, id int) (interface{}, error) {
+	tIdx := findTrash(entity, id)
+	if tIdx == -1 {
+		return nil, ErrNotInTrash
+	}
+	if _, idx := findActive(entity, id); idx != -1 {
+		return nil, ErrIDInUse
+	}
+
+	value := trash.Daftar[tIdx].value()
+	if err := insertActive(value); err != nil {
+		return nil, err
+	}
+	removeTrashAt(tIdx)
+	return value, nil
+}
+
+// trashExpired reports whether an item deleted at deletedAt is due for purging
+func trashExpired(deletedAt string, now time.Time, days int) bool {
+	t, err := time.Parse(time.RFC3339, deletedAt)
+	if err != nil {
+		return false
+	}
+	return !now.Before(t.AddDate(0, 0, days))
+}
+
+// purgeExpiredTrash removes items kept longer than the purge period. It runs
+// after login.
+func purgeExpiredTrash() {
+	now := time.Now()
+	purged := 0
+	for i := trash.N - 1; i >= 0; i-- {
+		item := trash.Daftar[i]
+		if trashExpired(item.DeletedAt, now, trashPurgeDays()) {
+			auditLog(AUDIT_PURGE, item.Entity, item.id(), item.value(), nil)
+			removeTrashAt(i)
+			purged++
+		}
+	}
+	if purged > 0 {
+		printSuccess(fmt.Sprintf("Trash: %d items older than %d days purged.", purged, trashPurgeDays()))
+	}
+}
+
+// purgeTrash removes every trashed item that matches and returns the IDs of
+// the records among them
+func purgeTrash(match func(TrashItem) bool) (int, []int) {
+	purged := 0
+	var recordIDs []int
+	for i := trash.N - 1; i >= 0; i-- {
+		item := trash.Daftar[i]
+		if !match(item) {
+			continue
+		}
+		if item.Record != nil {
+			recordIDs = append(recordIDs, item.Record.ID)
+		}
+		removeTrashAt(i)
+		purged++
+	}
+	return purged, recordIDs
+}
+
+// trashMentionsPatient matches the patient and their records
+func trashMentionsPatient(item TrashItem, patientID int) bool {
+	return (item.Patient != nil && item.Patient.ID == patientID) ||
+		(item.Record != nil && item.Record.Patient.ID == patientID)
+}
+
+// Undo functions
+func pushUndo(action, entity string, id int, before, after interface{}) {
+	undoStack = append(undoStack, UndoOp{Action: action, Entity: entity, ID: id, Before: before, After: after})
+	if len(undoStack) > UNDO_LIMIT {
+		undoStack = undoStack[1:]
+	}
+	redoStack = nil
+}
+
+// forgetUndoHistory drops the changes that match, e.g. for an erased patient
+func forgetUndoHistory(match func(UndoOp) bool) {
+	filter := func(ops []UndoOp) []UndoOp {
+		var kept []UndoOp
+		for _, op := range ops {
+			if !match(op) {
+				kept = append(kept, op)
+			}
+		}
+		return kept
+	}
+	undoStack = filter(undoStack)
+	redoStack = filter(redoStack)
+}
+
+// undoMentionsPatient matches changes to the patient and to their records
+func undoMentionsPatient(op UndoOp, patientID int) bool {
+	if op.Entity == ENTITY_PATIENT {
+		return op.ID == patientID
+	}
+	for _, v := range []interface{}{op.Before, op.After} {
+		if r, ok := v.(Record); ok && r.Patient.ID == patientID {
+			return true
+		}
+	}
+	return false
+}
+
+func describeUndo(op UndoOp) string {
+	return fmt.Sprintf("%s %s %d", op.Action, op.Entity, op.ID)
+}
+
+// applyUndo moves an item from one side of a change to the other. Undo goes
+// from After to Before, redo the other way. The item must still be as the
+// change left it, so later edits are never overwritten.
+func applyUndo(op UndoOp, undo bool) error {
+	from, to := op.Before, op.After
+	if undo {
+		from, to = op.After, op.Before
+	}
+	current, idx := findActive(op.Entity, op.ID)
+
+	// Trashing and restoring move the item between its list and the trash
+	if op.Action == AUDIT_DELETE || op.Action == AUDIT_RESTORE {
+		if to == nil {
+			if idx == -1 || !sameContent(current, from) {
+				return ErrChangedSince
+			}
+			moveToTrash(op.Entity, idx)
+			return nil
+		}
+		_, err := restoreFromTrash(op.Entity, op.ID)
+		return err
+	}
+
+	switch {
+	case from == nil:
+		if idx != -1 {
+			return ErrIDInUse
+		}
+		return insertActive(to)
+	case idx == -1 || !sameContent(current, from):
+		return ErrChangedSince
+	case to == nil:
+		removeActive(op.Entity, idx)
+	default:
+		replaceActive(idx, to)
+	}
+	return nil
+}
+
+func undoLast(undo bool) {
+	title, stack, action := "Undo", &undoStack, AUDIT_UNDO
+	if !undo {
+		title, stack, action = "Redo", &redoStack, AUDIT_REDO
+	}
+	printHeader(title)
+
+	if len(*stack) == 0 {
+		printWarning(fmt.Sprintf("Nothing to %s.", strings.ToLower(title)))
+		pause()
+		return
+	}
+
+	op := (*stack)[len(*stack)-1]
+	if !requirePermission(entityPermission(op.Entity, op.Action)) {
+		return
+	}
+
+	fmt.Printf("%s: %s\n", title, describeUndo(op))
+	if err := applyUndo(op, undo); err != nil {
+		printError(fmt.Sprintf("Cannot %s: %v.", strings.ToLower(title), err))
+		// A change that can no longer be applied would block the ones below it
+		*stack = (*stack)[:len(*stack)-1]
+		pause()
+		return
+	}
+
+	*stack = (*stack)[:len(*stack)-1]
+	before, after := op.Before, op.After
+	if undo {
+		redoStack = append(redoStack, op)
+		before, after = op.After, op.Before
+	} else {
+		undoStack = append(undoStack, op)
+	}
+	auditLog(action, op.Entity, op.ID, before, after)
+	publishChange(op.Entity, op.ID, before, after)
+
+	printSuccess(fmt.Sprintf("%s complete.", title))
+	pause()
+}
+
+// Trash bin functions
+// visibleTrash lists the trash entries the user may see
+func visibleTrash() []int {
+	var list []int
+	for i := 0; i < trash.N; i++ {
+		if hasPermission(entityViewPermission(trash.Daftar[i].Entity)) {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+func printTrash(list []int) {
+	fmt.Printf("%s%-5s %-8s %-8s %-40s %-12s %-12s%s\n", BOLD, "No.", "Type", "ID", "Item", "Deleted by", "Purged on", RESET)
+	fmt.Println(strings.Repeat("-", 90))
+	for n, i := range list {
+		item := trash.Daftar[i]
+		purgeOn := "-"
+		if t, err := time.Parse(time.RFC3339, item.DeletedAt); err == nil {
+			purgeOn = t.AddDate(0, 0, trashPurgeDays()).Format("02/01/2006")
+		}
+		fmt.Printf("%-5d %-8s %-8d %-40s %-12s %-12s\n", n+1, item.Entity, item.id(), item.describe(), item.DeletedBy, purgeOn)
+	}
+}
+
+func viewTrash() {
+	printHeader("Trash Bin")
+
+	list := visibleTrash()
+	if len(list) == 0 {
+		printWarning("The trash is empty.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_VIEW, "trash", 0, nil, nil)
+	fmt.Printf("Deleted items are purged after %d days.\n\n", trashPurgeDays())
+	printTrash(list)
+	pause()
+}
+
+// selectTrashItem shows the trash and returns the chosen entry, or -1
+func selectTrashItem(title string) int {
+	printHeader(title)
+
+	list := visibleTrash()
+	if len(list) == 0 {
+		printWarning("The trash is empty.")
+		pause()
+		return -1
+	}
+
+	printTrash(list)
+	choice := getValidInt("\nSelect item (0 to cancel): ", 0, len(list))
+	if choice == 0 {
+		return -1
+	}
+	return list[choice-1]
+}
+
+func restoreTrashItem() {
+	tIdx := selectTrashItem("Restore from Trash")
+	if tIdx == -1 {
+		return
+	}
+
+	item := trash.Daftar[tIdx]
+	if !requirePermission(entityPermission(item.Entity, AUDIT_RESTORE)) {
+		return
+	}
+
+	value, err := restoreFromTrash(item.Entity, item.id())
+	if err != nil {
+		printError(fmt.Sprintf("Cannot restore: %v.", err))
+		pause()
+		return
+	}
+	pushUndo(AUDIT_RESTORE, item.Entity, item.id(), nil, value)
+	auditLog(AUDIT_RESTORE, item.Entity, item.id(), nil, value)
+	publishChange(item.Entity, item.id(), nil, value)
+
+	printSuccess(fmt.Sprintf("Restored %s %d.", item.Entity, item.id()))
+	pause()
+}
+
+func purgeTrashItem() {
+	tIdx := selectTrashItem("Delete Permanently")
+	if tIdx == -1 {
+		return
+	}
+
+	item := trash.Daftar[tIdx]
+	if !requirePermission(entityPermission(item.Entity, AUDIT_DELETE)) {
+		return
+	}
+
+	confirm := getValidInput(fmt.Sprintf("\n%s %d will be deleted permanently. Continue? (y/N): ", item.Entity, item.id()))
+	if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+		printWarning("Deletion cancelled.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_PURGE, item.Entity, item.id(), item.value(), nil)
+	removeTrashAt(tIdx)
+
+	printSuccess("Item deleted permanently.")
+	pause()
+}
+
+func editTrashSettings() {
+	printHeader("Trash Settings")
+
+	if !requirePermission(PERM_PRIVACY_MANAGE) {
+		return
+	}
+
+	fmt.Printf("Deleted items are currently purged after %d days.\n\n", trashPurgeDays())
+	before := trashPurgeDays()
+	trash.PurgeDays = getValidInt("Purge deleted items after how many days (1-365): ", 1, 365)
+	auditLog(AUDIT_UPDATE, "trash_settings", 0, before, trash.PurgeDays)
+
+	printSuccess(fmt.Sprintf("Deleted items will be purged after %d days.", trash.PurgeDays))
+	pause()
+}
+
+func trashManagement() {
+	for {
+		printHeader("Trash & Undo")
+		undoHint, redoHint := "", ""
+		if len(undoStack) > 0 {
+			undoHint = " (" + describeUndo(undoStack[len(undoStack)-1]) + ")"
+		}
+		if len(redoStack) > 0 {
+			redoHint = " (" + describeUndo(redoStack[len(redoStack)-1]) + ")"
+		}
+		fmt.Printf("%s1.%s Undo Last Change%s\n", YELLOW, RESET, undoHint)
+		fmt.Printf("%s2.%s Redo%s\n", YELLOW, RESET, redoHint)
+		fmt.Printf("%s3.%s View Trash (%d items)\n", YELLOW, RESET, len(visibleTrash()))
+		fmt.Printf("%s4.%s Restore from Trash\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Delete Permanently\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Trash Settings\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back to Main Menu\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 6)
+
+		switch choice {
+		case 1:
+			undoLast(true)
+		case 2:
+			undoLast(false)
+		case 3:
+			viewTrash()
+		case 4:
+			restoreTrashItem()
+		case 5:
+			purgeTrashItem()
+		case 6:
+			editTrashSettings()
+		case 0:
+			return
+		}
+	}
+}
@@ -0,0 +1,850 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const (
+	EVENT_LOG_MAX         = 10000
+	WEBHOOK_MAX_ATTEMPTS  = 5
+	WEBHOOK_BATCH         = 50
+	WEBHOOK_TIMEOUT       = 10 * time.Second
+	WEBHOOK_MAX_REDIRECTS = 10
+	WEBHOOK_SIGNATURE_KEY = "X-Webhook-Signature"
+
+	// What happened to a patient, package or record
+	CHANGE_CREATED   = "created"
+	CHANGE_UPDATED   = "updated"
+	CHANGE_DELETED   = "deleted"
+	CHANGE_COMPLETED = "completed"
+)
+
+// EVENT_TYPES are the event types subscribers can ask for
+var EVENT_TYPES = []string{
+	"patient.created", "patient.updated", "patient.deleted",
+	"package.created", "package.updated", "package.deleted",
+	"record.created", "record.updated", "record.deleted", "record.completed",
+}
+
+// Data structures
+// DomainEvent is one entry of the event stream. Offsets count up from 1 and
+// are never reused.
+type DomainEvent struct {
+	Offset   int             `json:"offset"`
+	Type     string          `json:"type"`
+	Entity   string          `json:"entity"`
+	EntityID int             `json:"entity_id"`
+	Time     string          `json:"time"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// EventLog keeps the latest EVENT_LOG_MAX events
+type EventLog struct {
+	Last   int           `json:"last"`
+	Events []DomainEvent `json:"events,omitempty"`
+}
+
+// Webhook is a subscriber. Events are sent one at a time in offset order;
+// Offset is the next one to send.
+type Webhook struct {
+	ID          int      `json:"id"`
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	Events      []string `json:"events,omitempty"`
+	Active      bool     `json:"active"`
+	Offset      int      `json:"offset"`
+	Attempts    int      `json:"attempts,omitempty"`
+	NextAttempt string   `json:"next_attempt,omitempty"`
+	LeasedUntil string   `json:"leased_until,omitempty"`
+	LastError   string   `json:"last_error,omitempty"`
+	LastSent    string   `json:"last_sent,omitempty"`
+}
+
+// webhookClaim is the part of the stream a webhook is due to get, taken for
+// sending without the data lock. Delivered counts the events dealt with
+// from the start, sent or not subscribed to; Err is why the next one failed.
+type webhookClaim struct {
+	Webhook     Webhook
+	LeasedUntil string
+	Events      []DomainEvent
+	Delivered   int
+	Sent        int
+	Err         error
+}
+
+// DeadLetter is an event a webhook gave up on after WEBHOOK_MAX_ATTEMPTS
+type DeadLetter struct {
+	WebhookID int    `json:"webhook_id"`
+	Offset    int    `json:"offset"`
+	Type      string `json:"type"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	FailedAt  string `json:"failed_at"`
+}
+
+// What subscribers get to see. Results, findings and contact details stay
+// out of the stream; a signed record carries only its fitness category.
+type PatientEventData struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Gender       string `json:"gender"`
+	Age          int    `json:"age"`
+	PayerID      int    `json:"payer_id,omitempty"`
+	MemberNumber string `json:"member_number,omitempty"`
+}
+
+type PackageEventData struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Category     string   `json:"category"`
+	Price        float64  `json:"price"`
+	Examinations []string `json:"examinations,omitempty"`
+}
+
+type RecordEventData struct {
+	ID        int     `json:"id"`
+	PatientID int     `json:"patient_id"`
+	PackageID int     `json:"package_id"`
+	Package   string  `json:"package"`
+	Date      string  `json:"date"`
+	CompanyID int     `json:"company_id,omitempty"`
+	PayerID   int     `json:"payer_id,omitempty"`
+	Price     float64 `json:"price"`
+	Status    string  `json:"status"`
+	Fitness   string  `json:"fitness,omitempty"`
+	SignedAt  string  `json:"signed_at,omitempty"`
+}
+
+var (
+	eventLog    EventLog
+	webhooks    []Webhook
+	deadLetters []DeadLetter
+)
+
+// Event stream functions
+func eventData(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Patient:
+		return PatientEventData{ID: v.ID, Name: v.Name, Gender: v.Gender, Age: v.Age, PayerID: v.PayerID, MemberNumber: v.MemberNumber}
+	case Package:
+		return PackageEventData{ID: v.ID, Name: v.Name, Category: v.Category, Price: v.Price, Examinations: v.Examinations}
+	case Record:
+		data := RecordEventData{ID: v.ID, PatientID: v.Patient.ID, PackageID: v.Package.ID, Package: v.Package.Name,
+			Date: v.Date, CompanyID: v.CompanyID, PayerID: v.PayerID, Price: recordPrice(v), Status: recordStatus(v)}
+		if isSignedOff(v) {
+			data.Fitness, data.SignedAt = v.Fitness, v.ConcludedAt
+		}
+		return data
+	}
+	return value
+}
+
+// publishEvent adds an event to the stream. Webhooks pick it up on their
+// next delivery run.
+func publishEvent(eventType, entity string, id int, value interface{}) {
+	data, err := json.Marshal(eventData(value))
+	if err != nil {
+		return
+	}
+	eventLog.Last++
+	eventLog.Events = append(eventLog.Events, DomainEvent{
+		Offset:   eventLog.Last,
+		Type:     eventType,
+		Entity:   entity,
+		EntityID: id,
+		Time:     time.Now().Format(time.RFC3339),
+		Data:     data,
+	})
+	if len(eventLog.Events) > EVENT_LOG_MAX {
+		eventLog.Events = eventLog.Events[len(eventLog.Events)-EVENT_LOG_MAX:]
+	}
+}
+
+// publishChange publishes a create, update or delete the way pushUndo
+// records it: nil before means created, nil after means deleted
+func publishChange(entity string, id int, before, after interface{}) {
+	switch {
+	case before == nil:
+		publishEvent(entity+"."+CHANGE_CREATED, entity, id, after)
+	case after == nil:
+		publishEvent(entity+"."+CHANGE_DELETED, entity, id, before)
+	case !sameValue(before, after):
+		publishEvent(entity+"."+CHANGE_UPDATED, entity, id, after)
+	}
+}
+
+// redactEvents blanks the data of the events that match, e.g. for an erased
+// patient. Offsets and types stay so subscribers can still follow the stream.
+func redactEvents(match func(*DomainEvent) bool) int {
+	redacted := 0
+	for i := range eventLog.Events {
+		ev := &eventLog.Events[i]
+		if match(ev) {
+			ev.Data = json.RawMessage(fmt.Sprintf(`{"id":%d,"redacted":true}`, ev.EntityID))
+			redacted++
+		}
+	}
+	return redacted
+}
+
+func firstOffset() int {
+	if len(eventLog.Events) == 0 {
+		return eventLog.Last + 1
+	}
+	return eventLog.Events[0].Offset
+}
+
+// eventAt returns the event at an offset, if it is still kept
+func eventAt(offset int) (DomainEvent, bool) {
+	first := firstOffset()
+	if offset < first || offset > eventLog.Last {
+		return DomainEvent{}, false
+	}
+	return eventLog.Events[offset-first], true
+}
+
+// eventMatches checks an event type against a subscription's patterns:
+// exact types, "record.*" or "*". No patterns means every event.
+func eventMatches(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" || p == eventType || (strings.HasSuffix(p, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook functions
+func getNextWebhookID() int {
+	if len(webhooks) == 0 {
+		return 1
+	}
+	return webhooks[len(webhooks)-1].ID + 1
+}
+
+func findWebhook(id int) int {
+	for i := range webhooks {
+		if webhooks[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isValidWebhookURL accepts https addresses, and plain http only to this
+// machine, since events carry patient data
+func isValidWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "https" || (u.Scheme == "http" && isLoopbackHost(u.Hostname()))
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// signWebhook is the HMAC-SHA256 of "timestamp.body" with the webhook's
+// secret. Receivers recompute it and reject old timestamps to stop replays.
+func signWebhook(secret, timestamp string, body []byte) string {
+	return "sha256=" + hex.EncodeToString(keyedHash(secret, timestamp+"."+string(body)))
+}
+
+// newWebhookClient makes the client deliveries are sent with. A redirect is
+// held to the same rule as the webhook's own address, since a 307 or 308
+// sends the signed event on to it.
+func newWebhookClient() *http.Client {
+	return &http.Client{Timeout: WEBHOOK_TIMEOUT, CheckRedirect: checkWebhookRedirect}
+}
+
+func checkWebhookRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= WEBHOOK_MAX_REDIRECTS {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	if !isValidWebhookURL(req.URL.String()) {
+		return fmt.Errorf("redirected to %s, which is not an https address", req.URL.Redacted())
+	}
+	return nil
+}
+
+func postWebhook(client *http.Client, w Webhook, ev DomainEvent, now time.Time) error {
+	// Webhooks registered before https was required are not sent in the clear
+	if !isValidWebhookURL(w.URL) {
+		return fmt.Errorf("%s is not an https address; change the webhook's URL", w.URL)
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", ev.Type)
+	req.Header.Set("X-Webhook-Offset", strconv.Itoa(ev.Offset))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set(WEBHOOK_SIGNATURE_KEY, signWebhook(w.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// deliverWebhooks sends each active webhook the events from its offset on,
+// in order. The events are claimed under the data lock and sent without it.
+func deliverWebhooks(now time.Time) (int, int) {
+	var claims []webhookClaim
+	conflicts, err := commitData(func() { claims = claimWebhooks(now) })
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "Another terminal")
+	}
+	if err != nil {
+		return 0, 0
+	}
+	sendWebhooks(claims, now)
+	return applyWebhooks(claims, now)
+}
+
+// claimWebhooks leases every active webhook that is due, with up to
+// WEBHOOK_BATCH events from its offset on, so that other terminals do not
+// send them too. It runs under the data lock.
+func claimWebhooks(now time.Time) []webhookClaim {
+	lease := now.Add(DELIVERY_LEASE).Format(NOTIFY_TIME_FORMAT)
+	var claims []webhookClaim
+
+	for i := range webhooks {
+		w := &webhooks[i]
+		if !w.Active || leaseHeld(w.LeasedUntil, now) {
+			continue
+		}
+		if next, err := time.ParseInLocation(NOTIFY_TIME_FORMAT, w.NextAttempt, time.Local); err == nil && now.Before(next) {
+			continue
+		}
+		// Events trimmed from the stream can no longer be sent
+		if w.Offset < firstOffset() {
+			w.Offset = firstOffset()
+		}
+
+		var events []DomainEvent
+		for offset := w.Offset; len(events) < WEBHOOK_BATCH; offset++ {
+			ev, ok := eventAt(offset)
+			if !ok {
+				break
+			}
+			events = append(events, ev)
+		}
+		if len(events) == 0 {
+			continue
+		}
+		w.LeasedUntil = lease
+		claims = append(claims, webhookClaim{Webhook: *w, LeasedUntil: lease, Events: events})
+	}
+	return claims
+}
+
+// sendWebhooks posts the claimed events in order. A failed event holds back
+// the ones after it. It touches no shared data, so it can run in the
+// background.
+func sendWebhooks(claims []webhookClaim, now time.Time) {
+	client := newWebhookClient()
+	for i := range claims {
+		c := &claims[i]
+		for _, ev := range c.Events {
+			if eventMatches(c.Webhook.Events, ev.Type) {
+				if err := postWebhook(client, c.Webhook, ev, now); err != nil {
+					c.Err = err
+					break
+				}
+				c.Sent++
+			}
+			c.Delivered++
+		}
+	}
+}
+
+// applyWebhooks moves each webhook past the events it got. A failed event
+// is retried with a growing delay; after WEBHOOK_MAX_ATTEMPTS it goes to the
+// dead-letter queue so the rest can flow. A webhook that was replayed or
+// claimed again in the meantime is left alone.
+func applyWebhooks(claims []webhookClaim, now time.Time) (int, int) {
+	sent, failed := 0, 0
+	for _, c := range claims {
+		idx := findWebhook(c.Webhook.ID)
+		if idx == -1 || webhooks[idx].LeasedUntil != c.LeasedUntil || webhooks[idx].Offset != c.Webhook.Offset {
+			continue
+		}
+		w := &webhooks[idx]
+		w.LeasedUntil = ""
+		w.Offset += c.Delivered
+		if c.Sent > 0 {
+			w.Attempts, w.NextAttempt, w.LastError = 0, "", ""
+			w.LastSent = now.Format(NOTIFY_TIME_FORMAT)
+			sent += c.Sent
+		}
+		if c.Err == nil {
+			continue
+		}
+
+		ev := c.Events[c.Delivered]
+		failed++
+		w.Attempts++
+		w.LastError = c.Err.Error()
+		w.NextAttempt = now.Add(retryDelay(w.Attempts)).Format(NOTIFY_TIME_FORMAT)
+		if w.Attempts >= WEBHOOK_MAX_ATTEMPTS {
+			deadLetters = append(deadLetters, DeadLetter{WebhookID: w.ID, Offset: ev.Offset, Type: ev.Type,
+				Attempts: w.Attempts, LastError: w.LastError, FailedAt: now.Format(NOTIFY_TIME_FORMAT)})
+			auditLog(AUDIT_NOTIFY, "webhook_dead_letter", w.ID, nil, deadLetters[len(deadLetters)-1])
+			w.Offset++
+			w.Attempts, w.NextAttempt, w.LastError = 0, "", ""
+		}
+	}
+	return sent, failed
+}
+
+// redeliverDeadLetter tries a dead letter once more and drops it on success
+func redeliverDeadLetter(idx int) error {
+	d := &deadLetters[idx]
+	wIdx := findWebhook(d.WebhookID)
+	if wIdx == -1 {
+		return fmt.Errorf("webhook %d no longer exists", d.WebhookID)
+	}
+	ev, ok := eventAt(d.Offset)
+	if !ok {
+		return fmt.Errorf("event %d is no longer in the stream", d.Offset)
+	}
+
+	client := newWebhookClient()
+	if err := postWebhook(client, webhooks[wIdx], ev, time.Now()); err != nil {
+		d.Attempts++
+		d.LastError = err.Error()
+		return err
+	}
+	deadLetters = append(deadLetters[:idx], deadLetters[idx+1:]...)
+	return nil
+}
+
+// replayWebhook makes a webhook send everything again from an offset
+func replayWebhook(w *Webhook, offset int) error {
+	if offset < firstOffset() || offset > eventLog.Last+1 {
+		return fmt.Errorf("offset must be from %d to %d", firstOffset(), eventLog.Last+1)
+	}
+	before := w.Offset
+	w.Offset = offset
+	w.Attempts, w.NextAttempt, w.LastError, w.LeasedUntil = 0, "", "", ""
+	auditLog(AUDIT_UPDATE, "webhook", w.ID, map[string]int{"offset": before}, map[string]int{"offset": offset})
+	return nil
+}
+
+// webhookAudit is what the audit trail keeps of a webhook, without its secret
+func webhookAudit(w Webhook) map[string]interface{} {
+	return map[string]interface{}{"url": w.URL, "events": w.Events, "active": w.Active, "offset": w.Offset}
+}
+
+// Menu functions
+func getEventPatterns() []string {
+	fmt.Println("\nEvent types:")
+	for _, t := range EVENT_TYPES {
+		fmt.Println("  " + t)
+	}
+	for {
+		text := getValidInput("Events to send, separated by commas (e.g. record.*, patient.created; * for all): ")
+		var patterns []string
+		valid := true
+		for _, p := range strings.Split(text, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if p == "*" {
+				return nil
+			}
+			known := false
+			for _, t := range EVENT_TYPES {
+				if eventMatches([]string{p}, t) {
+					known = true
+					break
+				}
+			}
+			if !known {
+				printError(fmt.Sprintf("%q matches no event type.", p))
+				valid = false
+				break
+			}
+			patterns = append(patterns, p)
+		}
+		if valid && len(patterns) > 0 {
+			return patterns
+		}
+	}
+}
+
+func getWebhookURL() string {
+	for {
+		raw := getValidInput("Webhook URL (https://, or http:// to this machine): ")
+		if isValidWebhookURL(raw) {
+			return raw
+		}
+		printError("Please enter a full https:// URL; plain http:// is only allowed to localhost.")
+	}
+}
+
+func eventsText(patterns []string) string {
+	if len(patterns) == 0 {
+		return "*"
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func listWebhooks() {
+	printHeader("Webhooks")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	fmt.Printf("Event stream: offsets %d to %d\n\n", firstOffset(), eventLog.Last)
+	if len(webhooks) == 0 {
+		printWarning("No webhooks yet.")
+		pause()
+		return
+	}
+
+	fmt.Printf("%s%-4s %-40s %-22s %-7s %-7s %-8s%s\n", BOLD, "ID", "URL", "Events", "Active", "Offset", "Behind", RESET)
+	fmt.Println(strings.Repeat("-", 94))
+	for _, w := range webhooks {
+		behind := eventLog.Last - w.Offset + 1
+		if behind < 0 {
+			behind = 0
+		}
+		fmt.Printf("%-4d %-40s %-22s %-7v %-7d %-8d\n", w.ID, w.URL, eventsText(w.Events), w.Active, w.Offset, behind)
+		if w.LastError != "" {
+			fmt.Printf("%s     attempt %d failed: %s (next try %s)%s\n", YELLOW, w.Attempts, w.LastError, w.NextAttempt, RESET)
+		}
+	}
+	pause()
+}
+
+func addWebhook() {
+	printHeader("Add Webhook")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	w := Webhook{ID: getNextWebhookID(), URL: getWebhookURL(), Secret: newWebhookSecret(), Active: true}
+	w.Events = getEventPatterns()
+
+	w.Offset = eventLog.Last + 1
+	if len(eventLog.Events) > 0 {
+		fmt.Printf("\nSend events from: 1. Now on  2. The oldest kept (offset %d)\n", firstOffset())
+		if getValidInt("Choose option: ", 1, 2) == 2 {
+			w.Offset = firstOffset()
+		}
+	}
+
+	webhooks = append(webhooks, w)
+	auditLog(AUDIT_CREATE, "webhook", w.ID, nil, webhookAudit(w))
+
+	printSuccess(fmt.Sprintf("Webhook %d added.", w.ID))
+	fmt.Printf("Signing secret (set it up in the receiving system): %s\n", w.Secret)
+	fmt.Printf("Each request carries %s: sha256=HMAC-SHA256(secret, X-Webhook-Timestamp + \".\" + body).\n", WEBHOOK_SIGNATURE_KEY)
+	pause()
+}
+
+func selectWebhook() int {
+	if len(webhooks) == 0 {
+		printWarning("No webhooks yet.")
+		return -1
+	}
+	for _, w := range webhooks {
+		fmt.Printf("%d. %s (%s)\n", w.ID, w.URL, eventsText(w.Events))
+	}
+	idx := findWebhook(getValidInt("Enter webhook ID: ", 1, 999999))
+	if idx == -1 {
+		printError("Webhook not found.")
+	}
+	return idx
+}
+
+func editWebhook() {
+	printHeader("Edit Webhook")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	idx := selectWebhook()
+	if idx == -1 {
+		pause()
+		return
+	}
+	w := &webhooks[idx]
+	before := webhookAudit(*w)
+
+	fmt.Println("\n1. URL  2. Events  3. Switch on/off  4. New signing secret  5. Delete")
+	switch getValidInt("Choose option (0 to cancel): ", 0, 5) {
+	case 0:
+		return
+	case 1:
+		w.URL = getWebhookURL()
+	case 2:
+		w.Events = getEventPatterns()
+	case 3:
+		w.Active = !w.Active
+	case 4:
+		w.Secret = newWebhookSecret()
+		fmt.Printf("New signing secret: %s\n", w.Secret)
+		auditLog(AUDIT_UPDATE, "webhook", w.ID, nil, map[string]string{"secret": "rotated"})
+		printSuccess("Secret changed. Update the receiving system before the next event.")
+		pause()
+		return
+	case 5:
+		confirm := getValidInput("Delete this webhook? Its dead letters are dropped too. (y/N): ")
+		if strings.ToLower(confirm) != "y" && strings.ToLower(confirm) != "yes" {
+			printWarning("Deletion cancelled.")
+			pause()
+			return
+		}
+		id := w.ID
+		webhooks = append(webhooks[:idx], webhooks[idx+1:]...)
+		kept := deadLetters[:0]
+		for _, d := range deadLetters {
+			if d.WebhookID != id {
+				kept = append(kept, d)
+			}
+		}
+		deadLetters = kept
+		auditLog(AUDIT_DELETE, "webhook", id, before, nil)
+		printSuccess("Webhook deleted.")
+		pause()
+		return
+	}
+
+	auditLog(AUDIT_UPDATE, "webhook", w.ID, before, webhookAudit(*w))
+	printSuccess("Webhook updated.")
+	pause()
+}
+
+func replayWebhookMenu() {
+	printHeader("Replay Events")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	idx := selectWebhook()
+	if idx == -1 {
+		pause()
+		return
+	}
+	w := &webhooks[idx]
+	fmt.Printf("\nWebhook %d is at offset %d. The stream holds offsets %d to %d.\n", w.ID, w.Offset, firstOffset(), eventLog.Last)
+	offset := getValidInt("Send again from offset: ", 0, 999999999)
+	if err := replayWebhook(w, offset); err != nil {
+		printError(err.Error())
+		pause()
+		return
+	}
+
+	sent, failed := deliverWebhooks(time.Now())
+	printSuccess(fmt.Sprintf("Replay started: %d events sent, %d failed.", sent, failed))
+	pause()
+}
+
+func manageDeadLetters() {
+	printHeader("Dead Letters")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	if len(deadLetters) == 0 {
+		printWarning("No dead letters.")
+		pause()
+		return
+	}
+
+	fmt.Printf("%s%-4s %-8s %-8s %-18s %-4s %-19s%s\n", BOLD, "No.", "Webhook", "Offset", "Event", "Try", "Failed", RESET)
+	fmt.Println(strings.Repeat("-", 68))
+	for i, d := range deadLetters {
+		fmt.Printf("%-4d %-8d %-8d %-18s %-4d %-19s\n", i+1, d.WebhookID, d.Offset, d.Type, d.Attempts, d.FailedAt)
+		fmt.Printf("%s     %s%s\n", YELLOW, d.LastError, RESET)
+	}
+
+	fmt.Println("\n1. Send one again  2. Send all again  3. Discard one  0. Back")
+	switch getValidInt("Choose option: ", 0, 3) {
+	case 1:
+		n := getValidInt("Dead letter number: ", 1, len(deadLetters))
+		if err := redeliverDeadLetter(n - 1); err != nil {
+			printError(fmt.Sprintf("Failed again: %v", err))
+		} else {
+			printSuccess("Delivered.")
+		}
+	case 2:
+		delivered := 0
+		for i := len(deadLetters) - 1; i >= 0; i-- {
+			if redeliverDeadLetter(i) == nil {
+				delivered++
+			}
+		}
+		printSuccess(fmt.Sprintf("%d delivered, %d still failing.", delivered, len(deadLetters)))
+	case 3:
+		n := getValidInt("Dead letter number: ", 1, len(deadLetters))
+		d := deadLetters[n-1]
+		deadLetters = append(deadLetters[:n-1], deadLetters[n:]...)
+		auditLog(AUDIT_DELETE, "webhook_dead_letter", d.WebhookID, d, nil)
+		printSuccess("Dead letter discarded.")
+	case 0:
+		return
+	}
+	pause()
+}
+
+func viewEventStream() {
+	printHeader("Event Stream")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	if len(eventLog.Events) == 0 {
+		printWarning("No events yet.")
+		pause()
+		return
+	}
+
+	start := len(eventLog.Events) - 20
+	if start < 0 {
+		start = 0
+	}
+	fmt.Printf("Showing the latest %d of offsets %d to %d\n\n", len(eventLog.Events)-start, firstOffset(), eventLog.Last)
+	fmt.Printf("%s%-8s %-26s %-18s %-8s%s\n", BOLD, "Offset", "Time", "Event", "ID", RESET)
+	fmt.Println(strings.Repeat("-", 62))
+	for _, ev := range eventLog.Events[start:] {
+		fmt.Printf("%-8d %-26s %-18s %-8d\n", ev.Offset, ev.Time, ev.Type, ev.EntityID)
+	}
+	pause()
+}
+
+func deliverWebhooksNow() {
+	printHeader("Deliver Webhooks")
+
+	if !requirePermission(PERM_DATA_EXCHANGE) {
+		return
+	}
+
+	// Sending by hand does not wait for the retry delay
+	for i := range webhooks {
+		webhooks[i].NextAttempt = ""
+	}
+	sent, failed := deliverWebhooks(time.Now())
+	printSuccess(fmt.Sprintf("%d events sent, %d failed.", sent, failed))
+	pause()
+}
+
+func webhookManagement() {
+	for {
+		printHeader("Webhooks")
+		fmt.Printf("%s1.%s List Webhooks\n", YELLOW, RESET)
+		fmt.Printf("%s2.%s Add Webhook\n", YELLOW, RESET)
+		fmt.Printf("%s3.%s Edit or Delete Webhook\n", YELLOW, RESET)
+		fmt.Printf("%s4.%s Replay Events From an Offset\n", YELLOW, RESET)
+		fmt.Printf("%s5.%s Dead Letters\n", YELLOW, RESET)
+		fmt.Printf("%s6.%s Event Stream\n", YELLOW, RESET)
+		fmt.Printf("%s7.%s Deliver Now\n", YELLOW, RESET)
+		fmt.Printf("%s0.%s Back\n", RED, RESET)
+
+		choice := getValidInt("\nSelect option: ", 0, 7)
+
+		switch choice {
+		case 1:
+			listWebhooks()
+		case 2:
+			addWebhook()
+		case 3:
+			editWebhook()
+		case 4:
+			replayWebhookMenu()
+		case 5:
+			manageDeadLetters()
+		case 6:
+			viewEventStream()
+		case 7:
+			deliverWebhooksNow()
+		case 0:
+			return
+		}
+	}
+}
+
+// webhookCommand runs "webhook run" and "webhook replay <id> <offset>" after login
+func webhookCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "run" && args[0] != "replay") || (args[0] == "replay" && len(args) != 3) {
+		fmt.Println("Usage: webhook run | webhook replay <webhook-id> <offset>")
+		return 2
+	}
+	if !checkPermission(PERM_DATA_EXCHANGE) {
+		return 1
+	}
+
+	if args[0] == "replay" {
+		id, err1 := strconv.Atoi(args[1])
+		offset, err2 := strconv.Atoi(args[2])
+		idx := findWebhook(id)
+		if err1 != nil || err2 != nil || idx == -1 {
+			printError("Unknown webhook or offset.")
+			return 2
+		}
+		if err := replayWebhook(&webhooks[idx], offset); err != nil {
+			printError(err.Error())
+			return 2
+		}
+	}
+
+	sent, failed := deliverWebhooks(time.Now())
+	if err := saveData(); err != nil {
+		printError(fmt.Sprintf("Failed to save data: %v", err))
+		return 1
+	}
+	printSuccess(fmt.Sprintf("%d events sent, %d failed.", sent, failed))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}